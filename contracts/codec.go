@@ -0,0 +1,100 @@
+package contracts
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// HeaderContentType names the codec the message is encoded with. Message without the header is JSON.
+const HeaderContentType = `content-type`
+
+const (
+	ContentTypeJSON     = `application/json`
+	ContentTypeProtobuf = `application/x-protobuf`
+)
+
+// Names of the codecs used in the configuration of the services.
+const (
+	CodecJSON     = `json`
+	CodecProtobuf = `protobuf`
+)
+
+var (
+	ErrUnknownCodec           = errors.New(`unknown codec`)
+	ErrUnsupportedContentType = errors.New(`unsupported content type`)
+)
+
+// Codec encodes the envelope to the wire format and back.
+type Codec interface {
+	// ContentType is the value of the content type header of the encoded messages.
+	ContentType() string
+	Encode(e *Envelope) ([]byte, error)
+	// Decode reads the envelope from the value. Type of the topic is used for the messages without envelope.
+	Decode(t EventType, value []byte) (*Envelope, error)
+}
+
+var codecs = map[string]Codec{
+	ContentTypeJSON:     JSONCodec{},
+	ContentTypeProtobuf: ProtobufCodec{},
+}
+
+// NewCodec returns the codec by its name from the configuration.
+func NewCodec(name string) (Codec, error) {
+	switch name {
+	case CodecJSON:
+		return JSONCodec{}, nil
+	case CodecProtobuf:
+		return ProtobufCodec{}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnknownCodec, name)
+}
+
+// CodecFor returns the codec of the content type header. Empty content type means JSON,
+// since messages were published without the header before codecs were introduced.
+func CodecFor(contentType string) (Codec, error) {
+	if contentType == "" {
+		return JSONCodec{}, nil
+	}
+
+	codec, ok := codecs[contentType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedContentType, contentType)
+	}
+
+	return codec, nil
+}
+
+// JSONCodec encodes the envelope with encoding/json.
+type JSONCodec struct{}
+
+func (JSONCodec) ContentType() string {
+	return ContentTypeJSON
+}
+
+func (JSONCodec) Encode(e *Envelope) ([]byte, error) {
+	return json.Marshal(e)
+}
+
+// Decode wraps bare payload of the legacy message into the envelope of the legacy version.
+func (JSONCodec) Decode(t EventType, value []byte) (*Envelope, error) {
+	var probe struct {
+		Type EventType `json:"type"`
+	}
+	if err := json.Unmarshal(value, &probe); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedEnvelope, err)
+	}
+
+	if probe.Type == "" {
+		return &Envelope{Type: t, Version: LegacyVersion, Payload: append(json.RawMessage(nil), bytes.TrimSpace(value)...)}, nil
+	}
+
+	e := new(Envelope)
+	if err := json.Unmarshal(value, e); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedEnvelope, err)
+	}
+
+	return e, nil
+}
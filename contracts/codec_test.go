@@ -0,0 +1,128 @@
+package contracts
+
+import (
+	"encoding/json"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"reflect"
+	"testing"
+)
+
+func TestCodecsRoundTrip(t *testing.T) {
+	payloads := []struct {
+		topic   string
+		payload interface{}
+		decoded interface{}
+	}{
+		{
+			topic: StorageReserveOrderTopic,
+			payload: &Order{
+				Id:     primitive.NewObjectID(),
+				UserId: primitive.NewObjectID(),
+				Amount: 12.5,
+				Items:  []OrderProduct{{Sku: "apple", Quantity: 2}, {Sku: "pear", Quantity: 1}},
+			},
+			decoded: new(Order),
+		},
+		{topic: WalletCreateTopic, payload: &User{Id: primitive.NewObjectID(), Name: "user"}, decoded: new(User)},
+		{
+			topic:   WalletCreateResponseTopic,
+			payload: &Wallet{Id: primitive.NewObjectID(), UserId: primitive.NewObjectID(), Balance: 100},
+			decoded: new(Wallet),
+		},
+	}
+
+	for _, codec := range []Codec{JSONCodec{}, ProtobufCodec{}} {
+		for _, p := range payloads {
+			t.Run(codec.ContentType()+" "+p.topic, func(t *testing.T) {
+				eventType, _ := TopicEvent(p.topic)
+				cause, err := NewEnvelope(eventType, "saga", p.payload)
+				if err != nil {
+					t.Fatal(err)
+				}
+				e, err := NewCausedEnvelope(cause, eventType, p.payload)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				value, err := codec.Encode(e)
+				if err != nil {
+					t.Fatal(err)
+				}
+				decoded, err := Decode(p.topic, codec.ContentType(), value)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if decoded.Id != e.Id || decoded.Type != e.Type || decoded.Version != e.Version ||
+					decoded.CorrelationId != "saga" || decoded.CausationId != cause.Id || !decoded.Timestamp.Equal(e.Timestamp) {
+					t.Errorf("envelope %+v, want %+v", decoded, e)
+				}
+				if err = decoded.Unmarshal(p.decoded); err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(p.decoded, p.payload) {
+					t.Errorf("payload %+v, want %+v", p.decoded, p.payload)
+				}
+			})
+		}
+	}
+}
+
+func TestCodecFor(t *testing.T) {
+	tests := []struct {
+		contentType string
+		codec       Codec
+		err         error
+	}{
+		// Messages published before codecs were introduced have no content type.
+		{contentType: "", codec: JSONCodec{}},
+		{contentType: ContentTypeJSON, codec: JSONCodec{}},
+		{contentType: ContentTypeProtobuf, codec: ProtobufCodec{}},
+		{contentType: "text/plain", err: ErrUnsupportedContentType},
+	}
+
+	for _, tt := range tests {
+		codec, err := CodecFor(tt.contentType)
+		if !errors.Is(err, tt.err) || codec != tt.codec {
+			t.Errorf("codec of %q: %T, %v, want %T, %v", tt.contentType, codec, err, tt.codec, tt.err)
+		}
+	}
+}
+
+func TestNewCodec(t *testing.T) {
+	for name, want := range map[string]Codec{CodecJSON: JSONCodec{}, CodecProtobuf: ProtobufCodec{}} {
+		if codec, err := NewCodec(name); err != nil || codec != want {
+			t.Errorf("codec %q: %T, %v, want %T", name, codec, err, want)
+		}
+	}
+
+	if _, err := NewCodec("xml"); !errors.Is(err, ErrUnknownCodec) {
+		t.Errorf("unknown codec: %v, want %v", err, ErrUnknownCodec)
+	}
+}
+
+func TestMalformedEnvelope(t *testing.T) {
+	for _, codec := range []Codec{JSONCodec{}, ProtobufCodec{}} {
+		if _, err := codec.Decode(ReserveOrder, []byte("{not an envelope")); !errors.Is(err, ErrMalformedEnvelope) {
+			t.Errorf("%s: %v, want %v", codec.ContentType(), err, ErrMalformedEnvelope)
+		}
+	}
+}
+
+// JSON payload published before the envelope was introduced is wrapped into the envelope of the legacy version.
+func TestJSONCodecBarePayload(t *testing.T) {
+	payload := `{"id":"` + primitive.NewObjectID().Hex() + `","items":[]}`
+
+	e, err := JSONCodec{}.Decode(ReserveOrder, []byte(" "+payload+"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.Type != ReserveOrder || e.Version != LegacyVersion || string(e.Payload) != payload {
+		t.Errorf("envelope %s v%d with %s, want %s v%d with %s", e.Type, e.Version, e.Payload, ReserveOrder, LegacyVersion, payload)
+	}
+	if !json.Valid(e.Payload) {
+		t.Errorf("payload %s is not valid JSON", e.Payload)
+	}
+}
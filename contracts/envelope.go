@@ -0,0 +1,94 @@
+// Package contracts defines events of the order saga shared by registry, storage and wallet:
+// topics, headers, payloads and the versioned envelope every event is published in.
+package contracts
+
+import (
+	"encoding/json"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// Envelope wraps the payload of the event. Correlation id is shared by all events of the saga,
+// causation id is the id of the event which caused this one.
+type Envelope struct {
+	Id            string          `json:"id"`
+	Type          EventType       `json:"type"`
+	Version       int             `json:"version"`
+	CorrelationId string          `json:"correlation_id"`
+	CausationId   string          `json:"causation_id,omitempty"`
+	Timestamp     time.Time       `json:"timestamp"`
+	Payload       json.RawMessage `json:"payload"`
+}
+
+// NewEnvelope wraps the payload into the envelope of the current version of the event type.
+func NewEnvelope(t EventType, correlationId string, payload interface{}) (*Envelope, error) {
+	version, err := Version(t)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Envelope{
+		Id:            primitive.NewObjectID().Hex(),
+		Type:          t,
+		Version:       version,
+		CorrelationId: correlationId,
+		Timestamp:     time.Now().UTC(),
+		Payload:       value,
+	}, nil
+}
+
+// NewCausedEnvelope wraps the payload of the event caused by the cause event in the same saga.
+func NewCausedEnvelope(cause *Envelope, t EventType, payload interface{}) (*Envelope, error) {
+	e, err := NewEnvelope(t, cause.CorrelationId, payload)
+	if err != nil {
+		return nil, err
+	}
+	e.CausationId = cause.Id
+
+	return e, nil
+}
+
+// Unmarshal decodes the payload of the envelope.
+func (e *Envelope) Unmarshal(payload interface{}) error {
+	return json.Unmarshal(e.Payload, payload)
+}
+
+// Decode reads the envelope of the message published to the topic with the codec of the content type header.
+// Bare payload of the legacy message is wrapped into the envelope of the legacy version with the type of the topic.
+// Checked envelope is upgraded to the current version of its type, so consumers handle only the current payloads.
+func Decode(topic, contentType string, value []byte) (*Envelope, error) {
+	expected, ok := TopicEvent(topic)
+	if !ok {
+		return nil, fmt.Errorf("%w: topic %s", ErrUnknownEventType, topic)
+	}
+
+	codec, err := CodecFor(contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := codec.Decode(expected, value)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.Type != expected {
+		return nil, fmt.Errorf("%w: %s in topic %s, want %s", ErrUnexpectedEventType, e.Type, topic, expected)
+	}
+
+	if err = Check(e); err != nil {
+		return nil, err
+	}
+
+	if err = upgrade(e); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
@@ -0,0 +1,81 @@
+package contracts
+
+import (
+	"errors"
+	"testing"
+)
+
+// Decode upgrades the payloads of the older versions to the current version, so items of the orders published
+// before SKUs were introduced reference products by SKU.
+func TestDecodeUpgradesPayload(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{name: "bare payload", value: `{"items":[{"name":"apple","quantity":2}]}`},
+		{name: "version 1", value: `{"id":"1","type":"ReserveOrder","version":1,"payload":{"items":[{"name":"apple","quantity":2}]}}`},
+		{name: "version 2", value: `{"id":"1","type":"ReserveOrder","version":2,"payload":{"items":[{"sku":"apple","quantity":2}]}}`},
+		{name: "version 1 with sku", value: `{"id":"1","type":"ReserveOrder","version":1,"payload":{"items":[{"name":"apple","sku":"apple","quantity":2}]}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := Decode(StorageReserveOrderTopic, "", []byte(tt.value))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if e.Type != ReserveOrder || e.Version != 2 {
+				t.Fatalf("envelope %s v%d, want %s v2", e.Type, e.Version, ReserveOrder)
+			}
+
+			order := new(Order)
+			if err = e.Unmarshal(order); err != nil {
+				t.Fatal(err)
+			}
+			if len(order.Items) != 1 || order.Items[0] != (OrderProduct{Sku: "apple", Quantity: 2}) {
+				t.Errorf("items %+v, want 2 of apple", order.Items)
+			}
+		})
+	}
+}
+
+func TestDecodeRejectsEnvelope(t *testing.T) {
+	tests := []struct {
+		name  string
+		topic string
+		value string
+		err   error
+	}{
+		{name: "unknown topic", topic: "unknown", value: `{}`, err: ErrUnknownEventType},
+		{name: "newer version", topic: StorageReserveOrderTopic, value: `{"type":"ReserveOrder","version":3,"payload":{}}`, err: ErrUnsupportedVersion},
+		// Commit was introduced after the envelope, so it has no bare payloads.
+		{name: "bare commit", topic: StorageCommitOrderTopic, value: `{"items":[]}`, err: ErrUnsupportedVersion},
+		{name: "another type", topic: StorageReserveOrderTopic, value: `{"type":"PayOrder","version":2,"payload":{}}`, err: ErrUnexpectedEventType},
+		{name: "unknown content type", topic: StorageReserveOrderTopic, value: `{}`, err: ErrUnsupportedContentType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			contentType := ContentTypeJSON
+			if tt.err == ErrUnsupportedContentType {
+				contentType = "text/plain"
+			}
+
+			if _, err := Decode(tt.topic, contentType, []byte(tt.value)); !errors.Is(err, tt.err) {
+				t.Fatalf("decode: %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestNewEnvelopeUnknownType(t *testing.T) {
+	if _, err := NewEnvelope("Unknown", "saga", struct{}{}); !errors.Is(err, ErrUnknownEventType) {
+		t.Fatalf("envelope of the unknown type: %v, want %v", err, ErrUnknownEventType)
+	}
+}
+
+func TestStatusHeader(t *testing.T) {
+	if !IsSuccessStatus(StatusHeader(true)) || IsSuccessStatus(StatusHeader(false)) || IsSuccessStatus(nil) {
+		t.Error("status header does not round trip")
+	}
+}
@@ -0,0 +1,162 @@
+package contracts
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// EventType names the command or the response of the saga.
+type EventType string
+
+const (
+	ReserveOrder      EventType = `ReserveOrder`
+	CancelReservation EventType = `CancelReservation`
+	CommitReservation EventType = `CommitReservation`
+	CreateWallet      EventType = `CreateWallet`
+	PayOrder          EventType = `PayOrder`
+	CancelPayment     EventType = `CancelPayment`
+
+	ReserveOrderResult      EventType = `ReserveOrderResult`
+	CancelReservationResult EventType = `CancelReservationResult`
+	CommitReservationResult EventType = `CommitReservationResult`
+	CreateWalletResult      EventType = `CreateWalletResult`
+	PayOrderResult          EventType = `PayOrderResult`
+	CancelPaymentResult     EventType = `CancelPaymentResult`
+
+	ReservationExpired EventType = `ReservationExpired`
+)
+
+// LegacyVersion is the version of the bare payloads published before the envelope was introduced.
+const LegacyVersion = 0
+
+var (
+	ErrUnknownEventType    = errors.New(`unknown event type`)
+	ErrUnsupportedVersion  = errors.New(`unsupported event version`)
+	ErrUnexpectedEventType = errors.New(`unexpected event type`)
+	ErrMalformedEnvelope   = errors.New(`malformed event envelope`)
+	errMissingUpgrade      = errors.New(`missing upgrade to the next version`)
+)
+
+// Upgrade converts the payload of the version to the payload of the next version.
+type Upgrade func(payload json.RawMessage) (json.RawMessage, error)
+
+// schema describes versions of the event type which could be consumed.
+type schema struct {
+	// version is the current version. Events are published with it.
+	version int
+	// oldest is the oldest version which is still accepted.
+	oldest int
+	// upgrades are indexed by the version they are converting from.
+	upgrades map[int]Upgrade
+}
+
+// same is the upgrade of the version with compatible payload.
+func same(payload json.RawMessage) (json.RawMessage, error) {
+	return payload, nil
+}
+
+// skus converts items of the order payload of the version 1, which referenced products by name, to items of
+// the version 2 referencing products by SKU. Names of the products created before SKUs were introduced are
+// their SKUs.
+func skus(payload json.RawMessage) (json.RawMessage, error) {
+	var order map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &order); err != nil {
+		return nil, err
+	}
+
+	var items []map[string]json.RawMessage
+	if raw, ok := order["items"]; ok {
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, item := range items {
+		if name, ok := item["name"]; ok {
+			if _, ok = item["sku"]; !ok {
+				item["sku"] = name
+			}
+			delete(item, "name")
+		}
+	}
+
+	raw, err := json.Marshal(items)
+	if err != nil {
+		return nil, err
+	}
+	order["items"] = raw
+
+	return json.Marshal(order)
+}
+
+// legacy accepts bare payloads of the version 0, which are compatible with the version 1.
+var legacy = map[int]Upgrade{LegacyVersion: same}
+
+// legacyOrder accepts order payloads of the version 0 and 1, which reference products by name.
+var legacyOrder = map[int]Upgrade{LegacyVersion: same, 1: skus}
+
+var schemas = map[EventType]schema{
+	ReserveOrder:      {version: 2, oldest: LegacyVersion, upgrades: legacyOrder},
+	CancelReservation: {version: 2, oldest: LegacyVersion, upgrades: legacyOrder},
+	CommitReservation: {version: 2, oldest: 1, upgrades: legacyOrder},
+	CreateWallet:      {version: 1, oldest: LegacyVersion, upgrades: legacy},
+	PayOrder:          {version: 2, oldest: LegacyVersion, upgrades: legacyOrder},
+	CancelPayment:     {version: 2, oldest: LegacyVersion, upgrades: legacyOrder},
+
+	ReserveOrderResult:      {version: 2, oldest: LegacyVersion, upgrades: legacyOrder},
+	CancelReservationResult: {version: 2, oldest: LegacyVersion, upgrades: legacyOrder},
+	CommitReservationResult: {version: 2, oldest: 1, upgrades: legacyOrder},
+	CreateWalletResult:      {version: 1, oldest: LegacyVersion, upgrades: legacy},
+	PayOrderResult:          {version: 2, oldest: LegacyVersion, upgrades: legacyOrder},
+	CancelPaymentResult:     {version: 2, oldest: LegacyVersion, upgrades: legacyOrder},
+
+	// Events introduced after the envelope have no legacy payloads.
+	ReservationExpired: {version: 2, oldest: 1, upgrades: legacyOrder},
+}
+
+// Version returns the current version of the event type.
+func Version(t EventType) (int, error) {
+	s, ok := schemas[t]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrUnknownEventType, t)
+	}
+
+	return s.version, nil
+}
+
+// Check reports whether the envelope could be consumed: its type is known and its version is
+// between the oldest accepted and the current version.
+func Check(e *Envelope) error {
+	s, ok := schemas[e.Type]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownEventType, e.Type)
+	}
+
+	if e.Version < s.oldest || e.Version > s.version {
+		return fmt.Errorf("%w: %s v%d, supported v%d..v%d", ErrUnsupportedVersion, e.Type, e.Version, s.oldest, s.version)
+	}
+
+	return nil
+}
+
+// upgrade converts payload of the checked envelope to the current version.
+func upgrade(e *Envelope) error {
+	s := schemas[e.Type]
+	for e.Version < s.version {
+		next, ok := s.upgrades[e.Version]
+		if !ok {
+			return fmt.Errorf("%w: %s v%d", errMissingUpgrade, e.Type, e.Version)
+		}
+
+		payload, err := next(e.Payload)
+		if err != nil {
+			return err
+		}
+
+		e.Payload = payload
+		e.Version++
+	}
+
+	return nil
+}
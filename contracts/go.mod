@@ -0,0 +1,8 @@
+module eCommerce/contracts
+
+go 1.14
+
+require (
+	go.mongodb.org/mongo-driver v1.8.2
+	google.golang.org/protobuf v1.27.1
+)
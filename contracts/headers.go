@@ -0,0 +1,22 @@
+package contracts
+
+// Headers of the response messages. Status is a single byte, 1 when the command succeeded and 0 otherwise,
+// message describes the result of the command.
+const (
+	HeaderStatus  = `status`
+	HeaderMessage = `message`
+)
+
+// StatusHeader returns value of the status header.
+func StatusHeader(success bool) []byte {
+	if success {
+		return []byte{1}
+	}
+
+	return []byte{0}
+}
+
+// IsSuccessStatus reports whether value of the status header means success.
+func IsSuccessStatus(status []byte) bool {
+	return len(status) > 0 && status[0] != 0
+}
@@ -0,0 +1,31 @@
+package contracts
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Order is the payload of the order commands and their responses.
+type Order struct {
+	Id     primitive.ObjectID `json:"id"`
+	UserId primitive.ObjectID `json:"user_id"`
+	Amount float64            `json:"amount"`
+	Items  []OrderProduct     `json:"items"`
+}
+
+// OrderProduct is the line of the order. Product is referenced by its SKU, which is stable across renames
+// and distinguishes variants of the same product.
+type OrderProduct struct {
+	Sku      string `json:"sku"`
+	Quantity int64  `json:"quantity"`
+}
+
+// User is the payload of the wallet creation command.
+type User struct {
+	Id   primitive.ObjectID `json:"id"`
+	Name string             `json:"name"`
+}
+
+// Wallet is the payload of the wallet creation response.
+type Wallet struct {
+	Id      primitive.ObjectID `json:"id"`
+	UserId  primitive.ObjectID `json:"user_id"`
+	Balance float64            `json:"balance"`
+}
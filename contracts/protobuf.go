@@ -0,0 +1,162 @@
+package contracts
+
+import (
+	"eCommerce/contracts/pb"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var errUnsupportedPayload = errors.New(`payload is not supported by protobuf codec`)
+
+// ProtobufCodec encodes the envelope as contracts.v1.Envelope message from proto/contracts/v1/saga.proto.
+// Payload is converted to the typed message of the event type, so consumers still get JSON payload
+// from the decoded envelope and upgrades of the payloads are shared by both codecs.
+type ProtobufCodec struct{}
+
+func (ProtobufCodec) ContentType() string {
+	return ContentTypeProtobuf
+}
+
+func (ProtobufCodec) Encode(e *Envelope) ([]byte, error) {
+	message := &pb.Envelope{
+		Id:            e.Id,
+		Type:          string(e.Type),
+		Version:       int32(e.Version),
+		CorrelationId: e.CorrelationId,
+		CausationId:   e.CausationId,
+		Timestamp:     timestamppb.New(e.Timestamp),
+	}
+
+	switch e.Type {
+	case CreateWallet:
+		user := new(User)
+		if err := e.Unmarshal(user); err != nil {
+			return nil, err
+		}
+		message.Payload = &pb.Envelope_User{User: &pb.User{Id: hex(user.Id), Name: user.Name}}
+	case CreateWalletResult:
+		wallet := new(Wallet)
+		if err := e.Unmarshal(wallet); err != nil {
+			return nil, err
+		}
+		message.Payload = &pb.Envelope_Wallet{Wallet: &pb.Wallet{Id: hex(wallet.Id), UserId: hex(wallet.UserId), Balance: wallet.Balance}}
+	default:
+		order := new(Order)
+		if err := e.Unmarshal(order); err != nil {
+			return nil, err
+		}
+		message.Payload = &pb.Envelope_Order{Order: orderMessage(order)}
+	}
+
+	return proto.Marshal(message)
+}
+
+func (ProtobufCodec) Decode(_ EventType, value []byte) (*Envelope, error) {
+	message := new(pb.Envelope)
+	if err := proto.Unmarshal(value, message); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedEnvelope, err)
+	}
+
+	var payload interface{}
+	var err error
+	switch p := message.Payload.(type) {
+	case *pb.Envelope_Order:
+		payload, err = orderPayload(p.Order)
+	case *pb.Envelope_User:
+		payload, err = userPayload(p.User)
+	case *pb.Envelope_Wallet:
+		payload, err = walletPayload(p.Wallet)
+	default:
+		err = errUnsupportedPayload
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedEnvelope, err)
+	}
+
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Envelope{
+		Id:            message.Id,
+		Type:          EventType(message.Type),
+		Version:       int(message.Version),
+		CorrelationId: message.CorrelationId,
+		CausationId:   message.CausationId,
+		Timestamp:     message.Timestamp.AsTime(),
+		Payload:       raw,
+	}, nil
+}
+
+func orderMessage(order *Order) *pb.Order {
+	items := make([]*pb.OrderProduct, 0, len(order.Items))
+	for _, item := range order.Items {
+		items = append(items, &pb.OrderProduct{Sku: item.Sku, Quantity: item.Quantity})
+	}
+
+	return &pb.Order{Id: hex(order.Id), UserId: hex(order.UserId), Amount: order.Amount, Items: items}
+}
+
+func orderPayload(message *pb.Order) (*Order, error) {
+	id, err := objectId(message.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	userId, err := objectId(message.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]OrderProduct, 0, len(message.Items))
+	for _, item := range message.Items {
+		items = append(items, OrderProduct{Sku: item.Sku, Quantity: item.Quantity})
+	}
+
+	return &Order{Id: id, UserId: userId, Amount: message.Amount, Items: items}, nil
+}
+
+func userPayload(message *pb.User) (*User, error) {
+	id, err := objectId(message.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &User{Id: id, Name: message.Name}, nil
+}
+
+func walletPayload(message *pb.Wallet) (*Wallet, error) {
+	id, err := objectId(message.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	userId, err := objectId(message.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Wallet{Id: id, UserId: userId, Balance: message.Balance}, nil
+}
+
+// hex encodes the object id, empty string means the id is not set.
+func hex(id primitive.ObjectID) string {
+	if id.IsZero() {
+		return ""
+	}
+
+	return id.Hex()
+}
+
+func objectId(hex string) (primitive.ObjectID, error) {
+	if hex == "" {
+		return primitive.NilObjectID, nil
+	}
+
+	return primitive.ObjectIDFromHex(hex)
+}
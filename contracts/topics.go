@@ -0,0 +1,45 @@
+package contracts
+
+// Topics of the order saga. Every command topic has the response topic with '-response' suffix.
+const (
+	StorageReserveOrderTopic = `storage-reserve-order`
+	StorageCancelOrderTopic  = `storage-cancel-order`
+	StorageCommitOrderTopic  = `storage-commit-order`
+	WalletCreateTopic        = `wallet-create`
+	WalletPayOrderTopic      = `wallet-pay-order`
+	WalletCancelOrderTopic   = `wallet-cancel-order`
+
+	StorageReserveOrderResponseTopic = `storage-reserve-order-response`
+	StorageCancelOrderResponseTopic  = `storage-cancel-order-response`
+	StorageCommitOrderResponseTopic  = `storage-commit-order-response`
+	WalletCreateResponseTopic        = `wallet-create-response`
+	WalletPayOrderResponseTopic      = `wallet-pay-order-response`
+	WalletCancelOrderResponseTopic   = `wallet-cancel-order-response`
+
+	// StorageReservationExpiredTopic receives events about reservations released by the storage after expiry.
+	StorageReservationExpiredTopic = `storage-reservation-expired`
+)
+
+// topicEvents are types of the events published to the topics. Messages published before the envelope
+// was introduced carry the bare payload, so the type of such message is known only from its topic.
+var topicEvents = map[string]EventType{
+	StorageReserveOrderTopic:         ReserveOrder,
+	StorageCancelOrderTopic:          CancelReservation,
+	StorageCommitOrderTopic:          CommitReservation,
+	WalletCreateTopic:                CreateWallet,
+	WalletPayOrderTopic:              PayOrder,
+	WalletCancelOrderTopic:           CancelPayment,
+	StorageReserveOrderResponseTopic: ReserveOrderResult,
+	StorageCancelOrderResponseTopic:  CancelReservationResult,
+	StorageCommitOrderResponseTopic:  CommitReservationResult,
+	WalletCreateResponseTopic:        CreateWalletResult,
+	WalletPayOrderResponseTopic:      PayOrderResult,
+	WalletCancelOrderResponseTopic:   CancelPaymentResult,
+	StorageReservationExpiredTopic:   ReservationExpired,
+}
+
+// TopicEvent returns type of the events published to the topic.
+func TopicEvent(topic string) (EventType, bool) {
+	t, ok := topicEvents[topic]
+	return t, ok
+}
@@ -0,0 +1,63 @@
+package e2e
+
+import (
+	"eCommerce/contracts"
+	"fmt"
+	"testing"
+)
+
+// TestCodecs runs the saga with services publishing in different wire formats, as during the migration
+// from JSON to Protobuf, and checks the content type of every message.
+func TestCodecs(t *testing.T) {
+	jsonCodec, protobufCodec := contracts.JSONCodec{}, contracts.ProtobufCodec{}
+
+	tests := []struct {
+		name   string
+		codecs Codecs
+	}{
+		{name: "protobuf", codecs: Codecs{Registry: protobufCodec, Storage: protobufCodec, Wallet: protobufCodec}},
+		{name: "registry migrated", codecs: Codecs{Registry: protobufCodec, Storage: jsonCodec, Wallet: jsonCodec}},
+		{name: "storage and wallet migrated", codecs: Codecs{Registry: jsonCodec, Storage: protobufCodec, Wallet: protobufCodec}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := startHarnessWith(t, tt.codecs)
+
+			uid := registerUser(t, h)
+			order := createOrder(t, h, uid, []orderItem{{Sku: "apple", Quantity: 2}})
+			awaitStatus(t, h, uid, order.Id, "ORDER_PAID")
+
+			eventually(t, func() error {
+				balance, err := h.Wallet.Balance(uid)
+				if err != nil {
+					return err
+				}
+				if balance != walletBonus-2*10 {
+					return fmt.Errorf("balance %v, want %v", balance, walletBonus-2*10)
+				}
+				return nil
+			})
+
+			publishers := map[string]contracts.Codec{
+				contracts.WalletCreateTopic:                tt.codecs.Registry,
+				contracts.WalletCreateResponseTopic:        tt.codecs.Wallet,
+				contracts.StorageReserveOrderTopic:         tt.codecs.Registry,
+				contracts.StorageReserveOrderResponseTopic: tt.codecs.Storage,
+				contracts.WalletPayOrderTopic:              tt.codecs.Registry,
+				contracts.WalletPayOrderResponseTopic:      tt.codecs.Wallet,
+			}
+			for topic, codec := range publishers {
+				m := readMessages(t, h, topic, 1)[0]
+				contentType, _ := m.Header(contracts.HeaderContentType)
+				if string(contentType) != codec.ContentType() {
+					t.Errorf("%s: content type %q, want %q", topic, contentType, codec.ContentType())
+				}
+
+				if e := decode(t, m); e.CorrelationId == "" {
+					t.Errorf("%s: correlation id is empty", topic)
+				}
+			}
+		})
+	}
+}
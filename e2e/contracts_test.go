@@ -0,0 +1,133 @@
+package e2e
+
+import (
+	"context"
+	"eCommerce/contracts"
+	"eCommerce/messaging"
+	"encoding/json"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strings"
+	"testing"
+)
+
+// TestEventEnvelopes checks that every event of the saga carries the order id as correlation id
+// and the id of the event which caused it as causation id.
+func TestEventEnvelopes(t *testing.T) {
+	h := startHarness(t)
+
+	uid := registerUser(t, h)
+	order := createOrder(t, h, uid, []orderItem{{Sku: "apple", Quantity: 1}})
+	awaitStatus(t, h, uid, order.Id, "ORDER_PAID")
+
+	topics := []string{
+		contracts.StorageReserveOrderTopic,
+		contracts.StorageReserveOrderResponseTopic,
+		contracts.WalletPayOrderTopic,
+		contracts.WalletPayOrderResponseTopic,
+	}
+
+	var cause *contracts.Envelope
+	for _, topic := range topics {
+		e := decode(t, readMessages(t, h, topic, 1)[0])
+
+		if e.CorrelationId != order.Id {
+			t.Errorf("%s: correlation id %s, want %s", topic, e.CorrelationId, order.Id)
+		}
+		if cause != nil && e.CausationId != cause.Id {
+			t.Errorf("%s: causation id %s, want %s", topic, e.CausationId, cause.Id)
+		}
+		cause = e
+	}
+}
+
+// TestLegacyCommand publishes the bare payload of the command, which was used before envelopes, and expects it
+// to be processed as the current version.
+func TestLegacyCommand(t *testing.T) {
+	h := startHarness(t)
+
+	orderId := primitive.NewObjectID().Hex()
+	publish(t, h, contracts.StorageReserveOrderTopic, orderId, `{"id":"`+orderId+`","items":[{"name":"pear","quantity":1}]}`)
+
+	m := readMessages(t, h, contracts.StorageReserveOrderResponseTopic, 1)[0]
+	e := decode(t, m)
+	if e.Type != contracts.ReserveOrderResult || e.Version != 2 {
+		t.Errorf("response %s v%d, want %s v2", e.Type, e.Version, contracts.ReserveOrderResult)
+	}
+
+	status, _ := m.Header(contracts.HeaderStatus)
+	if !contracts.IsSuccessStatus(status) {
+		message, _ := m.Header(contracts.HeaderMessage)
+		t.Fatalf("reservation failed: %s", message)
+	}
+
+	quantity, err := h.Storage.Quantity("pear")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quantity != 2 {
+		t.Errorf("quantity of pear %d, want 2", quantity)
+	}
+}
+
+// TestNamedItemsCommand publishes the command of the version 1, which references products by name. Names of
+// the products created before SKUs were introduced are their SKUs, so the order is reserved.
+func TestNamedItemsCommand(t *testing.T) {
+	h := startHarness(t)
+
+	orderId := primitive.NewObjectID().Hex()
+	publish(t, h, contracts.StorageReserveOrderTopic, orderId, `{"id":"1","type":"ReserveOrder","version":1,`+
+		`"payload":{"id":"`+orderId+`","items":[{"name":"apple","quantity":2},{"name":"pear","quantity":1}]}}`)
+
+	m := readMessages(t, h, contracts.StorageReserveOrderResponseTopic, 1)[0]
+	if status, _ := m.Header(contracts.HeaderStatus); !contracts.IsSuccessStatus(status) {
+		message, _ := m.Header(contracts.HeaderMessage)
+		t.Fatalf("reservation failed: %s", message)
+	}
+
+	order := new(contracts.Order)
+	if err := json.Unmarshal(decode(t, m).Payload, order); err != nil {
+		t.Fatal(err)
+	}
+	if len(order.Items) != 2 || order.Items[0].Sku != "apple" || order.Items[1].Sku != "pear" {
+		t.Errorf("items %+v, want apple and pear", order.Items)
+	}
+
+	assertQuantity(t, h, "apple", 3)
+	assertQuantity(t, h, "pear", 2)
+}
+
+// TestUnsupportedVersion expects the command of the unknown version to be moved to the dead letter topic.
+func TestUnsupportedVersion(t *testing.T) {
+	h := startHarness(t)
+
+	orderId := primitive.NewObjectID().Hex()
+	publish(t, h, contracts.StorageReserveOrderTopic, orderId, `{"id":"1","type":"ReserveOrder","version":99,"payload":{}}`)
+
+	m := readMessages(t, h, messaging.DeadLetterTopic(contracts.StorageReserveOrderTopic), 1)[0]
+	cause, _ := m.Header(messaging.HeaderDeadLetterError)
+	if !strings.Contains(string(cause), contracts.ErrUnsupportedVersion.Error()) {
+		t.Errorf("dead letter error %q, want %q", cause, contracts.ErrUnsupportedVersion)
+	}
+}
+
+func publish(t *testing.T, h *Harness, topic, key, value string) {
+	t.Helper()
+
+	err := h.Bus.Publish(context.Background(), messaging.Message{Topic: topic, Key: []byte(key), Value: []byte(value)})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// decode reads the envelope of the message with the codec of its content type.
+func decode(t *testing.T, m messaging.Message) *contracts.Envelope {
+	t.Helper()
+
+	contentType, _ := m.Header(contracts.HeaderContentType)
+	e, err := contracts.Decode(m.Topic, string(contentType), m.Value)
+	if err != nil {
+		t.Fatalf("%s: %v", m.Topic, err)
+	}
+
+	return e
+}
@@ -0,0 +1,67 @@
+package e2e
+
+import (
+	"eCommerce/telemetry"
+	"encoding/json"
+	"fmt"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestDiagnostics checks the readiness probe over the lifecycle of the services: consumers are not ready
+// before the start and after the stop, while the liveness probe responds all the time.
+func TestDiagnostics(t *testing.T) {
+	h, err := New(zap.NewNop().Sugar(), JSONCodecs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := telemetry.NewDiagnostics(zap.NewNop().Sugar(), 0)
+	d.AddCheck("bus", h.Bus.Ping)
+	d.AddCheck("registry", h.Registry.Ping)
+	d.AddCheck("storage", h.Storage.Ping)
+	d.AddCheck("wallet", h.Wallet.Ping)
+
+	probe := func(path string, status int) telemetry.ReadinessReport {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		d.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != status {
+			t.Fatalf("%s: status %d, want %d: %s", path, w.Code, status, w.Body)
+		}
+
+		var report telemetry.ReadinessReport
+		if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+			t.Fatal(err)
+		}
+		return report
+	}
+
+	report := probe("/readyz", http.StatusServiceUnavailable)
+	if report.Checks["bus"] != "ok" || report.Checks["registry"] == "ok" {
+		t.Errorf("not started: unexpected checks %v", report.Checks)
+	}
+
+	if err = h.Start(); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, func() error {
+		w := httptest.NewRecorder()
+		d.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		if w.Code != http.StatusOK {
+			return fmt.Errorf("not ready: %s", w.Body)
+		}
+		return nil
+	})
+	probe("/healthz", http.StatusOK)
+
+	h.Stop()
+	report = probe("/readyz", http.StatusServiceUnavailable)
+	if report.Checks["bus"] == "ok" {
+		t.Errorf("stopped: bus is ready")
+	}
+	probe("/healthz", http.StatusOK)
+}
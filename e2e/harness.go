@@ -0,0 +1,148 @@
+// Package e2e boots registry, storage and wallet inside a single process connected by the in-memory bus,
+// so the whole order saga is tested through the registry HTTP API without kafka and mongodb.
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"eCommerce/contracts"
+	"eCommerce/messaging"
+	registry "eCommerce/registry/inprocess"
+	storage "eCommerce/storage/inprocess"
+	wallet "eCommerce/wallet/inprocess"
+	"encoding/json"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+const (
+	busPartitions = 4
+	// shutdownTimeout limits draining of the consumers when the harness is stopped.
+	shutdownTimeout = 5 * time.Second
+)
+
+type Harness struct {
+	Bus      *messaging.MemoryBus
+	Registry *registry.Registry
+	Storage  *storage.Storage
+	Wallet   *wallet.Wallet
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Codecs are the codecs each service publishes its messages with.
+type Codecs struct {
+	Registry contracts.Codec
+	Storage  contracts.Codec
+	Wallet   contracts.Codec
+}
+
+// JSONCodecs publish all messages in JSON.
+var JSONCodecs = Codecs{Registry: contracts.JSONCodec{}, Storage: contracts.JSONCodec{}, Wallet: contracts.JSONCodec{}}
+
+func New(log *zap.SugaredLogger, codecs Codecs) (*Harness, error) {
+	h := new(Harness)
+	h.Bus = messaging.NewMemoryBus(busPartitions)
+	h.Registry = registry.New(log, h.Bus, codecs.Registry)
+
+	var err error
+	if h.Storage, err = storage.New(log, h.Bus, codecs.Storage); err != nil {
+		return nil, err
+	}
+
+	if h.Wallet, err = wallet.New(log, h.Bus, codecs.Wallet); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+func (h *Harness) Start() error {
+	h.ctx, h.cancel = context.WithCancel(context.Background())
+	if err := h.Registry.Start(h.ctx); err != nil {
+		return err
+	}
+	h.Storage.Start()
+	h.Wallet.Start()
+
+	return nil
+}
+
+// Stop shuts the services down with the default timeout.
+func (h *Harness) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	_ = h.Shutdown(ctx)
+}
+
+// Shutdown drains consumers of the services within the deadline of the context and closes the bus,
+// so all consumers are released. Returns the first error of the services.
+func (h *Harness) Shutdown(ctx context.Context) error {
+	err := h.Registry.Stop(ctx)
+	if storageErr := h.Storage.Stop(ctx); storageErr != nil && err == nil {
+		err = storageErr
+	}
+	if walletErr := h.Wallet.Stop(ctx); walletErr != nil && err == nil {
+		err = walletErr
+	}
+
+	h.cancel()
+	if busErr := h.Bus.Close(); busErr != nil && err == nil {
+		err = busErr
+	}
+
+	return err
+}
+
+// Do sends the request to the registry API on behalf of the user. Request is anonymous when uid is empty.
+func (h *Harness) Do(method, path, uid string, body interface{}) (*httptest.ResponseRecorder, error) {
+	r, err := newRequest(method, path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if uid != "" {
+		q := r.URL.Query()
+		q.Set("uid", uid)
+		r.URL.RawQuery = q.Encode()
+	}
+
+	w := httptest.NewRecorder()
+	h.Registry.Handler().ServeHTTP(w, r)
+
+	return w, nil
+}
+
+// DoStorage sends the request to the product administration API of the storage.
+func (h *Harness) DoStorage(method, path string, body interface{}) (*httptest.ResponseRecorder, error) {
+	r, err := newRequest(method, path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	w := httptest.NewRecorder()
+	h.Storage.Handler().ServeHTTP(w, r)
+
+	return w, nil
+}
+
+func newRequest(method, path string, body interface{}) (*http.Request, error) {
+	var reader io.Reader = http.NoBody
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	r := httptest.NewRequest(method, path, reader)
+	r.Header.Set("Content-Type", "application/json")
+
+	return r, nil
+}
@@ -0,0 +1,74 @@
+package e2e
+
+import (
+	"net/http"
+	"testing"
+)
+
+type movement struct {
+	Type          string `json:"type"`
+	Delta         int64  `json:"delta"`
+	Quantity      int64  `json:"quantity"`
+	OrderId       string `json:"order_id"`
+	ReservationId string `json:"reservation_id"`
+	Reason        string `json:"reason"`
+}
+
+type drift struct {
+	Sku   string `json:"sku"`
+	Drift int64  `json:"drift"`
+}
+
+// TestStockLedger records each change of the stock made by the order saga and the operator as the movement,
+// so the stock is reconciled with the movements.
+func TestStockLedger(t *testing.T) {
+	h := startHarness(t)
+
+	var products []product
+	storageRequest(t, h, http.MethodGet, "/products", nil, http.StatusOK, &products)
+	path := "/products/" + products[0].Id
+
+	uid := registerUser(t, h)
+	order := createOrder(t, h, uid, []orderItem{{Sku: "apple", Quantity: 2}})
+	awaitStatus(t, h, uid, order.Id, "ORDER_PAID")
+	awaitReservation(t, h, order.Id, "committed")
+
+	storageRequest(t, h, http.MethodPost, path+"/restock", stockRequest{Quantity: 3, Reason: "delivery"}, http.StatusOK, nil)
+	storageRequest(t, h, http.MethodPost, path+"/adjustments", stockRequest{Quantity: -1, Reason: "damaged"}, http.StatusOK, nil)
+	assertQuantity(t, h, "apple", 5)
+
+	var movements []movement
+	storageRequest(t, h, http.MethodGet, path+"/movements", nil, http.StatusOK, &movements)
+
+	want := []movement{
+		{Type: "opening", Delta: 5, Quantity: 5},
+		{Type: "reservation", Delta: -2, Quantity: 3, OrderId: order.Id},
+		{Type: "commit", Delta: 0, Quantity: 3, OrderId: order.Id},
+		{Type: "restock", Delta: 3, Quantity: 6, Reason: "delivery"},
+		{Type: "adjustment", Delta: -1, Quantity: 5, Reason: "damaged"},
+	}
+	if len(movements) != len(want) {
+		t.Fatalf("movements %+v, want %d", movements, len(want))
+	}
+	for i, m := range movements {
+		if m.Type != want[i].Type || m.Delta != want[i].Delta || m.Quantity != want[i].Quantity ||
+			m.Reason != want[i].Reason || (want[i].OrderId != "" && m.OrderId != want[i].OrderId) {
+			t.Errorf("movement %d %+v, want %+v", i, m, want[i])
+		}
+	}
+	if movements[1].ReservationId != movements[2].ReservationId {
+		t.Errorf("reservation %s is committed, want %s", movements[2].ReservationId, movements[1].ReservationId)
+	}
+
+	var page []movement
+	storageRequest(t, h, http.MethodGet, path+"/movements?page=1&size=2", nil, http.StatusOK, &page)
+	if len(page) != 2 || page[0].Type != "commit" {
+		t.Errorf("second page %+v, want commit and restock", page)
+	}
+
+	var drifts []drift
+	storageRequest(t, h, http.MethodGet, "/reconciliation", nil, http.StatusOK, &drifts)
+	if len(drifts) != 0 {
+		t.Errorf("drifts %+v, want none", drifts)
+	}
+}
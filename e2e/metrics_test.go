@@ -0,0 +1,105 @@
+package e2e
+
+import (
+	"eCommerce/contracts"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"testing"
+)
+
+// TestMetrics runs the paid and the rejected order and checks that the saga is reflected in the metrics.
+// Metrics of the services are shared by all tests of the process, so the changes are compared.
+func TestMetrics(t *testing.T) {
+	h := startHarness(t)
+
+	counters := []struct {
+		name   string
+		labels map[string]string
+		delta  float64
+	}{
+		{`registry_http_requests_total`, map[string]string{"method": "POST", "route": "/order", "status": "200"}, 2},
+		{`registry_order_transitions_total`, map[string]string{"from": "NONE", "to": "ORDER_PENDING"}, 2},
+		{`registry_order_transitions_total`, map[string]string{"from": "ORDER_PAYMENT_PENDING", "to": "ORDER_PAID"}, 1},
+		{`registry_order_transitions_total`, map[string]string{"from": "ORDER_RESERVATION_PENDING", "to": "ORDER_ERROR"}, 1},
+		{`registry_order_saga_duration_seconds`, map[string]string{"status": "ORDER_PAID"}, 1},
+		{`messaging_messages_produced_total`, map[string]string{"topic": contracts.StorageReserveOrderTopic, "result": "ok"}, 2},
+		{`messaging_messages_consumed_total`, map[string]string{"topic": contracts.StorageReserveOrderTopic, "result": "processed"}, 2},
+		{`messaging_handler_duration_seconds`, map[string]string{"topic": contracts.WalletPayOrderTopic, "result": "ok"}, 1},
+		{`wallet_payments_total`, map[string]string{"operation": "pay", "result": "success"}, 1},
+	}
+
+	before := make([]float64, len(counters))
+	for i, c := range counters {
+		before[i] = metricValue(t, prometheus.DefaultGatherer, c.name, c.labels)
+	}
+
+	uid := registerUser(t, h)
+	paid := createOrder(t, h, uid, []orderItem{{Sku: "apple", Quantity: 2}})
+	awaitStatus(t, h, uid, paid.Id, "ORDER_PAID")
+	rejected := createOrder(t, h, uid, []orderItem{{Sku: "pear", Quantity: 4}})
+	awaitStatus(t, h, uid, rejected.Id, "ORDER_ERROR")
+
+	for i, c := range counters {
+		if delta := metricValue(t, prometheus.DefaultGatherer, c.name, c.labels) - before[i]; delta != c.delta {
+			t.Errorf("%s%v: changed by %v, want %v", c.name, c.labels, delta, c.delta)
+		}
+	}
+
+	stock := prometheus.NewRegistry()
+	stock.MustRegister(h.Storage.StockCollector())
+	if apples := metricValue(t, stock, `storage_product_stock`, map[string]string{"sku": "apple"}); apples != 3 {
+		t.Errorf("apple stock %v, want 3", apples)
+	}
+	if pears := metricValue(t, stock, `storage_product_stock`, map[string]string{"sku": "pear"}); pears != 3 {
+		t.Errorf("pear stock %v, want 3", pears)
+	}
+}
+
+// metricValue sums the metrics of the family which have the labels. Counters and gauges are summed by value,
+// histograms by the number of observations.
+func metricValue(t *testing.T, g prometheus.Gatherer, name string, labels map[string]string) float64 {
+	t.Helper()
+
+	families, err := g.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sum float64
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+
+		for _, m := range f.GetMetric() {
+			if !hasLabels(m, labels) {
+				continue
+			}
+
+			switch {
+			case m.Counter != nil:
+				sum += m.Counter.GetValue()
+			case m.Gauge != nil:
+				sum += m.Gauge.GetValue()
+			case m.Histogram != nil:
+				sum += float64(m.Histogram.GetSampleCount())
+			}
+		}
+	}
+
+	return sum
+}
+
+func hasLabels(m *dto.Metric, labels map[string]string) bool {
+	matched := 0
+	for _, pair := range m.GetLabel() {
+		if value, ok := labels[pair.GetName()]; ok {
+			if value != pair.GetValue() {
+				return false
+			}
+			matched++
+		}
+	}
+
+	return matched == len(labels)
+}
@@ -0,0 +1,57 @@
+package e2e
+
+import (
+	"context"
+	"eCommerce/contracts"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"testing"
+)
+
+// TestPaymentBeforeWallet delivers the payment of the order before the command creating the wallet of the user.
+// Wallet retries the payment until the wallet is created, so the order is paid.
+func TestPaymentBeforeWallet(t *testing.T) {
+	h := startHarnessWithoutWallet(t)
+
+	// Command creating the wallet is held by the reader of the wallet group until the payment is attempted.
+	held, err := h.Bus.Subscribe(contracts.WalletCreateTopic, "wallet-create-group")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = held.Close() })
+
+	uid := registerUserWithoutWallet(t, h)
+	ctx, cancel := context.WithTimeout(context.Background(), sagaTimeout)
+	defer cancel()
+	if m, err := held.Fetch(ctx); err != nil || string(m.Key) != uid {
+		t.Fatalf("wallet command of %s is not held: %v", uid, err)
+	}
+
+	order := createOrder(t, h, uid, []orderItem{{Sku: "apple", Quantity: 2}})
+	awaitStatus(t, h, uid, order.Id, "ORDER_PAYMENT_PENDING")
+
+	failures := map[string]string{"topic": contracts.WalletPayOrderTopic}
+	before := metricValue(t, prometheus.DefaultGatherer, `messaging_handler_failures_total`, failures)
+	h.Wallet.Start()
+	eventually(t, func() error {
+		if metricValue(t, prometheus.DefaultGatherer, `messaging_handler_failures_total`, failures) == before {
+			return errors.New("payment is not attempted before the wallet is created")
+		}
+		return nil
+	})
+
+	// Released command is delivered to the wallet.
+	if err = held.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	awaitStatus(t, h, uid, order.Id, "ORDER_PAID")
+
+	balance, err := h.Wallet.Balance(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := float64(walletBonus - 2*10); balance != want {
+		t.Errorf("balance %v, want %v", balance, want)
+	}
+}
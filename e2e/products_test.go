@@ -0,0 +1,185 @@
+package e2e
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+type product struct {
+	Id       string            `json:"id"`
+	Sku      string            `json:"sku"`
+	Name     string            `json:"name"`
+	Variant  map[string]string `json:"variant"`
+	Cost     float64           `json:"cost"`
+	Quantity int64             `json:"quantity"`
+	Archived bool              `json:"archived"`
+}
+
+type productRequest struct {
+	Sku      string            `json:"sku,omitempty"`
+	Name     string            `json:"name"`
+	Variant  map[string]string `json:"variant,omitempty"`
+	Cost     float64           `json:"cost"`
+	Quantity int64             `json:"quantity"`
+}
+
+type stockRequest struct {
+	Quantity int64  `json:"quantity"`
+	Reason   string `json:"reason"`
+}
+
+type reservation struct {
+	OrderId string `json:"order_id"`
+	Status  string `json:"status"`
+}
+
+// TestProductAdministration manages the product through the storage API. Orders are reserved at the cost and
+// the stock set by the API, archived product is not reserved anymore.
+func TestProductAdministration(t *testing.T) {
+	h := startHarness(t)
+
+	plum := new(product)
+	storageRequest(t, h, http.MethodPost, "/products", productRequest{Sku: "plum", Name: "plum", Cost: 5}, http.StatusCreated, plum)
+	storageRequest(t, h, http.MethodPost, "/products", productRequest{Sku: "plum", Name: "prune", Cost: 5}, http.StatusConflict, nil)
+	storageRequest(t, h, http.MethodPost, "/products", productRequest{Sku: "", Name: "plum", Cost: 5}, http.StatusUnprocessableEntity, nil)
+	storageRequest(t, h, http.MethodPost, "/products", productRequest{Sku: "plum-2", Name: "", Cost: 5}, http.StatusUnprocessableEntity, nil)
+
+	path := "/products/" + plum.Id
+	storageRequest(t, h, http.MethodPost, path+"/restock", stockRequest{Quantity: 4}, http.StatusUnprocessableEntity, nil)
+	storageRequest(t, h, http.MethodPost, path+"/restock", stockRequest{Quantity: 4, Reason: "delivery"}, http.StatusOK, plum)
+	storageRequest(t, h, http.MethodPost, path+"/adjustments", stockRequest{Quantity: -5, Reason: "stocktaking"}, http.StatusConflict, nil)
+	storageRequest(t, h, http.MethodPost, path+"/adjustments", stockRequest{Quantity: -1, Reason: "stocktaking"}, http.StatusOK, plum)
+	storageRequest(t, h, http.MethodPut, path, productRequest{Name: "plum", Cost: 7}, http.StatusOK, plum)
+	if plum.Quantity != 3 || plum.Cost != 7 {
+		t.Fatalf("product %+v, want quantity 3 and cost 7", plum)
+	}
+
+	uid := registerUser(t, h)
+	order := createOrder(t, h, uid, []orderItem{{Sku: "plum", Quantity: 2}})
+	awaitStatus(t, h, uid, order.Id, "ORDER_PAID")
+	assertQuantity(t, h, "plum", 1)
+
+	// Reservation of the paid order refers to the product by SKU, so the product is renamed anyway.
+	storageRequest(t, h, http.MethodPut, path, productRequest{Name: "prune", Cost: 7}, http.StatusOK, plum)
+	if plum.Sku != "plum" || plum.Name != "prune" {
+		t.Fatalf("product %+v, want sku plum and name prune", plum)
+	}
+
+	storageRequest(t, h, http.MethodDelete, path, nil, http.StatusOK, plum)
+	if !plum.Archived {
+		t.Fatalf("product %+v is not archived", plum)
+	}
+	storageRequest(t, h, http.MethodPost, path+"/restock", stockRequest{Quantity: 1, Reason: "delivery"}, http.StatusConflict, nil)
+
+	var active, all []product
+	storageRequest(t, h, http.MethodGet, "/products", nil, http.StatusOK, &active)
+	storageRequest(t, h, http.MethodGet, "/products?archived=true", nil, http.StatusOK, &all)
+	if len(active) != 2 || len(all) != 3 {
+		t.Errorf("listed %d active and %d of all products, want 2 and 3", len(active), len(all))
+	}
+
+	order = createOrder(t, h, uid, []orderItem{{Sku: "plum", Quantity: 1}})
+	awaitStatus(t, h, uid, order.Id, "ORDER_ERROR")
+	assertQuantity(t, h, "plum", 1)
+
+	storageRequest(t, h, http.MethodGet, "/products/"+order.Id, nil, http.StatusNotFound, nil)
+	storageRequest(t, h, http.MethodGet, "/products/plum", nil, http.StatusBadRequest, nil)
+}
+
+// TestProductVariants orders variants of the product, each variant is reserved from its own stock at its own cost.
+func TestProductVariants(t *testing.T) {
+	h := startHarness(t)
+
+	small, large := new(product), new(product)
+	storageRequest(t, h, http.MethodPost, "/products", productRequest{
+		Sku: "shirt-s", Name: "shirt", Variant: map[string]string{"size": "S"}, Cost: 10, Quantity: 2,
+	}, http.StatusCreated, small)
+	storageRequest(t, h, http.MethodPost, "/products", productRequest{
+		Sku: "shirt-l", Name: "shirt", Variant: map[string]string{"size": "L"}, Cost: 12, Quantity: 1,
+	}, http.StatusCreated, large)
+	storageRequest(t, h, http.MethodPost, "/products", productRequest{
+		Sku: "shirt-m", Name: "shirt", Variant: map[string]string{"size": ""}, Cost: 11,
+	}, http.StatusUnprocessableEntity, nil)
+
+	uid := registerUser(t, h)
+	order := createOrder(t, h, uid, []orderItem{{Sku: "shirt-s", Quantity: 1}, {Sku: "shirt-l", Quantity: 1}})
+	awaitStatus(t, h, uid, order.Id, "ORDER_PAID")
+	assertQuantity(t, h, "shirt-s", 1)
+	assertQuantity(t, h, "shirt-l", 0)
+
+	w, _ := h.Do(http.MethodGet, "/order/"+order.Id, uid, nil)
+	paid := new(orderResponse)
+	if err := json.Unmarshal(w.Body.Bytes(), paid); err != nil {
+		t.Fatal(err)
+	}
+	if paid.Amount != 22 {
+		t.Errorf("amount %v, want 22", paid.Amount)
+	}
+
+	order = createOrder(t, h, uid, []orderItem{{Sku: "shirt-l", Quantity: 1}})
+	awaitStatus(t, h, uid, order.Id, "ORDER_ERROR")
+	assertQuantity(t, h, "shirt-s", 1)
+
+	var products []product
+	storageRequest(t, h, http.MethodGet, "/products", nil, http.StatusOK, &products)
+	var variants []string
+	for _, p := range products {
+		if p.Name == "shirt" {
+			variants = append(variants, p.Sku+"="+p.Variant["size"])
+		}
+	}
+	if len(variants) != 2 || variants[0] != "shirt-l=L" || variants[1] != "shirt-s=S" {
+		t.Errorf("variants %v, want shirt-l=L and shirt-s=S", variants)
+	}
+}
+
+// TestProductReservations lists the held reservations of the product until the order is paid.
+func TestProductReservations(t *testing.T) {
+	h := startHarnessWithoutWallet(t)
+
+	var products []product
+	storageRequest(t, h, http.MethodGet, "/products", nil, http.StatusOK, &products)
+	if len(products) != 1 {
+		t.Fatalf("listed %d products, want 1", len(products))
+	}
+	path := "/products/" + products[0].Id + "/reservations"
+
+	uid := registerUserWithoutWallet(t, h)
+	order := createOrder(t, h, uid, []orderItem{{Sku: "apple", Quantity: 2}})
+	awaitStatus(t, h, uid, order.Id, "ORDER_PAYMENT_PENDING")
+
+	var held []reservation
+	storageRequest(t, h, http.MethodGet, path, nil, http.StatusOK, &held)
+	if len(held) != 1 || held[0].OrderId != order.Id || held[0].Status != "held" {
+		t.Fatalf("reservations %+v, want held reservation of the order %s", held, order.Id)
+	}
+
+	h.Wallet.Start()
+	awaitStatus(t, h, uid, order.Id, "ORDER_PAID")
+	awaitReservation(t, h, order.Id, "committed")
+
+	storageRequest(t, h, http.MethodGet, path, nil, http.StatusOK, &held)
+	if len(held) != 0 {
+		t.Errorf("reservations %+v, want none", held)
+	}
+}
+
+// storageRequest sends the request to the storage API and decodes the response into the result when it is given.
+func storageRequest(t *testing.T, h *Harness, method, path string, body interface{}, status int, result interface{}) {
+	t.Helper()
+
+	w, err := h.DoStorage(method, path, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != status {
+		t.Fatalf("%s %s: status %d, want %d, body %s", method, path, w.Code, status, w.Body)
+	}
+
+	if result != nil {
+		if err = json.Unmarshal(w.Body.Bytes(), result); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"eCommerce/messaging"
+	"testing"
+)
+
+// TestRedeliveredCommands republishes commands of the paid order. Storage and wallet must answer them
+// with the recorded responses without reserving products and charging the wallet again.
+func TestRedeliveredCommands(t *testing.T) {
+	h := startHarness(t)
+
+	uid := registerUser(t, h)
+	order := createOrder(t, h, uid, []orderItem{{Sku: "apple", Quantity: 2}})
+	awaitStatus(t, h, uid, order.Id, "ORDER_PAID")
+
+	for _, topic := range []string{"storage-reserve-order", "wallet-pay-order"} {
+		command := readMessages(t, h, topic, 1)[0]
+		redelivered := messaging.Message{Topic: command.Topic, Key: command.Key, Value: command.Value, Headers: command.Headers}
+		if err := h.Bus.Publish(context.Background(), redelivered); err != nil {
+			t.Fatal(err)
+		}
+
+		responses := readMessages(t, h, topic+"-response", 2)
+		if !bytes.Equal(responses[0].Value, responses[1].Value) {
+			t.Errorf("%s: response %s, want %s", topic, responses[1].Value, responses[0].Value)
+		}
+		for _, key := range []string{"status", "message"} {
+			want, _ := responses[0].Header(key)
+			got, _ := responses[1].Header(key)
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s: response header %s %q, want %q", topic, key, got, want)
+			}
+		}
+	}
+
+	awaitStatus(t, h, uid, order.Id, "ORDER_PAID")
+
+	balance, err := h.Wallet.Balance(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := float64(walletBonus - 2*10); balance != want {
+		t.Errorf("balance %v, want %v", balance, want)
+	}
+
+	quantity, err := h.Storage.Quantity("apple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quantity != 3 {
+		t.Errorf("quantity of apple %d, want 3", quantity)
+	}
+}
+
+// readMessages reads n messages of the topic from the beginning.
+func readMessages(t *testing.T, h *Harness, topic string, n int) []messaging.Message {
+	t.Helper()
+
+	reader, err := h.Bus.Subscribe(topic, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), sagaTimeout)
+	defer cancel()
+
+	messages := make([]messaging.Message, 0, n)
+	for len(messages) < n {
+		m, err := reader.Fetch(ctx)
+		if err != nil {
+			t.Fatalf("%s: %d of %d messages read: %v", topic, len(messages), n, err)
+		}
+		messages = append(messages, m)
+	}
+
+	return messages
+}
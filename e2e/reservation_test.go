@@ -0,0 +1,126 @@
+package e2e
+
+import (
+	"context"
+	"eCommerce/contracts"
+	"fmt"
+	"go.uber.org/zap"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestCommittedReservation pays the order, so its reservation is committed and not released by the sweeper.
+func TestCommittedReservation(t *testing.T) {
+	h := startHarness(t)
+
+	uid := registerUser(t, h)
+	order := createOrder(t, h, uid, []orderItem{{Sku: "apple", Quantity: 2}})
+	awaitStatus(t, h, uid, order.Id, "ORDER_PAID")
+	awaitReservation(t, h, order.Id, "committed")
+
+	h.Storage.ExpireReservations(context.Background(), time.Now().Add(2*time.Hour))
+
+	awaitReservation(t, h, order.Id, "committed")
+	awaitStatus(t, h, uid, order.Id, "ORDER_PAID")
+	assertQuantity(t, h, "apple", 3)
+}
+
+// TestExpiredReservation expires the reservation of the order waiting for the payment. Order fails and products
+// are returned to the stock. Payment made after the order failed is refunded and the order is canceled.
+func TestExpiredReservation(t *testing.T) {
+	h := startHarnessWithoutWallet(t)
+
+	uid := registerUserWithoutWallet(t, h)
+	order := createOrder(t, h, uid, []orderItem{{Sku: "apple", Quantity: 2}})
+	awaitStatus(t, h, uid, order.Id, "ORDER_PAYMENT_PENDING")
+	assertQuantity(t, h, "apple", 3)
+
+	h.Storage.ExpireReservations(context.Background(), time.Now().Add(2*time.Hour))
+
+	awaitReservation(t, h, order.Id, "expired")
+	awaitStatus(t, h, uid, order.Id, "ORDER_ERROR")
+	assertQuantity(t, h, "apple", 5)
+
+	h.Wallet.Start()
+
+	refund := readMessages(t, h, "wallet-cancel-order-response", 1)[0]
+	if status, _ := refund.Header(contracts.HeaderStatus); !contracts.IsSuccessStatus(status) {
+		message, _ := refund.Header(contracts.HeaderMessage)
+		t.Fatalf("payment is not refunded: %s", message)
+	}
+
+	balance, err := h.Wallet.Balance(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != walletBonus {
+		t.Errorf("balance %v, want %v", balance, walletBonus)
+	}
+	awaitStatus(t, h, uid, order.Id, "ORDER_CANCELED")
+	assertQuantity(t, h, "apple", 5)
+}
+
+// startHarnessWithoutWallet starts registry and storage only, so orders are waiting for the payment
+// until the wallet is started.
+func startHarnessWithoutWallet(t *testing.T) *Harness {
+	t.Helper()
+
+	h, err := New(zap.NewNop().Sugar(), JSONCodecs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = h.Storage.AddProduct("apple", "Apple", 10, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	h.ctx, h.cancel = context.WithCancel(context.Background())
+	if err = h.Registry.Start(h.ctx); err != nil {
+		t.Fatal(err)
+	}
+	h.Storage.Start()
+	t.Cleanup(h.Stop)
+
+	return h
+}
+
+// registerUserWithoutWallet makes anonymous request, so the registry creates the user. Wallet of the user
+// is created when the wallet is started.
+func registerUserWithoutWallet(t *testing.T, h *Harness) string {
+	t.Helper()
+
+	w, _ := h.Do(http.MethodGet, "/orders", "", nil)
+	uid := w.Header().Get("X-UID")
+	if uid == "" {
+		t.Fatalf("user is not registered: status %d, body %s", w.Code, w.Body)
+	}
+
+	return uid
+}
+
+func awaitReservation(t *testing.T, h *Harness, orderId, status string) {
+	t.Helper()
+
+	eventually(t, func() error {
+		got, err := h.Storage.Reservation(orderId)
+		if err != nil {
+			return err
+		}
+		if got != status {
+			return fmt.Errorf("reservation status %s, want %s", got, status)
+		}
+		return nil
+	})
+}
+
+func assertQuantity(t *testing.T, h *Harness, sku string, want int64) {
+	t.Helper()
+
+	got, err := h.Storage.Quantity(sku)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("quantity of %s %d, want %d", sku, got, want)
+	}
+}
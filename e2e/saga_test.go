@@ -0,0 +1,215 @@
+package e2e
+
+import (
+	"encoding/json"
+	"fmt"
+	"go.uber.org/zap"
+	"net/http"
+	"testing"
+	"time"
+)
+
+const (
+	sagaTimeout  = 5 * time.Second
+	pollInterval = 10 * time.Millisecond
+
+	// walletBonus is the balance of the new customer wallet.
+	walletBonus = 100
+)
+
+type orderItem struct {
+	Sku      string `json:"sku"`
+	Quantity int64  `json:"quantity"`
+}
+
+type orderRequest struct {
+	Items []orderItem `json:"items"`
+}
+
+type orderResponse struct {
+	Id     string  `json:"id"`
+	Status string  `json:"status"`
+	Amount float64 `json:"amount"`
+}
+
+func TestOrderSaga(t *testing.T) {
+	tests := []struct {
+		name     string
+		items    []orderItem
+		cancel   bool
+		status   string
+		balance  float64
+		quantity map[string]int64
+	}{
+		{
+			name:     "paid",
+			items:    []orderItem{{Sku: "apple", Quantity: 2}, {Sku: "pear", Quantity: 1}},
+			status:   "ORDER_PAID",
+			balance:  walletBonus - 2*10 - 25,
+			quantity: map[string]int64{"apple": 3, "pear": 2},
+		},
+		{
+			name:     "out of stock",
+			items:    []orderItem{{Sku: "apple", Quantity: 1}, {Sku: "pear", Quantity: 4}},
+			status:   "ORDER_ERROR",
+			balance:  walletBonus,
+			quantity: map[string]int64{"apple": 5, "pear": 3},
+		},
+		{
+			name:     "insufficient funds",
+			items:    []orderItem{{Sku: "pear", Quantity: 3}, {Sku: "apple", Quantity: 3}},
+			status:   "ORDER_CANCELED",
+			balance:  walletBonus,
+			quantity: map[string]int64{"apple": 5, "pear": 3},
+		},
+		{
+			name:     "canceled by customer",
+			items:    []orderItem{{Sku: "apple", Quantity: 1}},
+			cancel:   true,
+			status:   "ORDER_CANCELED",
+			balance:  walletBonus,
+			quantity: map[string]int64{"apple": 5, "pear": 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := startHarness(t)
+
+			uid := registerUser(t, h)
+			order := createOrder(t, h, uid, tt.items)
+
+			if tt.cancel {
+				awaitStatus(t, h, uid, order.Id, "ORDER_PAID")
+				w, _ := h.Do(http.MethodDelete, "/order/"+order.Id, uid, nil)
+				if w.Code != http.StatusOK {
+					t.Fatalf("cancel order: status %d, body %s", w.Code, w.Body)
+				}
+			}
+
+			awaitStatus(t, h, uid, order.Id, tt.status)
+
+			// Compensations are finished together with the order status, but the wallet could be refunded concurrently.
+			eventually(t, func() error {
+				balance, err := h.Wallet.Balance(uid)
+				if err != nil {
+					return err
+				}
+				if balance != tt.balance {
+					return fmt.Errorf("balance %v, want %v", balance, tt.balance)
+				}
+				return nil
+			})
+
+			for sku, want := range tt.quantity {
+				got, err := h.Storage.Quantity(sku)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != want {
+					t.Errorf("quantity of %s %d, want %d", sku, got, want)
+				}
+			}
+		})
+	}
+}
+
+func startHarness(t *testing.T) *Harness {
+	t.Helper()
+
+	return startHarnessWith(t, JSONCodecs)
+}
+
+func startHarnessWith(t *testing.T, codecs Codecs) *Harness {
+	t.Helper()
+
+	h, err := New(zap.NewNop().Sugar(), codecs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = h.Storage.AddProduct("apple", "Apple", 10, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err = h.Storage.AddProduct("pear", "Pear", 25, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = h.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(h.Stop)
+
+	return h
+}
+
+// registerUser makes anonymous request, so the registry creates the user, and waits for the user wallet.
+func registerUser(t *testing.T, h *Harness) string {
+	t.Helper()
+
+	w, _ := h.Do(http.MethodGet, "/orders", "", nil)
+	uid := w.Header().Get("X-UID")
+	if uid == "" {
+		t.Fatalf("user is not registered: status %d, body %s", w.Code, w.Body)
+	}
+
+	eventually(t, func() error {
+		_, err := h.Wallet.Balance(uid)
+		return err
+	})
+
+	return uid
+}
+
+func createOrder(t *testing.T, h *Harness, uid string, items []orderItem) *orderResponse {
+	t.Helper()
+
+	w, _ := h.Do(http.MethodPost, "/order", uid, orderRequest{Items: items})
+	if w.Code != http.StatusOK {
+		t.Fatalf("create order: status %d, body %s", w.Code, w.Body)
+	}
+
+	order := new(orderResponse)
+	if err := json.Unmarshal(w.Body.Bytes(), order); err != nil {
+		t.Fatal(err)
+	}
+
+	return order
+}
+
+func awaitStatus(t *testing.T, h *Harness, uid, orderId, status string) {
+	t.Helper()
+
+	eventually(t, func() error {
+		w, _ := h.Do(http.MethodGet, "/order/"+orderId, uid, nil)
+		if w.Code != http.StatusOK {
+			return fmt.Errorf("get order: status %d, body %s", w.Code, w.Body)
+		}
+
+		order := new(orderResponse)
+		if err := json.Unmarshal(w.Body.Bytes(), order); err != nil {
+			return err
+		}
+		if order.Status != status {
+			return fmt.Errorf("order status %s, want %s", order.Status, status)
+		}
+		return nil
+	})
+}
+
+func eventually(t *testing.T, check func() error) {
+	t.Helper()
+
+	deadline := time.Now().Add(sagaTimeout)
+	for {
+		err := check()
+		if err == nil {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(pollInterval)
+	}
+}
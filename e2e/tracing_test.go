@@ -0,0 +1,77 @@
+package e2e
+
+import (
+	"eCommerce/contracts"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"sync"
+	"testing"
+)
+
+var (
+	recorderOnce sync.Once
+	recorder     *tracetest.SpanRecorder
+)
+
+// spanRecorder installs the global tracer provider recording spans in memory. Provider is installed
+// once, because tracers obtained before are bound to the first installed provider.
+func spanRecorder() *tracetest.SpanRecorder {
+	recorderOnce.Do(func() {
+		recorder = tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+
+	return recorder
+}
+
+// TestTracing checks that the order request and every step of the saga it causes share one trace.
+func TestTracing(t *testing.T) {
+	spans := spanRecorder()
+	h := startHarness(t)
+
+	uid := registerUser(t, h)
+	order := createOrder(t, h, uid, []orderItem{{Sku: "apple", Quantity: 1}})
+	awaitStatus(t, h, uid, order.Id, "ORDER_PAID")
+
+	var traceId trace.TraceID
+	for _, s := range spans.Ended() {
+		if s.Name() == "POST /order" {
+			traceId = s.SpanContext().TraceID()
+		}
+	}
+	if !traceId.IsValid() {
+		t.Fatal("span of the order request is not recorded")
+	}
+
+	expected := []string{
+		contracts.StorageReserveOrderTopic + " send",
+		contracts.StorageReserveOrderTopic + " process",
+		contracts.StorageReserveOrderResponseTopic + " send",
+		contracts.StorageReserveOrderResponseTopic + " process",
+		contracts.WalletPayOrderTopic + " send",
+		contracts.WalletPayOrderTopic + " process",
+		contracts.WalletPayOrderResponseTopic + " send",
+		contracts.WalletPayOrderResponseTopic + " process",
+	}
+
+	eventually(t, func() error {
+		names := make(map[string]bool)
+		for _, s := range spans.Ended() {
+			if s.SpanContext().TraceID() == traceId {
+				names[s.Name()] = true
+			}
+		}
+
+		for _, name := range expected {
+			if !names[name] {
+				return fmt.Errorf("span %q is not in the trace %s", name, traceId)
+			}
+		}
+		return nil
+	})
+}
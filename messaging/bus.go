@@ -0,0 +1,74 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+var (
+	ErrClosed     = errors.New(`messaging: closed`)
+	ErrNotRunning = errors.New(`messaging: consumer is not running`)
+)
+
+// Publisher writes messages to the topics.
+type Publisher interface {
+	// Publish writes messages synchronously. When only part of the messages failed, PublishErrors is returned.
+	Publish(ctx context.Context, messages ...Message) error
+	Close() error
+}
+
+// Subscriber creates readers of the topics.
+type Subscriber interface {
+	// Subscribe creates reader of the topic for the consumer group.
+	// Reader without group receives all messages of the topic and its acknowledgements are ignored.
+	Subscribe(topic, group string) (Reader, error)
+}
+
+// Reader receives messages of the topic.
+type Reader interface {
+	// Fetch blocks until the next message is available or context is done.
+	Fetch(ctx context.Context) (Message, error)
+	// Ack commits messages as processed by the group. Messages which are not acknowledged are delivered
+	// again to the group after the reader is closed.
+	Ack(ctx context.Context, messages ...Message) error
+	Close() error
+}
+
+// Pinger checks connectivity with the broker. Buses and readers implement it to report readiness of the service.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Bus is publisher and subscriber of the same broker.
+type Bus interface {
+	Publisher
+	Subscriber
+}
+
+// PublishErrors contains error of each message passed to Publish in the same order. Nil means message was published.
+type PublishErrors []error
+
+// publishError returns error of the i-th message passed to Publish which returned err.
+func publishError(err error, i int) error {
+	var errs PublishErrors
+	if errors.As(err, &errs) {
+		if i < len(errs) {
+			return errs[i]
+		}
+		return nil
+	}
+
+	return err
+}
+
+func (e PublishErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		if err != nil {
+			messages = append(messages, err.Error())
+		}
+	}
+
+	return `messaging: failed to publish messages: ` + strings.Join(messages, `; `)
+}
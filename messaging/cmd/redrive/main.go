@@ -0,0 +1,49 @@
+// Command redrive publishes messages of the dead letter topic back to the original topic.
+//
+//	redrive -brokers kafka:9092 -topic storage-reserve-order
+//
+// Messages are read by the consumer group, so each message is re-driven once even when the command is repeated.
+package main
+
+import (
+	"context"
+	"eCommerce/messaging"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func main() {
+	brokers := flag.String("brokers", "localhost:9093", "kafka broker address")
+	topic := flag.String("topic", "", "original topic, messages are read from its dead letter topic")
+	group := flag.String("group", "dlq-redrive", "consumer group of the dead letter topic")
+	idle := flag.Duration("idle", 10*time.Second, "stop when there are no messages for this period")
+	limit := flag.Int("limit", 0, "maximum number of messages to re-drive, 0 means all")
+	flag.Parse()
+
+	if *topic == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	bus := messaging.NewKafkaBus(*brokers)
+	defer bus.Close()
+
+	reader, err := bus.Subscribe(messaging.DeadLetterTopic(*topic), *group)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer reader.Close()
+
+	n, err := messaging.Redrive(ctx, reader, bus, *idle, *limit)
+	log.Printf("re-driven %d messages from %s to %s", n, messaging.DeadLetterTopic(*topic), *topic)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,336 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Handler processes the message. Returned error means the message was not processed and it is retried,
+// unless the error is permanent. Context carries the span of the message which continues the trace of the publisher.
+type Handler func(ctx context.Context, m *Message) error
+
+type RetryPolicy struct {
+	// MaxAttempts is number of attempts to process the message before it is moved to the dead letter topic.
+	MaxAttempts int
+	// MinBackoff and MaxBackoff are bounds of the exponential delay between attempts.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.MinBackoff
+	for i := 1; i < attempt && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+
+	return delay
+}
+
+// PoolConfig configures concurrent processing of messages.
+type PoolConfig struct {
+	// Workers is number of messages processed concurrently. Messages with the same key are processed by the same
+	// worker in the order of offsets.
+	Workers int
+	// QueueSize is number of fetched messages waiting for each worker. Fetching is blocked while the queue is full.
+	QueueSize int
+}
+
+// Consumer processes messages of the reader at least once. Message is acknowledged only after it was processed
+// or moved to the dead letter topic, so the message is delivered again when the consumer stops in between.
+// Messages are processed concurrently by the pool of workers, messages with the same key are processed one by one.
+// Failed message is retried with exponential backoff and blocks the following messages of its worker, so the order
+// of the key is kept.
+type Consumer struct {
+	log         *zap.SugaredLogger
+	reader      Reader
+	deadLetters Publisher
+	handler     Handler
+	policy      RetryPolicy
+	pool        PoolConfig
+	// running is set while Run is processing messages.
+	running int32
+
+	mu      sync.Mutex
+	started bool
+	stopped bool
+	// stop cancels fetching and retries, abort cancels the message being processed.
+	stop  context.CancelFunc
+	abort context.CancelFunc
+	done  chan struct{}
+}
+
+func NewConsumer(log *zap.SugaredLogger, reader Reader, deadLetters Publisher, handler Handler, policy RetryPolicy, pool PoolConfig) *Consumer {
+	c := new(Consumer)
+	c.log = log
+	c.reader = reader
+	c.deadLetters = deadLetters
+	c.handler = handler
+	c.policy = policy
+	c.pool = pool
+
+	if c.policy.MaxAttempts < 1 {
+		c.policy.MaxAttempts = 1
+	}
+	if c.pool.Workers < 1 {
+		c.pool.Workers = 1
+	}
+	if c.pool.QueueSize < 0 {
+		c.pool.QueueSize = 0
+	}
+
+	return c
+}
+
+// Run processes messages until the reader is closed, the context is done or the consumer is stopped.
+// Done context cancels the message being processed, use Stop to finish it first. Run is called once.
+func (c *Consumer) Run(ctx context.Context) {
+	ctx, abort := context.WithCancel(ctx)
+	stop, cancelStop := context.WithCancel(ctx)
+	defer abort()
+	defer cancelStop()
+
+	c.mu.Lock()
+	if c.stopped || c.started {
+		c.mu.Unlock()
+		return
+	}
+	c.started = true
+	c.stop, c.abort, c.done = cancelStop, abort, make(chan struct{})
+	defer close(c.done)
+	c.mu.Unlock()
+
+	atomic.StoreInt32(&c.running, 1)
+	defer atomic.StoreInt32(&c.running, 0)
+
+	offsets := newOffsetTracker()
+	queues := make([]chan *trackedMessage, c.pool.Workers)
+	wg := sync.WaitGroup{}
+	wg.Add(len(queues))
+	for i := range queues {
+		queues[i] = make(chan *trackedMessage, c.pool.QueueSize)
+		go func(queue <-chan *trackedMessage) {
+			defer wg.Done()
+			c.work(ctx, stop, queue, offsets)
+		}(queues[i])
+	}
+	defer wg.Wait()
+	defer func() {
+		for _, queue := range queues {
+			close(queue)
+		}
+	}()
+
+	for attempt := 1; ; attempt++ {
+		m, err := c.reader.Fetch(stop)
+		if errors.Is(err, ErrClosed) || stop.Err() != nil {
+			return
+		}
+		if err != nil {
+			c.log.Errorw("failed to fetch message", "err", err)
+			if !sleep(stop, c.policy.backoff(attempt)) {
+				return
+			}
+			continue
+		}
+		attempt = 0
+
+		select {
+		case queues[c.worker(m.Key)] <- offsets.add(m):
+		case <-stop.Done():
+			// Message is not dispatched and not acknowledged, it will be delivered again.
+			return
+		}
+	}
+}
+
+// work processes messages of the queue one by one. Messages left in the queue after the consumer is stopped are
+// skipped and will be delivered again, as well as the messages after them.
+func (c *Consumer) work(ctx, stop context.Context, queue <-chan *trackedMessage, offsets *offsetTracker) {
+	for m := range queue {
+		if stop.Err() != nil {
+			continue
+		}
+
+		if err := c.process(ctx, stop, &m.Message); err != nil {
+			// Consumer is stopped during retries, message is not acknowledged and will be delivered again.
+			continue
+		}
+
+		// Processed message is acknowledged even when the consumer is stopping, so it is not processed again.
+		offsets.complete(m, func(last Message) {
+			if err := c.reader.Ack(ctx, last); err != nil {
+				c.log.Errorw("failed to acknowledge message", "topic", last.Topic, "key", string(last.Key), "offset", last.Offset, "err", err)
+			}
+		})
+	}
+}
+
+// worker returns index of the worker processing messages with the key.
+func (c *Consumer) worker(key []byte) int {
+	h := fnv.New32a()
+	_, _ = h.Write(key)
+	return int(h.Sum32() % uint32(c.pool.Workers))
+}
+
+// Stop stops fetching messages and waits until the messages being processed are finished and acknowledged.
+// Retries of the failed message are interrupted, so it is delivered again after the restart. When the context
+// is done first, processing is canceled and the context error is returned.
+func (c *Consumer) Stop(ctx context.Context) error {
+	c.mu.Lock()
+	c.stopped = true
+	started, stop, abort, done := c.started, c.stop, c.abort, c.done
+	c.mu.Unlock()
+
+	if !started {
+		return nil
+	}
+
+	stop()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		abort()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// Ping reports readiness of the consumer. Returns ErrNotRunning when the consumer is not started or already stopped,
+// otherwise checks connectivity of the reader when it implements Pinger.
+func (c *Consumer) Ping(ctx context.Context) error {
+	if atomic.LoadInt32(&c.running) == 0 {
+		return ErrNotRunning
+	}
+
+	if pinger, ok := c.reader.(Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+
+	return nil
+}
+
+// StopConsumers stops the consumers concurrently, so they share the deadline of the context.
+// Returns the first error of the consumers.
+func StopConsumers(ctx context.Context, consumers ...*Consumer) error {
+	errs := make([]error, len(consumers))
+	wg := sync.WaitGroup{}
+	wg.Add(len(consumers))
+	for i, consumer := range consumers {
+		go func(i int, consumer *Consumer) {
+			defer wg.Done()
+			errs[i] = consumer.Stop(ctx)
+		}(i, consumer)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// process handles the message with the context of the consumer. Delays between attempts are interrupted
+// when the stop context is done.
+func (c *Consumer) process(ctx, stop context.Context, m *Message) (err error) {
+	ctx, span := traceProcess(ctx, m)
+	defer span.End()
+
+	attempt := 1
+	for ; ; attempt++ {
+		started := time.Now()
+		err = c.handler(ctx, m)
+		observeHandler(m, started, err)
+		if err == nil {
+			consumedMessages.WithLabelValues(m.Topic, resultProcessed).Inc()
+			return nil
+		}
+		span.RecordError(err)
+
+		if IsPermanent(err) || attempt >= c.policy.MaxAttempts {
+			break
+		}
+
+		delay := c.policy.backoff(attempt)
+		c.log.Warnw("message processing failed, retrying", "topic", m.Topic, "key", string(m.Key), "attempt", attempt, "delay", delay, "err", err, "trace_id", span.SpanContext().TraceID())
+		if !sleep(stop, delay) {
+			return stop.Err()
+		}
+	}
+
+	span.SetStatus(codes.Error, err.Error())
+	return c.deadLetter(ctx, stop, m, err, attempt)
+}
+
+// deadLetter publishes the message to the dead letter topic. Publishing is retried until it succeeds,
+// because the message is lost otherwise.
+func (c *Consumer) deadLetter(ctx, stop context.Context, m *Message, cause error, attempts int) error {
+	letter := NewDeadLetter(m, cause, attempts)
+	for attempt := 1; ; attempt++ {
+		err := c.deadLetters.Publish(ctx, letter)
+		if err == nil {
+			consumedMessages.WithLabelValues(m.Topic, resultDeadLetter).Inc()
+			c.log.Errorw("message moved to the dead letter topic", "topic", m.Topic, "key", string(m.Key), "dlq", letter.Topic, "attempts", attempts, "err", cause, "trace_id", trace.SpanContextFromContext(ctx).TraceID())
+			return nil
+		}
+
+		c.log.Errorw("failed to publish dead letter", "topic", letter.Topic, "key", string(m.Key), "err", err)
+		if !sleep(stop, c.policy.backoff(attempt)) {
+			return stop.Err()
+		}
+	}
+}
+
+// sleep waits for the duration. Returns false when the context is done earlier.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks the error which is not resolved by retries, e.g. malformed message.
+// Such message is moved to the dead letter topic without retries.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &permanentError{err: err}
+}
+
+func IsPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent)
+}
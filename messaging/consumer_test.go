@@ -0,0 +1,304 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	poolTopic     = `pool-test`
+	shutdownTopic = `shutdown-test`
+	retryTopic    = `retry-test`
+
+	testTimeout = time.Second
+)
+
+// TestConsumerPool processes messages of two keys by the pool of workers. Message of the second key is processed
+// while the first key is blocked, the following message of the first key waits for the previous one.
+// Offset is not committed past the blocked message.
+func TestConsumerPool(t *testing.T) {
+	bus := NewMemoryBus(1)
+	t.Cleanup(func() { _ = bus.Close() })
+
+	err := bus.Publish(context.Background(),
+		Message{Topic: poolTopic, Key: []byte("a"), Value: []byte("a1")},
+		Message{Topic: poolTopic, Key: []byte("b"), Value: []byte("b1")},
+		Message{Topic: poolTopic, Key: []byte("a"), Value: []byte("a2")},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := bus.Subscribe(poolTopic, "pool-group")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = reader.Close() })
+
+	mu := sync.Mutex{}
+	var processed []string
+	blocked, release, done := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	consumer := NewConsumer(zap.NewNop().Sugar(), reader, bus, func(ctx context.Context, m *Message) error {
+		if string(m.Value) == "a1" {
+			close(blocked)
+			<-release
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		processed = append(processed, string(m.Value))
+		if len(processed) == 3 {
+			close(done)
+		}
+
+		return nil
+	}, RetryPolicy{MaxAttempts: 1}, PoolConfig{Workers: 2, QueueSize: 4})
+
+	go consumer.Run(context.Background())
+	t.Cleanup(func() { _ = consumer.Stop(context.Background()) })
+
+	<-blocked
+	eventually(t, func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(processed) != 1 {
+			return fmt.Errorf("processed %v while a1 is blocked, want [b1]", processed)
+		}
+		return nil
+	})
+
+	mu.Lock()
+	if processed[0] != "b1" {
+		t.Errorf("processed %v while a1 is blocked, want [b1]", processed)
+	}
+	mu.Unlock()
+
+	if pending := bus.Pending(poolTopic, "pool-group"); pending != 3 {
+		t.Errorf("pending messages %d while a1 is blocked, want 3", pending)
+	}
+
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(testTimeout):
+		t.Fatal("messages are not processed")
+	}
+
+	mu.Lock()
+	if processed[1] != "a1" || processed[2] != "a2" {
+		t.Errorf("processed %v, want a1 before a2", processed)
+	}
+	mu.Unlock()
+
+	eventually(t, func() error {
+		if pending := bus.Pending(poolTopic, "pool-group"); pending != 0 {
+			return fmt.Errorf("pending messages %d, want 0", pending)
+		}
+		return nil
+	})
+}
+
+// TestGracefulShutdown stops the consumer while it processes the message. The message is finished and
+// acknowledged, the following message is left for the next consumer.
+func TestGracefulShutdown(t *testing.T) {
+	bus, consumer, started, release := startBlockingConsumer(t)
+	<-started
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- consumer.Stop(context.Background())
+	}()
+
+	select {
+	case err := <-stopped:
+		t.Fatalf("consumer stopped before the message was processed: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-stopped; err != nil {
+		t.Fatal(err)
+	}
+
+	if pending := bus.Pending(shutdownTopic, "shutdown-group"); pending != 1 {
+		t.Errorf("pending messages %d, want 1", pending)
+	}
+}
+
+// TestShutdownDeadline stops the consumer which does not finish the message before the deadline.
+// Processing is canceled and the message is delivered again.
+func TestShutdownDeadline(t *testing.T) {
+	bus, consumer, started, _ := startBlockingConsumer(t)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := consumer.Stop(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("stop error %v, want deadline exceeded", err)
+	}
+
+	if pending := bus.Pending(shutdownTopic, "shutdown-group"); pending != 2 {
+		t.Errorf("pending messages %d, want 2", pending)
+	}
+}
+
+// startBlockingConsumer publishes two messages and starts the consumer which blocks on the first message
+// until release is closed or its context is canceled.
+func startBlockingConsumer(t *testing.T) (*MemoryBus, *Consumer, <-chan struct{}, chan struct{}) {
+	t.Helper()
+
+	bus := NewMemoryBus(1)
+	t.Cleanup(func() { _ = bus.Close() })
+
+	err := bus.Publish(context.Background(),
+		Message{Topic: shutdownTopic, Key: []byte("1"), Value: []byte("first")},
+		Message{Topic: shutdownTopic, Key: []byte("1"), Value: []byte("second")},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := bus.Subscribe(shutdownTopic, "shutdown-group")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started, release := make(chan struct{}), make(chan struct{})
+	consumer := NewConsumer(zap.NewNop().Sugar(), reader, bus, func(ctx context.Context, m *Message) error {
+		if string(m.Value) != "first" {
+			t.Errorf("message %q is processed after the stop", m.Value)
+			return nil
+		}
+
+		close(started)
+		select {
+		case <-release:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}, RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}, PoolConfig{})
+
+	go consumer.Run(context.Background())
+
+	return bus, consumer, started, release
+}
+
+// TestConsumerRetries retries the failed message until it is processed. Message which is failed permanently
+// or runs out of attempts is moved to the dead letter topic with the number of attempts.
+func TestConsumerRetries(t *testing.T) {
+	tests := []struct {
+		name string
+		// failures are errors returned by the handler before it succeeds.
+		failures []error
+		attempts int
+		// deadLetter is the number of attempts recorded in the dead letter, empty when the message is processed.
+		deadLetter string
+	}{
+		{name: "processed", failures: []error{errors.New("timeout")}, attempts: 2},
+		{name: "out of attempts", failures: []error{errors.New("timeout"), errors.New("timeout"), errors.New("timeout")}, attempts: 3, deadLetter: "3"},
+		{name: "permanent", failures: []error{Permanent(errors.New("malformed"))}, attempts: 1, deadLetter: "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bus := NewMemoryBus(1)
+			t.Cleanup(func() { _ = bus.Close() })
+
+			if err := bus.Publish(context.Background(), Message{Topic: retryTopic, Key: []byte("1"), Value: []byte("value")}); err != nil {
+				t.Fatal(err)
+			}
+			reader, err := bus.Subscribe(retryTopic, "retry-group")
+			if err != nil {
+				t.Fatal(err)
+			}
+			letters, err := bus.Subscribe(DeadLetterTopic(retryTopic), "retry-dlq-group")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			mu := sync.Mutex{}
+			attempts := 0
+			consumer := NewConsumer(zap.NewNop().Sugar(), reader, bus, func(ctx context.Context, m *Message) error {
+				mu.Lock()
+				defer mu.Unlock()
+
+				attempts++
+				if attempts <= len(tt.failures) {
+					return tt.failures[attempts-1]
+				}
+				return nil
+			}, RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}, PoolConfig{})
+
+			go consumer.Run(context.Background())
+			t.Cleanup(func() { _ = consumer.Stop(context.Background()) })
+
+			eventually(t, func() error {
+				if pending := bus.Pending(retryTopic, "retry-group"); pending != 0 {
+					return fmt.Errorf("pending messages %d, want 0", pending)
+				}
+				return nil
+			})
+
+			mu.Lock()
+			if attempts != tt.attempts {
+				t.Errorf("attempts %d, want %d", attempts, tt.attempts)
+			}
+			mu.Unlock()
+
+			if pending := bus.Pending(DeadLetterTopic(retryTopic), "retry-dlq-group"); tt.deadLetter == "" {
+				if pending != 0 {
+					t.Fatalf("%d dead letters of the processed message", pending)
+				}
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+			defer cancel()
+			letter, err := letters.Fetch(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := letter.Header(HeaderDeadLetterAttempts); string(got) != tt.deadLetter {
+				t.Errorf("dead letter attempts %q, want %q", got, tt.deadLetter)
+			}
+			if got, _ := letter.Header(HeaderDeadLetterTopic); string(got) != retryTopic {
+				t.Errorf("dead letter of the topic %q, want %q", got, retryTopic)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{MinBackoff: time.Second, MaxBackoff: 5 * time.Second}
+
+	for attempt, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 10: 5 * time.Second} {
+		if got := policy.backoff(attempt); got != want {
+			t.Errorf("backoff of the attempt %d %s, want %s", attempt, got, want)
+		}
+	}
+}
+
+// eventually repeats the check until it succeeds or the test timeout is over.
+func eventually(t *testing.T, check func() error) {
+	t.Helper()
+
+	deadline := time.Now().Add(testTimeout)
+	for {
+		err := check()
+		if err == nil {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,87 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DeadLetterSuffix = `-dlq`
+
+	deadLetterHeaderPrefix    = `dlq-`
+	HeaderDeadLetterTopic     = `dlq-topic`
+	HeaderDeadLetterPartition = `dlq-partition`
+	HeaderDeadLetterOffset    = `dlq-offset`
+	HeaderDeadLetterError     = `dlq-error`
+	HeaderDeadLetterAttempts  = `dlq-attempts`
+	HeaderDeadLetterFailedAt  = `dlq-failed-at`
+)
+
+// DeadLetterTopic returns name of the dead letter topic of the topic.
+func DeadLetterTopic(topic string) string {
+	return topic + DeadLetterSuffix
+}
+
+// NewDeadLetter returns copy of the message addressed to the dead letter topic.
+// Headers describe the original position of the message, the last error and the number of attempts.
+func NewDeadLetter(m *Message, cause error, attempts int) Message {
+	letter := Message{
+		Topic:   DeadLetterTopic(m.Topic),
+		Key:     m.Key,
+		Value:   m.Value,
+		Headers: append([]Header(nil), m.Headers...),
+	}
+
+	letter.SetHeader(HeaderDeadLetterTopic, []byte(m.Topic))
+	letter.SetHeader(HeaderDeadLetterPartition, []byte(strconv.Itoa(m.Partition)))
+	letter.SetHeader(HeaderDeadLetterOffset, []byte(strconv.FormatInt(m.Offset, 10)))
+	letter.SetHeader(HeaderDeadLetterError, []byte(cause.Error()))
+	letter.SetHeader(HeaderDeadLetterAttempts, []byte(strconv.Itoa(attempts)))
+	letter.SetHeader(HeaderDeadLetterFailedAt, []byte(time.Now().UTC().Format(time.RFC3339Nano)))
+
+	return letter
+}
+
+// Redrive publishes messages of the dead letter reader back to their original topics without dead letter headers.
+// It stops when there is no message during the idle period or after the limit of messages, zero means no limit.
+func Redrive(ctx context.Context, reader Reader, publisher Publisher, idle time.Duration, limit int) (int, error) {
+	n := 0
+	for limit == 0 || n < limit {
+		fetchCtx, cancel := context.WithTimeout(ctx, idle)
+		letter, err := reader.Fetch(fetchCtx)
+		cancel()
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+
+		m := Message{Key: letter.Key, Value: letter.Value}
+		for _, h := range letter.Headers {
+			if h.Key == HeaderDeadLetterTopic {
+				m.Topic = string(h.Value)
+			}
+			if !strings.HasPrefix(h.Key, deadLetterHeaderPrefix) {
+				m.Headers = append(m.Headers, h)
+			}
+		}
+		if m.Topic == "" {
+			m.Topic = strings.TrimSuffix(letter.Topic, DeadLetterSuffix)
+		}
+
+		if err = publisher.Publish(ctx, m); err != nil {
+			return n, err
+		}
+
+		if err = reader.Ack(ctx, letter); err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	return n, nil
+}
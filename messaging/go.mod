@@ -0,0 +1,11 @@
+module eCommerce/messaging
+
+go 1.14
+
+require (
+	github.com/prometheus/client_golang v1.12.1
+	github.com/segmentio/kafka-go v0.4.26
+	go.opentelemetry.io/otel v1.4.1
+	go.opentelemetry.io/otel/trace v1.4.1
+	go.uber.org/zap v1.20.0
+)
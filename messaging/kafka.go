@@ -0,0 +1,282 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/segmentio/kafka-go"
+	"io"
+	"strconv"
+	"sync/atomic"
+)
+
+// Topic settings checked by EnsureTopics.
+const (
+	topicRetention     = `retention.ms`
+	topicCleanupPolicy = `cleanup.policy`
+)
+
+var baseReaderConfig = kafka.ReaderConfig{
+	MinBytes: 10e1,
+	MaxBytes: 10e3,
+}
+
+// KafkaBus implements Bus with segmentio kafka client.
+type KafkaBus struct {
+	addr   string
+	writer *kafka.Writer
+	config kafka.ReaderConfig
+}
+
+func NewKafkaBus(addr string) *KafkaBus {
+	b := new(KafkaBus)
+	b.addr = addr
+	b.writer = &kafka.Writer{
+		Addr:     kafka.TCP(addr),
+		Balancer: new(kafka.Hash),
+	}
+	b.config = baseReaderConfig
+	b.config.Brokers = []string{addr}
+
+	return b
+}
+
+func (b *KafkaBus) Publish(ctx context.Context, messages ...Message) (err error) {
+	messages, finish := tracePublish(ctx, messages)
+	defer func() {
+		finish(err)
+		countPublished(messages, err)
+	}()
+
+	out := make([]kafka.Message, len(messages))
+	for i, m := range messages {
+		out[i] = kafka.Message{
+			Topic:   m.Topic,
+			Key:     m.Key,
+			Value:   m.Value,
+			Headers: make([]kafka.Header, len(m.Headers)),
+		}
+		for j, h := range m.Headers {
+			out[i].Headers[j] = kafka.Header{Key: h.Key, Value: h.Value}
+		}
+	}
+
+	err = b.writer.WriteMessages(ctx, out...)
+
+	var writeErrors kafka.WriteErrors
+	if errors.As(err, &writeErrors) {
+		return PublishErrors(writeErrors)
+	}
+
+	return err
+}
+
+func (b *KafkaBus) Subscribe(topic, group string) (Reader, error) {
+	cfg := b.config
+	cfg.Topic = topic
+	cfg.GroupID = group
+
+	return &kafkaReader{reader: kafka.NewReader(cfg), group: group, brokers: cfg.Brokers}, nil
+}
+
+func (b *KafkaBus) Close() error {
+	return b.writer.Close()
+}
+
+// Ping checks that the broker of the writer is reachable and returns the cluster metadata.
+func (b *KafkaBus) Ping(ctx context.Context) error {
+	conn, err := dial(ctx, b.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Brokers()
+	return err
+}
+
+// EnsureTopics creates missing topics through the admin API of the cluster and checks partitions, replication
+// factor, retention and cleanup policy of all the topics. Topics created concurrently by other services are checked too.
+func (b *KafkaBus) EnsureTopics(ctx context.Context, topics ...TopicSpec) error {
+	if len(topics) == 0 {
+		return nil
+	}
+
+	client := &kafka.Client{Addr: kafka.TCP(b.addr)}
+
+	configs := make([]kafka.TopicConfig, len(topics))
+	for i, t := range topics {
+		configs[i] = kafka.TopicConfig{
+			Topic:             t.Name,
+			NumPartitions:     t.Partitions,
+			ReplicationFactor: t.ReplicationFactor,
+			ConfigEntries:     []kafka.ConfigEntry{{ConfigName: topicCleanupPolicy, ConfigValue: t.CleanupPolicy}},
+		}
+		if t.Retention > 0 {
+			configs[i].ConfigEntries = append(configs[i].ConfigEntries, kafka.ConfigEntry{ConfigName: topicRetention, ConfigValue: retentionMs(t)})
+		}
+	}
+
+	created, err := client.CreateTopics(ctx, &kafka.CreateTopicsRequest{Topics: configs})
+	if err != nil {
+		return err
+	}
+	for topic, err := range created.Errors {
+		if err != nil && !errors.Is(err, kafka.TopicAlreadyExists) {
+			return fmt.Errorf("create topic %s: %w", topic, err)
+		}
+	}
+
+	return b.checkTopics(ctx, client, topics)
+}
+
+// checkTopics compares the topics of the cluster with the specs.
+func (b *KafkaBus) checkTopics(ctx context.Context, client *kafka.Client, topics []TopicSpec) error {
+	names := make([]string, len(topics))
+	resources := make([]kafka.DescribeConfigRequestResource, len(topics))
+	for i, t := range topics {
+		names[i] = t.Name
+		resources[i] = kafka.DescribeConfigRequestResource{
+			ResourceType: kafka.ResourceTypeTopic,
+			ResourceName: t.Name,
+			ConfigNames:  []string{topicRetention, topicCleanupPolicy},
+		}
+	}
+
+	metadata, err := client.Metadata(ctx, &kafka.MetadataRequest{Topics: names})
+	if err != nil {
+		return err
+	}
+	described, err := client.DescribeConfigs(ctx, &kafka.DescribeConfigsRequest{Resources: resources})
+	if err != nil {
+		return err
+	}
+
+	partitions := make(map[string][]kafka.Partition, len(metadata.Topics))
+	for _, t := range metadata.Topics {
+		if t.Error != nil {
+			return fmt.Errorf("describe topic %s: %w", t.Name, t.Error)
+		}
+		partitions[t.Name] = t.Partitions
+	}
+
+	settings := make(map[string]map[string]string, len(described.Resources))
+	for _, r := range described.Resources {
+		if r.Error != nil {
+			return fmt.Errorf("describe configs of topic %s: %w", r.ResourceName, r.Error)
+		}
+
+		settings[r.ResourceName] = make(map[string]string, len(r.ConfigEntries))
+		for _, e := range r.ConfigEntries {
+			settings[r.ResourceName][e.ConfigName] = e.ConfigValue
+		}
+	}
+
+	mismatch := topicMismatch{}
+	for _, t := range topics {
+		p := partitions[t.Name]
+		mismatch.compare(t.Name, `partitions`, t.Partitions, len(p))
+		if len(p) > 0 {
+			mismatch.compare(t.Name, `replication factor`, t.ReplicationFactor, len(p[0].Replicas))
+		}
+		mismatch.compare(t.Name, topicCleanupPolicy, t.CleanupPolicy, settings[t.Name][topicCleanupPolicy])
+		if t.Retention > 0 {
+			mismatch.compare(t.Name, topicRetention, retentionMs(t), settings[t.Name][topicRetention])
+		}
+	}
+
+	return mismatch.err()
+}
+
+func retentionMs(t TopicSpec) string {
+	return strconv.FormatInt(t.Retention.Milliseconds(), 10)
+}
+
+// dial connects to the broker. Deadline of the context is applied to the requests of the connection.
+func dial(ctx context.Context, addr string) (*kafka.Conn, error) {
+	conn, err := kafka.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err = conn.SetDeadline(deadline); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+type kafkaReader struct {
+	reader  *kafka.Reader
+	group   string
+	brokers []string
+	closed  int32
+}
+
+// Ping checks that partitions of the topic can be read from the broker.
+func (r *kafkaReader) Ping(ctx context.Context) error {
+	var err error
+	for _, addr := range r.brokers {
+		var conn *kafka.Conn
+		if conn, err = dial(ctx, addr); err != nil {
+			continue
+		}
+
+		_, err = conn.ReadPartitions(r.reader.Config().Topic)
+		_ = conn.Close()
+		if err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+// Fetch returns ErrClosed after the reader is closed, as the in-memory reader does.
+func (r *kafkaReader) Fetch(ctx context.Context) (Message, error) {
+	m, err := r.reader.FetchMessage(ctx)
+	if err != nil {
+		// Closed kafka reader returns io.EOF, fetch blocked by Close could return the error of its canceled context.
+		if atomic.LoadInt32(&r.closed) == 1 || errors.Is(err, io.EOF) {
+			return Message{}, ErrClosed
+		}
+		return Message{}, err
+	}
+
+	headers := make([]Header, len(m.Headers))
+	for i, h := range m.Headers {
+		headers[i] = Header{Key: h.Key, Value: h.Value}
+	}
+
+	return Message{
+		Topic:     m.Topic,
+		Key:       m.Key,
+		Value:     m.Value,
+		Headers:   headers,
+		Partition: m.Partition,
+		Offset:    m.Offset,
+		Time:      m.Time,
+	}, nil
+}
+
+func (r *kafkaReader) Ack(ctx context.Context, messages ...Message) error {
+	// Offsets of the reader without group are not stored by the broker.
+	if r.group == "" || len(messages) == 0 {
+		return nil
+	}
+
+	out := make([]kafka.Message, len(messages))
+	for i, m := range messages {
+		out[i] = kafka.Message{Topic: m.Topic, Partition: m.Partition, Offset: m.Offset}
+	}
+
+	return r.reader.CommitMessages(ctx, out...)
+}
+
+func (r *kafkaReader) Close() error {
+	atomic.StoreInt32(&r.closed, 1)
+	return r.reader.Close()
+}
@@ -0,0 +1,328 @@
+package messaging
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// MemoryBus implements Bus in the process memory. It keeps every published message and
+// delivers it to each consumer group once, preserving the order of the messages with the same key.
+type MemoryBus struct {
+	mu         sync.Mutex
+	partitions int
+	topics     map[string]*memoryTopic
+	closed     bool
+}
+
+type memoryTopic struct {
+	partitions [][]Message
+	groups     map[string]*memoryGroup
+	notify     chan struct{}
+}
+
+type memoryGroup struct {
+	// next is the offset of the next message to deliver for each partition.
+	next []int64
+	// committed is the offset after the last acknowledged message for each partition.
+	committed []int64
+	// owners are readers fetching the partition. Partition is owned by a single reader of the group, so
+	// messages with the same key are processed in order even when the group has several readers.
+	owners []*memoryReader
+}
+
+func newMemoryGroup(partitions int) *memoryGroup {
+	return &memoryGroup{
+		next:      make([]int64, partitions),
+		committed: make([]int64, partitions),
+		owners:    make([]*memoryReader, partitions),
+	}
+}
+
+func NewMemoryBus(partitions int) *MemoryBus {
+	if partitions < 1 {
+		partitions = 1
+	}
+
+	b := new(MemoryBus)
+	b.partitions = partitions
+	b.topics = make(map[string]*memoryTopic)
+
+	return b
+}
+
+func (b *MemoryBus) topic(name string) *memoryTopic {
+	t, ok := b.topics[name]
+	if !ok {
+		t = &memoryTopic{
+			partitions: make([][]Message, b.partitions),
+			groups:     make(map[string]*memoryGroup),
+			notify:     make(chan struct{}),
+		}
+		b.topics[name] = t
+	}
+
+	return t
+}
+
+func (b *MemoryBus) partition(key []byte) int {
+	if len(key) == 0 {
+		return 0
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write(key)
+
+	return int(h.Sum32() % uint32(b.partitions))
+}
+
+func (b *MemoryBus) Publish(ctx context.Context, messages ...Message) (err error) {
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	messages, finish := tracePublish(ctx, messages)
+	defer func() {
+		finish(err)
+		countPublished(messages, err)
+	}()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return ErrClosed
+	}
+
+	touched := make(map[*memoryTopic]struct{})
+	for _, m := range messages {
+		t := b.topic(m.Topic)
+		p := b.partition(m.Key)
+
+		m.Partition = p
+		m.Offset = int64(len(t.partitions[p]))
+		m.Time = time.Now()
+		m.Headers = append([]Header(nil), m.Headers...)
+		t.partitions[p] = append(t.partitions[p], m)
+
+		touched[t] = struct{}{}
+	}
+
+	for t := range touched {
+		close(t.notify)
+		t.notify = make(chan struct{})
+	}
+
+	return nil
+}
+
+func (b *MemoryBus) Subscribe(topic, group string) (Reader, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, ErrClosed
+	}
+
+	r := &memoryReader{bus: b, topic: topic, group: group}
+
+	t := b.topic(topic)
+	if group == "" {
+		r.own = newMemoryGroup(b.partitions)
+		return r, nil
+	}
+
+	if _, ok := t.groups[group]; !ok {
+		t.groups[group] = newMemoryGroup(b.partitions)
+	}
+
+	return r, nil
+}
+
+// Close stops the bus. Blocked readers return ErrClosed.
+func (b *MemoryBus) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil
+	}
+
+	b.closed = true
+	for _, t := range b.topics {
+		close(t.notify)
+		t.notify = make(chan struct{})
+	}
+
+	return nil
+}
+
+// Ping returns ErrClosed after the bus is closed.
+func (b *MemoryBus) Ping(_ context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return ErrClosed
+	}
+
+	return nil
+}
+
+// EnsureTopics creates missing topics. Topics of the memory bus have partitions of the bus and keep messages
+// until the bus is closed, so other settings of the specs are ignored.
+func (b *MemoryBus) EnsureTopics(_ context.Context, topics ...TopicSpec) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return ErrClosed
+	}
+
+	for _, t := range topics {
+		b.topic(t.Name)
+	}
+
+	return nil
+}
+
+// Pending returns number of messages of the topic which are not acknowledged by the group.
+func (b *MemoryBus) Pending(topic, group string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	t, ok := b.topics[topic]
+	if !ok {
+		return 0
+	}
+
+	var committed []int64
+	if g, ok := t.groups[group]; ok {
+		committed = g.committed
+	}
+
+	pending := 0
+	for p, messages := range t.partitions {
+		pending += len(messages)
+		if committed != nil {
+			pending -= int(committed[p])
+		}
+	}
+
+	return pending
+}
+
+type memoryReader struct {
+	bus   *MemoryBus
+	topic string
+	group string
+	// own is the cursor of the reader without group.
+	own    *memoryGroup
+	closed bool
+}
+
+func (r *memoryReader) cursor(t *memoryTopic) *memoryGroup {
+	if r.own != nil {
+		return r.own
+	}
+
+	return t.groups[r.group]
+}
+
+func (r *memoryReader) Fetch(ctx context.Context) (Message, error) {
+	for {
+		r.bus.mu.Lock()
+		if r.closed || r.bus.closed {
+			r.bus.mu.Unlock()
+			return Message{}, ErrClosed
+		}
+
+		t := r.bus.topic(r.topic)
+		g := r.cursor(t)
+		for p, messages := range t.partitions {
+			if g.owners[p] != nil && g.owners[p] != r {
+				continue
+			}
+
+			if g.next[p] < int64(len(messages)) {
+				m := messages[g.next[p]]
+				g.owners[p] = r
+				g.next[p]++
+				r.bus.mu.Unlock()
+
+				m.Headers = append([]Header(nil), m.Headers...)
+				return m, nil
+			}
+		}
+
+		notify := t.notify
+		r.bus.mu.Unlock()
+
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return Message{}, ctx.Err()
+		}
+	}
+}
+
+func (r *memoryReader) Ack(ctx context.Context, messages ...Message) error {
+	if r.group == "" {
+		return nil
+	}
+
+	r.bus.mu.Lock()
+	defer r.bus.mu.Unlock()
+
+	g := r.bus.topic(r.topic).groups[r.group]
+	for _, m := range messages {
+		if m.Offset+1 > g.committed[m.Partition] {
+			g.committed[m.Partition] = m.Offset + 1
+		}
+	}
+
+	return nil
+}
+
+// Ping returns ErrClosed after the reader or the bus is closed.
+func (r *memoryReader) Ping(_ context.Context) error {
+	r.bus.mu.Lock()
+	defer r.bus.mu.Unlock()
+
+	if r.closed || r.bus.closed {
+		return ErrClosed
+	}
+
+	return nil
+}
+
+// Close releases partitions of the reader. Messages which were fetched but not acknowledged
+// are delivered again to the next reader of the group.
+func (r *memoryReader) Close() error {
+	r.bus.mu.Lock()
+	defer r.bus.mu.Unlock()
+
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+
+	if r.group == "" {
+		return nil
+	}
+
+	t := r.bus.topic(r.topic)
+	g := t.groups[r.group]
+	for p, owner := range g.owners {
+		if owner == r {
+			g.owners[p] = nil
+			g.next[p] = g.committed[p]
+		}
+	}
+
+	close(t.notify)
+	t.notify = make(chan struct{})
+
+	return nil
+}
@@ -0,0 +1,116 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+const orderTopic = `order-test`
+
+// TestMemoryBusKeyOrder fetches messages of several keys by two readers of the group. Messages with the same key
+// are stored in a single partition and fetched in the order they are published by the reader owning the partition.
+func TestMemoryBusKeyOrder(t *testing.T) {
+	bus := NewMemoryBus(4)
+	t.Cleanup(func() { _ = bus.Close() })
+
+	keys := []string{"a", "b", "c", "d", "e", "f"}
+	const perKey = 3
+
+	var messages []Message
+	for i := 0; i < perKey; i++ {
+		for _, key := range keys {
+			messages = append(messages, Message{Topic: orderTopic, Key: []byte(key), Value: []byte(fmt.Sprintf("%s%d", key, i))})
+		}
+	}
+	if err := bus.Publish(context.Background(), messages...); err != nil {
+		t.Fatal(err)
+	}
+
+	readers := make([]Reader, 2)
+	for i := range readers {
+		reader, err := bus.Subscribe(orderTopic, "order-group")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = reader.Close() })
+		readers[i] = reader
+	}
+
+	fetched := make(map[string][]Message)
+	owners := make(map[string]int)
+	deadline := time.Now().Add(testTimeout)
+	for n := 0; n < len(messages); {
+		if time.Now().After(deadline) {
+			t.Fatalf("fetched %d of %d messages", n, len(messages))
+		}
+
+		for i, reader := range readers {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			m, err := reader.Fetch(ctx)
+			cancel()
+			if errors.Is(err, context.DeadlineExceeded) {
+				continue
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			key := string(m.Key)
+			if owner, ok := owners[key]; ok && owner != i {
+				t.Errorf("key %s is fetched by readers %d and %d", key, owner, i)
+			}
+			owners[key] = i
+			fetched[key] = append(fetched[key], m)
+			n++
+		}
+	}
+
+	for _, key := range keys {
+		if len(fetched[key]) != perKey {
+			t.Fatalf("fetched %d messages of the key %s, want %d", len(fetched[key]), key, perKey)
+		}
+		for i, m := range fetched[key] {
+			if want := fmt.Sprintf("%s%d", key, i); string(m.Value) != want {
+				t.Errorf("message %d of the key %s is %s, want %s", i, key, m.Value, want)
+			}
+			if m.Partition != fetched[key][0].Partition {
+				t.Errorf("key %s is stored in partitions %d and %d", key, fetched[key][0].Partition, m.Partition)
+			}
+		}
+	}
+}
+
+// Fetch blocked by close and fetch of the closed reader return ErrClosed, so the consumer stops instead of retrying the fetch.
+func TestMemoryReaderClosed(t *testing.T) {
+	bus := NewMemoryBus(1)
+	t.Cleanup(func() { _ = bus.Close() })
+
+	reader, err := bus.Subscribe(orderTopic, "closed-group")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetched := make(chan error, 1)
+	go func() {
+		_, err := reader.Fetch(context.Background())
+		fetched <- err
+	}()
+
+	if err = reader.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err = <-fetched:
+		if !errors.Is(err, ErrClosed) {
+			t.Errorf("fetch blocked by close: %v, want %v", err, ErrClosed)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("fetch is blocked after close")
+	}
+	if _, err = reader.Fetch(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Errorf("fetch after close: %v, want %v", err, ErrClosed)
+	}
+}
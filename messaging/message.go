@@ -0,0 +1,45 @@
+package messaging
+
+import "time"
+
+type Header struct {
+	Key   string
+	Value []byte
+}
+
+// Message is the unit of data published to the topic.
+// Messages with the same key are delivered to the subscribers of the group in the order they were published.
+type Message struct {
+	Topic   string
+	Key     []byte
+	Value   []byte
+	Headers []Header
+
+	// Partition, Offset and Time are set for the message received by the subscriber.
+	Partition int
+	Offset    int64
+	Time      time.Time
+}
+
+// Header returns value of the first header with the key.
+func (m *Message) Header(key string) ([]byte, bool) {
+	for _, h := range m.Headers {
+		if h.Key == key {
+			return h.Value, true
+		}
+	}
+
+	return nil, false
+}
+
+// SetHeader replaces value of the header with the key or adds new header.
+func (m *Message) SetHeader(key string, value []byte) {
+	for i := range m.Headers {
+		if m.Headers[i].Key == key {
+			m.Headers[i].Value = value
+			return
+		}
+	}
+
+	m.Headers = append(m.Headers, Header{Key: key, Value: value})
+}
@@ -0,0 +1,67 @@
+package messaging
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"time"
+)
+
+// Results of the published and consumed messages.
+const (
+	resultOk         = `ok`
+	resultError      = `error`
+	resultProcessed  = `processed`
+	resultDeadLetter = `dead_letter`
+)
+
+// Metrics of the messages are registered in the default prometheus registry, so they are served
+// by the diagnostics server of each service.
+var (
+	producedMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: `messaging`,
+		Name:      `messages_produced_total`,
+		Help:      `Messages published to the topic by result: ok or error.`,
+	}, []string{`topic`, `result`})
+
+	consumedMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: `messaging`,
+		Name:      `messages_consumed_total`,
+		Help:      `Messages of the topic consumed by result: processed or moved to the dead letter topic.`,
+	}, []string{`topic`, `result`})
+
+	handlerFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: `messaging`,
+		Name:      `handler_failures_total`,
+		Help:      `Failed attempts to process the message of the topic.`,
+	}, []string{`topic`})
+
+	handlerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: `messaging`,
+		Name:      `handler_duration_seconds`,
+		Help:      `Duration of the attempt to process the message of the topic by result: ok or error.`,
+		Buckets:   prometheus.DefBuckets,
+	}, []string{`topic`, `result`})
+)
+
+// countPublished counts each message by the result of the publishing.
+func countPublished(messages []Message, err error) {
+	for i, m := range messages {
+		result := resultOk
+		if publishError(err, i) != nil {
+			result = resultError
+		}
+
+		producedMessages.WithLabelValues(m.Topic, result).Inc()
+	}
+}
+
+// observeHandler records duration of the attempt to process the message.
+func observeHandler(m *Message, started time.Time, err error) {
+	result := resultOk
+	if err != nil {
+		result = resultError
+		handlerFailures.WithLabelValues(m.Topic).Inc()
+	}
+
+	handlerDuration.WithLabelValues(m.Topic, result).Observe(time.Since(started).Seconds())
+}
@@ -0,0 +1,67 @@
+package messaging
+
+import "sync"
+
+type partitionKey struct {
+	topic     string
+	partition int
+}
+
+// trackedMessage is the fetched message which is not acknowledged yet.
+type trackedMessage struct {
+	Message
+	done bool
+}
+
+// offsetTracker acknowledges messages which are processed out of order. Offset of the partition is committed
+// only up to the oldest message still in progress, so no message is skipped when the consumer restarts.
+type offsetTracker struct {
+	mu         sync.Mutex
+	partitions map[partitionKey][]*trackedMessage
+}
+
+func newOffsetTracker() *offsetTracker {
+	t := new(offsetTracker)
+	t.partitions = make(map[partitionKey][]*trackedMessage)
+	return t
+}
+
+// add registers the fetched message. Messages of the partition are added in the order of offsets.
+func (t *offsetTracker) add(m Message) *trackedMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tracked := &trackedMessage{Message: m}
+	key := partitionKey{topic: m.Topic, partition: m.Partition}
+	t.partitions[key] = append(t.partitions[key], tracked)
+
+	return tracked
+}
+
+// complete marks the message processed and acknowledges the last message of the processed prefix of the partition.
+// Acknowledgement is made under the lock, so commits of the partition never go backwards.
+func (t *offsetTracker) complete(m *trackedMessage, ack func(m Message)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	m.done = true
+	key := partitionKey{topic: m.Topic, partition: m.Partition}
+	pending := t.partitions[key]
+
+	n := 0
+	for n < len(pending) && pending[n].done {
+		n++
+	}
+	if n == 0 {
+		return
+	}
+
+	last := pending[n-1].Message
+	if n == len(pending) {
+		delete(t.partitions, key)
+	} else {
+		t.partitions[key] = pending[n:]
+	}
+
+	ack(last)
+}
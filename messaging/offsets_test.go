@@ -0,0 +1,58 @@
+package messaging
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOffsetTrackerAcknowledgesProcessedPrefix(t *testing.T) {
+	tracker := newOffsetTracker()
+	first, second, third := tracker.add(offset(0, 1)), tracker.add(offset(0, 2)), tracker.add(offset(0, 3))
+
+	var acked []int64
+	ack := func(m Message) { acked = append(acked, m.Offset) }
+
+	// Messages processed before the oldest one are not acknowledged.
+	tracker.complete(third, ack)
+	tracker.complete(second, ack)
+	if len(acked) != 0 {
+		t.Fatalf("acknowledged %v while the first message is in progress", acked)
+	}
+
+	// The oldest message acknowledges the whole processed prefix at once.
+	tracker.complete(first, ack)
+	if want := []int64{3}; !reflect.DeepEqual(acked, want) {
+		t.Fatalf("acknowledged %v, want %v", acked, want)
+	}
+	if len(tracker.partitions) != 0 {
+		t.Errorf("%d partitions are tracked after all messages are processed", len(tracker.partitions))
+	}
+}
+
+func TestOffsetTrackerPartitions(t *testing.T) {
+	tracker := newOffsetTracker()
+	blocked := tracker.add(offset(0, 1))
+	tracker.add(offset(0, 2))
+	other := tracker.add(offset(1, 1))
+
+	var acked []Message
+	ack := func(m Message) { acked = append(acked, m) }
+
+	// Partition is acknowledged regardless of the messages in progress in the other partitions.
+	tracker.complete(other, ack)
+	if len(acked) != 1 || acked[0].Partition != 1 || acked[0].Offset != 1 {
+		t.Fatalf("acknowledged %+v, want offset 1 of the partition 1", acked)
+	}
+
+	tracker.complete(blocked, ack)
+	if len(acked) != 2 || acked[1].Partition != 0 || acked[1].Offset != 1 {
+		t.Fatalf("acknowledged %+v, want offset 1 of the partition 0", acked)
+	}
+	if pending := tracker.partitions[partitionKey{topic: "topic", partition: 0}]; len(pending) != 1 {
+		t.Errorf("%d messages of the partition 0 are tracked, want 1", len(pending))
+	}
+}
+
+func offset(partition int, offset int64) Message {
+	return Message{Topic: "topic", Partition: partition, Offset: offset}
+}
@@ -0,0 +1,127 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	CleanupPolicyDelete  = `delete`
+	CleanupPolicyCompact = `compact`
+)
+
+var ErrTopicMismatch = errors.New(`messaging: topic configuration does not match`)
+
+// TopicSpec declares the topic produced or consumed by the service.
+type TopicSpec struct {
+	Name              string
+	Partitions        int
+	ReplicationFactor int
+	// Retention is time the messages are kept. Zero keeps the default of the broker.
+	Retention     time.Duration
+	CleanupPolicy string
+}
+
+// TopicConfig are settings of the topics declared by the service. Services which share the topic must use
+// the same settings, otherwise the topic mismatches the declaration of one of them.
+type TopicConfig struct {
+	Partitions        int
+	ReplicationFactor int
+	Retention         time.Duration
+	// DeadLetterRetention is retention of the dead letter topics, which is usually longer to investigate failures.
+	DeadLetterRetention time.Duration
+}
+
+// Declare returns specs of the produced and consumed topics. Consumed topics are declared with their dead letter topics.
+func (c TopicConfig) Declare(produced, consumed []string) []TopicSpec {
+	specs := make([]TopicSpec, 0, len(produced)+2*len(consumed))
+	for _, name := range produced {
+		specs = append(specs, c.spec(name, c.Retention))
+	}
+	for _, name := range consumed {
+		specs = append(specs, c.spec(name, c.Retention), c.spec(DeadLetterTopic(name), c.DeadLetterRetention))
+	}
+
+	return specs
+}
+
+func (c TopicConfig) spec(name string, retention time.Duration) TopicSpec {
+	return TopicSpec{
+		Name:              name,
+		Partitions:        c.Partitions,
+		ReplicationFactor: c.ReplicationFactor,
+		Retention:         retention,
+		CleanupPolicy:     CleanupPolicyDelete,
+	}
+}
+
+// TopicProvisioner creates topics of the broker. Buses implement it to ensure topics declared by the service.
+type TopicProvisioner interface {
+	// EnsureTopics creates missing topics and checks configuration of the existing ones.
+	// Returns error wrapping ErrTopicMismatch when configuration of the broker differs from the specs.
+	EnsureTopics(ctx context.Context, topics ...TopicSpec) error
+}
+
+// Topics ensures topics declared by the service and reports the result as the readiness check.
+type Topics struct {
+	bus   Bus
+	specs []TopicSpec
+
+	mu      sync.Mutex
+	ensured bool
+}
+
+func NewTopics(bus Bus, specs []TopicSpec) *Topics {
+	t := new(Topics)
+	t.bus = bus
+	t.specs = specs
+
+	return t
+}
+
+// Ensure creates missing topics of the bus and checks the existing ones. Bus which does not implement
+// TopicProvisioner creates topics on its own.
+func (t *Topics) Ensure(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.ensured {
+		return nil
+	}
+
+	if provisioner, ok := t.bus.(TopicProvisioner); ok {
+		if err := provisioner.EnsureTopics(ctx, t.specs...); err != nil {
+			return err
+		}
+	}
+	t.ensured = true
+
+	return nil
+}
+
+// Ping reports readiness of the topics. Topics are ensured again until the broker is reachable and its
+// configuration matches the declaration.
+func (t *Topics) Ping(ctx context.Context) error {
+	return t.Ensure(ctx)
+}
+
+// topicMismatch collects differences between the topic of the broker and its spec.
+type topicMismatch []string
+
+func (m *topicMismatch) compare(topic, setting string, want, got interface{}) {
+	if want != got {
+		*m = append(*m, fmt.Sprintf("%s: %s is %v, want %v", topic, setting, got, want))
+	}
+}
+
+func (m topicMismatch) err() error {
+	if len(m) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s", ErrTopicMismatch, strings.Join(m, "; "))
+}
@@ -0,0 +1,73 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// mismatchedBus is the bus whose broker configuration does not match the declared topics until it is fixed.
+type mismatchedBus struct {
+	*MemoryBus
+	mismatch error
+	ensured  []TopicSpec
+}
+
+func (b *mismatchedBus) EnsureTopics(ctx context.Context, topics ...TopicSpec) error {
+	if b.mismatch != nil {
+		return b.mismatch
+	}
+
+	b.ensured = append(b.ensured, topics...)
+	return b.MemoryBus.EnsureTopics(ctx, topics...)
+}
+
+// TestTopics declares topics with dead letter topics. Readiness fails while the broker configuration mismatches
+// the declaration and succeeds once it is fixed.
+func TestTopics(t *testing.T) {
+	specs := TopicConfig{
+		Partitions:          8,
+		ReplicationFactor:   3,
+		Retention:           time.Hour,
+		DeadLetterRetention: 24 * time.Hour,
+	}.Declare([]string{"order-response"}, []string{"order"})
+
+	want := map[string]time.Duration{
+		"order-response":         time.Hour,
+		"order":                  time.Hour,
+		DeadLetterTopic("order"): 24 * time.Hour,
+	}
+	if len(specs) != len(want) {
+		t.Fatalf("declared %d topics, want %d", len(specs), len(want))
+	}
+	for _, spec := range specs {
+		retention, ok := want[spec.Name]
+		if !ok || spec.Retention != retention || spec.Partitions != 8 || spec.ReplicationFactor != 3 || spec.CleanupPolicy != CleanupPolicyDelete {
+			t.Errorf("unexpected topic %+v", spec)
+		}
+	}
+
+	bus := &mismatchedBus{
+		MemoryBus: NewMemoryBus(1),
+		mismatch:  fmt.Errorf("%w: %s: partitions is 1, want 8", ErrTopicMismatch, "order"),
+	}
+	t.Cleanup(func() { _ = bus.Close() })
+
+	topics := NewTopics(bus, specs)
+	if err := topics.Ping(context.Background()); !errors.Is(err, ErrTopicMismatch) {
+		t.Fatalf("readiness error %v, want topic mismatch", err)
+	}
+
+	bus.mismatch = nil
+	if err := topics.Ping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := topics.Ping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(bus.ensured) != len(specs) {
+		t.Errorf("ensured %d topics, want %d once", len(bus.ensured), len(specs))
+	}
+}
@@ -0,0 +1,84 @@
+package messaging
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer records spans of the published and processed messages with the global tracer provider.
+// Trace context is propagated in the message headers with the global propagator.
+var tracer = otel.Tracer(`eCommerce/messaging`)
+
+// headerCarrier exposes headers of the message to the propagator.
+type headerCarrier struct {
+	m *Message
+}
+
+func (c headerCarrier) Get(key string) string {
+	value, _ := c.m.Header(key)
+	return string(value)
+}
+
+func (c headerCarrier) Set(key, value string) {
+	c.m.SetHeader(key, []byte(value))
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, len(c.m.Headers))
+	for i, h := range c.m.Headers {
+		keys[i] = h.Key
+	}
+
+	return keys
+}
+
+func messageAttributes(m *Message) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		semconv.MessagingDestinationKey.String(m.Topic),
+		semconv.MessagingKafkaMessageKeyKey.String(string(m.Key)),
+	}
+}
+
+// tracePublish starts the producer span of each message and injects its context into the copy of the message headers.
+// Message published without the span in the context continues the trace of its headers, e.g. the message staged
+// in the outbox by the traced request. Returned function ends the spans with the result of the publishing.
+func tracePublish(ctx context.Context, messages []Message) ([]Message, func(err error)) {
+	traced := make([]Message, len(messages))
+	spans := make([]trace.Span, len(messages))
+	for i, m := range messages {
+		m.Headers = append([]Header(nil), m.Headers...)
+
+		parent := ctx
+		if !trace.SpanContextFromContext(ctx).IsValid() {
+			parent = otel.GetTextMapPropagator().Extract(ctx, headerCarrier{m: &m})
+		}
+
+		spanCtx, span := tracer.Start(parent, m.Topic+` send`, trace.WithSpanKind(trace.SpanKindProducer), trace.WithAttributes(messageAttributes(&m)...))
+		otel.GetTextMapPropagator().Inject(spanCtx, headerCarrier{m: &m})
+
+		traced[i] = m
+		spans[i] = span
+	}
+
+	return traced, func(err error) {
+		for i, span := range spans {
+			if cause := publishError(err, i); cause != nil {
+				span.RecordError(cause)
+				span.SetStatus(codes.Error, cause.Error())
+			}
+			span.End()
+		}
+	}
+}
+
+// traceProcess continues the trace of the publisher with the consumer span of the message.
+func traceProcess(ctx context.Context, m *Message) (context.Context, trace.Span) {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, headerCarrier{m: m})
+
+	attributes := append(messageAttributes(m), semconv.MessagingOperationProcess, semconv.MessagingKafkaPartitionKey.Int(m.Partition))
+	return tracer.Start(ctx, m.Topic+` process`, trace.WithSpanKind(trace.SpanKindConsumer), trace.WithAttributes(attributes...))
+}
@@ -56,7 +56,7 @@ var doc = `{
         },
         "/order": {
             "post": {
-                "description": "Processing user order request. When ` + "`" + `uid` + "`" + ` param missed - creates new user and wallet as well.\nParameter ` + "`" + `uid` + "`" + ` is stored in response headers under ` + "`" + `x-uid` + "`" + ` name.",
+                "description": "Processing user order request. When ` + "`" + `uid` + "`" + ` param missed - creates new user and wallet as well.\nParameter ` + "`" + `uid` + "`" + ` is stored in response headers under ` + "`" + `x-uid` + "`" + ` name.\nWhen ` + "`" + `Idempotency-Key` + "`" + ` header is provided, repeated request with the same key returns the original order\nwith ` + "`" + `Idempotent-Replayed` + "`" + ` header. Reuse of the key with the different order is rejected.",
                 "consumes": [
                     "application/json"
                 ],
@@ -74,6 +74,12 @@ var doc = `{
                         "name": "uid",
                         "in": "query"
                     },
+                    {
+                        "type": "string",
+                        "description": "Unique key of the request",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    },
                     {
                         "description": "Order",
                         "name": "order",
@@ -97,6 +103,18 @@ var doc = `{
                             "$ref": "#/definitions/api.Response"
                         }
                     },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/api.Response"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/api.Response"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -106,6 +124,187 @@ var doc = `{
                 }
             }
         },
+        "/order/{id}": {
+            "get": {
+                "description": "Find and return the order, its items, amount and ordered list of status updates.\nEach update contains status, timestamp and the message from storage or wallet service when it was provided.\nOnly owner of the order is able to read it.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "orders"
+                ],
+                "summary": "Returns the order with its status timeline.",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Order ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "auth User ID",
+                        "name": "uid",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.OrderDetails"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.Response"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.Response"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.Response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.Response"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Starts compensation of the order. Only owner of the order is able to cancel it.\nPaid order is refunded to the wallet and its products reservation is released.\nOrder waiting for the reservation or the payment is marked with ` + "`" + `cancel_requested` + "`" + ` and is compensated\nwhen the response arrives. Orders in other statuses can not be canceled.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "orders"
+                ],
+                "summary": "Cancels the order.",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Order ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "auth User ID",
+                        "name": "uid",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.Order"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.Response"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.Response"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.Response"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/api.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/order/{id}/events": {
+            "get": {
+                "description": "Server-Sent Events stream of the order status changes. The first event contains current state of the order.\nOnly owner of the order is able to stream it.\nEach event has ` + "`" + `status` + "`" + ` type and ` + "`" + `models.OrderEvent` + "`" + ` as data. Comment lines are sent periodically to keep connection alive.",
+                "produces": [
+                    "text/event-stream"
+                ],
+                "tags": [
+                    "orders"
+                ],
+                "summary": "Streams status changes of the order.",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Order ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "auth User ID",
+                        "name": "uid",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.OrderEvent"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.Response"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.Response"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.Response"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/api.Response"
+                        }
+                    }
+                }
+            }
+        },
         "/orders": {
             "get": {
                 "description": "Find and return created orders of the user using paging.",
@@ -205,6 +404,56 @@ var doc = `{
                 }
             }
         },
+        "/orders/{id}/ws": {
+            "get": {
+                "description": "WebSocket stream of the status changes of all orders of the user. Each message is ` + "`" + `models.OrderEvent` + "`" + ` in json.\nOnly the user is able to stream own orders.\nConnection is closed by the server when client does not answer to ping or does not read events fast enough.",
+                "tags": [
+                    "orders"
+                ],
+                "summary": "Streams status changes of all user orders.",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "auth User ID",
+                        "name": "uid",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "101": {
+                        "description": "Switching Protocols",
+                        "schema": {
+                            "$ref": "#/definitions/models.OrderEvent"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.Response"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.Response"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/api.Response"
+                        }
+                    }
+                }
+            }
+        },
         "/requests": {
             "get": {
                 "description": "Find and return created requests of the users using paging.",
@@ -373,13 +622,91 @@ var doc = `{
                         "$ref": "#/definitions/models.OrderProduct"
                     },
                     "x-order": "5"
+                },
+                "cancel_requested": {
+                    "description": "CancelRequested is set when the customer cancels the order waiting for the reservation or the payment.\nCompensation is started when the response arrives.",
+                    "type": "boolean",
+                    "x-order": "7"
+                }
+            }
+        },
+        "models.OrderDetails": {
+            "type": "object",
+            "properties": {
+                "id": {
+                    "type": "string",
+                    "x-order": "0"
+                },
+                "user_id": {
+                    "type": "string",
+                    "x-order": "1"
+                },
+                "status": {
+                    "type": "string",
+                    "x-order": "2"
+                },
+                "amount": {
+                    "type": "number",
+                    "x-order": "3"
+                },
+                "timestamp": {
+                    "type": "string",
+                    "x-order": "4"
+                },
+                "items": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.OrderProduct"
+                    },
+                    "x-order": "5"
+                },
+                "timeline": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.OrderUpdate"
+                    },
+                    "x-order": "6"
+                },
+                "cancel_requested": {
+                    "description": "CancelRequested is set when the order is going to be canceled after the pending response.",
+                    "type": "boolean",
+                    "x-order": "7"
+                }
+            }
+        },
+        "models.OrderEvent": {
+            "type": "object",
+            "properties": {
+                "order_id": {
+                    "type": "string",
+                    "x-order": "0"
+                },
+                "user_id": {
+                    "type": "string",
+                    "x-order": "1"
+                },
+                "status": {
+                    "type": "string",
+                    "x-order": "2"
+                },
+                "amount": {
+                    "type": "number",
+                    "x-order": "3"
+                },
+                "message": {
+                    "type": "string",
+                    "x-order": "4"
+                },
+                "timestamp": {
+                    "type": "string",
+                    "x-order": "5"
                 }
             }
         },
         "models.OrderProduct": {
             "type": "object",
             "properties": {
-                "name": {
+                "sku": {
                     "type": "string",
                     "x-order": "0"
                 },
@@ -389,6 +716,27 @@ var doc = `{
                 }
             }
         },
+        "models.OrderUpdate": {
+            "type": "object",
+            "properties": {
+                "id": {
+                    "type": "string",
+                    "x-order": "0"
+                },
+                "status": {
+                    "type": "string",
+                    "x-order": "1"
+                },
+                "timestamp": {
+                    "type": "string",
+                    "x-order": "2"
+                },
+                "message": {
+                    "type": "string",
+                    "x-order": "3"
+                }
+            }
+        },
         "models.UserRequest": {
             "type": "object",
             "properties": {
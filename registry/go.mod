@@ -3,11 +3,25 @@ module eCommerce/registry
 go 1.14
 
 require (
+	eCommerce/contracts v0.0.0
+	eCommerce/messaging v0.0.0
+	eCommerce/telemetry v0.0.0
 	github.com/go-chi/chi v1.5.4
+	github.com/gorilla/websocket v1.4.2
 	github.com/kelseyhightower/envconfig v1.4.0
-	github.com/segmentio/kafka-go v0.4.25
+	github.com/prometheus/client_golang v1.12.1
 	github.com/swaggo/http-swagger v1.1.2
 	github.com/swaggo/swag v1.7.8
-	go.mongodb.org/mongo-driver v1.8.2
+	go.mongodb.org/mongo-driver v1.8.3
+	go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo v0.29.0
+	go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp v0.29.0
+	go.opentelemetry.io/otel v1.4.1
+	go.opentelemetry.io/otel/trace v1.4.1
 	go.uber.org/zap v1.20.0
 )
+
+replace (
+	eCommerce/contracts => ../contracts
+	eCommerce/messaging => ../messaging
+	eCommerce/telemetry => ../telemetry
+)
@@ -0,0 +1,96 @@
+// Package inprocess runs the registry service inside the current process on top of the given message bus
+// with orders, users and outbox kept in memory. It is used to test the order saga without external services.
+// Commands are encoded with the given codec.
+package inprocess
+
+import (
+	"context"
+	"eCommerce/contracts"
+	"eCommerce/messaging"
+	"eCommerce/registry/internal/api"
+	"eCommerce/registry/internal/core"
+	"eCommerce/registry/internal/data"
+	"eCommerce/registry/internal/events"
+	"eCommerce/registry/internal/metrics"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+type Registry struct {
+	bus         messaging.Bus
+	broker      *events.Broker
+	relay       *core.OutboxRelay
+	coordinator *core.OrderCoordinator
+	router      http.Handler
+}
+
+func New(log *zap.SugaredLogger, bus messaging.Bus, codec contracts.Codec) *Registry {
+	r := new(Registry)
+	r.bus = bus
+	r.broker = events.NewBroker(events.BrokerConfig{
+		MaxConnections:     100,
+		MaxUserConnections: 5,
+		BufferSize:         16,
+	})
+
+	outbox := data.NewMemoryOutboxRepository()
+	keys := data.NewMemoryIdempotencyRepository()
+	repository := data.NewObservedRegistryRepository(data.NewMemoryRegistryRepository(outbox, keys), data.OrderListeners{r.broker, metrics.NewOrders()})
+
+	r.coordinator = core.NewOrderCoordinator(log, repository, codec, messaging.RetryPolicy{
+		MaxAttempts: 3,
+		MinBackoff:  time.Millisecond,
+		MaxBackoff:  10 * time.Millisecond,
+	}, messaging.PoolConfig{
+		Workers:   4,
+		QueueSize: 8,
+	})
+	r.relay = core.NewOutboxRelay(log, outbox, bus, core.OutboxRelayConfig{
+		Interval:    10 * time.Millisecond,
+		BatchSize:   100,
+		MinBackoff:  10 * time.Millisecond,
+		MaxBackoff:  time.Second,
+		Lease:       time.Second,
+		MaxAttempts: 20,
+	})
+
+	purchaser := core.NewPurchaser(log, repository, r.coordinator, keys, time.Hour, 30*time.Second)
+	registry := core.NewRequestRegistry(log, data.NewMemoryUserRepository(), repository, codec)
+	router := api.NewRouter(purchaser, registry, r.broker, &api.RouterConfig{
+		Host:            "localhost",
+		StreamHeartbeat: 15 * time.Second,
+	})
+	r.router = *router
+
+	return r
+}
+
+// Handler returns the HTTP API of the registry.
+func (r *Registry) Handler() http.Handler {
+	return r.router
+}
+
+// Start runs consumers of the saga responses and the outbox relay.
+func (r *Registry) Start(ctx context.Context) error {
+	if err := r.coordinator.Run(ctx, r.bus); err != nil {
+		return err
+	}
+	r.relay.Start(ctx)
+
+	return nil
+}
+
+// Ping reports readiness of the saga consumers.
+func (r *Registry) Ping(ctx context.Context) error {
+	return r.coordinator.Ping(ctx)
+}
+
+// Stop drains the saga consumers within the deadline of the context and stops the relay.
+func (r *Registry) Stop(ctx context.Context) error {
+	err := r.coordinator.Stop(ctx)
+	r.relay.Stop()
+	r.broker.Close()
+
+	return err
+}
@@ -4,4 +4,6 @@ const (
 	RequestBodyParseError = "Couldn't parse request body"
 	BadPathParameterError = "Bad path parameter error"
 	InternalServerError   = "Internal server error"
+
+	StreamingUnsupportedError = "Streaming is not supported"
 )
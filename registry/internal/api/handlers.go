@@ -42,13 +42,18 @@ func Index(swagURI string) func(w http.ResponseWriter, r *http.Request) {
 // @Summary 	Creates new order.
 // @Description	Processing user order request. When `uid` param missed - creates new user and wallet as well.
 // @Description	Parameter `uid` is stored in response headers under `x-uid` name.
+// @Description	When `Idempotency-Key` header is provided, repeated request with the same key returns the original order
+// @Description	with `Idempotent-Replayed` header. Reuse of the key with the different order is rejected.
 // @Tags        orders
 // @Accept      json
 // @Produce     json
-// @Param   	uid		query	string					false	"auth User ID"
-// @Param   	order	body	requests.OrderRequest	true	"Order"
+// @Param   	uid				query	string					false	"auth User ID"
+// @Param   	Idempotency-Key	header	string					false	"Unique key of the request"
+// @Param   	order			body	requests.OrderRequest	true	"Order"
 // @Success 	200 {object} models.Order
 // @Success 	400 {object} api.Response
+// @Failure 	409 {object} api.Response
+// @Failure 	422 {object} api.Response
 // @Failure 	500 {object} api.Response
 // @Router 		/order [post]
 func (c *OrderHandlers) OrderHandler(w http.ResponseWriter, r *http.Request) {
@@ -61,13 +66,131 @@ func (c *OrderHandlers) OrderHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result, err := c.PurchaseController.Order(identity.Id, req)
+	key := r.Header.Get(IdempotencyKeyHeader)
+	if key == "" {
+		result, err := c.PurchaseController.Order(r.Context(), identity.Id, req)
+		if err != nil {
+			ErrorResponse(w, err)
+			return
+		}
+
+		OkResponse(w, result)
+		return
+	}
+
+	result, replayed, err := c.PurchaseController.OrderOnce(r.Context(), identity.Id, key, req)
+	switch err {
+	case nil:
+		if replayed {
+			w.Header().Set(IdempotentReplayedHeader, "true")
+		}
+		OkResponse(w, result)
+	case core.ErrIdempotencyKeyReused:
+		UnprocessableEntityResponse(w, err.Error())
+	case core.ErrIdempotencyKeyInProgress:
+		ConflictResponse(w, err.Error())
+	default:
+		ErrorResponse(w, err)
+	}
+}
+
+// OrderDetailsHandler godoc
+// @Summary 	Returns the order with its status timeline.
+// @Description	Find and return the order, its items, amount and ordered list of status updates.
+// @Description	Each update contains status, timestamp and the message from storage or wallet service when it was provided.
+// @Description	Only owner of the order is able to read it.
+// @Tags        orders
+// @Accept      json
+// @Produce     json
+// @Param   	id	path	string	true	"Order ID"
+// @Param   	uid	query	string	false	"auth User ID"
+// @Success 	200 {object} models.OrderDetails
+// @Failure 	400 {object} api.Response
+// @Failure 	403 {object} api.Response
+// @Failure 	404 {object} api.Response
+// @Failure 	500 {object} api.Response
+// @Router 		/order/{id} [get]
+func (c *OrderHandlers) OrderDetailsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := GetPathParameter(r.URL.Path, 1)
+	if err != nil {
+		BadRequestResponse(w, BadPathParameterError)
+		return
+	}
+
+	orderId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		BadRequestResponse(w, BadPathParameterError)
+		return
+	}
+
+	identity, err := core.Identity(r)
 	if err != nil {
 		ErrorResponse(w, err)
 		return
 	}
 
-	OkResponse(w, result)
+	result, err := c.PurchaseController.GetOrder(orderId)
+	switch {
+	case err == core.ErrOrderNotFound:
+		NotFoundResponse(w, err.Error())
+	case err != nil:
+		InternalErrorResponse(w, InternalServerError)
+	case result.UserId != identity.Id:
+		ForbiddenResponse(w, core.ErrOrderAccessDenied.Error())
+	default:
+		OkResponse(w, result)
+	}
+}
+
+// CancelOrderHandler godoc
+// @Summary 	Cancels the order.
+// @Description	Starts compensation of the order. Only owner of the order is able to cancel it.
+// @Description	Paid order is refunded to the wallet and its products reservation is released.
+// @Description	Order waiting for the reservation or the payment is marked with `cancel_requested` and is compensated
+// @Description	when the response arrives. Orders in other statuses can not be canceled.
+// @Tags        orders
+// @Accept      json
+// @Produce     json
+// @Param   	id	path	string	true	"Order ID"
+// @Param   	uid	query	string	false	"auth User ID"
+// @Success 	200 {object} models.Order
+// @Failure 	400 {object} api.Response
+// @Failure 	403 {object} api.Response
+// @Failure 	404 {object} api.Response
+// @Failure 	409 {object} api.Response
+// @Router 		/order/{id} [delete]
+func (c *OrderHandlers) CancelOrderHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := GetPathParameter(r.URL.Path, 1)
+	if err != nil {
+		BadRequestResponse(w, BadPathParameterError)
+		return
+	}
+
+	orderId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		BadRequestResponse(w, BadPathParameterError)
+		return
+	}
+
+	identity, err := core.Identity(r)
+	if err != nil {
+		ErrorResponse(w, err)
+		return
+	}
+
+	result, err := c.PurchaseController.CancelOrder(r.Context(), identity.Id, orderId)
+	switch err {
+	case nil:
+		OkResponse(w, result)
+	case core.ErrOrderNotFound:
+		NotFoundResponse(w, err.Error())
+	case core.ErrOrderAccessDenied:
+		ForbiddenResponse(w, err.Error())
+	case core.ErrOrderCancelNotAllowed, core.ErrOrderChanged:
+		ConflictResponse(w, err.Error())
+	default:
+		InternalErrorResponse(w, InternalServerError)
+	}
 }
 
 // ListOrdersHandler godoc
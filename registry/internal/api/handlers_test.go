@@ -0,0 +1,74 @@
+package api
+
+import (
+	"eCommerce/registry/internal/models"
+	"encoding/json"
+	"github.com/go-chi/chi"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOrderDetails(t *testing.T) {
+	now := time.Now().UTC()
+	order := &models.Order{
+		Id:        primitive.NewObjectID(),
+		UserId:    primitive.NewObjectID(),
+		Status:    models.OrderPaid,
+		Timestamp: now,
+		// Updates are stored in the order they were recorded, which is not the order of their time.
+		Updates: []models.OrderUpdate{
+			{Status: models.OrderPaid, Timestamp: now.Add(2 * time.Second)},
+			{Status: models.OrderReservationPending, Timestamp: now},
+			{Status: models.OrderPaymentPending, Timestamp: now.Add(time.Second), Message: "reserved order"},
+		},
+	}
+	orders := &streamOrders{details: map[primitive.ObjectID]*models.OrderDetails{order.Id: order.Details()}}
+
+	r := chi.NewRouter()
+	r.Get("/order/{id}", (&OrderHandlers{PurchaseController: orders}).OrderDetailsHandler)
+
+	tests := []struct {
+		name   string
+		id     primitive.ObjectID
+		uid    primitive.ObjectID
+		status int
+	}{
+		{name: "owner", id: order.Id, uid: order.UserId, status: http.StatusOK},
+		{name: "another user", id: order.Id, uid: primitive.NewObjectID(), status: http.StatusForbidden},
+		{name: "unknown order", id: primitive.NewObjectID(), uid: order.UserId, status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/order/"+tt.id.Hex()+"?uid="+tt.uid.Hex(), nil))
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d, body %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			details := new(models.OrderDetails)
+			if err := json.Unmarshal(w.Body.Bytes(), details); err != nil {
+				t.Fatal(err)
+			}
+
+			want := []models.OrderStatus{models.OrderReservationPending, models.OrderPaymentPending, models.OrderPaid}
+			if len(details.Timeline) != len(want) {
+				t.Fatalf("timeline %+v, want %v", details.Timeline, want)
+			}
+			for i, u := range details.Timeline {
+				if u.Status != want[i] {
+					t.Errorf("update %d %s, want %s", i, u.Status, want[i])
+				}
+			}
+			if details.Timeline[1].Message != "reserved order" {
+				t.Errorf("message %q, want the message of the storage", details.Timeline[1].Message)
+			}
+		})
+	}
+}
@@ -2,10 +2,17 @@ package api
 
 import (
 	"context"
+	"eCommerce/registry/internal/metrics"
 	"eCommerce/registry/internal/models"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"net/http"
 	"strings"
+	"time"
 )
 
 type RequestRegistryMiddleware struct {
@@ -57,6 +64,46 @@ func (rr *RequestRegistryMiddleware) RequestRegistry(next http.Handler) http.Han
 	return http.HandlerFunc(fn)
 }
 
+// Tracing starts the server span of the request, continuing the trace of the traceparent header.
+// Span is named after the route pattern, so requests of the same endpoint are grouped together.
+func Tracing(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		span := trace.SpanFromContext(r.Context())
+		span.SetAttributes(attribute.String("http.request_id", middleware.GetReqID(r.Context())))
+
+		next.ServeHTTP(w, r)
+
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			span.SetName(r.Method + " " + rctx.RoutePattern())
+		}
+	}
+
+	return otelhttp.NewHandler(http.HandlerFunc(fn), "http request")
+}
+
+// Metrics counts requests and observes their duration per route pattern.
+func Metrics(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		route := `unmatched`
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		metrics.ObserveRequest(r.Method, route, status, time.Since(started))
+	}
+
+	return http.HandlerFunc(fn)
+}
+
 // DefaultContentType set content type
 func DefaultContentType(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
@@ -27,6 +27,26 @@ func UnauthorizedResponse(w http.ResponseWriter, message string) {
 	response(w, http.StatusUnauthorized, Response{message})
 }
 
+func ForbiddenResponse(w http.ResponseWriter, message string) {
+	response(w, http.StatusForbidden, Response{message})
+}
+
+func NotFoundResponse(w http.ResponseWriter, message string) {
+	response(w, http.StatusNotFound, Response{message})
+}
+
+func ConflictResponse(w http.ResponseWriter, message string) {
+	response(w, http.StatusConflict, Response{message})
+}
+
+func UnprocessableEntityResponse(w http.ResponseWriter, message string) {
+	response(w, http.StatusUnprocessableEntity, Response{message})
+}
+
+func TooManyRequestsResponse(w http.ResponseWriter, message string) {
+	response(w, http.StatusTooManyRequests, Response{message})
+}
+
 func BadRequestResponse(w http.ResponseWriter, message string) {
 	response(w, http.StatusBadRequest, Response{message})
 }
@@ -2,6 +2,7 @@ package api
 
 import (
 	"eCommerce/registry/internal/core"
+	"eCommerce/registry/internal/events"
 	"fmt"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
@@ -11,12 +12,15 @@ import (
 
 type RouterConfig struct {
 	Host string
+	// StreamHeartbeat is interval of keep alive messages of the order event streams.
+	StreamHeartbeat time.Duration
 }
 
-func NewRouter(pc core.PurchaseController, rc core.RegistryController, cfg *RouterConfig) *chi.Router {
+func NewRouter(pc core.PurchaseController, rc core.RegistryController, broker *events.Broker, cfg *RouterConfig) *chi.Router {
 	ph := OrderHandlers{pc}
 	rh := RegistryHandlers{rc}
 	rr := RequestRegistryMiddleware{&rh}
+	sh := NewStreamHandlers(pc, broker, cfg.StreamHeartbeat)
 
 	swagIndex := fmt.Sprintf("http://%s/swagger/index.html", cfg.Host)
 	swagDoc := fmt.Sprintf("http://%s/swagger/doc.json", cfg.Host)
@@ -24,23 +28,40 @@ func NewRouter(pc core.PurchaseController, rc core.RegistryController, cfg *Rout
 	var r chi.Router = chi.NewRouter()
 
 	r.Use(middleware.RequestID)
+	r.Use(Tracing)
+	r.Use(Metrics)
 	r.Use(middleware.RealIP)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.AllowContentType("application/json"))
-	r.Use(middleware.Timeout(10 * time.Second))
-	r.Use(DefaultContentType)
-	r.Use(rr.UserRegistry)
-	r.Use(rr.RequestRegistry)
-
-	r.Get("/", Index(swagIndex))
-	r.Get("/requests", rh.ListRequestsHandler)
-	r.Get("/requests/{id}", rh.ListUserRequestsHandler)
-	r.Get("/orders", ph.ListOrdersHandler)
-	r.Get("/orders/{id}", ph.ListUserOrdersHandler)
-	r.Post("/order", ph.OrderHandler)
-
-	r.Get("/swagger/*", swag.Handler(swag.URL(swagDoc)))
+
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.Timeout(10 * time.Second))
+		r.Use(DefaultContentType)
+		r.Use(rr.UserRegistry)
+		r.Use(rr.RequestRegistry)
+
+		r.Get("/", Index(swagIndex))
+		r.Get("/requests", rh.ListRequestsHandler)
+		r.Get("/requests/{id}", rh.ListUserRequestsHandler)
+		r.Get("/orders", ph.ListOrdersHandler)
+		r.Get("/orders/{id}", ph.ListUserOrdersHandler)
+		r.Post("/order", ph.OrderHandler)
+		r.Get("/order/{id}", ph.OrderDetailsHandler)
+		r.Delete("/order/{id}", ph.CancelOrderHandler)
+
+		r.Get("/swagger/*", swag.Handler(swag.URL(swagDoc)))
+	})
+
+	// Event streams are long living connections, so they are not limited by the request timeout.
+	r.Group(func(r chi.Router) {
+		r.Use(DefaultContentType)
+		r.Use(rr.UserRegistry)
+		r.Use(rr.RequestRegistry)
+
+		r.Get("/order/{id}/events", sh.OrderEventsHandler)
+		r.Get("/orders/{id}/ws", sh.UserOrderEventsHandler)
+	})
 
 	return &r
 }
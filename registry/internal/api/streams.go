@@ -0,0 +1,245 @@
+package api
+
+import (
+	"eCommerce/registry/internal/core"
+	"eCommerce/registry/internal/events"
+	"eCommerce/registry/internal/models"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"time"
+)
+
+const (
+	wsWriteTimeout   = 10 * time.Second
+	wsReadLimit      = 512
+	wsSlowSubscriber = "subscriber is too slow"
+)
+
+type StreamHandlers struct {
+	core.PurchaseController
+	broker    *events.Broker
+	heartbeat time.Duration
+	upgrader  websocket.Upgrader
+}
+
+func NewStreamHandlers(pc core.PurchaseController, broker *events.Broker, heartbeat time.Duration) *StreamHandlers {
+	h := new(StreamHandlers)
+	h.PurchaseController = pc
+	h.broker = broker
+	h.heartbeat = heartbeat
+
+	return h
+}
+
+// OrderEventsHandler godoc
+// @Summary 	Streams status changes of the order.
+// @Description	Server-Sent Events stream of the order status changes. The first event contains current state of the order.
+// @Description	Only owner of the order is able to stream it.
+// @Description	Each event has `status` type and `models.OrderEvent` as data. Comment lines are sent periodically to keep connection alive.
+// @Tags        orders
+// @Produce     text/event-stream
+// @Param   	id	path	string	true	"Order ID"
+// @Param   	uid	query	string	false	"auth User ID"
+// @Success 	200 {object} models.OrderEvent
+// @Failure 	400 {object} api.Response
+// @Failure 	403 {object} api.Response
+// @Failure 	404 {object} api.Response
+// @Failure 	429 {object} api.Response
+// @Router 		/order/{id}/events [get]
+func (h *StreamHandlers) OrderEventsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := GetPathParameter(r.URL.Path, 1)
+	if err != nil {
+		BadRequestResponse(w, BadPathParameterError)
+		return
+	}
+
+	orderId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		BadRequestResponse(w, BadPathParameterError)
+		return
+	}
+
+	identity, err := core.Identity(r)
+	if err != nil {
+		ErrorResponse(w, err)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		InternalErrorResponse(w, StreamingUnsupportedError)
+		return
+	}
+
+	order, err := h.PurchaseController.GetOrder(orderId)
+	if err == core.ErrOrderNotFound {
+		NotFoundResponse(w, err.Error())
+		return
+	}
+	if err != nil {
+		InternalErrorResponse(w, InternalServerError)
+		return
+	}
+
+	if order.UserId != identity.Id {
+		ForbiddenResponse(w, core.ErrOrderAccessDenied.Error())
+		return
+	}
+
+	subscription, err := h.broker.Subscribe(order.UserId, orderId)
+	if err != nil {
+		TooManyRequestsResponse(w, err.Error())
+		return
+	}
+	defer subscription.Close()
+
+	// Order is read again after subscription, so the update which happened in between is not lost.
+	if order, err = h.PurchaseController.GetOrder(orderId); err != nil {
+		InternalErrorResponse(w, InternalServerError)
+		return
+	}
+
+	w.Header().Set(`Content-Type`, `text/event-stream`)
+	w.Header().Set(`Cache-Control`, `no-cache`)
+	w.Header().Set(`Connection`, `keep-alive`)
+	w.Header().Set(`X-Accel-Buffering`, `no`)
+	w.WriteHeader(http.StatusOK)
+
+	if err = writeEvent(w, order.Event()); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(h.heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-subscription.Events():
+			if !ok {
+				return
+			}
+			if err = writeEvent(w, event); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err = fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// UserOrderEventsHandler godoc
+// @Summary 	Streams status changes of all user orders.
+// @Description	WebSocket stream of the status changes of all orders of the user. Each message is `models.OrderEvent` in json.
+// @Description	Only the user is able to stream own orders.
+// @Description	Connection is closed by the server when client does not answer to ping or does not read events fast enough.
+// @Tags        orders
+// @Param   	id	path	string	true	"User ID"
+// @Param   	uid	query	string	false	"auth User ID"
+// @Success 	101 {object} models.OrderEvent
+// @Failure 	400 {object} api.Response
+// @Failure 	403 {object} api.Response
+// @Failure 	429 {object} api.Response
+// @Router 		/orders/{id}/ws [get]
+func (h *StreamHandlers) UserOrderEventsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := GetPathParameter(r.URL.Path, 1)
+	if err != nil {
+		BadRequestResponse(w, BadPathParameterError)
+		return
+	}
+
+	userId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		BadRequestResponse(w, BadPathParameterError)
+		return
+	}
+
+	identity, err := core.Identity(r)
+	if err != nil {
+		ErrorResponse(w, err)
+		return
+	}
+
+	if userId != identity.Id {
+		ForbiddenResponse(w, core.ErrOrdersAccessDenied.Error())
+		return
+	}
+
+	subscription, err := h.broker.Subscribe(userId, primitive.NilObjectID)
+	if err != nil {
+		TooManyRequestsResponse(w, err.Error())
+		return
+	}
+	defer subscription.Close()
+
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	closed := make(chan struct{})
+	go h.readPump(conn, closed)
+
+	ping := time.NewTicker(h.heartbeat)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case event, ok := <-subscription.Events():
+			deadline := time.Now().Add(wsWriteTimeout)
+			if !ok {
+				message := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, wsSlowSubscriber)
+				_ = conn.WriteControl(websocket.CloseMessage, message, deadline)
+				return
+			}
+			_ = conn.SetWriteDeadline(deadline)
+			if err = conn.WriteJSON(event); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readPump reads messages from the client to process control frames and detect disconnection.
+// Client is considered disconnected when it does not answer to ping during two heartbeat intervals.
+func (h *StreamHandlers) readPump(conn *websocket.Conn, closed chan<- struct{}) {
+	defer close(closed)
+
+	wait := 2 * h.heartbeat
+	conn.SetReadLimit(wsReadLimit)
+	_ = conn.SetReadDeadline(time.Now().Add(wait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wait))
+	})
+
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event models.OrderEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: status\ndata: %s\n\n", data)
+	return err
+}
@@ -0,0 +1,238 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"eCommerce/registry/internal/core"
+	"eCommerce/registry/internal/events"
+	"eCommerce/registry/internal/models"
+	"github.com/go-chi/chi"
+	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const streamTimeout = 2 * time.Second
+
+func TestOrderEventsOwner(t *testing.T) {
+	s := newStreams(t, events.BrokerConfig{BufferSize: 4})
+	order := s.addOrder(primitive.NewObjectID())
+
+	tests := []struct {
+		name   string
+		uid    primitive.ObjectID
+		status int
+	}{
+		{name: "owner", uid: order.UserId, status: http.StatusOK},
+		{name: "another user", uid: primitive.NewObjectID(), status: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			resp := s.get(t, ctx, "/order/"+order.Id.Hex()+"/events", tt.uid)
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status %d, want %d", resp.StatusCode, tt.status)
+			}
+		})
+	}
+}
+
+func TestOrderEventsDisconnect(t *testing.T) {
+	s := newStreams(t, events.BrokerConfig{BufferSize: 4})
+	order := s.addOrder(primitive.NewObjectID())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	resp := s.get(t, ctx, "/order/"+order.Id.Hex()+"/events", order.UserId)
+	defer resp.Body.Close()
+
+	lines := bufio.NewScanner(resp.Body)
+	if !lines.Scan() || lines.Text() != "event: status" {
+		t.Fatalf("first line %q, want the current state of the order", lines.Text())
+	}
+	if n := s.broker.Connections(); n != 1 {
+		t.Fatalf("connections %d, want 1", n)
+	}
+
+	cancel()
+	s.awaitConnections(t, 0)
+}
+
+func TestOrderEventsUserLimit(t *testing.T) {
+	s := newStreams(t, events.BrokerConfig{MaxUserConnections: 1, BufferSize: 4})
+	userId := primitive.NewObjectID()
+	first, second := s.addOrder(userId), s.addOrder(userId)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	resp := s.get(t, ctx, "/order/"+first.Id.Hex()+"/events", userId)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("first stream status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	rejected := s.get(t, ctx, "/order/"+second.Id.Hex()+"/events", userId)
+	defer rejected.Body.Close()
+	if rejected.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("second stream status %d, want %d", rejected.StatusCode, http.StatusTooManyRequests)
+	}
+}
+
+func TestUserOrderEventsOwner(t *testing.T) {
+	s := newStreams(t, events.BrokerConfig{BufferSize: 4})
+
+	_, resp, err := s.dial(primitive.NewObjectID(), primitive.NewObjectID())
+	if err == nil {
+		t.Fatal("stream of another user is opened")
+	}
+	if resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("response %v, want status %d", resp, http.StatusForbidden)
+	}
+	if n := s.broker.Connections(); n != 0 {
+		t.Fatalf("connections %d, want 0", n)
+	}
+}
+
+func TestUserOrderEventsDisconnect(t *testing.T) {
+	s := newStreams(t, events.BrokerConfig{BufferSize: 4})
+	userId := primitive.NewObjectID()
+
+	conn, _, err := s.dial(userId, userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.awaitConnections(t, 1)
+
+	order := &models.Order{Id: primitive.NewObjectID(), UserId: userId, Status: models.OrderPaid}
+	s.broker.OrderUpdated(order)
+
+	var event models.OrderEvent
+	_ = conn.SetReadDeadline(time.Now().Add(streamTimeout))
+	if err = conn.ReadJSON(&event); err != nil {
+		t.Fatal(err)
+	}
+	if event.OrderId != order.Id || event.Status != order.Status {
+		t.Fatalf("event %+v, want order %s in %s", event, order.Id.Hex(), order.Status)
+	}
+
+	_ = conn.Close()
+	s.awaitConnections(t, 0)
+}
+
+func TestUserOrderEventsUserLimit(t *testing.T) {
+	s := newStreams(t, events.BrokerConfig{MaxUserConnections: 1, BufferSize: 4})
+	userId := primitive.NewObjectID()
+
+	conn, _, err := s.dial(userId, userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, resp, err := s.dial(userId, userId)
+	if err == nil {
+		t.Fatal("stream over the user limit is opened")
+	}
+	if resp == nil || resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("response %v, want status %d", resp, http.StatusTooManyRequests)
+	}
+}
+
+// streams serves the stream handlers over the broker with orders kept by the test.
+type streams struct {
+	broker *events.Broker
+	orders *streamOrders
+	server *httptest.Server
+}
+
+func newStreams(t *testing.T, cfg events.BrokerConfig) *streams {
+	t.Helper()
+
+	s := new(streams)
+	s.broker = events.NewBroker(cfg)
+	s.orders = &streamOrders{details: make(map[primitive.ObjectID]*models.OrderDetails)}
+
+	h := NewStreamHandlers(s.orders, s.broker, time.Second)
+	r := chi.NewRouter()
+	r.Get("/order/{id}/events", h.OrderEventsHandler)
+	r.Get("/orders/{id}/ws", h.UserOrderEventsHandler)
+
+	s.server = httptest.NewServer(r)
+	t.Cleanup(func() {
+		s.broker.Close()
+		s.server.Close()
+	})
+
+	return s
+}
+
+func (s *streams) addOrder(userId primitive.ObjectID) *models.OrderDetails {
+	order := &models.Order{
+		Id:        primitive.NewObjectID(),
+		UserId:    userId,
+		Status:    models.OrderReservationPending,
+		Timestamp: time.Now().UTC(),
+	}
+	s.orders.details[order.Id] = order.Details()
+
+	return s.orders.details[order.Id]
+}
+
+func (s *streams) get(t *testing.T, ctx context.Context, path string, uid primitive.ObjectID) *http.Response {
+	t.Helper()
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, s.server.URL+path+"?uid="+uid.Hex(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp
+}
+
+func (s *streams) dial(userId, uid primitive.ObjectID) (*websocket.Conn, *http.Response, error) {
+	url := "ws" + strings.TrimPrefix(s.server.URL, "http") + "/orders/" + userId.Hex() + "/ws?uid=" + uid.Hex()
+
+	return websocket.DefaultDialer.Dial(url, nil)
+}
+
+// awaitConnections waits until the handlers release or take the subscriptions.
+func (s *streams) awaitConnections(t *testing.T, want int) {
+	t.Helper()
+
+	deadline := time.Now().Add(streamTimeout)
+	for s.broker.Connections() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("connections %d, want %d", s.broker.Connections(), want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// streamOrders returns details of the orders added by the test.
+type streamOrders struct {
+	core.PurchaseController
+	details map[primitive.ObjectID]*models.OrderDetails
+}
+
+func (o *streamOrders) GetOrder(orderId primitive.ObjectID) (*models.OrderDetails, error) {
+	details, ok := o.details[orderId]
+	if !ok {
+		return nil, core.ErrOrderNotFound
+	}
+
+	return details, nil
+}
@@ -2,11 +2,16 @@ package application
 
 import (
 	"context"
+	"eCommerce/contracts"
+	"eCommerce/messaging"
 	"eCommerce/registry/docs"
 	"eCommerce/registry/internal/api"
 	"eCommerce/registry/internal/consumers"
 	"eCommerce/registry/internal/core"
 	"eCommerce/registry/internal/data"
+	"eCommerce/registry/internal/events"
+	"eCommerce/registry/internal/metrics"
+	"eCommerce/telemetry"
 	"github.com/go-chi/chi"
 	"go.uber.org/zap"
 	"net/http"
@@ -14,7 +19,6 @@ import (
 	"os/signal"
 	"strconv"
 	"syscall"
-	"time"
 )
 
 const ServiceName = `registry`
@@ -27,8 +31,15 @@ type App struct {
 	log       *zap.SugaredLogger
 	router    *chi.Router
 	resources *RegistryResources
+	broker    *events.Broker
+	// topics are declared by the service and ensured on startup.
+	topics *messaging.Topics
+	// diagnostics serves health and readiness probes on the diagnostic port.
+	diagnostics *telemetry.Diagnostics
 
 	OrderCoordinator   *core.OrderCoordinator
+	OutboxRelay        *core.OutboxRelay
+	SagaWatchdog       *core.SagaWatchdog
 	PurchaseController *core.Purchaser
 	RegistryController *core.RequestRegistry
 }
@@ -48,17 +59,95 @@ func (a *App) Build() {
 	a.log.Info("Configuring server and initializing resources...")
 	a.resources = NewRegistryResources(a.ctx, a.log, a.cfg).Initialize()
 
-	repository := data.NewMongoRegistryRepository(a.resources.Database)
-	coordinator := core.NewOrderCoordinator(a.log, repository, a.resources.Producer)
-	a.OrderCoordinator = coordinator
-	a.PurchaseController = core.NewPurchaser(a.log, a.resources.Database, a.resources.Producer, coordinator)
-	a.RegistryController = core.NewRequestRegistry(a.log, a.resources.Database, a.resources.Producer)
+	a.broker = events.NewBroker(events.BrokerConfig{
+		MaxConnections:     a.cfg.StreamMaxConnections,
+		MaxUserConnections: a.cfg.StreamMaxUserConnections,
+		BufferSize:         a.cfg.StreamBufferSize,
+	})
+
+	codec, err := contracts.NewCodec(a.cfg.MessageCodec)
+	if err != nil {
+		a.log.Fatal(err)
+	}
+
+	orders := data.NewMongoRegistryRepository(a.resources.Database)
+	if err := orders.MigrateSkus(a.ctx); err != nil {
+		a.log.Fatal(err)
+	}
+	repository := data.NewObservedRegistryRepository(orders, data.OrderListeners{a.broker, metrics.NewOrders()})
+	coordinator := core.NewOrderCoordinator(a.log, repository, codec, messaging.RetryPolicy{
+		MaxAttempts: a.cfg.ConsumerMaxAttempts,
+		MinBackoff:  a.cfg.ConsumerMinBackoff,
+		MaxBackoff:  a.cfg.ConsumerMaxBackoff,
+	}, messaging.PoolConfig{
+		Workers:   a.cfg.ConsumerWorkers,
+		QueueSize: a.cfg.ConsumerQueueSize,
+	})
+
+	outbox := data.NewMongoOutboxRepository(a.resources.Database)
+	if err := outbox.EnsureIndexes(a.ctx, a.cfg.OutboxRetention); err != nil {
+		a.log.Fatal(err)
+	}
+	a.OutboxRelay = core.NewOutboxRelay(a.log, outbox, a.resources.Bus, core.OutboxRelayConfig{
+		Interval:    a.cfg.OutboxInterval,
+		BatchSize:   a.cfg.OutboxBatchSize,
+		MinBackoff:  a.cfg.OutboxMinBackoff,
+		MaxBackoff:  a.cfg.OutboxMaxBackoff,
+		Lease:       a.cfg.OutboxLease,
+		MaxAttempts: a.cfg.OutboxMaxAttempts,
+	})
+
+	a.SagaWatchdog = core.NewSagaWatchdog(a.log, repository, codec, core.SagaWatchdogConfig{
+		Interval:    a.cfg.WatchdogInterval,
+		MaxAttempts: a.cfg.WatchdogMaxAttempts,
+		Rules: core.DefaultWatchdogRules(
+			a.cfg.WatchdogReservationTimeout,
+			a.cfg.WatchdogPaymentTimeout,
+			a.cfg.WatchdogCancelTimeout,
+			a.cfg.WatchdogCommitTimeout,
+		),
+	})
+
+	keys := data.NewMongoIdempotencyRepository(a.resources.Database)
+	if err := keys.EnsureIndexes(a.ctx); err != nil {
+		a.log.Fatal(err)
+	}
 
-	a.router = api.NewRouter(a.PurchaseController, a.RegistryController, &api.RouterConfig{
-		Host: a.cfg.ApplicationHost,
+	a.topics = messaging.NewTopics(a.resources.Bus, messaging.TopicConfig{
+		Partitions:          a.cfg.TopicPartitions,
+		ReplicationFactor:   a.cfg.TopicReplicationFactor,
+		Retention:           a.cfg.TopicRetention,
+		DeadLetterRetention: a.cfg.TopicDeadLetterRetention,
+	}.Declare(consumers.ProducedTopics, consumers.ConsumedTopics))
+	a.ensureTopics()
+
+	a.OrderCoordinator = coordinator
+	a.PurchaseController = core.NewPurchaser(a.log, repository, coordinator, keys, a.cfg.IdempotencyKeyTTL, a.cfg.IdempotencyKeyLease)
+	a.RegistryController = core.NewRequestRegistry(a.log, data.NewMongoUserRepository(a.resources.Database), repository, codec)
+
+	a.diagnostics = telemetry.NewDiagnostics(a.log, a.cfg.DiagnosticPort)
+	a.diagnostics.AddCheck("mongodb", a.resources.PingMongoDB)
+	a.diagnostics.AddCheck("bus", a.resources.PingBus)
+	a.diagnostics.AddCheck("consumers", a.OrderCoordinator.Ping)
+	a.diagnostics.AddCheck("topics", a.topics.Ping)
+
+	a.router = api.NewRouter(a.PurchaseController, a.RegistryController, a.broker, &api.RouterConfig{
+		Host:            a.cfg.ApplicationHost,
+		StreamHeartbeat: a.cfg.StreamHeartbeat,
 	})
 }
 
+// ensureTopics creates missing topics of the service. Service is started anyway, the topics are ensured again
+// by the readiness probe until the broker configuration matches the declaration.
+func (a *App) ensureTopics() {
+	ctx, cancel := context.WithTimeout(a.ctx, telemetry.CheckTimeout)
+	defer cancel()
+
+	if err := a.topics.Ensure(ctx); err != nil {
+		a.log.Errorw("Failed to ensure topics, the service is not ready.", "err", err)
+	}
+}
+
 func (a *App) Run() {
 	docs.SwaggerInfo.Host = a.cfg.ApplicationHost
 	docs.SwaggerInfo.BasePath = "/"
@@ -71,10 +160,16 @@ func (a *App) Run() {
 	}(a.log)
 
 	a.log.Info("Starting the server...")
-	a.OrderCoordinator.Run(consumers.BrokerReaderConfig(a.cfg.KafkaConnectionUrl))
+	a.diagnostics.Start()
+	if err := a.OrderCoordinator.Run(a.ctx, a.resources.Bus); err != nil {
+		a.log.Fatal(err)
+	}
+	a.OutboxRelay.Start(a.ctx)
+	a.SagaWatchdog.Start(a.ctx)
 
 	addr := ":" + strconv.Itoa(a.cfg.ApplicationPort)
 	server := &http.Server{Addr: addr, Handler: *a.router}
+	server.RegisterOnShutdown(a.broker.Close)
 
 	// Listen for syscall signals for process to interrupt/quit
 	sig := make(chan os.Signal, 1)
@@ -82,22 +177,23 @@ func (a *App) Run() {
 	go func() {
 		<-sig
 
-		shutdownCtx, cancelShutdownCtx := context.WithTimeout(a.ctx, 30*time.Second)
+		shutdownCtx, cancelShutdownCtx := context.WithTimeout(a.ctx, a.cfg.ShutdownTimeout)
 		defer cancelShutdownCtx()
 
-		go func() {
-			<-shutdownCtx.Done()
-			if shutdownCtx.Err() == context.DeadlineExceeded {
-				a.log.Fatal("graceful shutdown timed out.. forcing exit.")
-			}
-		}()
-
-		// Trigger graceful shutdown
-		a.resources.Release(shutdownCtx)
-		err := server.Shutdown(shutdownCtx)
-		if err != nil {
-			a.log.Fatal(err)
+		// Trigger graceful shutdown. Requests and responses being processed are finished within the timeout
+		// before the resources are released, the rest of the responses are delivered again after the restart.
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			a.log.Error("Got an error while stopping the server.", "err", err)
+		}
+		if err := a.OrderCoordinator.Stop(shutdownCtx); err != nil {
+			a.log.Error("Got an error while stopping the saga consumers.", "err", err)
 		}
+		a.SagaWatchdog.Stop()
+		a.OutboxRelay.Stop()
+		if err := a.diagnostics.Shutdown(shutdownCtx); err != nil {
+			a.log.Error("Got an error while stopping the diagnostics server.", "err", err)
+		}
+		a.resources.Release(shutdownCtx)
 		a.cancelCtx()
 	}()
 
@@ -2,9 +2,12 @@ package application
 
 import (
 	"context"
-	"github.com/segmentio/kafka-go"
+	"eCommerce/messaging"
+	"eCommerce/telemetry"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo"
 	"go.uber.org/zap"
 	"sync"
 )
@@ -14,8 +17,10 @@ type RegistryResources struct {
 	ctx context.Context
 	log *zap.SugaredLogger
 
-	Producer *kafka.Writer
+	Bus      messaging.Bus
 	Database *mongo.Database
+
+	shutdownTracing telemetry.Shutdown
 }
 
 func NewRegistryResources(ctx context.Context, log *zap.SugaredLogger, cfg *Config) *RegistryResources {
@@ -28,17 +33,29 @@ func NewRegistryResources(ctx context.Context, log *zap.SugaredLogger, cfg *Conf
 }
 
 func (r *RegistryResources) Initialize() *RegistryResources {
+	r.shutdownTracing = r.InitializeTracing()
 	r.Database = r.InitializeMongoDB()
-	r.Producer = &kafka.Writer{
-		Addr:     kafka.TCP(r.cfg.KafkaConnectionUrl),
-		Balancer: new(kafka.LeastBytes),
-	}
+	r.Bus = r.InitializeBus()
 
 	return r
 }
 
+// InitializeTracing installs the tracer provider which exports spans of the service to the configured exporter.
+func (r *RegistryResources) InitializeTracing() telemetry.Shutdown {
+	shutdown, err := telemetry.Setup(telemetry.Config{
+		ServiceName: ServiceName,
+		Exporter:    r.cfg.TraceExporter,
+		File:        r.cfg.TraceFile,
+	})
+	if err != nil {
+		r.log.Fatal(err)
+	}
+
+	return shutdown
+}
+
 func (r *RegistryResources) InitializeMongoDB() *mongo.Database {
-	uri := options.Client().ApplyURI(r.cfg.MongoConnectionUrl)
+	uri := options.Client().ApplyURI(r.cfg.MongoConnectionUrl).SetMonitor(otelmongo.NewMonitor())
 	c, err := mongo.Connect(r.ctx, uri)
 	if err != nil {
 		r.log.Fatal(err)
@@ -52,6 +69,33 @@ func (r *RegistryResources) InitializeMongoDB() *mongo.Database {
 	return c.Database(ServiceName)
 }
 
+// InitializeBus creates the message bus. In-memory bus is useful for local runs without kafka.
+func (r *RegistryResources) InitializeBus() messaging.Bus {
+	switch r.cfg.MessageBus {
+	case MessageBusKafka:
+		return messaging.NewKafkaBus(r.cfg.KafkaConnectionUrl)
+	case MessageBusMemory:
+		return messaging.NewMemoryBus(r.cfg.MemoryBusPartitions)
+	}
+
+	r.log.Fatalf("unknown message bus %q", r.cfg.MessageBus)
+	return nil
+}
+
+// PingMongoDB checks connectivity with the primary of the replica set, which serves the transactions.
+func (r *RegistryResources) PingMongoDB(ctx context.Context) error {
+	return r.Database.Client().Ping(ctx, readpref.Primary())
+}
+
+// PingBus checks connectivity of the bus publisher with the broker.
+func (r *RegistryResources) PingBus(ctx context.Context) error {
+	if pinger, ok := r.Bus.(messaging.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+
+	return nil
+}
+
 func (r *RegistryResources) Release(ctx context.Context) {
 	wg := sync.WaitGroup{}
 	wg.Add(2)
@@ -65,10 +109,14 @@ func (r *RegistryResources) Release(ctx context.Context) {
 
 	go func(ctx context.Context, wg *sync.WaitGroup) {
 		defer wg.Done()
-		if err := r.Producer.Close(); err != nil {
-			r.log.Error("Got an error while releasing kafka resources.", "err", err)
+		if err := r.Bus.Close(); err != nil {
+			r.log.Error("Got an error while releasing message bus resources.", "err", err)
 		}
 	}(ctx, &wg)
 
 	wg.Wait()
+
+	if err := r.shutdownTracing(ctx); err != nil {
+		r.log.Error("Got an error while flushing traces.", "err", err)
+	}
 }
@@ -2,70 +2,111 @@ package consumers
 
 import (
 	"context"
+	"eCommerce/contracts"
+	"eCommerce/messaging"
 	"eCommerce/registry/internal/data"
 	"eCommerce/registry/internal/models"
-	"encoding/json"
-	"github.com/segmentio/kafka-go"
+	"eCommerce/telemetry"
+	"errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.uber.org/zap"
+	"sync"
 )
 
 type ConsumerBinding struct {
 	topic   string
-	handler func(message *kafka.Message)
+	group   string
+	handler messaging.Handler
 }
 
-func RunConsumers(baseConfig kafka.ReaderConfig, bindings []ConsumerBinding) {
+// RunningConsumer is the started consumer of the binding and its reader, which is closed after the consumer is stopped.
+type RunningConsumer struct {
+	Consumer *messaging.Consumer
+	Reader   messaging.Reader
+}
+
+// RunConsumers starts consumer of each binding. Consumers are stopped when the context is done or the bus is closed.
+// Messages which are failed after all attempts are moved to the dead letter topics. Responses of different orders
+// are processed concurrently by the pool of workers.
+func RunConsumers(ctx context.Context, log *zap.SugaredLogger, bus messaging.Bus, policy messaging.RetryPolicy, pool messaging.PoolConfig, bindings []ConsumerBinding) ([]RunningConsumer, error) {
+	started := make([]RunningConsumer, 0, len(bindings))
 	for _, bind := range bindings {
-		baseConfig.Topic = bind.topic
-		reader := kafka.NewReader(baseConfig)
-		RunConsumer(reader, bind.handler)
+		reader, err := bus.Subscribe(bind.topic, bind.group)
+		if err != nil {
+			return started, err
+		}
+
+		consumer := messaging.NewConsumer(log, reader, bus, bind.handler, policy, pool)
+		started = append(started, RunningConsumer{Consumer: consumer, Reader: reader})
+		go consumer.Run(ctx)
 	}
+
+	return started, nil
 }
 
-func RunConsumer(reader *kafka.Reader, handler func(message *kafka.Message)) {
-	go func() {
-		for {
-			m, err := reader.ReadMessage(context.Background())
-			if err != nil {
-				continue
-			}
+const (
+	StorageReserveOrderResponseGroup = `storage-reserve-order-response-group`
+	StorageCancelOrderResponseGroup  = `storage-cancel-order-response-group`
+	StorageCommitOrderResponseGroup  = `storage-commit-order-response-group`
+	StorageReservationExpiredGroup   = `storage-reservation-expired-group`
+	WalletPayOrderResponseGroup      = `wallet-pay-order-response-group`
+	WalletCancelOrderResponseGroup   = `wallet-cancel-order-response-group`
+)
 
-			handler(&m)
-		}
-	}()
-}
+// CanceledByCustomer is the timeline message of the compensation requested by the customer.
+const CanceledByCustomer = `canceled by customer`
+
+// PaidAfterError is the timeline message of the refund of the payment which arrived after the order failed.
+const PaidAfterError = `paid after the order failed`
 
 type OrderConsumerSet struct {
 	log        *zap.SugaredLogger
 	repository data.RegistryRepository
-	producer   *kafka.Writer
+	codec      contracts.Codec
 
 	bindings []ConsumerBinding
+
+	mu        sync.Mutex
+	consumers []RunningConsumer
 }
 
-func NewOrderConsumerSet(log *zap.SugaredLogger, producer *kafka.Writer, repository data.RegistryRepository) *OrderConsumerSet {
+func NewOrderConsumerSet(log *zap.SugaredLogger, repository data.RegistryRepository, codec contracts.Codec) *OrderConsumerSet {
 	set := new(OrderConsumerSet)
 	set.log = log
-	set.producer = producer
 	set.repository = repository
+	set.codec = codec
 
 	set.bindings = []ConsumerBinding{
 		{
-			topic:   models.StorageReserveOrderResponseTopic,
+			topic:   contracts.StorageReserveOrderResponseTopic,
+			group:   StorageReserveOrderResponseGroup,
 			handler: set.OrderReservedHandler,
 		},
 		{
-			topic:   models.StorageCancelOrderResponseTopic,
+			topic:   contracts.StorageCancelOrderResponseTopic,
+			group:   StorageCancelOrderResponseGroup,
 			handler: set.OrderReserveCanceledHandler,
 		},
 		{
-			topic:   models.WalletPayOrderResponseTopic,
+			topic:   contracts.StorageCommitOrderResponseTopic,
+			group:   StorageCommitOrderResponseGroup,
+			handler: set.OrderCommittedHandler,
+		},
+		{
+			topic:   contracts.StorageReservationExpiredTopic,
+			group:   StorageReservationExpiredGroup,
+			handler: set.ReservationExpiredHandler,
+		},
+		{
+			topic:   contracts.WalletPayOrderResponseTopic,
+			group:   WalletPayOrderResponseGroup,
 			handler: set.OrderPaidHandler,
 		},
 		{
-			topic:   models.WalletCancelOrderResponseTopic,
+			topic:   contracts.WalletCancelOrderResponseTopic,
+			group:   WalletCancelOrderResponseGroup,
 			handler: set.OrderPayCanceledHandler,
 		},
 	}
@@ -73,172 +114,331 @@ func NewOrderConsumerSet(log *zap.SugaredLogger, producer *kafka.Writer, reposit
 	return set
 }
 
-func (oc *OrderConsumerSet) Run(baseCfg kafka.ReaderConfig) {
-	RunConsumers(baseCfg, oc.bindings)
+func (oc *OrderConsumerSet) Run(ctx context.Context, bus messaging.Bus, policy messaging.RetryPolicy, pool messaging.PoolConfig) error {
+	started, err := RunConsumers(ctx, oc.log, bus, policy, pool, oc.bindings)
+
+	oc.mu.Lock()
+	oc.consumers = append(oc.consumers, started...)
+	oc.mu.Unlock()
+
+	return err
+}
+
+// Ping reports readiness of the consumers. Returns messaging.ErrNotRunning until the set is started.
+func (oc *OrderConsumerSet) Ping(ctx context.Context) error {
+	oc.mu.Lock()
+	started := oc.consumers
+	oc.mu.Unlock()
+
+	if len(started) < len(oc.bindings) {
+		return messaging.ErrNotRunning
+	}
+
+	for _, running := range started {
+		if err := running.Consumer.Ping(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Stop stops fetching responses, waits for the responses being processed until the context is done and closes
+// the readers. Offsets of the processed responses are committed before the readers are closed.
+func (oc *OrderConsumerSet) Stop(ctx context.Context) error {
+	oc.mu.Lock()
+	started := oc.consumers
+	oc.consumers = nil
+	oc.mu.Unlock()
+
+	consumers := make([]*messaging.Consumer, len(started))
+	for i, running := range started {
+		consumers[i] = running.Consumer
+	}
+	err := messaging.StopConsumers(ctx, consumers...)
+
+	for _, running := range started {
+		if closeErr := running.Reader.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
 }
 
 // OrderReservedHandler processing products reservation result.
 // When products successfully reserved - update order status to 'ORDER_RESERVED', update a price of the order and
-// finally publish an event to kafka.
+// finally publish an event to the bus. Reservation of the order canceled by the customer is released instead.
 // When products reservation failed - update order status to 'Error'.
-func (oc *OrderConsumerSet) OrderReservedHandler(m *kafka.Message) {
-	order, err := ParseOrder(m.Value)
+// Status updates and the event are stored in the single transaction.
+func (oc *OrderConsumerSet) OrderReservedHandler(ctx context.Context, m *messaging.Message) error {
+	response, order, err := ParseOrder(m)
 	if err != nil {
-		oc.log.Error(err)
-		return
+		return messaging.Permanent(err)
 	}
 
 	if !IsSuccess(m) {
-		order, err = oc.repository.UpdateOrderStatus(order.Id, models.OrderError)
+		_, err = oc.repository.UpdateOrderStatusMessage(order.Id, models.OrderError, Message(m))
+		return oc.report(ctx, m, err)
+	}
+
+	err = data.WithUnitOfWork(ctx, oc.repository, func(uow data.RegistryRepository) error {
+		reserved, err := uow.UpdateOrder(order.Id, bson.D{
+			{"status", models.OrderReserved},
+			{"amount", order.Amount},
+		})
 		if err != nil {
-			oc.log.Error(err)
+			return err
+		}
+
+		if reserved.CancelRequested {
+			if err = oc.Publish(ctx, uow, contracts.StorageCancelOrderTopic, reserved.Event(), response); err != nil {
+				return err
+			}
+
+			_, err = uow.UpdateOrderStatusMessage(reserved.Id, models.OrderReservationCancelPending, CanceledByCustomer)
+			return err
+		}
+
+		if err = oc.Publish(ctx, uow, contracts.WalletPayOrderTopic, reserved.Event(), response); err != nil {
+			return err
 		}
-		return
-	}
 
-	order, err = oc.repository.UpdateOrder(order.Id, bson.D{
-		{"status", models.OrderReserved},
-		{"amount", order.Amount},
+		_, err = uow.UpdateOrderStatus(reserved.Id, models.OrderPaymentPending)
+		return err
 	})
-	if err != nil {
-		oc.log.Error(err)
-		return
-	}
+	return oc.report(ctx, m, err)
+}
 
-	value, err := json.Marshal(order)
+func (oc *OrderConsumerSet) OrderReserveCanceledHandler(ctx context.Context, m *messaging.Message) error {
+	_, order, err := ParseOrder(m)
 	if err != nil {
-		oc.log.Error(err)
-		return
+		return messaging.Permanent(err)
 	}
 
-	err = oc.Publish(models.WalletPayOrderTopic, order.Id.Hex(), value)
-	if err != nil {
-		oc.log.Error(err)
-		return
+	if IsSuccess(m) {
+		_, err = oc.repository.UpdateOrderStatus(order.Id, models.OrderCanceled)
+		return oc.report(ctx, m, err)
 	}
 
-	order, err = oc.repository.UpdateOrderStatus(order.Id, models.OrderPaymentPending)
-	if err != nil {
-		oc.log.Error(err)
-	}
+	_, err = oc.repository.UpdateOrderStatusMessage(order.Id, models.OrderCancellationError, Message(m))
+	return oc.report(ctx, m, err)
 }
 
-func (oc *OrderConsumerSet) OrderReserveCanceledHandler(m *kafka.Message) {
-	orderId, err := KeyOrderId(m)
+// OrderPaidHandler processing payment result.
+// When the order is paid - update order status to 'ORDER_PAID' and publish the command to commit its reservation.
+// Order which failed in the meantime, because its reservation is expired, is moved to 'ORDER_PAYMENT_CANCEL_PENDING'
+// and its payment is refunded.
+// Payment of the order canceled by the customer is refunded as well.
+// When the payment failed - release products reservation.
+func (oc *OrderConsumerSet) OrderPaidHandler(ctx context.Context, m *messaging.Message) error {
+	response, order, err := ParseOrder(m)
 	if err != nil {
-		panic("")
+		return messaging.Permanent(err)
 	}
 
 	if IsSuccess(m) {
-		_, err = oc.repository.UpdateOrderStatus(orderId, models.OrderCanceled)
+		err = data.WithUnitOfWork(ctx, oc.repository, func(uow data.RegistryRepository) error {
+			current, err := uow.FindOrderId(order.Id)
+			if err != nil {
+				return err
+			}
+
+			// Paid order keeps its status while the commit is published again, so the repeated payment is dropped.
+			if current.Status == models.OrderPaid {
+				telemetry.Logger(ctx, oc.log).Warnw("message dropped", "topic", m.Topic, "key", string(m.Key), "reason", "order is already paid")
+				return nil
+			}
+
+			if current.Status == models.OrderError {
+				telemetry.Logger(ctx, oc.log).Warnw("payment of the failed order is refunded", "order", order.Id.Hex())
+				if _, err = uow.CompareAndUpdateOrderStatus(current, models.OrderPaymentCancelPending, PaidAfterError); err != nil {
+					return err
+				}
+
+				return oc.Publish(ctx, uow, contracts.WalletCancelOrderTopic, current.Event(), response)
+			}
+
+			paid, err := uow.CompareAndUpdateOrderStatus(current, models.OrderPaid, "")
+			if err != nil {
+				return err
+			}
+
+			if paid.CancelRequested {
+				if _, err = uow.CompareAndUpdateOrderStatus(paid, models.OrderPaymentCancelPending, CanceledByCustomer); err != nil {
+					return err
+				}
+
+				return oc.Publish(ctx, uow, contracts.WalletCancelOrderTopic, paid.Event(), response)
+			}
+
+			return oc.Publish(ctx, uow, contracts.StorageCommitOrderTopic, paid.Event(), response)
+		})
+		return oc.report(ctx, m, err)
+	}
+
+	err = data.WithUnitOfWork(ctx, oc.repository, func(uow data.RegistryRepository) error {
+		_, err := uow.UpdateOrderStatusMessage(order.Id, models.OrderCancelPending, Message(m))
 		if err != nil {
-			oc.log.Error(err)
+			return err
 		}
-		return
-	}
 
-	_, err = oc.repository.UpdateOrderStatus(orderId, models.OrderCancellationError)
-	if err != nil {
-		oc.log.Error(err)
-	}
+		if err = oc.Publish(ctx, uow, contracts.StorageCancelOrderTopic, order, response); err != nil {
+			return err
+		}
+
+		_, err = uow.UpdateOrderStatus(order.Id, models.OrderReservationCancelPending)
+		return err
+	})
+	return oc.report(ctx, m, err)
 }
 
-func (oc *OrderConsumerSet) OrderPaidHandler(m *kafka.Message) {
-	orderId, err := KeyOrderId(m)
+// OrderCommittedHandler processing commit of the products reservation. Committed order is marked, so the watchdog
+// stops publishing the commit again. When the commit is rejected, because the reservation is expired,
+// the payment is refunded.
+func (oc *OrderConsumerSet) OrderCommittedHandler(ctx context.Context, m *messaging.Message) error {
+	response, order, err := ParseOrder(m)
 	if err != nil {
-		oc.log.Error(err)
-		return
+		return messaging.Permanent(err)
 	}
 
 	if IsSuccess(m) {
-		_, err := oc.repository.UpdateOrderStatus(orderId, models.OrderPaid)
-		if err != nil {
-			oc.log.Error(err)
-		}
-		return
+		_, err = oc.repository.UpdateOrder(order.Id, bson.D{{"committed", true}})
+		return oc.report(ctx, m, err)
 	}
 
-	_, err = oc.repository.UpdateOrderStatus(orderId, models.OrderCancelPending)
+	current, err := oc.repository.FindOrderId(order.Id)
 	if err != nil {
-		oc.log.Error(err)
-		return
+		return oc.report(ctx, m, err)
 	}
 
-	err = oc.Publish(models.StorageCancelOrderTopic, orderId.Hex(), m.Value)
+	return oc.report(ctx, m, oc.refund(ctx, current, Message(m), response))
+}
+
+// ReservationExpiredHandler processing reservation released by the storage because it was not committed in time.
+// Order waiting for the payment fails, paid order is refunded. Orders in other statuses are finished or already
+// compensated, so the event is dropped.
+func (oc *OrderConsumerSet) ReservationExpiredHandler(ctx context.Context, m *messaging.Message) error {
+	event, order, err := ParseOrder(m)
 	if err != nil {
-		oc.log.Error(err)
-		return
+		return messaging.Permanent(err)
 	}
 
-	_, err = oc.repository.UpdateOrderStatus(orderId, models.OrderReservationCancelPending)
+	current, err := oc.repository.FindOrderId(order.Id)
 	if err != nil {
-		oc.log.Error(err)
+		return oc.report(ctx, m, err)
 	}
+
+	message := "storage: " + Message(m)
+	switch current.Status {
+	case models.OrderReserved, models.OrderPaymentPending:
+		_, err = oc.repository.CompareAndUpdateOrderStatus(current, models.OrderError, message)
+	case models.OrderPaid:
+		err = oc.refund(ctx, current, message, event)
+	}
+
+	return oc.report(ctx, m, err)
 }
 
-func (oc *OrderConsumerSet) OrderPayCanceledHandler(m *kafka.Message) {
-	orderId, err := KeyOrderId(m)
+// refund moves the paid order to 'ORDER_PAYMENT_CANCEL_PENDING' and publishes the command to refund its payment.
+// Products of the order are released by OrderPayCanceledHandler.
+func (oc *OrderConsumerSet) refund(ctx context.Context, order *models.Order, message string, cause *contracts.Envelope) error {
+	return data.WithUnitOfWork(ctx, oc.repository, func(uow data.RegistryRepository) error {
+		if _, err := uow.CompareAndUpdateOrderStatus(order, models.OrderPaymentCancelPending, message); err != nil {
+			return err
+		}
+
+		return oc.Publish(ctx, uow, contracts.WalletCancelOrderTopic, order.Event(), cause)
+	})
+}
+
+func (oc *OrderConsumerSet) OrderPayCanceledHandler(ctx context.Context, m *messaging.Message) error {
+	response, order, err := ParseOrder(m)
 	if err != nil {
-		oc.log.Error(err)
-		return
+		return messaging.Permanent(err)
 	}
 
 	if !IsSuccess(m) {
-		_, err = oc.repository.UpdateOrderStatus(orderId, models.OrderCancellationError)
+		_, err = oc.repository.UpdateOrderStatusMessage(order.Id, models.OrderCancellationError, Message(m))
+		return oc.report(ctx, m, err)
+	}
+
+	err = data.WithUnitOfWork(ctx, oc.repository, func(uow data.RegistryRepository) error {
+		_, err := uow.UpdateOrderStatus(order.Id, models.OrderPaymentCanceled)
 		if err != nil {
-			oc.log.Error(err)
+			return err
 		}
-		return
-	}
 
-	_, err = oc.repository.UpdateOrderStatus(orderId, models.OrderPaymentCanceled)
-	if err != nil {
-		oc.log.Error(err)
-		return
-	}
+		if err = oc.Publish(ctx, uow, contracts.StorageCancelOrderTopic, order, response); err != nil {
+			return err
+		}
 
-	err = oc.Publish(models.StorageCancelOrderTopic, orderId.Hex(), m.Value)
-	if err != nil {
-		oc.log.Error(err)
-		return
+		_, err = uow.UpdateOrderStatus(order.Id, models.OrderReservationCancelPending)
+		return err
+	})
+	return oc.report(ctx, m, err)
+}
+
+// report classifies an error of the message processing.
+// Illegal status transitions are expected for late or duplicated messages, so such messages are dropped deliberately.
+// Order changed concurrently is retried by the consumer, so the handler reads it again.
+// Message of the unknown order is not retried, other errors are retried by the consumer.
+func (oc *OrderConsumerSet) report(ctx context.Context, m *messaging.Message, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, data.ErrIllegalTransition):
+		telemetry.Logger(ctx, oc.log).Warnw("message dropped", "topic", m.Topic, "key", string(m.Key), "reason", err)
+		return nil
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return messaging.Permanent(err)
 	}
 
-	_, err = oc.repository.UpdateOrderStatus(orderId, models.OrderReservationCancelPending)
+	return err
+}
+
+// Publish stages the command caused by the response in the outbox of the unit of work.
+// Message is sent to the bus after the commit and continues the trace of the context.
+func (oc *OrderConsumerSet) Publish(ctx context.Context, uow data.RegistryRepository, topic string, order *contracts.Order, response *contracts.Envelope) error {
+	message, err := models.NewOrderCommand(ctx, oc.codec, topic, order, response)
 	if err != nil {
-		oc.log.Error(err)
+		return err
 	}
-}
 
-func (oc *OrderConsumerSet) Publish(topic, key string, data []byte) error {
-	return oc.producer.WriteMessages(context.Background(), kafka.Message{
-		Topic: topic,
-		Key:   []byte(key),
-		Value: data,
-	})
+	return uow.Enqueue(message)
 }
 
-func KeyOrderId(m *kafka.Message) (primitive.ObjectID, error) {
+func KeyOrderId(m *messaging.Message) (primitive.ObjectID, error) {
 	return primitive.ObjectIDFromHex(string(m.Key))
 }
 
-func ParseOrder(data []byte) (*models.Order, error) {
-	order := new(models.Order)
-	err := json.Unmarshal(data, order)
+// ParseOrder decodes the envelope of the response and the order in its payload.
+// Codec of the envelope is selected by the content type header.
+func ParseOrder(m *messaging.Message) (*contracts.Envelope, *contracts.Order, error) {
+	contentType, _ := m.Header(contracts.HeaderContentType)
+
+	e, err := contracts.Decode(m.Topic, string(contentType), m.Value)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return order, nil
+	order := new(contracts.Order)
+	if err = e.Unmarshal(order); err != nil {
+		return nil, nil, err
+	}
+
+	return e, order, nil
 }
 
-func IsSuccess(m *kafka.Message) bool {
-	for _, h := range m.Headers {
-		if h.Key != `status` {
-			continue
-		}
+func IsSuccess(m *messaging.Message) bool {
+	status, _ := m.Header(contracts.HeaderStatus)
 
-		return len(h.Value) > 0 && h.Value[0] != 0
-	}
+	return contracts.IsSuccessStatus(status)
+}
+
+// Message returns text of the 'message' header which is describing result of the operation.
+func Message(m *messaging.Message) string {
+	message, _ := m.Header(contracts.HeaderMessage)
 
-	return false
+	return string(message)
 }
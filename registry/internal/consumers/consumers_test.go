@@ -0,0 +1,176 @@
+package consumers
+
+import (
+	"context"
+	"eCommerce/contracts"
+	"eCommerce/messaging"
+	"eCommerce/registry/internal/data"
+	"eCommerce/registry/internal/models"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+// Response is processed while the order is changed concurrently, so the handler fails and the consumer
+// retries it against the new version of the order.
+func TestStaleOrderIsRetried(t *testing.T) {
+	outbox := data.NewMemoryOutboxRepository()
+	repository := &staleOrders{RegistryRepository: data.NewMemoryRegistryRepository(outbox, data.NewMemoryIdempotencyRepository()), stale: 1}
+	set := NewOrderConsumerSet(zap.NewNop().Sugar(), repository, contracts.JSONCodec{})
+	order := insertOrder(t, repository, models.OrderReservationPending)
+	m := response(t, contracts.StorageReserveOrderResponseTopic, order)
+
+	err := set.OrderReservedHandler(context.Background(), m)
+	if !errors.Is(err, data.ErrStaleOrder) || messaging.IsPermanent(err) {
+		t.Fatalf("handler of the stale order: %v, want retried %v", err, data.ErrStaleOrder)
+	}
+	if got := findOrder(t, repository, order.Id); got.Status != models.OrderReservationPending {
+		t.Fatalf("status %s after the failed attempt, want %s", got.Status, models.OrderReservationPending)
+	}
+	if pending, _ := outbox.Pending(10); len(pending) != 0 {
+		t.Fatalf("outbox has %d commands of the failed attempt", len(pending))
+	}
+
+	if err = set.OrderReservedHandler(context.Background(), m); err != nil {
+		t.Fatalf("retried handler: %v", err)
+	}
+	if got := findOrder(t, repository, order.Id); got.Status != models.OrderPaymentPending {
+		t.Fatalf("status %s after the retry, want %s", got.Status, models.OrderPaymentPending)
+	}
+	if pending, _ := outbox.Pending(10); len(pending) != 1 || pending[0].Topic != contracts.WalletPayOrderTopic {
+		t.Fatalf("outbox has %d commands, want %s", len(pending), contracts.WalletPayOrderTopic)
+	}
+}
+
+// Late response is not allowed to change the order anymore, so it is dropped.
+func TestIllegalTransitionIsDropped(t *testing.T) {
+	repository := data.NewMemoryRegistryRepository(data.NewMemoryOutboxRepository(), data.NewMemoryIdempotencyRepository())
+	set := NewOrderConsumerSet(zap.NewNop().Sugar(), repository, contracts.JSONCodec{})
+	order := insertOrder(t, repository, models.OrderError)
+
+	if err := set.OrderReservedHandler(context.Background(), response(t, contracts.StorageReserveOrderResponseTopic, order)); err != nil {
+		t.Fatalf("handler of the late response: %v, want dropped", err)
+	}
+	if got := findOrder(t, repository, order.Id); got.Status != models.OrderError {
+		t.Fatalf("status %s, want %s", got.Status, models.OrderError)
+	}
+}
+
+// Payment which arrives after the order failed moves the order to the payment cancellation, so the refund is
+// watched like any other compensation. Redelivered payment is dropped without another refund.
+func TestLatePaymentIsRefunded(t *testing.T) {
+	outbox := data.NewMemoryOutboxRepository()
+	repository := data.NewMemoryRegistryRepository(outbox, data.NewMemoryIdempotencyRepository())
+	set := NewOrderConsumerSet(zap.NewNop().Sugar(), repository, contracts.JSONCodec{})
+	order := insertOrder(t, repository, models.OrderError)
+	m := response(t, contracts.WalletPayOrderResponseTopic, order)
+
+	for i := 0; i < 2; i++ {
+		if err := set.OrderPaidHandler(context.Background(), m); err != nil {
+			t.Fatalf("handler of the late payment: %v", err)
+		}
+	}
+
+	got := findOrder(t, repository, order.Id)
+	if got.Status != models.OrderPaymentCancelPending || got.Updates[len(got.Updates)-1].Message != PaidAfterError {
+		t.Fatalf("status %s, want %s", got.Status, models.OrderPaymentCancelPending)
+	}
+
+	pending, _ := outbox.Pending(10)
+	if len(pending) != 1 || pending[0].Topic != contracts.WalletCancelOrderTopic || pending[0].Key != order.Id.Hex() {
+		t.Fatalf("outbox %+v, want the single %s", pending, contracts.WalletCancelOrderTopic)
+	}
+	e, err := contracts.Decode(pending[0].Topic, contracts.ContentTypeJSON, pending[0].Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refund := new(contracts.Order)
+	if err = e.Unmarshal(refund); err != nil {
+		t.Fatal(err)
+	}
+	if refund.Id != order.Id || refund.UserId != order.UserId || len(refund.Items) != len(order.Items) {
+		t.Errorf("refund of %+v, want the order %s", refund, order.Id.Hex())
+	}
+}
+
+func insertOrder(t *testing.T, repository data.RegistryRepository, status models.OrderStatus) *models.Order {
+	t.Helper()
+
+	now := time.Now().UTC()
+	order, err := repository.InsertOrder(&models.Order{
+		UserId:    primitive.NewObjectID(),
+		Status:    status,
+		Timestamp: now,
+		Items:     []models.OrderProduct{{Sku: "apple", Quantity: 1}},
+		Updates:   []models.OrderUpdate{{Status: status, Timestamp: now}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return order
+}
+
+func findOrder(t *testing.T, repository data.RegistryRepository, id primitive.ObjectID) *models.Order {
+	t.Helper()
+
+	order, err := repository.FindOrderId(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return order
+}
+
+// response returns the successful response of the storage or the wallet.
+func response(t *testing.T, topic string, order *models.Order) *messaging.Message {
+	t.Helper()
+
+	eventType, _ := contracts.TopicEvent(topic)
+	e, err := contracts.NewEnvelope(eventType, order.Id.Hex(), order.Event())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	codec := contracts.JSONCodec{}
+	value, err := codec.Encode(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &messaging.Message{Topic: topic, Key: []byte(order.Id.Hex()), Value: value}
+	m.SetHeader(contracts.HeaderContentType, []byte(codec.ContentType()))
+	m.SetHeader(contracts.HeaderStatus, contracts.StatusHeader(true))
+
+	return m
+}
+
+// staleOrders rejects the first stale updates made in the unit of work, as the version of the order
+// read by the handler was changed by another replica.
+type staleOrders struct {
+	data.RegistryRepository
+	stale  int
+	parent *staleOrders
+}
+
+func (s *staleOrders) Begin(ctx context.Context) (data.RegistryRepository, error) {
+	uow, err := s.RegistryRepository.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &staleOrders{RegistryRepository: uow, parent: s}, nil
+}
+
+func (s *staleOrders) UpdateOrder(id primitive.ObjectID, updates []bson.E) (*models.Order, error) {
+	if s.parent != nil && s.parent.stale > 0 {
+		s.parent.stale--
+		status, _ := data.StatusOf(updates)
+		return nil, &data.TransitionError{OrderId: id, To: status, Err: data.ErrStaleOrder}
+	}
+
+	return s.RegistryRepository.UpdateOrder(id, updates)
+}
@@ -0,0 +1,23 @@
+package consumers
+
+import "eCommerce/contracts"
+
+// Topics declared by the registry. Topics are ensured on startup, consumed topics are declared with dead letter topics.
+var (
+	ConsumedTopics = []string{
+		contracts.StorageReserveOrderResponseTopic,
+		contracts.StorageCancelOrderResponseTopic,
+		contracts.StorageCommitOrderResponseTopic,
+		contracts.StorageReservationExpiredTopic,
+		contracts.WalletPayOrderResponseTopic,
+		contracts.WalletCancelOrderResponseTopic,
+	}
+	ProducedTopics = []string{
+		contracts.StorageReserveOrderTopic,
+		contracts.StorageCancelOrderTopic,
+		contracts.StorageCommitOrderTopic,
+		contracts.WalletCreateTopic,
+		contracts.WalletPayOrderTopic,
+		contracts.WalletCancelOrderTopic,
+	}
+)
@@ -0,0 +1,16 @@
+package core
+
+import "errors"
+
+var (
+	ErrOrderNotFound         = errors.New(`order not found`)
+	ErrOrderAccessDenied     = errors.New(`order belongs to another user`)
+	ErrOrdersAccessDenied    = errors.New(`orders of another user are not accessible`)
+	ErrOrderCancelNotAllowed = errors.New(`order can not be canceled in the current status`)
+	ErrOrderChanged          = errors.New(`order was changed, try again`)
+	ErrOrderItemInvalid      = errors.New(`order item requires unique sku and positive quantity`)
+
+	ErrIdempotencyKeyInvalid    = errors.New(`idempotency key must be from 1 to 255 characters long`)
+	ErrIdempotencyKeyReused     = errors.New(`idempotency key was already used with a different request`)
+	ErrIdempotencyKeyInProgress = errors.New(`request with the same idempotency key is in progress`)
+)
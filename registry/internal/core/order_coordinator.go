@@ -2,55 +2,150 @@ package core
 
 import (
 	"context"
+	"eCommerce/contracts"
+	"eCommerce/messaging"
 	"eCommerce/registry/internal/consumers"
 	"eCommerce/registry/internal/data"
 	"eCommerce/registry/internal/models"
-	"encoding/json"
-	"github.com/segmentio/kafka-go"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.uber.org/zap"
 )
 
 // OrderCoordinator is managing order flow.
 type OrderCoordinator struct {
 	log        *zap.SugaredLogger
-	producer   *kafka.Writer
 	consumers  *consumers.OrderConsumerSet
 	repository data.RegistryRepository
+	policy     messaging.RetryPolicy
+	pool       messaging.PoolConfig
+	codec      contracts.Codec
 }
 
-func NewOrderCoordinator(log *zap.SugaredLogger, repository data.RegistryRepository, writer *kafka.Writer) *OrderCoordinator {
+// NewOrderCoordinator returns the coordinator which publishes commands of the saga encoded with the codec.
+func NewOrderCoordinator(log *zap.SugaredLogger, repository data.RegistryRepository, codec contracts.Codec, policy messaging.RetryPolicy, pool messaging.PoolConfig) *OrderCoordinator {
 	oc := new(OrderCoordinator)
 	oc.log = log
-	oc.producer = writer
 	oc.repository = repository
-	oc.consumers = consumers.NewOrderConsumerSet(log, writer, repository)
+	oc.policy = policy
+	oc.pool = pool
+	oc.codec = codec
+	oc.consumers = consumers.NewOrderConsumerSet(log, repository, codec)
 
 	return oc
 }
 
-func (oc *OrderCoordinator) Run(baseConfig kafka.ReaderConfig) {
-	oc.consumers.Run(baseConfig)
+// Run starts consumers of the saga responses. Failed responses are retried according to the policy and then
+// moved to the dead letter topics.
+func (oc *OrderCoordinator) Run(ctx context.Context, bus messaging.Bus) error {
+	return oc.consumers.Run(ctx, bus, oc.policy, oc.pool)
 }
 
-// NewOrder initialize new order saga and publish an event to kafka to reserve credit.
-func (oc *OrderCoordinator) NewOrder(order *models.Order) {
-	value, err := json.Marshal(order)
+// Stop drains consumers of the saga responses within the deadline of the context.
+func (oc *OrderCoordinator) Stop(ctx context.Context) error {
+	return oc.consumers.Stop(ctx)
+}
+
+// Ping reports readiness of the saga consumers.
+func (oc *OrderCoordinator) Ping(ctx context.Context) error {
+	return oc.consumers.Ping(ctx)
+}
+
+// NewOrder stores new order and initialize its saga with an event to reserve products.
+// Order and the event are stored in the same transaction, the event is published to the bus by the outbox relay.
+// When the key is not zero, the idempotency key is completed with the order in the same transaction.
+func (oc *OrderCoordinator) NewOrder(ctx context.Context, order *models.Order, key primitive.ObjectID) (*models.Order, error) {
+	err := data.WithUnitOfWork(ctx, oc.repository, func(uow data.RegistryRepository) error {
+		inserted, err := uow.InsertOrder(order)
+		if err != nil {
+			return err
+		}
+
+		message, err := models.NewOrderCommand(ctx, oc.codec, contracts.StorageReserveOrderTopic, inserted.Event(), nil)
+		if err != nil {
+			return err
+		}
+
+		if err = uow.Enqueue(message); err != nil {
+			return err
+		}
+
+		if order, err = uow.UpdateOrderStatus(inserted.Id, models.OrderReservationPending); err != nil {
+			return err
+		}
+
+		if key.IsZero() {
+			return nil
+		}
+
+		return uow.CompleteIdempotencyKey(key, order)
+	})
 	if err != nil {
-		oc.log.Error(err)
-		return
+		return nil, err
 	}
 
-	err = oc.producer.WriteMessages(context.Background(), kafka.Message{
-		Topic: models.StorageReserveOrderTopic,
-		Key:   []byte(order.Id.Hex()),
-		Value: value,
+	return order, nil
+}
+
+// CancelOrder starts compensation chain for the order depending on its current status.
+// Paid order is refunded first and then its reservation is released by OrderPayCanceledHandler.
+// Order waiting for the reservation or the payment is marked to be canceled, compensation is started by the handler
+// of the pending response, so it follows whatever the response is.
+func (oc *OrderCoordinator) CancelOrder(ctx context.Context, order *models.Order) (*models.Order, error) {
+	switch order.Status {
+	case models.OrderPaid:
+		return oc.refund(ctx, order)
+	case models.OrderReservationPending, models.OrderPaymentPending:
+		return oc.requestCancel(order)
+	}
+
+	return nil, ErrOrderCancelNotAllowed
+}
+
+// refund moves the paid order to 'ORDER_PAYMENT_CANCEL_PENDING' and publishes the command to refund its payment.
+func (oc *OrderCoordinator) refund(ctx context.Context, order *models.Order) (*models.Order, error) {
+	message, err := models.NewOrderCommand(ctx, oc.codec, contracts.WalletCancelOrderTopic, order.Event(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = data.WithUnitOfWork(ctx, oc.repository, func(uow data.RegistryRepository) error {
+		if err := uow.Enqueue(message); err != nil {
+			return err
+		}
+
+		order, err = uow.CompareAndUpdateOrderStatus(order, models.OrderPaymentCancelPending, consumers.CanceledByCustomer)
+		return err
 	})
 	if err != nil {
-		oc.log.Error(err)
+		return nil, cancelError(err)
+	}
+
+	return order, nil
+}
+
+// requestCancel marks the pending order to be canceled. Repeated request returns the order unchanged.
+func (oc *OrderCoordinator) requestCancel(order *models.Order) (*models.Order, error) {
+	if order.CancelRequested {
+		return order, nil
 	}
 
-	order, err = oc.repository.UpdateOrderStatus(order.Id, models.OrderReservationPending)
+	order, err := oc.repository.CompareAndUpdateOrder(order, bson.D{{"cancel_requested", true}})
 	if err != nil {
-		oc.log.Error(err)
+		return nil, cancelError(err)
 	}
+
+	return order, nil
+}
+
+func cancelError(err error) error {
+	switch {
+	case errors.Is(err, data.ErrStaleOrder):
+		return ErrOrderChanged
+	case errors.Is(err, data.ErrIllegalTransition):
+		return ErrOrderCancelNotAllowed
+	}
+
+	return err
 }
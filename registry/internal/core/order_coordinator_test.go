@@ -0,0 +1,245 @@
+package core
+
+import (
+	"context"
+	"eCommerce/contracts"
+	"eCommerce/messaging"
+	"eCommerce/registry/internal/consumers"
+	"eCommerce/registry/internal/data"
+	"eCommerce/registry/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+func TestCancelPendingOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		// responses are delivered before the order is canceled.
+		before []string
+		// response is delivered after the order is canceled.
+		response string
+		success  bool
+		status   models.OrderStatus
+		command  string
+	}{
+		{
+			name:     "reserved",
+			response: contracts.StorageReserveOrderResponseTopic,
+			success:  true,
+			status:   models.OrderReservationCancelPending,
+			command:  contracts.StorageCancelOrderTopic,
+		},
+		{
+			name:     "not reserved",
+			response: contracts.StorageReserveOrderResponseTopic,
+			status:   models.OrderError,
+		},
+		{
+			name:     "paid",
+			before:   []string{contracts.StorageReserveOrderResponseTopic},
+			response: contracts.WalletPayOrderResponseTopic,
+			success:  true,
+			status:   models.OrderPaymentCancelPending,
+			command:  contracts.WalletCancelOrderTopic,
+		},
+		{
+			name:     "not paid",
+			before:   []string{contracts.StorageReserveOrderResponseTopic},
+			response: contracts.WalletPayOrderResponseTopic,
+			status:   models.OrderReservationCancelPending,
+			command:  contracts.StorageCancelOrderTopic,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSaga(t)
+			order := s.newOrder(t)
+			for _, topic := range tt.before {
+				s.respond(t, topic, order, true)
+			}
+			s.sent(t)
+
+			pending := s.find(t, order.Id)
+			canceled, err := s.coordinator.CancelOrder(context.Background(), pending)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if canceled.Status != pending.Status || !canceled.CancelRequested {
+				t.Fatalf("canceled order %s, cancel requested %v, want %s and requested", canceled.Status, canceled.CancelRequested, pending.Status)
+			}
+
+			// Repeated cancellation does not change the order.
+			if _, err = s.coordinator.CancelOrder(context.Background(), canceled); err != nil {
+				t.Fatalf("repeated cancel: %v", err)
+			}
+
+			s.respond(t, tt.response, order, tt.success)
+
+			compensated := s.find(t, order.Id)
+			if compensated.Status != tt.status {
+				t.Errorf("status %s, want %s", compensated.Status, tt.status)
+			}
+			if last := compensated.Updates[len(compensated.Updates)-1]; tt.success && last.Message != consumers.CanceledByCustomer {
+				t.Errorf("timeline message %q, want %q", last.Message, consumers.CanceledByCustomer)
+			}
+
+			var want []string
+			if tt.command != "" {
+				want = []string{tt.command}
+			}
+			if got := s.sent(t); !equalTopics(got, want) {
+				t.Errorf("commands %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestCancelOrderNotAllowed(t *testing.T) {
+	s := newSaga(t)
+	order := s.newOrder(t)
+	s.respond(t, contracts.StorageReserveOrderResponseTopic, order, false)
+
+	_, err := s.coordinator.CancelOrder(context.Background(), s.find(t, order.Id))
+	if err != ErrOrderCancelNotAllowed {
+		t.Fatalf("cancel failed order: %v, want %v", err, ErrOrderCancelNotAllowed)
+	}
+}
+
+func TestCancelChangedOrder(t *testing.T) {
+	s := newSaga(t)
+	order := s.newOrder(t)
+	read := s.find(t, order.Id)
+	s.respond(t, contracts.StorageReserveOrderResponseTopic, order, true)
+
+	_, err := s.coordinator.CancelOrder(context.Background(), read)
+	if err != ErrOrderChanged {
+		t.Fatalf("cancel changed order: %v, want %v", err, ErrOrderChanged)
+	}
+}
+
+// saga is the coordinator over the in-memory repository. Responses are passed to the handlers directly.
+type saga struct {
+	coordinator *OrderCoordinator
+	repository  data.RegistryRepository
+	outbox      *data.MemoryOutboxRepository
+}
+
+func newSaga(t *testing.T) *saga {
+	t.Helper()
+
+	s := new(saga)
+	s.outbox = data.NewMemoryOutboxRepository()
+	s.repository = data.NewMemoryRegistryRepository(s.outbox, data.NewMemoryIdempotencyRepository())
+	s.coordinator = NewOrderCoordinator(zap.NewNop().Sugar(), s.repository, contracts.JSONCodec{}, messaging.RetryPolicy{}, messaging.PoolConfig{})
+
+	return s
+}
+
+// newOrder creates the order waiting for the reservation.
+func (s *saga) newOrder(t *testing.T) *models.Order {
+	t.Helper()
+
+	now := time.Now().UTC()
+	order, err := s.coordinator.NewOrder(context.Background(), &models.Order{
+		UserId:    primitive.NewObjectID(),
+		Status:    models.OrderPending,
+		Timestamp: now,
+		Items:     []models.OrderProduct{{Sku: "apple", Quantity: 1}},
+		Updates:   []models.OrderUpdate{{Status: models.OrderPending, Timestamp: now}},
+	}, primitive.NilObjectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return order
+}
+
+func (s *saga) find(t *testing.T, id primitive.ObjectID) *models.Order {
+	t.Helper()
+
+	order, err := s.repository.FindOrderId(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return order
+}
+
+// respond passes the response of the storage or the wallet to its handler.
+func (s *saga) respond(t *testing.T, topic string, order *models.Order, success bool) {
+	t.Helper()
+
+	handlers := map[string]messaging.Handler{
+		contracts.StorageReserveOrderResponseTopic: s.coordinator.consumers.OrderReservedHandler,
+		contracts.WalletPayOrderResponseTopic:      s.coordinator.consumers.OrderPaidHandler,
+		contracts.WalletCancelOrderResponseTopic:   s.coordinator.consumers.OrderPayCanceledHandler,
+		contracts.StorageCancelOrderResponseTopic:  s.coordinator.consumers.OrderReserveCanceledHandler,
+		contracts.StorageCommitOrderResponseTopic:  s.coordinator.consumers.OrderCommittedHandler,
+	}
+
+	if err := handlers[topic](context.Background(), response(t, topic, order, success)); err != nil {
+		t.Fatalf("%s: %v", topic, err)
+	}
+}
+
+// sent returns topics of the commands staged in the outbox since the last call and marks them sent.
+func (s *saga) sent(t *testing.T) []string {
+	t.Helper()
+
+	pending, err := s.outbox.Pending(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var topics []string
+	for _, m := range pending {
+		topics = append(topics, m.Topic)
+		if err = s.outbox.MarkSent(m.Id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return topics
+}
+
+// response returns the message of the storage or the wallet with the result of the command.
+func response(t *testing.T, topic string, order *models.Order, success bool) *messaging.Message {
+	t.Helper()
+
+	eventType, _ := contracts.TopicEvent(topic)
+	e, err := contracts.NewEnvelope(eventType, order.Id.Hex(), order.Event())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	codec := contracts.JSONCodec{}
+	value, err := codec.Encode(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &messaging.Message{Topic: topic, Key: []byte(order.Id.Hex()), Value: value}
+	m.SetHeader(contracts.HeaderContentType, []byte(codec.ContentType()))
+	m.SetHeader(contracts.HeaderStatus, contracts.StatusHeader(success))
+	if !success {
+		m.SetHeader(contracts.HeaderMessage, []byte("rejected"))
+	}
+
+	return m
+}
+
+func equalTopics(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -2,46 +2,143 @@ package core
 
 import (
 	"context"
+	"crypto/sha256"
 	"eCommerce/registry/internal/api/requests"
+	"eCommerce/registry/internal/data"
 	"eCommerce/registry/internal/models"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
-	"github.com/segmentio/kafka-go"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.uber.org/zap"
+	"strings"
 	"time"
 )
 
 type PurchaseController interface {
-	Order(userId primitive.ObjectID, r *requests.OrderRequest) (*models.Order, error)
+	Order(ctx context.Context, userId primitive.ObjectID, r *requests.OrderRequest) (*models.Order, error)
+	OrderOnce(ctx context.Context, userId primitive.ObjectID, key string, r *requests.OrderRequest) (*models.Order, bool, error)
 	ListOrders(r *requests.PageRequest) ([]models.Order, error)
 	ListUserOrders(userId primitive.ObjectID, r *requests.PageRequest) ([]models.Order, error)
+	CancelOrder(ctx context.Context, userId, orderId primitive.ObjectID) (*models.Order, error)
+	GetOrder(orderId primitive.ObjectID) (*models.OrderDetails, error)
 }
 
+const MaxIdempotencyKeyLength = 255
+
 type Purchaser struct {
 	log         *zap.SugaredLogger
-	Orders      *mongo.Collection
-	Producer    *kafka.Writer
+	Orders      data.RegistryRepository
 	Coordinator *OrderCoordinator
+	Keys        data.IdempotencyRepository
+	KeyTTL      time.Duration
+	// KeyLease is the time the request holds the pending idempotency key. Key is acquired again by the next request
+	// when the order was not created within the lease.
+	KeyLease time.Duration
 }
 
-func NewPurchaser(log *zap.SugaredLogger, db *mongo.Database, producer *kafka.Writer, c *OrderCoordinator) *Purchaser {
+func NewPurchaser(log *zap.SugaredLogger, orders data.RegistryRepository, c *OrderCoordinator, keys data.IdempotencyRepository, keyTTL, keyLease time.Duration) *Purchaser {
 	p := new(Purchaser)
 	p.log = log
-	p.Orders = db.Collection("orders")
-	p.Producer = producer
+	p.Orders = orders
 	p.Coordinator = c
+	p.Keys = keys
+	p.KeyTTL = keyTTL
+	p.KeyLease = keyLease
 
 	return p
 }
 
 // Order creating an order and publish event
-func (p *Purchaser) Order(userId primitive.ObjectID, r *requests.OrderRequest) (*models.Order, error) {
+func (p *Purchaser) Order(ctx context.Context, userId primitive.ObjectID, r *requests.OrderRequest) (*models.Order, error) {
+	order, err := NewPendingOrder(userId, r)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.Coordinator.NewOrder(ctx, order, primitive.NilObjectID)
+}
+
+// OrderOnce creates an order only once for the idempotency key of the user.
+// Repeated request with the same key and body returns the original order and true as the second result.
+// Request with the same key and different body is rejected.
+// Key is completed in the same transaction the order is created, so the order is never created twice:
+// request which lease of the key is over fails with ErrIdempotencyKeyInProgress when the key is taken by another one.
+func (p *Purchaser) OrderOnce(ctx context.Context, userId primitive.ObjectID, key string, r *requests.OrderRequest) (*models.Order, bool, error) {
+	if len(key) == 0 || len(key) > MaxIdempotencyKeyLength {
+		return nil, false, ErrIdempotencyKeyInvalid
+	}
+
+	order, err := NewPendingOrder(userId, r)
+	if err != nil {
+		return nil, false, err
+	}
+
+	fingerprint, err := RequestFingerprint(r)
+	if err != nil {
+		return nil, false, err
+	}
+
+	now := time.Now().UTC()
+	stored, acquired, err := p.Keys.Acquire(&models.IdempotencyKey{
+		Key:         key,
+		UserId:      userId,
+		Fingerprint: fingerprint,
+		Status:      models.IdempotencyPending,
+		CreatedAt:   now,
+		LockedUntil: now.Add(p.KeyLease),
+		ExpiresAt:   now.Add(p.KeyTTL),
+	})
+	if errors.Is(err, data.ErrIdempotencyKeyContended) {
+		return nil, false, ErrIdempotencyKeyInProgress
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if !acquired {
+		switch {
+		case stored.Fingerprint != fingerprint:
+			return nil, false, ErrIdempotencyKeyReused
+		case stored.Status != models.IdempotencyCompleted:
+			return nil, false, ErrIdempotencyKeyInProgress
+		default:
+			return stored.Response, true, nil
+		}
+	}
+
+	order, err = p.Coordinator.NewOrder(ctx, order, stored.Id)
+	if errors.Is(err, data.ErrIdempotencyKeyReleased) {
+		return nil, false, ErrIdempotencyKeyInProgress
+	}
+	if err != nil {
+		// Key is released, so client is able to retry failed request with the same key.
+		if releaseErr := p.Keys.Release(stored.Id); releaseErr != nil {
+			p.log.Error(releaseErr)
+		}
+		return nil, false, err
+	}
+
+	return order, false, nil
+}
+
+// NewPendingOrder validates the request and returns the new order of the user.
+func NewPendingOrder(userId primitive.ObjectID, r *requests.OrderRequest) (*models.Order, error) {
 	if r.Items == nil || len(r.Items) == 0 {
 		return nil, errors.New(`no items in order`)
 	}
+	// Storage reserves each SKU once, so items of the same product are sent as a single item.
+	skus := make(map[string]struct{}, len(r.Items))
+	for _, item := range r.Items {
+		if strings.TrimSpace(item.Sku) == "" || item.Quantity <= 0 {
+			return nil, ErrOrderItemInvalid
+		}
+		if _, ok := skus[item.Sku]; ok {
+			return nil, ErrOrderItemInvalid
+		}
+		skus[item.Sku] = struct{}{}
+	}
 
 	order := new(models.Order)
 	order.UserId = userId
@@ -55,59 +152,57 @@ func (p *Purchaser) Order(userId primitive.ObjectID, r *requests.OrderRequest) (
 		},
 	}
 
-	one, err := p.Orders.InsertOne(context.Background(), order)
+	return order, nil
+}
+
+// RequestFingerprint returns hash of the order request used to detect reuse of the idempotency key.
+func RequestFingerprint(r *requests.OrderRequest) (string, error) {
+	body, err := json.Marshal(r)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
-	order.Id = one.InsertedID.(primitive.ObjectID)
-	p.Coordinator.NewOrder(order)
-
-	return order, nil
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:]), nil
 }
 
-func (p *Purchaser) ListOrders(r *requests.PageRequest) ([]models.Order, error) {
-	opt := options.Find()
-	opt.SetSort(bson.D{{"_id", -1}})
-	opt.SetSkip(int64(r.Page * r.Size))
-	opt.SetLimit(int64(r.Size))
-
-	records, err := p.Orders.Find(context.Background(), bson.D{}, opt)
+// CancelOrder checks that order belongs to the user and passes it to the coordinator for cancellation.
+func (p *Purchaser) CancelOrder(ctx context.Context, userId, orderId primitive.ObjectID) (*models.Order, error) {
+	order, err := p.findOrder(orderId)
 	if err != nil {
 		return nil, err
 	}
 
-	list := make([]models.Order, 0, r.Size)
-	for records.Next(context.Background()) {
-		var record models.Order
-		if err = records.Decode(&record); err != nil {
-			return nil, err
-		}
-		list = append(list, record)
+	if order.UserId != userId {
+		return nil, ErrOrderAccessDenied
 	}
 
-	return list, nil
+	return p.Coordinator.CancelOrder(ctx, order)
 }
 
-func (p *Purchaser) ListUserOrders(userId primitive.ObjectID, r *requests.PageRequest) ([]models.Order, error) {
-	opt := options.Find()
-	opt.SetSort(bson.D{{"_id", -1}})
-	opt.SetSkip(int64(r.Page * r.Size))
-	opt.SetLimit(int64(r.Size))
-	filter := bson.D{{"user_id", userId}}
-	records, err := p.Orders.Find(context.Background(), filter, opt)
+// GetOrder returns the order with the timeline of its status updates.
+func (p *Purchaser) GetOrder(orderId primitive.ObjectID) (*models.OrderDetails, error) {
+	order, err := p.findOrder(orderId)
 	if err != nil {
 		return nil, err
 	}
 
-	list := make([]models.Order, 0, r.Size)
-	for records.Next(context.Background()) {
-		var record models.Order
-		if err = records.Decode(&record); err != nil {
-			return nil, err
-		}
-		list = append(list, record)
+	return order.Details(), nil
+}
+
+func (p *Purchaser) findOrder(orderId primitive.ObjectID) (*models.Order, error) {
+	order, err := p.Orders.FindOrderId(orderId)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrOrderNotFound
 	}
 
-	return list, nil
+	return order, err
+}
+
+func (p *Purchaser) ListOrders(r *requests.PageRequest) ([]models.Order, error) {
+	return p.Orders.ListOrders(nil, int64(r.Page*r.Size), int64(r.Size))
+}
+
+func (p *Purchaser) ListUserOrders(userId primitive.ObjectID, r *requests.PageRequest) ([]models.Order, error) {
+	return p.Orders.ListOrders(&userId, int64(r.Page*r.Size), int64(r.Size))
 }
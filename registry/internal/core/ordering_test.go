@@ -0,0 +1,248 @@
+package core
+
+import (
+	"context"
+	"eCommerce/contracts"
+	"eCommerce/messaging"
+	"eCommerce/registry/internal/api/requests"
+	"eCommerce/registry/internal/data"
+	"eCommerce/registry/internal/models"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+const idempotencyKey = "order-1"
+
+func TestOrderOnceReplaysCompletedKey(t *testing.T) {
+	p := newPurchaser(t, time.Hour)
+	userId := primitive.NewObjectID()
+
+	first, replayed, err := p.OrderOnce(context.Background(), userId, idempotencyKey, orderRequest("apple"))
+	if err != nil || replayed {
+		t.Fatalf("first request: replayed %v, err %v", replayed, err)
+	}
+
+	second, replayed, err := p.OrderOnce(context.Background(), userId, idempotencyKey, orderRequest("apple"))
+	if err != nil || !replayed {
+		t.Fatalf("repeated request: replayed %v, err %v", replayed, err)
+	}
+	if second.Id != first.Id {
+		t.Errorf("repeated request returned order %s, want %s", second.Id.Hex(), first.Id.Hex())
+	}
+
+	// The same key of another user is the different key.
+	if _, replayed, err = p.OrderOnce(context.Background(), primitive.NewObjectID(), idempotencyKey, orderRequest("apple")); err != nil || replayed {
+		t.Fatalf("request of another user: replayed %v, err %v", replayed, err)
+	}
+
+	p.assertOrders(t, 2)
+}
+
+func TestOrderOnceRejectsReusedKey(t *testing.T) {
+	p := newPurchaser(t, time.Hour)
+	userId := primitive.NewObjectID()
+
+	if _, _, err := p.OrderOnce(context.Background(), userId, idempotencyKey, orderRequest("apple")); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err := p.OrderOnce(context.Background(), userId, idempotencyKey, orderRequest("pear"))
+	if err != ErrIdempotencyKeyReused {
+		t.Fatalf("request with another body: %v, want %v", err, ErrIdempotencyKeyReused)
+	}
+
+	p.assertOrders(t, 1)
+}
+
+func TestOrderOncePendingKey(t *testing.T) {
+	tests := []struct {
+		name  string
+		lease time.Duration
+		err   error
+		// orders is number of orders created by the request.
+		orders int
+	}{
+		{name: "in progress", lease: time.Minute, err: ErrIdempotencyKeyInProgress},
+		{name: "lease is over", lease: -time.Second, orders: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newPurchaser(t, time.Hour)
+			userId := primitive.NewObjectID()
+
+			// Key acquired by the request which is still running or crashed before the order was created.
+			fingerprint, err := RequestFingerprint(orderRequest("apple"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			now := time.Now().UTC()
+			if _, _, err = p.keys.Acquire(&models.IdempotencyKey{
+				Key:         idempotencyKey,
+				UserId:      userId,
+				Fingerprint: fingerprint,
+				Status:      models.IdempotencyPending,
+				CreatedAt:   now,
+				LockedUntil: now.Add(tt.lease),
+				ExpiresAt:   now.Add(time.Hour),
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			_, replayed, err := p.OrderOnce(context.Background(), userId, idempotencyKey, orderRequest("apple"))
+			if err != tt.err || replayed {
+				t.Fatalf("request: replayed %v, err %v, want %v", replayed, err, tt.err)
+			}
+
+			p.assertOrders(t, tt.orders)
+		})
+	}
+}
+
+func TestOrderOnceExpiredKey(t *testing.T) {
+	p := newPurchaser(t, 10*time.Millisecond)
+	userId := primitive.NewObjectID()
+
+	first, _, err := p.OrderOnce(context.Background(), userId, idempotencyKey, orderRequest("apple"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	second, replayed, err := p.OrderOnce(context.Background(), userId, idempotencyKey, orderRequest("apple"))
+	if err != nil || replayed {
+		t.Fatalf("request after the key expired: replayed %v, err %v", replayed, err)
+	}
+	if second.Id == first.Id {
+		t.Error("request after the key expired returned the original order")
+	}
+}
+
+// Key which lease is over is acquired by another request while the order is being created.
+// The order is not created, so the other request creates it only once.
+func TestOrderOnceKeyTakenOver(t *testing.T) {
+	p := newPurchaser(t, time.Hour)
+	p.repository.complete = func(id primitive.ObjectID) error {
+		return p.keys.Release(id)
+	}
+
+	_, _, err := p.OrderOnce(context.Background(), primitive.NewObjectID(), idempotencyKey, orderRequest("apple"))
+	if err != ErrIdempotencyKeyInProgress {
+		t.Fatalf("request which lost the key: %v, want %v", err, ErrIdempotencyKeyInProgress)
+	}
+
+	p.assertOrders(t, 0)
+	if pending, _ := p.outbox.Pending(10); len(pending) != 0 {
+		t.Errorf("outbox has %d messages of the rolled back order", len(pending))
+	}
+}
+
+// Key of the failed request is released, so the client is able to retry it with the same key.
+func TestOrderOnceFailedRequest(t *testing.T) {
+	p := newPurchaser(t, time.Hour)
+	userId := primitive.NewObjectID()
+	failure := errors.New("database is not available")
+
+	p.repository.complete = func(primitive.ObjectID) error { return failure }
+	if _, _, err := p.OrderOnce(context.Background(), userId, idempotencyKey, orderRequest("apple")); err != failure {
+		t.Fatalf("failed request: %v, want %v", err, failure)
+	}
+	p.assertOrders(t, 0)
+
+	p.repository.complete = nil
+	if _, replayed, err := p.OrderOnce(context.Background(), userId, idempotencyKey, orderRequest("apple")); err != nil || replayed {
+		t.Fatalf("retried request: replayed %v, err %v", replayed, err)
+	}
+	p.assertOrders(t, 1)
+}
+
+// Order with the item without SKU, with the quantity which is not positive or with the repeated SKU is rejected.
+func TestNewPendingOrderInvalidItems(t *testing.T) {
+	tests := map[string][]models.OrderProduct{
+		"no sku":       {{Sku: " ", Quantity: 1}},
+		"zero":         {{Sku: "apple", Quantity: 0}},
+		"negative":     {{Sku: "apple", Quantity: 1}, {Sku: "pear", Quantity: -1}},
+		"repeated sku": {{Sku: "apple", Quantity: 1}, {Sku: "pear", Quantity: 1}, {Sku: "apple", Quantity: 2}},
+	}
+
+	for name, items := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := NewPendingOrder(primitive.NewObjectID(), &requests.OrderRequest{Items: items}); err != ErrOrderItemInvalid {
+				t.Errorf("order of %+v: %v, want %v", items, err, ErrOrderItemInvalid)
+			}
+		})
+	}
+}
+
+type purchaser struct {
+	*Purchaser
+	repository *completeHook
+	keys       *data.MemoryIdempotencyRepository
+	outbox     *data.MemoryOutboxRepository
+}
+
+func newPurchaser(t *testing.T, ttl time.Duration) *purchaser {
+	t.Helper()
+
+	p := new(purchaser)
+	p.keys = data.NewMemoryIdempotencyRepository()
+	p.outbox = data.NewMemoryOutboxRepository()
+	p.repository = &completeHook{RegistryRepository: data.NewMemoryRegistryRepository(p.outbox, p.keys)}
+
+	log := zap.NewNop().Sugar()
+	coordinator := NewOrderCoordinator(log, p.repository, contracts.JSONCodec{}, messaging.RetryPolicy{}, messaging.PoolConfig{})
+	p.Purchaser = NewPurchaser(log, p.repository, coordinator, p.keys, ttl, time.Minute)
+
+	return p
+}
+
+func (p *purchaser) assertOrders(t *testing.T, want int) {
+	t.Helper()
+
+	orders, err := p.repository.ListOrders(nil, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != want {
+		t.Errorf("%d orders are created, want %d", len(orders), want)
+	}
+}
+
+// completeHook calls complete before the idempotency key is completed in the unit of work.
+type completeHook struct {
+	data.RegistryRepository
+	complete func(id primitive.ObjectID) error
+	parent   *completeHook
+}
+
+func (h *completeHook) Begin(ctx context.Context) (data.RegistryRepository, error) {
+	uow, err := h.RegistryRepository.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &completeHook{RegistryRepository: uow, parent: h}, nil
+}
+
+func (h *completeHook) CompleteIdempotencyKey(id primitive.ObjectID, response *models.Order) error {
+	hook := h.complete
+	if h.parent != nil {
+		hook = h.parent.complete
+	}
+
+	if hook != nil {
+		if err := hook(id); err != nil {
+			return err
+		}
+	}
+
+	return h.RegistryRepository.CompleteIdempotencyKey(id, response)
+}
+
+func orderRequest(sku string) *requests.OrderRequest {
+	return &requests.OrderRequest{Items: []models.OrderProduct{{Sku: sku, Quantity: 1}}}
+}
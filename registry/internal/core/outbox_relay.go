@@ -0,0 +1,261 @@
+package core
+
+import (
+	"context"
+	"eCommerce/messaging"
+	"eCommerce/registry/internal/data"
+	"eCommerce/registry/internal/models"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+	"time"
+)
+
+type OutboxRelayConfig struct {
+	// Interval between checks of the outbox for the new messages.
+	Interval time.Duration
+	// BatchSize is maximum number of messages published at once.
+	BatchSize int64
+	// MinBackoff and MaxBackoff are bounds of the exponential delay before the next attempt to publish failed message.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// MaxAttempts is the number of failed attempts after which the message is marked dead and is not published anymore.
+	MaxAttempts int
+	// Lease is the time the relay holds the outbox. Only the relay holding the lease publishes messages,
+	// another instance takes the outbox over when the lease is not extended in time.
+	Lease time.Duration
+}
+
+// OutboxRelay publishes messages stored in the outbox to the bus and marks them sent.
+// Message is published at least once: it could be published again when relay stopped before marking it sent.
+// Messages of the same key are published in the order they were stored. Message which failed is retried before
+// the later messages of its key are published, until it runs out of attempts and is marked dead.
+type OutboxRelay struct {
+	log      *zap.SugaredLogger
+	cfg      OutboxRelayConfig
+	outbox   data.OutboxRepository
+	producer messaging.Publisher
+
+	// owner identifies the relay holding the lease of the outbox.
+	owner  string
+	leased time.Time
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewOutboxRelay(log *zap.SugaredLogger, outbox data.OutboxRepository, producer messaging.Publisher, cfg OutboxRelayConfig) *OutboxRelay {
+	r := new(OutboxRelay)
+	r.log = log
+	r.cfg = cfg
+	r.outbox = outbox
+	r.producer = producer
+	r.owner = primitive.NewObjectID().Hex()
+
+	return r
+}
+
+func (r *OutboxRelay) Start(ctx context.Context) {
+	ctx, r.cancel = context.WithCancel(ctx)
+	r.done = make(chan struct{})
+
+	go func() {
+		defer close(r.done)
+
+		ticker := time.NewTicker(r.cfg.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.Drain(ctx)
+			}
+		}
+	}()
+}
+
+// Stop waits until the current batch is published.
+func (r *OutboxRelay) Stop() {
+	if r.cancel == nil {
+		return
+	}
+
+	r.cancel()
+	<-r.done
+}
+
+// Drain publishes pending messages until the outbox has no messages ready to be published.
+// Nothing is published while the outbox is leased by another relay.
+func (r *OutboxRelay) Drain(ctx context.Context) {
+	for ctx.Err() == nil {
+		leased, err := r.lease()
+		if err != nil || !leased {
+			if err != nil {
+				r.log.Error(err)
+			}
+			return
+		}
+
+		n, err := r.publishBatch(ctx)
+		if err != nil {
+			r.log.Error(err)
+			return
+		}
+
+		if int64(n) < r.cfg.BatchSize {
+			return
+		}
+	}
+}
+
+// lease extends the lease of the outbox when half of it is over. Returns false when the outbox is leased by another relay.
+func (r *OutboxRelay) lease() (bool, error) {
+	now := time.Now().UTC()
+	if r.leased.Sub(now) > r.cfg.Lease/2 {
+		return true, nil
+	}
+
+	until := now.Add(r.cfg.Lease)
+	leased, err := r.outbox.AcquireLease(r.owner, until)
+	if err != nil || !leased {
+		r.leased = time.Time{}
+		return false, err
+	}
+	r.leased = until
+
+	return true, nil
+}
+
+// publishBatch publishes the batch in rounds, each round contains the next message of each key.
+// When a message failed, the later messages of its key are left in the outbox. The lease is checked before
+// each round, so the batch is not published further once the outbox is taken over by another relay.
+func (r *OutboxRelay) publishBatch(ctx context.Context) (int, error) {
+	pending, err := r.outbox.Pending(r.cfg.BatchSize)
+	if err != nil || len(pending) == 0 {
+		return 0, err
+	}
+
+	queues := make(map[string][]*models.OutboxMessage)
+	keys := make([]string, 0)
+	for i := range pending {
+		key := pending[i].Key
+		if _, ok := queues[key]; !ok {
+			keys = append(keys, key)
+		}
+		queues[key] = append(queues[key], &pending[i])
+	}
+
+	published := 0
+	for len(keys) > 0 && ctx.Err() == nil {
+		leased, err := r.lease()
+		if err != nil {
+			return 0, err
+		}
+		if !leased {
+			break
+		}
+
+		round := make([]*models.OutboxMessage, len(keys))
+		for i, key := range keys {
+			round[i] = queues[key][0]
+		}
+
+		failed, err := r.publishRound(ctx, round)
+		if err != nil {
+			return 0, err
+		}
+		published += len(round) - len(failed)
+
+		next := keys[:0]
+		for _, key := range keys {
+			if queues[key] = queues[key][1:]; len(queues[key]) > 0 && !failed[key] {
+				next = append(next, key)
+			}
+		}
+		keys = next
+	}
+
+	if published == 0 {
+		return 0, nil
+	}
+
+	return len(pending), nil
+}
+
+// publishRound publishes messages of the different keys and returns keys of the failed messages.
+// Publishing is canceled when the lease is over, so the messages are not published concurrently with another relay.
+func (r *OutboxRelay) publishRound(ctx context.Context, round []*models.OutboxMessage) (map[string]bool, error) {
+	messages := make([]messaging.Message, len(round))
+	for i := range round {
+		messages[i] = OutboxBusMessage(round[i])
+	}
+
+	publishCtx, cancel := context.WithDeadline(ctx, r.leased)
+	err := r.producer.Publish(publishCtx, messages...)
+	cancel()
+
+	var writeErrors messaging.PublishErrors
+	if err != nil && !errors.As(err, &writeErrors) {
+		writeErrors = make(messaging.PublishErrors, len(round))
+		for i := range writeErrors {
+			writeErrors[i] = err
+		}
+	}
+
+	failed := make(map[string]bool)
+	for i, message := range round {
+		if writeErrors != nil && writeErrors[i] != nil {
+			failed[message.Key] = true
+			if message.Attempts+1 >= r.cfg.MaxAttempts {
+				if err = r.outbox.MarkDead(message.Id, writeErrors[i].Error()); err != nil {
+					return nil, err
+				}
+				r.log.Errorw("outbox message is dead", "id", message.Id.Hex(), "topic", message.Topic, "attempts", message.Attempts+1, "err", writeErrors[i])
+				continue
+			}
+
+			next := time.Now().UTC().Add(r.backoff(message.Attempts))
+			if err = r.outbox.MarkFailed(message.Id, writeErrors[i].Error(), next); err != nil {
+				return nil, err
+			}
+			r.log.Warnw("failed to publish outbox message", "id", message.Id.Hex(), "topic", message.Topic, "err", writeErrors[i])
+			continue
+		}
+
+		if err = r.outbox.MarkSent(message.Id); err != nil {
+			return nil, err
+		}
+	}
+
+	return failed, nil
+}
+
+// backoff returns delay before the next attempt which is growing exponentially with the number of failed attempts.
+func (r *OutboxRelay) backoff(attempts int) time.Duration {
+	delay := r.cfg.MinBackoff
+	for i := 0; i < attempts && delay < r.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > r.cfg.MaxBackoff {
+		return r.cfg.MaxBackoff
+	}
+
+	return delay
+}
+
+func OutboxBusMessage(m *models.OutboxMessage) messaging.Message {
+	headers := make([]messaging.Header, len(m.Headers))
+	for i, h := range m.Headers {
+		headers[i] = messaging.Header{Key: h.Key, Value: h.Value}
+	}
+
+	return messaging.Message{
+		Topic:   m.Topic,
+		Key:     []byte(m.Key),
+		Value:   m.Value,
+		Headers: headers,
+	}
+}
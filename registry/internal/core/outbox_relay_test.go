@@ -0,0 +1,273 @@
+package core
+
+import (
+	"context"
+	"eCommerce/messaging"
+	"eCommerce/registry/internal/data"
+	"eCommerce/registry/internal/models"
+	"errors"
+	"go.uber.org/zap"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+const relayBackoff = 20 * time.Millisecond
+
+func TestOutboxRelayPublishesKeysInOrder(t *testing.T) {
+	outbox := data.NewMemoryOutboxRepository()
+	stage(outbox, "a1", "a2", "b1", "a3", "b2")
+	producer := newRecordingPublisher()
+
+	newRelay(outbox, producer, time.Minute).Drain(context.Background())
+
+	want := []string{"a1", "b1", "a2", "b2", "a3"}
+	if got := producer.Published(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("published %v, want %v", got, want)
+	}
+	assertPublished(t, outbox)
+}
+
+func TestOutboxRelayHoldsKeyAfterFailure(t *testing.T) {
+	outbox := data.NewMemoryOutboxRepository()
+	stage(outbox, "a1", "b1", "a2", "b2")
+	producer := newRecordingPublisher()
+	producer.Fail("a1")
+	relay := newRelay(outbox, producer, time.Minute)
+
+	relay.Drain(context.Background())
+
+	if got, want := producer.Published(), []string{"b1", "b2"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("published %v, want %v", got, want)
+	}
+
+	// The later message of the key is not published while the failed one is waiting for the next attempt.
+	stage(outbox, "a3")
+	relay.Drain(context.Background())
+	if got := producer.Published(); len(got) != 2 {
+		t.Fatalf("published %v before the failed message is retried", got)
+	}
+
+	time.Sleep(2 * relayBackoff)
+	relay.Drain(context.Background())
+
+	if got, want := producer.Published(), []string{"b1", "b2", "a1", "a2", "a3"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("published %v, want %v", got, want)
+	}
+	assertPublished(t, outbox)
+}
+
+func TestOutboxRelayLease(t *testing.T) {
+	outbox := data.NewMemoryOutboxRepository()
+	producer := newRecordingPublisher()
+	lease := 50 * time.Millisecond
+	first, second := newRelay(outbox, producer, lease), newRelay(outbox, producer, lease)
+
+	stage(outbox, "a1")
+	first.Drain(context.Background())
+
+	stage(outbox, "a2")
+	second.Drain(context.Background())
+	if got, want := producer.Published(), []string{"a1"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("published %v while the outbox is leased by another relay, want %v", got, want)
+	}
+
+	first.Drain(context.Background())
+	if got, want := producer.Published(), []string{"a1", "a2"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("published %v by the relay holding the lease, want %v", got, want)
+	}
+
+	// Relay which stopped extending the lease is taken over.
+	time.Sleep(2 * lease)
+	stage(outbox, "a3")
+	second.Drain(context.Background())
+	if got, want := producer.Published(), []string{"a1", "a2", "a3"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("published %v after the lease is over, want %v", got, want)
+	}
+
+	stage(outbox, "a4")
+	first.Drain(context.Background())
+	if got := producer.Published(); len(got) != 3 {
+		t.Fatalf("published %v by the relay which lost the lease", got)
+	}
+}
+
+// Lease is extended before each round, so the relay publishing a long batch keeps the outbox.
+func TestOutboxRelayExtendsLeaseDuringBatch(t *testing.T) {
+	outbox := data.NewMemoryOutboxRepository()
+	lease := 100 * time.Millisecond
+	slow := &slowPublisher{recordingPublisher: newRecordingPublisher(), delay: lease / 3}
+	first, second := newRelay(outbox, slow, lease), newRelay(outbox, slow, lease)
+
+	// Rounds of the single key take longer than the lease.
+	stage(outbox, "a1", "a2", "a3", "a4", "a5")
+	first.Drain(context.Background())
+	if got := slow.Published(); len(got) != 5 {
+		t.Fatalf("published %v, want the whole batch", got)
+	}
+
+	stage(outbox, "b1")
+	second.Drain(context.Background())
+	if got := slow.Published(); len(got) != 5 {
+		t.Fatalf("published %v by the relay while the lease is extended by another one", got)
+	}
+}
+
+// Publish which outlives the lease is canceled, so the relay taking the outbox over does not publish concurrently.
+func TestOutboxRelayCancelsPublishAfterLease(t *testing.T) {
+	outbox := data.NewMemoryOutboxRepository()
+	lease := 50 * time.Millisecond
+	slow := &slowPublisher{recordingPublisher: newRecordingPublisher(), delay: time.Hour}
+
+	stage(outbox, "a1")
+	started := time.Now()
+	newRelay(outbox, slow, lease).Drain(context.Background())
+
+	if elapsed := time.Since(started); elapsed > 10*lease {
+		t.Fatalf("publish took %s, want it canceled after the lease %s", elapsed, lease)
+	}
+	if got := slow.Published(); len(got) != 0 {
+		t.Fatalf("published %v after the lease is over", got)
+	}
+}
+
+// Message which fails all attempts is marked dead, so later messages of its key are published.
+func TestOutboxRelayDeadMessage(t *testing.T) {
+	outbox := data.NewMemoryOutboxRepository()
+	stage(outbox, "a1", "a2", "b1")
+	producer := newRecordingPublisher()
+	producer.Break("a1")
+	relay := newRelay(outbox, producer, time.Minute)
+
+	relay.Drain(context.Background())
+	time.Sleep(2 * relayBackoff)
+	relay.Drain(context.Background())
+	relay.Drain(context.Background())
+
+	if got, want := producer.Published(), []string{"b1", "a2"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("published %v, want %v", got, want)
+	}
+	dead := outbox.Dead()
+	if len(dead) != 1 || string(dead[0].Value) != "a1" || dead[0].Attempts != 2 || dead[0].LastError == "" {
+		t.Fatalf("dead messages %+v, want a1 after 2 attempts", dead)
+	}
+	assertPublished(t, outbox)
+}
+
+func TestOutboxRelayBackoff(t *testing.T) {
+	relay := NewOutboxRelay(zap.NewNop().Sugar(), nil, nil, OutboxRelayConfig{MinBackoff: time.Second, MaxBackoff: 10 * time.Second})
+
+	for attempts, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second} {
+		if got := relay.backoff(attempts); got != want {
+			t.Errorf("backoff after %d attempts %s, want %s", attempts, got, want)
+		}
+	}
+}
+
+func newRelay(outbox data.OutboxRepository, producer messaging.Publisher, lease time.Duration) *OutboxRelay {
+	return NewOutboxRelay(zap.NewNop().Sugar(), outbox, producer, OutboxRelayConfig{
+		Interval:    time.Hour,
+		BatchSize:   10,
+		MinBackoff:  relayBackoff,
+		MaxBackoff:  relayBackoff,
+		Lease:       lease,
+		MaxAttempts: 2,
+	})
+}
+
+// stage stores messages which key is the first letter of the value.
+func stage(outbox *data.MemoryOutboxRepository, values ...string) {
+	for _, value := range values {
+		outbox.Insert(models.NewOutboxMessage("topic", value[:1], []byte(value)))
+	}
+}
+
+// assertPublished checks that no message is left ready to be published.
+func assertPublished(t *testing.T, outbox *data.MemoryOutboxRepository) {
+	t.Helper()
+
+	pending, err := outbox.Pending(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Fatalf("%d messages are not published", len(pending))
+	}
+}
+
+// recordingPublisher records values of the published messages. Messages with failing values are rejected once,
+// messages with broken values are always rejected.
+type recordingPublisher struct {
+	mu        sync.Mutex
+	failing   map[string]bool
+	broken    map[string]bool
+	published []string
+}
+
+func newRecordingPublisher() *recordingPublisher {
+	return &recordingPublisher{failing: make(map[string]bool), broken: make(map[string]bool)}
+}
+
+func (p *recordingPublisher) Break(value string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.broken[value] = true
+}
+
+func (p *recordingPublisher) Fail(value string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.failing[value] = true
+}
+
+func (p *recordingPublisher) Publish(_ context.Context, messages ...messaging.Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	errs := make(messaging.PublishErrors, len(messages))
+	failed := false
+	for i, m := range messages {
+		if p.failing[string(m.Value)] || p.broken[string(m.Value)] {
+			delete(p.failing, string(m.Value))
+			errs[i] = errors.New("broker is not available")
+			failed = true
+			continue
+		}
+		p.published = append(p.published, string(m.Value))
+	}
+
+	if failed {
+		return errs
+	}
+
+	return nil
+}
+
+func (p *recordingPublisher) Published() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]string(nil), p.published...)
+}
+
+func (p *recordingPublisher) Close() error {
+	return nil
+}
+
+// slowPublisher publishes messages after the delay unless the context is done first.
+type slowPublisher struct {
+	*recordingPublisher
+	delay time.Duration
+}
+
+func (p *slowPublisher) Publish(ctx context.Context, messages ...messaging.Message) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(p.delay):
+		return p.recordingPublisher.Publish(ctx, messages...)
+	}
+}
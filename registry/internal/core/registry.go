@@ -3,15 +3,13 @@ package core
 import (
 	"bytes"
 	"context"
+	"eCommerce/contracts"
 	"eCommerce/registry/internal/api/requests"
+	"eCommerce/registry/internal/data"
 	"eCommerce/registry/internal/models"
 	"encoding/json"
 	"errors"
-	"github.com/segmentio/kafka-go"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.uber.org/zap"
 	"io/ioutil"
 	"net/http"
@@ -26,18 +24,19 @@ type RegistryController interface {
 }
 
 type RequestRegistry struct {
-	log      *zap.SugaredLogger
-	Requests *mongo.Collection
-	Users    *mongo.Collection
-	Producer *kafka.Writer
+	log   *zap.SugaredLogger
+	Users data.UserRepository
+	// Outbox stores the commands of the new users, they are published by the outbox relay.
+	Outbox data.RegistryRepository
+	Codec  contracts.Codec
 }
 
-func NewRequestRegistry(log *zap.SugaredLogger, db *mongo.Database, producer *kafka.Writer) *RequestRegistry {
+func NewRequestRegistry(log *zap.SugaredLogger, users data.UserRepository, outbox data.RegistryRepository, codec contracts.Codec) *RequestRegistry {
 	p := new(RequestRegistry)
 	p.log = log
-	p.Producer = producer
-	p.Users = db.Collection("users")
-	p.Requests = db.Collection("requests")
+	p.Outbox = outbox
+	p.Codec = codec
+	p.Users = users
 
 	return p
 }
@@ -46,15 +45,12 @@ func (rr *RequestRegistry) RegisterUser(r *http.Request) (string, error) {
 	identity, err := Identity(r)
 
 	if err == nil {
-		query := bson.M{"_id": identity.Id}
-		result := rr.Users.FindOne(context.Background(), query)
-
-		if result.Err() == nil {
+		if _, err = rr.Users.FindUser(identity.Id); err == nil {
 			return identity.Id.Hex(), nil
 		}
 	}
 
-	user, err := rr.CreateUser()
+	user, err := rr.CreateUser(r.Context())
 	if err != nil {
 		return "", err
 	}
@@ -62,34 +58,25 @@ func (rr *RequestRegistry) RegisterUser(r *http.Request) (string, error) {
 	return user.Id.Hex(), nil
 }
 
-func (rr *RequestRegistry) CreateUser() (*models.User, error) {
-	user := models.User{}
-	result, err := rr.Users.InsertOne(context.Background(), user)
+// CreateUser stores the new user and stages the command to create the wallet in the outbox. Command is published
+// by the outbox relay and continues the trace of the context.
+func (rr *RequestRegistry) CreateUser(ctx context.Context) (*models.User, error) {
+	user, err := rr.Users.InsertUser(&models.User{})
 	if err != nil {
 		return nil, err
 	}
 
-	user.Id = result.InsertedID.(primitive.ObjectID)
-
-	// Create event
-	go func(rr RequestRegistry, u models.User) {
-		payload, err := json.Marshal(u)
-		if err != nil {
-			rr.log.Error(err)
-			return
-		}
+	message, err := models.NewWalletCommand(ctx, rr.Codec, &contracts.User{Id: user.Id})
+	if err != nil {
+		return nil, err
+	}
 
-		err = rr.Producer.WriteMessages(context.Background(), kafka.Message{
-			Key:   []byte(u.Id.Hex()),
-			Value: payload,
-			Topic: models.WalletCreateTopic,
-		})
-		if err != nil {
-			rr.log.Error(err)
-		}
-	}(*rr, user)
+	// User without the staged command has no wallet, so its id is not returned to the client.
+	if err = rr.Outbox.Enqueue(message); err != nil {
+		return nil, err
+	}
 
-	return &user, nil
+	return user, nil
 }
 
 func (rr *RequestRegistry) RegisterRequest(r *http.Request) error {
@@ -105,59 +92,15 @@ func (rr *RequestRegistry) RegisterRequest(r *http.Request) error {
 		Timestamp: time.Now().UTC(),
 	}
 
-	_, err = rr.Requests.InsertOne(context.Background(), request)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return rr.Users.InsertRequest(&request)
 }
 
 func (rr *RequestRegistry) ListRequests(r *requests.PageRequest) ([]models.UserRequest, error) {
-	opt := options.Find()
-	opt.SetSort(bson.D{{"_id", -1}})
-	opt.SetSkip(int64(r.Page * r.Size))
-	opt.SetLimit(int64(r.Size))
-
-	records, err := rr.Requests.Find(context.Background(), bson.D{}, opt)
-	if err != nil {
-		return nil, err
-	}
-
-	list := make([]models.UserRequest, 0, r.Size)
-	for records.Next(context.Background()) {
-		var record models.UserRequest
-		if err = records.Decode(&record); err != nil {
-			return nil, err
-		}
-		list = append(list, record)
-	}
-
-	return list, nil
+	return rr.Users.ListRequests(nil, int64(r.Page*r.Size), int64(r.Size))
 }
 
 func (rr *RequestRegistry) ListUserRequests(identity *models.Identity, r *requests.PageRequest) ([]models.UserRequest, error) {
-	opt := options.Find()
-	opt.SetSort(bson.D{{"_id", -1}})
-	opt.SetSkip(int64(r.Page * r.Size))
-	opt.SetLimit(int64(r.Size))
-	filter := bson.D{{"user_id", identity.Id}}
-
-	records, err := rr.Requests.Find(context.Background(), filter, opt)
-	if err != nil {
-		return nil, err
-	}
-
-	list := make([]models.UserRequest, 0, r.Size)
-	for records.Next(context.Background()) {
-		var record models.UserRequest
-		if err = records.Decode(&record); err != nil {
-			return nil, err
-		}
-		list = append(list, record)
-	}
-
-	return list, nil
+	return rr.Users.ListRequests(&identity.Id, int64(r.Page*r.Size), int64(r.Size))
 }
 
 func Identity(r *http.Request) (*models.Identity, error) {
@@ -0,0 +1,41 @@
+package core
+
+import (
+	"context"
+	"eCommerce/contracts"
+	"eCommerce/registry/internal/data"
+	"go.uber.org/zap"
+	"testing"
+)
+
+// Command creating the wallet of the new user is staged in the outbox, so it is published by the relay.
+func TestCreateUserStagesWallet(t *testing.T) {
+	outbox := data.NewMemoryOutboxRepository()
+	repository := data.NewMemoryRegistryRepository(outbox, data.NewMemoryIdempotencyRepository())
+	registry := NewRequestRegistry(zap.NewNop().Sugar(), data.NewMemoryUserRepository(), repository, contracts.JSONCodec{})
+
+	user, err := registry.CreateUser(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := outbox.Pending(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].Topic != contracts.WalletCreateTopic || pending[0].Key != user.Id.Hex() {
+		t.Fatalf("outbox %+v, want the wallet of %s", pending, user.Id.Hex())
+	}
+
+	e, err := contracts.Decode(pending[0].Topic, contracts.ContentTypeJSON, pending[0].Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := new(contracts.User)
+	if err = e.Unmarshal(created); err != nil {
+		t.Fatal(err)
+	}
+	if created.Id != user.Id {
+		t.Errorf("wallet of %s, want %s", created.Id.Hex(), user.Id.Hex())
+	}
+}
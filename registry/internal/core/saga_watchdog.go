@@ -0,0 +1,167 @@
+package core
+
+import (
+	"context"
+	"eCommerce/contracts"
+	"eCommerce/registry/internal/data"
+	"eCommerce/registry/internal/models"
+	"eCommerce/telemetry"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"time"
+)
+
+const watchdogBatchSize = 100
+
+// tracer records spans of the watchdog resolutions.
+var tracer = otel.Tracer(`eCommerce/registry`)
+
+// WatchdogRule describes how the order stuck in the status is resolved.
+// When Next is empty the command is published again to the Topic while attempts are left.
+// Otherwise the order is moved to the Next status and the command is published to the Topic once.
+type WatchdogRule struct {
+	Status  models.OrderStatus
+	Timeout time.Duration
+	Topic   string
+	Next    models.OrderStatus
+}
+
+type SagaWatchdogConfig struct {
+	// Interval between scans of the stuck orders.
+	Interval time.Duration
+	// MaxAttempts is number of times the command is published again before order is parked for manual review.
+	MaxAttempts int
+	Rules       []WatchdogRule
+}
+
+// DefaultWatchdogRules returns rules for all statuses which are waiting for response of storage or wallet.
+// Pending commands are published again. Orders stuck in the middle of compensation continue releasing reservation.
+// Commit of the paid order is published again until the storage confirms it, before the reservation expires.
+func DefaultWatchdogRules(reservation, payment, cancellation, commit time.Duration) []WatchdogRule {
+	return []WatchdogRule{
+		{Status: models.OrderReservationPending, Timeout: reservation, Topic: contracts.StorageReserveOrderTopic},
+		{Status: models.OrderPaymentPending, Timeout: payment, Topic: contracts.WalletPayOrderTopic},
+		{Status: models.OrderPaymentCancelPending, Timeout: cancellation, Topic: contracts.WalletCancelOrderTopic},
+		{Status: models.OrderReservationCancelPending, Timeout: cancellation, Topic: contracts.StorageCancelOrderTopic},
+		{Status: models.OrderPaid, Timeout: commit, Topic: contracts.StorageCommitOrderTopic},
+		{Status: models.OrderReserved, Timeout: payment, Topic: contracts.StorageCancelOrderTopic, Next: models.OrderReservationCancelPending},
+		{Status: models.OrderCancelPending, Timeout: cancellation, Topic: contracts.StorageCancelOrderTopic, Next: models.OrderReservationCancelPending},
+		{Status: models.OrderPaymentCanceled, Timeout: cancellation, Topic: contracts.StorageCancelOrderTopic, Next: models.OrderReservationCancelPending},
+	}
+}
+
+// SagaWatchdog detects orders which are not progressing because response message was lost and resolves them.
+// Every action is recorded in the order timeline. Orders which could not be resolved are parked in 'ORDER_MANUAL_REVIEW'.
+type SagaWatchdog struct {
+	log        *zap.SugaredLogger
+	cfg        SagaWatchdogConfig
+	repository data.RegistryRepository
+	codec      contracts.Codec
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewSagaWatchdog(log *zap.SugaredLogger, repository data.RegistryRepository, codec contracts.Codec, cfg SagaWatchdogConfig) *SagaWatchdog {
+	w := new(SagaWatchdog)
+	w.log = log
+	w.cfg = cfg
+	w.repository = repository
+	w.codec = codec
+
+	return w
+}
+
+func (w *SagaWatchdog) Start(ctx context.Context) {
+	ctx, w.cancel = context.WithCancel(ctx)
+	w.done = make(chan struct{})
+
+	go func() {
+		defer close(w.done)
+
+		ticker := time.NewTicker(w.cfg.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				w.Scan(ctx)
+			}
+		}
+	}()
+}
+
+func (w *SagaWatchdog) Stop() {
+	if w.cancel == nil {
+		return
+	}
+
+	w.cancel()
+	<-w.done
+}
+
+// Scan resolves orders which are stuck longer than timeout of the rule.
+func (w *SagaWatchdog) Scan(ctx context.Context) {
+	for _, rule := range w.cfg.Rules {
+		if ctx.Err() != nil {
+			return
+		}
+
+		before := time.Now().UTC().Add(-rule.Timeout)
+		orders, err := w.repository.FindStaleOrders(rule.Status, before, watchdogBatchSize)
+		if err != nil {
+			w.log.Error(err)
+			continue
+		}
+
+		for i := range orders {
+			if err = w.Resolve(ctx, &orders[i], rule); err != nil && !data.IsTransitionError(err) {
+				w.log.Errorw("watchdog failed to resolve order", "order", orders[i].Id.Hex(), "err", err)
+			}
+		}
+	}
+}
+
+// Resolve applies the rule to the stuck order. Returns data.TransitionError when order was changed in the meantime.
+// Each resolution starts the new trace, which is continued by the published command.
+func (w *SagaWatchdog) Resolve(ctx context.Context, order *models.Order, rule WatchdogRule) error {
+	ctx, span := tracer.Start(ctx, "watchdog resolve", trace.WithAttributes(
+		attribute.String("order.id", order.Id.Hex()),
+		attribute.String("order.status", string(rule.Status)),
+	))
+	defer span.End()
+
+	// The first update is the transition to the status, the others are previous attempts of the watchdog.
+	attempt := order.StatusRepeats()
+	if rule.Next == "" && attempt > w.cfg.MaxAttempts {
+		message := fmt.Sprintf("watchdog: no response in %s after %d attempts", rule.Status, w.cfg.MaxAttempts)
+		_, err := w.repository.CompareAndUpdateOrderStatus(order, models.OrderManualReview, message)
+		if err == nil {
+			telemetry.Logger(ctx, w.log).Warnw("order parked for manual review", "order", order.Id.Hex(), "status", rule.Status)
+		}
+		return err
+	}
+
+	command, err := models.NewOrderCommand(ctx, w.codec, rule.Topic, order.Event(), nil)
+	if err != nil {
+		return err
+	}
+
+	status, message := rule.Status, fmt.Sprintf("watchdog: %s published again (attempt %d of %d)", rule.Topic, attempt, w.cfg.MaxAttempts)
+	if rule.Next != "" {
+		status, message = rule.Next, fmt.Sprintf("watchdog: no progress in %s, %s published", rule.Status, rule.Topic)
+	}
+
+	return data.WithUnitOfWork(ctx, w.repository, func(uow data.RegistryRepository) error {
+		if _, err := uow.CompareAndUpdateOrderStatus(order, status, message); err != nil {
+			return err
+		}
+
+		return uow.Enqueue(command)
+	})
+}
@@ -0,0 +1,155 @@
+package core
+
+import (
+	"context"
+	"eCommerce/contracts"
+	"eCommerce/registry/internal/models"
+	"fmt"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+// staleAfter is the timeout of the rules which orders of the test are stuck for.
+const staleAfter = time.Millisecond
+
+func TestWatchdogTimeoutPerStatus(t *testing.T) {
+	s := newSaga(t)
+	order := s.newOrder(t)
+	s.sent(t)
+
+	w := s.watchdog(
+		WatchdogRule{Status: models.OrderReservationPending, Timeout: time.Hour, Topic: contracts.StorageReserveOrderTopic},
+		WatchdogRule{Status: models.OrderPaymentPending, Timeout: staleAfter, Topic: contracts.WalletPayOrderTopic},
+	)
+	s.scan(w)
+
+	if got := s.find(t, order.Id); got.StatusRepeats() != 1 {
+		t.Fatalf("order waiting less than the timeout of its status is resolved: %+v", got.Updates)
+	}
+	if got := s.sent(t); len(got) != 0 {
+		t.Fatalf("commands %v, want none", got)
+	}
+}
+
+func TestWatchdogPublishesCommandAgain(t *testing.T) {
+	s := newSaga(t)
+	order := s.newOrder(t)
+	s.sent(t)
+
+	w := s.watchdog(WatchdogRule{Status: models.OrderReservationPending, Timeout: staleAfter, Topic: contracts.StorageReserveOrderTopic})
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		s.scan(w)
+
+		resolved := s.find(t, order.Id)
+		if resolved.Status != models.OrderReservationPending {
+			t.Fatalf("attempt %d: status %s, want %s", attempt, resolved.Status, models.OrderReservationPending)
+		}
+		want := fmt.Sprintf("watchdog: %s published again (attempt %d of 2)", contracts.StorageReserveOrderTopic, attempt)
+		if last := resolved.Updates[len(resolved.Updates)-1]; last.Message != want {
+			t.Errorf("attempt %d: timeline message %q, want %q", attempt, last.Message, want)
+		}
+		if got := s.sent(t); !equalTopics(got, []string{contracts.StorageReserveOrderTopic}) {
+			t.Errorf("attempt %d: commands %v, want %s", attempt, got, contracts.StorageReserveOrderTopic)
+		}
+	}
+
+	// Attempts are over, so the order is parked.
+	s.scan(w)
+
+	parked := s.find(t, order.Id)
+	if parked.Status != models.OrderManualReview {
+		t.Fatalf("status %s, want %s", parked.Status, models.OrderManualReview)
+	}
+	want := fmt.Sprintf("watchdog: no response in %s after 2 attempts", models.OrderReservationPending)
+	if last := parked.Updates[len(parked.Updates)-1]; last.Message != want {
+		t.Errorf("timeline message %q, want %q", last.Message, want)
+	}
+	if got := s.sent(t); len(got) != 0 {
+		t.Errorf("commands %v for the parked order, want none", got)
+	}
+}
+
+func TestWatchdogMovesOrderToNextStatus(t *testing.T) {
+	s := newSaga(t)
+	now := time.Now().UTC()
+	order, err := s.repository.InsertOrder(&models.Order{
+		Status:    models.OrderCancelPending,
+		Timestamp: now,
+		Updates:   []models.OrderUpdate{{Status: models.OrderCancelPending, Timestamp: now}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := s.watchdog(WatchdogRule{
+		Status:  models.OrderCancelPending,
+		Timeout: staleAfter,
+		Topic:   contracts.StorageCancelOrderTopic,
+		Next:    models.OrderReservationCancelPending,
+	})
+	s.scan(w)
+
+	resolved := s.find(t, order.Id)
+	if resolved.Status != models.OrderReservationCancelPending {
+		t.Fatalf("status %s, want %s", resolved.Status, models.OrderReservationCancelPending)
+	}
+	want := fmt.Sprintf("watchdog: no progress in %s, %s published", models.OrderCancelPending, contracts.StorageCancelOrderTopic)
+	if last := resolved.Updates[len(resolved.Updates)-1]; last.Message != want {
+		t.Errorf("timeline message %q, want %q", last.Message, want)
+	}
+	if got := s.sent(t); !equalTopics(got, []string{contracts.StorageCancelOrderTopic}) {
+		t.Errorf("commands %v, want %s", got, contracts.StorageCancelOrderTopic)
+	}
+}
+
+// Commit of the paid order is lost, so it is published again before the reservation expires.
+func TestWatchdogCommitsPaidOrder(t *testing.T) {
+	s := newSaga(t)
+	order := s.newOrder(t)
+	s.respond(t, contracts.StorageReserveOrderResponseTopic, order, true)
+	s.respond(t, contracts.WalletPayOrderResponseTopic, order, true)
+	s.sent(t)
+
+	w := s.watchdog(DefaultWatchdogRules(time.Hour, time.Hour, time.Hour, staleAfter)...)
+	s.scan(w)
+
+	if got := s.find(t, order.Id); got.Status != models.OrderPaid {
+		t.Fatalf("status %s, want %s", got.Status, models.OrderPaid)
+	}
+	if got := s.sent(t); !equalTopics(got, []string{contracts.StorageCommitOrderTopic}) {
+		t.Fatalf("commands %v, want %s", got, contracts.StorageCommitOrderTopic)
+	}
+
+	// Repeated payment response does not publish the commit once more.
+	s.respond(t, contracts.WalletPayOrderResponseTopic, order, true)
+	if got := s.sent(t); len(got) != 0 {
+		t.Fatalf("commands %v after the repeated payment, want none", got)
+	}
+
+	s.respond(t, contracts.StorageCommitOrderResponseTopic, order, true)
+	s.scan(w)
+
+	committed := s.find(t, order.Id)
+	if committed.Status != models.OrderPaid || !committed.Committed {
+		t.Fatalf("status %s, committed %v, want %s and committed", committed.Status, committed.Committed, models.OrderPaid)
+	}
+	if got := s.sent(t); len(got) != 0 {
+		t.Errorf("commands %v for the committed order, want none", got)
+	}
+}
+
+func (s *saga) watchdog(rules ...WatchdogRule) *SagaWatchdog {
+	return NewSagaWatchdog(zap.NewNop().Sugar(), s.repository, contracts.JSONCodec{}, SagaWatchdogConfig{
+		Interval:    time.Hour,
+		MaxAttempts: 2,
+		Rules:       rules,
+	})
+}
+
+// scan waits until the orders are stuck longer than staleAfter and resolves them.
+func (s *saga) scan(w *SagaWatchdog) {
+	time.Sleep(2 * staleAfter)
+	w.Scan(context.Background())
+}
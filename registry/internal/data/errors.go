@@ -0,0 +1,42 @@
+package data
+
+import (
+	"eCommerce/registry/internal/models"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrIllegalTransition = errors.New(`illegal order status transition`)
+	ErrStaleOrder        = errors.New(`order was changed concurrently`)
+
+	// ErrIdempotencyKeyReleased is returned when the key is completed after its lease is over and the key
+	// is released or acquired by another request.
+	ErrIdempotencyKeyReleased = errors.New(`idempotency key is not pending anymore`)
+	// ErrIdempotencyKeyContended is returned when the key keeps being removed and stored again by other requests
+	// while it is acquired.
+	ErrIdempotencyKeyContended = errors.New(`idempotency key is changed concurrently`)
+)
+
+// TransitionError is returned when the order status could not be changed.
+// It wraps ErrIllegalTransition or ErrStaleOrder.
+type TransitionError struct {
+	OrderId primitive.ObjectID
+	From    models.OrderStatus
+	To      models.OrderStatus
+	Err     error
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("%s: order %s from %s to %s", e.Err, e.OrderId.Hex(), e.From, e.To)
+}
+
+func (e *TransitionError) Unwrap() error {
+	return e.Err
+}
+
+// IsTransitionError reports whether err is rejected transition of the order status.
+func IsTransitionError(err error) bool {
+	return errors.Is(err, ErrIllegalTransition) || errors.Is(err, ErrStaleOrder)
+}
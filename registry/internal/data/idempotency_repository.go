@@ -0,0 +1,121 @@
+package data
+
+import (
+	"context"
+	"eCommerce/registry/internal/models"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+const IdempotencyCollection = `idempotency_keys`
+
+// acquireAttempts is the number of attempts to store the key, when the duplicate key is removed before it is read.
+const acquireAttempts = 3
+
+// IdempotencyRepository stores idempotency keys. Key is completed with the response by
+// RegistryRepository.CompleteIdempotencyKey in the same unit of work as the order.
+type IdempotencyRepository interface {
+	// Acquire stores the new pending key. Returns the stored key and false when the key already exists.
+	// Expired key and pending key which lease is over are replaced by the new key.
+	Acquire(key *models.IdempotencyKey) (*models.IdempotencyKey, bool, error)
+	Release(id primitive.ObjectID) error
+}
+
+type MongoIdempotencyRepository struct {
+	keys *mongo.Collection
+
+	// testHookDuplicate is called after the key is rejected as the duplicate, before the stored key is read.
+	testHookDuplicate func()
+}
+
+func NewMongoIdempotencyRepository(db *mongo.Database) *MongoIdempotencyRepository {
+	r := new(MongoIdempotencyRepository)
+	r.keys = db.Collection(IdempotencyCollection)
+
+	return r
+}
+
+// EnsureIndexes creates unique index of the user keys and TTL index which removes expired keys.
+func (m *MongoIdempotencyRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := m.keys.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{"user_id", 1}, {"key", 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{"expires_at", 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+
+	return err
+}
+
+// Acquire stores the key or reads the stored one. The stored key could expire or be released between
+// the rejected insert and the read, so the key is stored again in that case.
+func (m *MongoIdempotencyRepository) Acquire(key *models.IdempotencyKey) (*models.IdempotencyKey, bool, error) {
+	for i := 0; i < acquireAttempts; i++ {
+		stored, acquired, err := m.acquire(key)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			continue
+		}
+
+		return stored, acquired, err
+	}
+
+	return nil, false, ErrIdempotencyKeyContended
+}
+
+// acquire makes a single attempt to store the key. Returns mongo.ErrNoDocuments when the duplicate key
+// is removed before it is read.
+func (m *MongoIdempotencyRepository) acquire(key *models.IdempotencyKey) (*models.IdempotencyKey, bool, error) {
+	// TTL monitor removes documents periodically, so the expired key could be still presented.
+	now := time.Now().UTC()
+	filter := bson.D{
+		{"user_id", key.UserId},
+		{"key", key.Key},
+		{"$or", bson.A{
+			bson.D{{"expires_at", bson.D{{"$lte", now}}}},
+			bson.D{{"status", models.IdempotencyPending}, {"locked_until", bson.D{{"$lte", now}}}},
+		}},
+	}
+	if _, err := m.keys.DeleteOne(context.Background(), filter); err != nil {
+		return nil, false, err
+	}
+
+	result, err := m.keys.InsertOne(context.Background(), key)
+	if err == nil {
+		key.Id = result.InsertedID.(primitive.ObjectID)
+		return key, true, nil
+	}
+
+	if !mongo.IsDuplicateKeyError(err) {
+		return nil, false, err
+	}
+
+	if m.testHookDuplicate != nil {
+		m.testHookDuplicate()
+	}
+
+	filter = bson.D{{"user_id", key.UserId}, {"key", key.Key}}
+	single := m.keys.FindOne(context.Background(), filter)
+	if err = single.Err(); err != nil {
+		return nil, false, err
+	}
+
+	stored := new(models.IdempotencyKey)
+	if err = single.Decode(stored); err != nil {
+		return nil, false, err
+	}
+
+	return stored, false, nil
+}
+
+func (m *MongoIdempotencyRepository) Release(id primitive.ObjectID) error {
+	_, err := m.keys.DeleteOne(context.Background(), bson.D{{"_id", id}})
+	return err
+}
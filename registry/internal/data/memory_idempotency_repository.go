@@ -0,0 +1,74 @@
+package data
+
+import (
+	"eCommerce/registry/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sync"
+	"time"
+)
+
+// MemoryIdempotencyRepository keeps idempotency keys in the process memory.
+type MemoryIdempotencyRepository struct {
+	mu   sync.Mutex
+	keys map[primitive.ObjectID]*models.IdempotencyKey
+}
+
+func NewMemoryIdempotencyRepository() *MemoryIdempotencyRepository {
+	r := new(MemoryIdempotencyRepository)
+	r.keys = make(map[primitive.ObjectID]*models.IdempotencyKey)
+
+	return r
+}
+
+func (m *MemoryIdempotencyRepository) Acquire(key *models.IdempotencyKey) (*models.IdempotencyKey, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+	for id, stored := range m.keys {
+		if stored.UserId != key.UserId || stored.Key != key.Key {
+			continue
+		}
+
+		if !stored.IsReleased(now) {
+			found := *stored
+			return &found, false, nil
+		}
+		delete(m.keys, id)
+	}
+
+	key.Id = primitive.NewObjectID()
+	stored := *key
+	m.keys[key.Id] = &stored
+
+	return key, true, nil
+}
+
+// complete stores the responses of the pending keys. Returns ErrIdempotencyKeyReleased and completes none of the keys
+// when any of them is not pending anymore.
+func (m *MemoryIdempotencyRepository) complete(completed map[primitive.ObjectID]*models.Order) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id := range completed {
+		if stored, ok := m.keys[id]; !ok || stored.Status != models.IdempotencyPending {
+			return ErrIdempotencyKeyReleased
+		}
+	}
+
+	for id, response := range completed {
+		m.keys[id].Status = models.IdempotencyCompleted
+		m.keys[id].Response = response
+	}
+
+	return nil
+}
+
+func (m *MemoryIdempotencyRepository) Release(id primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.keys, id)
+
+	return nil
+}
@@ -0,0 +1,131 @@
+package data
+
+import (
+	"eCommerce/registry/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"sync"
+	"time"
+)
+
+// MemoryOutboxRepository keeps outbox messages in the process memory. Sent messages are removed,
+// dead messages are kept.
+type MemoryOutboxRepository struct {
+	mu       sync.Mutex
+	messages []models.OutboxMessage
+
+	owner   string
+	expires time.Time
+}
+
+func NewMemoryOutboxRepository() *MemoryOutboxRepository {
+	return new(MemoryOutboxRepository)
+}
+
+// Insert stores messages to the outbox.
+func (m *MemoryOutboxRepository) Insert(messages ...*models.OutboxMessage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, message := range messages {
+		message.Id = primitive.NewObjectID()
+		m.messages = append(m.messages, *message)
+	}
+}
+
+func (m *MemoryOutboxRepository) Pending(limit int64) ([]models.OutboxMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+	blocked := make(map[string]bool)
+	list := make([]models.OutboxMessage, 0, limit)
+	for i := 0; i < len(m.messages) && int64(len(list)) < limit; i++ {
+		message := m.messages[i]
+		switch {
+		case message.Status == models.OutboxDead:
+		case blocked[message.Key]:
+		case message.NextAttemptAt.After(now):
+			blocked[message.Key] = true
+		default:
+			list = append(list, message)
+		}
+	}
+
+	return list, nil
+}
+
+func (m *MemoryOutboxRepository) MarkSent(id primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.messages {
+		if m.messages[i].Id == id {
+			m.messages = append(m.messages[:i], m.messages[i+1:]...)
+			return nil
+		}
+	}
+
+	return mongo.ErrNoDocuments
+}
+
+func (m *MemoryOutboxRepository) MarkFailed(id primitive.ObjectID, reason string, next time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.messages {
+		if m.messages[i].Id == id {
+			m.messages[i].Attempts++
+			m.messages[i].LastError = reason
+			m.messages[i].NextAttemptAt = next
+			return nil
+		}
+	}
+
+	return mongo.ErrNoDocuments
+}
+
+func (m *MemoryOutboxRepository) MarkDead(id primitive.ObjectID, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.messages {
+		if m.messages[i].Id == id {
+			m.messages[i].Attempts++
+			m.messages[i].LastError = reason
+			m.messages[i].Status = models.OutboxDead
+			return nil
+		}
+	}
+
+	return mongo.ErrNoDocuments
+}
+
+// Dead returns messages which are not published anymore.
+func (m *MemoryOutboxRepository) Dead() []models.OutboxMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var dead []models.OutboxMessage
+	for _, message := range m.messages {
+		if message.Status == models.OutboxDead {
+			dead = append(dead, message)
+		}
+	}
+
+	return dead
+}
+
+func (m *MemoryOutboxRepository) AcquireLease(owner string, until time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.owner != owner && m.expires.After(time.Now().UTC()) {
+		return false, nil
+	}
+
+	m.owner = owner
+	m.expires = until
+
+	return true, nil
+}
@@ -0,0 +1,356 @@
+package data
+
+import (
+	"context"
+	"eCommerce/registry/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryRegistryRepository keeps orders in the process memory and follows the rules of MongoRegistryRepository.
+// Unit of work holds the exclusive lock of the orders until it is committed or rolled back,
+// so units of work are serializable. Repository of the unit of work must not be used concurrently.
+type MemoryRegistryRepository struct {
+	store  *memoryOrders
+	outbox *MemoryOutboxRepository
+	keys   *MemoryIdempotencyRepository
+	// tx is the working copy of the unit of work.
+	tx *memoryOrdersState
+}
+
+type memoryOrders struct {
+	mu    sync.Mutex
+	state *memoryOrdersState
+}
+
+type memoryOrdersState struct {
+	orders map[primitive.ObjectID]*models.Order
+	// staged are messages enqueued by the unit of work.
+	staged []*models.OutboxMessage
+	// completed are responses of the idempotency keys completed by the unit of work.
+	completed map[primitive.ObjectID]*models.Order
+}
+
+func NewMemoryRegistryRepository(outbox *MemoryOutboxRepository, keys *MemoryIdempotencyRepository) *MemoryRegistryRepository {
+	r := new(MemoryRegistryRepository)
+	r.outbox = outbox
+	r.keys = keys
+	r.store = &memoryOrders{state: &memoryOrdersState{orders: make(map[primitive.ObjectID]*models.Order)}}
+
+	return r
+}
+
+func (s *memoryOrdersState) copy() *memoryOrdersState {
+	c := &memoryOrdersState{orders: make(map[primitive.ObjectID]*models.Order, len(s.orders))}
+	for id, order := range s.orders {
+		c.orders[id] = order
+	}
+
+	return c
+}
+
+func (m *MemoryRegistryRepository) do(fn func(s *memoryOrdersState) error) error {
+	if m.tx != nil {
+		return fn(m.tx)
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	return fn(m.store.state)
+}
+
+func (m *MemoryRegistryRepository) FindOrderId(id primitive.ObjectID) (*models.Order, error) {
+	var order *models.Order
+	err := m.do(func(s *memoryOrdersState) error {
+		stored, ok := s.orders[id]
+		if !ok {
+			return mongo.ErrNoDocuments
+		}
+
+		var err error
+		order, err = cloneOrder(stored)
+		return err
+	})
+
+	return order, err
+}
+
+func (m *MemoryRegistryRepository) ListOrders(userId *primitive.ObjectID, skip, limit int64) ([]models.Order, error) {
+	list := make([]models.Order, 0, limit)
+	err := m.do(func(s *memoryOrdersState) error {
+		orders := make([]*models.Order, 0, len(s.orders))
+		for _, order := range s.orders {
+			if userId == nil || order.UserId == *userId {
+				orders = append(orders, order)
+			}
+		}
+		sort.Slice(orders, func(i, j int) bool {
+			return orders[i].Id.Hex() > orders[j].Id.Hex()
+		})
+
+		for i := skip; i < int64(len(orders)) && int64(len(list)) < limit; i++ {
+			order, err := cloneOrder(orders[i])
+			if err != nil {
+				return err
+			}
+			list = append(list, *order)
+		}
+		return nil
+	})
+
+	return list, err
+}
+
+func (m *MemoryRegistryRepository) InsertOrder(order *models.Order) (*models.Order, error) {
+	err := m.do(func(s *memoryOrdersState) error {
+		order.Id = primitive.NewObjectID()
+
+		stored, err := cloneOrder(order)
+		if err != nil {
+			return err
+		}
+		s.orders[order.Id] = stored
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+func (m *MemoryRegistryRepository) UpdateOrder(id primitive.ObjectID, updates []bson.E) (*models.Order, error) {
+	return m.updateOrder(id, updates, "")
+}
+
+func (m *MemoryRegistryRepository) UpdateOrderStatus(id primitive.ObjectID, status models.OrderStatus) (*models.Order, error) {
+	return m.UpdateOrder(id, bson.D{{"status", status}})
+}
+
+func (m *MemoryRegistryRepository) UpdateOrderStatusMessage(id primitive.ObjectID, status models.OrderStatus, message string) (*models.Order, error) {
+	return m.updateOrder(id, bson.D{{"status", status}}, message)
+}
+
+func (m *MemoryRegistryRepository) CompareAndUpdateOrderStatus(expected *models.Order, status models.OrderStatus, message string) (*models.Order, error) {
+	var order *models.Order
+	err := m.do(func(s *memoryOrdersState) error {
+		var err error
+		order, err = compareAndUpdate(s, expected, bson.D{{"status", status}}, message)
+		return err
+	})
+
+	return order, err
+}
+
+func (m *MemoryRegistryRepository) CompareAndUpdateOrder(expected *models.Order, updates []bson.E) (*models.Order, error) {
+	var order *models.Order
+	err := m.do(func(s *memoryOrdersState) error {
+		var err error
+		order, err = compareAndUpdate(s, expected, updates, "")
+		return err
+	})
+
+	return order, err
+}
+
+func (m *MemoryRegistryRepository) FindStaleOrders(status models.OrderStatus, before time.Time, limit int64) ([]models.Order, error) {
+	list := make([]models.Order, 0, limit)
+	err := m.do(func(s *memoryOrdersState) error {
+		orders := make([]*models.Order, 0)
+		for _, order := range s.orders {
+			n := len(order.Updates)
+			if order.Status == models.OrderPaid && order.Committed {
+				continue
+			}
+			if order.Status == status && n > 0 && order.Updates[n-1].Timestamp.Before(before) {
+				orders = append(orders, order)
+			}
+		}
+		sort.Slice(orders, func(i, j int) bool {
+			return orders[i].Id.Hex() < orders[j].Id.Hex()
+		})
+
+		for i := 0; i < len(orders) && int64(len(list)) < limit; i++ {
+			order, err := cloneOrder(orders[i])
+			if err != nil {
+				return err
+			}
+			list = append(list, *order)
+		}
+		return nil
+	})
+
+	return list, err
+}
+
+func (m *MemoryRegistryRepository) Enqueue(messages ...*models.OutboxMessage) error {
+	if m.tx != nil {
+		m.tx.staged = append(m.tx.staged, messages...)
+		return nil
+	}
+
+	m.outbox.Insert(messages...)
+
+	return nil
+}
+
+func (m *MemoryRegistryRepository) CompleteIdempotencyKey(id primitive.ObjectID, response *models.Order) error {
+	response, err := cloneOrder(response)
+	if err != nil {
+		return err
+	}
+
+	if m.tx != nil {
+		if m.tx.completed == nil {
+			m.tx.completed = make(map[primitive.ObjectID]*models.Order)
+		}
+		m.tx.completed[id] = response
+		return nil
+	}
+
+	return m.keys.complete(map[primitive.ObjectID]*models.Order{id: response})
+}
+
+// updateOrder applies the update to the current version of the order.
+// Orders are changed under the lock, so the version read is never stale.
+func (m *MemoryRegistryRepository) updateOrder(id primitive.ObjectID, updates []bson.E, message string) (*models.Order, error) {
+	var order *models.Order
+	err := m.do(func(s *memoryOrdersState) error {
+		current, ok := s.orders[id]
+		if !ok {
+			return mongo.ErrNoDocuments
+		}
+
+		var err error
+		order, err = compareAndUpdate(s, current, updates, message)
+		return err
+	})
+
+	return order, err
+}
+
+func compareAndUpdate(s *memoryOrdersState, expected *models.Order, updates []bson.E, message string) (*models.Order, error) {
+	status, changesStatus := StatusOf(updates)
+
+	current, ok := s.orders[expected.Id]
+	if !ok || current.Version != expected.Version {
+		return nil, &TransitionError{OrderId: expected.Id, From: expected.Status, To: status, Err: ErrStaleOrder}
+	}
+
+	if changesStatus && !current.Status.CanTransitionTo(status) {
+		return nil, &TransitionError{OrderId: current.Id, From: current.Status, To: status, Err: ErrIllegalTransition}
+	}
+
+	updated, err := applyUpdates(current, updates)
+	if err != nil {
+		return nil, err
+	}
+
+	updated.Version++
+	if changesStatus {
+		updated.Updates = append(updated.Updates, models.OrderUpdate{
+			Status:    status,
+			Timestamp: time.Now().UTC(),
+			Message:   message,
+		})
+	}
+	s.orders[updated.Id] = updated
+
+	return cloneOrder(updated)
+}
+
+func (m *MemoryRegistryRepository) Begin(_ context.Context) (RegistryRepository, error) {
+	if m.tx != nil {
+		return nil, ErrUnitOfWorkStarted
+	}
+
+	m.store.mu.Lock()
+
+	uow := *m
+	uow.tx = m.store.state.copy()
+
+	return &uow, nil
+}
+
+func (m *MemoryRegistryRepository) Commit() error {
+	if m.tx == nil {
+		return ErrUnitOfWorkNotStarted
+	}
+	defer m.store.mu.Unlock()
+
+	tx := m.tx
+	m.tx = nil
+
+	// Keys are completed first, so nothing is committed when any of them is released.
+	if len(tx.completed) > 0 {
+		if err := m.keys.complete(tx.completed); err != nil {
+			return err
+		}
+	}
+
+	m.outbox.Insert(tx.staged...)
+	tx.staged = nil
+	tx.completed = nil
+	m.store.state = tx
+
+	return nil
+}
+
+func (m *MemoryRegistryRepository) Rollback() error {
+	if m.tx == nil {
+		return nil
+	}
+
+	m.tx = nil
+	m.store.mu.Unlock()
+
+	return nil
+}
+
+// cloneOrder returns deep copy of the order, so stored orders are never shared with the callers.
+func cloneOrder(order *models.Order) (*models.Order, error) {
+	raw, err := bson.Marshal(order)
+	if err != nil {
+		return nil, err
+	}
+
+	clone := new(models.Order)
+	if err = bson.Unmarshal(raw, clone); err != nil {
+		return nil, err
+	}
+
+	return clone, nil
+}
+
+// applyUpdates returns copy of the order with the fields set by the updates as $set operator does.
+func applyUpdates(order *models.Order, updates []bson.E) (*models.Order, error) {
+	raw, err := bson.Marshal(order)
+	if err != nil {
+		return nil, err
+	}
+
+	document := bson.M{}
+	if err = bson.Unmarshal(raw, &document); err != nil {
+		return nil, err
+	}
+
+	for _, update := range updates {
+		document[update.Key] = update.Value
+	}
+
+	if raw, err = bson.Marshal(document); err != nil {
+		return nil, err
+	}
+
+	updated := new(models.Order)
+	if err = bson.Unmarshal(raw, updated); err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
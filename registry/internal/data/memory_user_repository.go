@@ -0,0 +1,74 @@
+package data
+
+import (
+	"eCommerce/registry/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"sync"
+)
+
+// MemoryUserRepository keeps users and their requests in the process memory.
+type MemoryUserRepository struct {
+	mu       sync.Mutex
+	users    map[primitive.ObjectID]models.User
+	requests []models.UserRequest
+}
+
+func NewMemoryUserRepository() *MemoryUserRepository {
+	r := new(MemoryUserRepository)
+	r.users = make(map[primitive.ObjectID]models.User)
+
+	return r
+}
+
+func (m *MemoryUserRepository) FindUser(id primitive.ObjectID) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	return &user, nil
+}
+
+func (m *MemoryUserRepository) InsertUser(user *models.User) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user.Id = primitive.NewObjectID()
+	m.users[user.Id] = *user
+
+	return user, nil
+}
+
+func (m *MemoryUserRepository) InsertRequest(request *models.UserRequest) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	request.Id = primitive.NewObjectID()
+	m.requests = append(m.requests, *request)
+
+	return nil
+}
+
+func (m *MemoryUserRepository) ListRequests(userId *primitive.ObjectID, skip, limit int64) ([]models.UserRequest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]models.UserRequest, 0, limit)
+	for i := len(m.requests) - 1; i >= 0 && int64(len(list)) < limit; i-- {
+		if userId != nil && m.requests[i].UserId != *userId {
+			continue
+		}
+
+		if skip > 0 {
+			skip--
+			continue
+		}
+		list = append(list, m.requests[i])
+	}
+
+	return list, nil
+}
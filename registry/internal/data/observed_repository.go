@@ -0,0 +1,128 @@
+package data
+
+import (
+	"context"
+	"eCommerce/registry/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// OrderListener is notified with the new state of the order after each successful update.
+type OrderListener interface {
+	OrderUpdated(order *models.Order)
+}
+
+// OrderListeners notifies each listener in order.
+type OrderListeners []OrderListener
+
+func (l OrderListeners) OrderUpdated(order *models.Order) {
+	for _, listener := range l {
+		listener.OrderUpdated(order)
+	}
+}
+
+// ObservedRegistryRepository notifies listener about every order update made through the wrapped repository.
+// Updates which do not change the status, so do not add to the timeline, are not notified.
+// Updates made in the unit of work are notified only after it is committed.
+type ObservedRegistryRepository struct {
+	RegistryRepository
+	listener OrderListener
+	staged   *orderCollector
+}
+
+func NewObservedRegistryRepository(repository RegistryRepository, listener OrderListener) *ObservedRegistryRepository {
+	r := new(ObservedRegistryRepository)
+	r.RegistryRepository = repository
+	r.listener = listener
+
+	return r
+}
+
+func (o *ObservedRegistryRepository) InsertOrder(order *models.Order) (*models.Order, error) {
+	return o.notify(o.RegistryRepository.InsertOrder(order))
+}
+
+func (o *ObservedRegistryRepository) UpdateOrder(id primitive.ObjectID, updates []bson.E) (*models.Order, error) {
+	order, err := o.RegistryRepository.UpdateOrder(id, updates)
+	if _, ok := StatusOf(updates); !ok {
+		return order, err
+	}
+
+	return o.notify(order, err)
+}
+
+func (o *ObservedRegistryRepository) UpdateOrderStatus(id primitive.ObjectID, status models.OrderStatus) (*models.Order, error) {
+	return o.notify(o.RegistryRepository.UpdateOrderStatus(id, status))
+}
+
+func (o *ObservedRegistryRepository) UpdateOrderStatusMessage(id primitive.ObjectID, status models.OrderStatus, message string) (*models.Order, error) {
+	return o.notify(o.RegistryRepository.UpdateOrderStatusMessage(id, status, message))
+}
+
+func (o *ObservedRegistryRepository) CompareAndUpdateOrderStatus(expected *models.Order, status models.OrderStatus, message string) (*models.Order, error) {
+	return o.notify(o.RegistryRepository.CompareAndUpdateOrderStatus(expected, status, message))
+}
+
+func (o *ObservedRegistryRepository) CompareAndUpdateOrder(expected *models.Order, updates []bson.E) (*models.Order, error) {
+	order, err := o.RegistryRepository.CompareAndUpdateOrder(expected, updates)
+	if _, ok := StatusOf(updates); !ok {
+		return order, err
+	}
+
+	return o.notify(order, err)
+}
+
+func (o *ObservedRegistryRepository) Begin(ctx context.Context) (RegistryRepository, error) {
+	uow, err := o.RegistryRepository.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	r := NewObservedRegistryRepository(uow, o.listener)
+	r.staged = new(orderCollector)
+
+	return r, nil
+}
+
+func (o *ObservedRegistryRepository) Commit() error {
+	if err := o.RegistryRepository.Commit(); err != nil {
+		return err
+	}
+
+	if o.staged != nil {
+		for _, order := range *o.staged {
+			o.listener.OrderUpdated(order)
+		}
+		*o.staged = (*o.staged)[:0]
+	}
+
+	return nil
+}
+
+func (o *ObservedRegistryRepository) Rollback() error {
+	if o.staged != nil {
+		*o.staged = (*o.staged)[:0]
+	}
+
+	return o.RegistryRepository.Rollback()
+}
+
+func (o *ObservedRegistryRepository) notify(order *models.Order, err error) (*models.Order, error) {
+	if err != nil {
+		return order, err
+	}
+
+	if o.staged != nil {
+		o.staged.OrderUpdated(order)
+	} else {
+		o.listener.OrderUpdated(order)
+	}
+
+	return order, err
+}
+
+type orderCollector []*models.Order
+
+func (c *orderCollector) OrderUpdated(order *models.Order) {
+	*c = append(*c, order)
+}
@@ -3,6 +3,7 @@ package data
 import (
 	"context"
 	"eCommerce/registry/internal/models"
+	"errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -12,25 +13,59 @@ import (
 
 type RegistryRepository interface {
 	FindOrderId(id primitive.ObjectID) (*models.Order, error)
+	// ListOrders returns page of the orders starting from the latest. Orders of all users are listed when userId is nil.
+	ListOrders(userId *primitive.ObjectID, skip, limit int64) ([]models.Order, error)
+	InsertOrder(order *models.Order) (*models.Order, error)
 	UpdateOrder(id primitive.ObjectID, updates []bson.E) (*models.Order, error)
 	UpdateOrderStatus(id primitive.ObjectID, status models.OrderStatus) (*models.Order, error)
+	UpdateOrderStatusMessage(id primitive.ObjectID, status models.OrderStatus, message string) (*models.Order, error)
+	// CompareAndUpdateOrderStatus updates status of the order only when it was not changed since it was read.
+	// Returns TransitionError when the order was changed or the transition is not allowed.
+	CompareAndUpdateOrderStatus(expected *models.Order, status models.OrderStatus, message string) (*models.Order, error)
+	// CompareAndUpdateOrder applies the updates only when the order was not changed since it was read.
+	CompareAndUpdateOrder(expected *models.Order, updates []bson.E) (*models.Order, error)
+	// FindStaleOrders returns orders in the status which latest update is older than the time.
+	// Paid orders which reservation commit is confirmed are not stale.
+	FindStaleOrders(status models.OrderStatus, before time.Time, limit int64) ([]models.Order, error)
+	// Enqueue stores messages to the outbox. Messages are published to the bus by the outbox relay.
+	Enqueue(messages ...*models.OutboxMessage) error
+	// CompleteIdempotencyKey stores the response of the pending idempotency key, so the key is completed in the same
+	// unit of work as the order. Returns ErrIdempotencyKeyReleased when the key is not pending anymore.
+	CompleteIdempotencyKey(id primitive.ObjectID, response *models.Order) error
+	// Begin starts the unit of work. Changes made through the returned repository are staged in the database
+	// transaction until Commit is called, Rollback discards them. Rollback after Commit has no effect.
+	// Operations of the unit of work are traced as children of the span of the context.
+	Begin(ctx context.Context) (RegistryRepository, error)
 	Commit() error
+	Rollback() error
 }
 
+// staleRetries is number of attempts to apply an update when the order is changed concurrently.
+const staleRetries = 3
+
+// MongoRegistryRepository enforces allowed transitions of the order status declared by models.OrderTransitions.
+// Each update is applied only to the version of the order it was checked against.
 type MongoRegistryRepository struct {
-	orders *mongo.Collection
+	ctx     context.Context
+	session mongo.Session
+	orders  *mongo.Collection
+	outbox  *mongo.Collection
+	keys    *mongo.Collection
 }
 
 func NewMongoRegistryRepository(db *mongo.Database) *MongoRegistryRepository {
 	r := new(MongoRegistryRepository)
+	r.ctx = context.Background()
 	r.orders = db.Collection(`orders`)
+	r.outbox = db.Collection(OutboxCollection)
+	r.keys = db.Collection(IdempotencyCollection)
 
 	return r
 }
 
 func (m *MongoRegistryRepository) FindOrderId(id primitive.ObjectID) (*models.Order, error) {
 	filter := bson.D{{"_id", id}}
-	single := m.orders.FindOne(context.Background(), filter)
+	single := m.orders.FindOne(m.ctx, filter)
 	if err := single.Err(); err != nil {
 		return nil, err
 	}
@@ -43,15 +78,157 @@ func (m *MongoRegistryRepository) FindOrderId(id primitive.ObjectID) (*models.Or
 	return order, nil
 }
 
+func (m *MongoRegistryRepository) ListOrders(userId *primitive.ObjectID, skip, limit int64) ([]models.Order, error) {
+	filter := bson.D{}
+	if userId != nil {
+		filter = bson.D{{"user_id", *userId}}
+	}
+
+	opt := options.Find()
+	opt.SetSort(bson.D{{"_id", -1}})
+	opt.SetSkip(skip)
+	opt.SetLimit(limit)
+
+	records, err := m.orders.Find(m.ctx, filter, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]models.Order, 0, limit)
+	for records.Next(m.ctx) {
+		var record models.Order
+		if err = records.Decode(&record); err != nil {
+			return nil, err
+		}
+		list = append(list, record)
+	}
+
+	return list, nil
+}
+
+func (m *MongoRegistryRepository) InsertOrder(order *models.Order) (*models.Order, error) {
+	one, err := m.orders.InsertOne(m.ctx, order)
+	if err != nil {
+		return nil, err
+	}
+
+	order.Id = one.InsertedID.(primitive.ObjectID)
+
+	return order, nil
+}
+
 func (m *MongoRegistryRepository) UpdateOrder(id primitive.ObjectID, updates []bson.E) (*models.Order, error) {
-	filter := bson.D{{"_id", id}}
+	return m.updateOrder(id, updates, "")
+}
+
+func (m *MongoRegistryRepository) UpdateOrderStatus(id primitive.ObjectID, status models.OrderStatus) (*models.Order, error) {
+	return m.UpdateOrder(id, bson.D{{"status", status}})
+}
+
+// UpdateOrderStatusMessage updates status of the order and stores the message in the order timeline.
+func (m *MongoRegistryRepository) UpdateOrderStatusMessage(id primitive.ObjectID, status models.OrderStatus, message string) (*models.Order, error) {
+	return m.updateOrder(id, bson.D{{"status", status}}, message)
+}
+
+func (m *MongoRegistryRepository) CompareAndUpdateOrderStatus(expected *models.Order, status models.OrderStatus, message string) (*models.Order, error) {
+	return m.compareAndUpdate(expected, bson.D{{"status", status}}, message)
+}
+
+func (m *MongoRegistryRepository) CompareAndUpdateOrder(expected *models.Order, updates []bson.E) (*models.Order, error) {
+	return m.compareAndUpdate(expected, updates, "")
+}
+
+// MigrateSkus makes items of the orders stored before SKUs were introduced reference products by SKU.
+// Names of the products created before SKUs were introduced are their SKUs.
+func (m *MongoRegistryRepository) MigrateSkus(ctx context.Context) error {
+	_, err := m.orders.UpdateMany(ctx, bson.D{{"items.name", bson.D{{"$exists", true}}}}, mongo.Pipeline{
+		{{"$set", bson.D{{"items", bson.D{{"$map", bson.D{
+			{"input", "$items"},
+			{"as", "i"},
+			{"in", bson.D{
+				{"sku", bson.D{{"$ifNull", bson.A{"$$i.sku", "$$i.name"}}}},
+				{"quantity", "$$i.quantity"},
+			}},
+		}}}}}}},
+	})
+
+	return err
+}
+
+func (m *MongoRegistryRepository) FindStaleOrders(status models.OrderStatus, before time.Time, limit int64) ([]models.Order, error) {
+	filter := bson.D{
+		{"status", status},
+		{"$expr", bson.D{{"$lt", bson.A{
+			bson.D{{"$arrayElemAt", bson.A{"$updates.timestamp", -1}}},
+			before,
+		}}}},
+	}
+	if status == models.OrderPaid {
+		filter = append(filter, bson.E{"committed", bson.D{{"$ne", true}}})
+	}
+	opt := options.Find()
+	opt.SetSort(bson.D{{"_id", 1}})
+	opt.SetLimit(limit)
+
+	records, err := m.orders.Find(m.ctx, filter, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]models.Order, 0, limit)
+	for records.Next(m.ctx) {
+		var record models.Order
+		if err = records.Decode(&record); err != nil {
+			return nil, err
+		}
+		list = append(list, record)
+	}
+
+	return list, nil
+}
+
+// updateOrder reads the current version of the order and applies the update to it.
+// Update is repeated when the order was changed concurrently, unless it is a part of the transaction.
+func (m *MongoRegistryRepository) updateOrder(id primitive.ObjectID, updates []bson.E, message string) (*models.Order, error) {
+	for attempt := 1; ; attempt++ {
+		current, err := m.FindOrderId(id)
+		if err != nil {
+			return nil, err
+		}
+
+		order, err := m.compareAndUpdate(current, updates, message)
+		if m.session != nil || attempt == staleRetries || !errors.Is(err, ErrStaleOrder) {
+			return order, err
+		}
+	}
+}
+
+func (m *MongoRegistryRepository) compareAndUpdate(current *models.Order, updates []bson.E, message string) (*models.Order, error) {
+	status, changesStatus := StatusOf(updates)
+	if changesStatus && !current.Status.CanTransitionTo(status) {
+		return nil, &TransitionError{OrderId: current.Id, From: current.Status, To: status, Err: ErrIllegalTransition}
+	}
+
+	filter := bson.D{{"_id", current.Id}, {"version", current.Version}}
+	if current.Version == 0 {
+		// Orders created before versioning have no version field.
+		filter = bson.D{{"_id", current.Id}, {"version", bson.D{{"$in", bson.A{0, nil}}}}}
+	}
+
 	update := bson.D{
 		{"$set", updates},
-		{"$push", CreateStatusUpdateNote(updates)},
+		{"$inc", bson.D{{"version", 1}}},
+	}
+	if changesStatus {
+		update = append(update, bson.E{"$push", CreateStatusUpdateNote(updates, message)})
 	}
+
 	option := options.FindOneAndUpdate().SetReturnDocument(options.After)
-	single := m.orders.FindOneAndUpdate(context.Background(), filter, update, option)
+	single := m.orders.FindOneAndUpdate(m.ctx, filter, update, option)
 	if err := single.Err(); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, &TransitionError{OrderId: current.Id, From: current.Status, To: status, Err: ErrStaleOrder}
+		}
 		return nil, err
 	}
 
@@ -63,15 +240,96 @@ func (m *MongoRegistryRepository) UpdateOrder(id primitive.ObjectID, updates []b
 	return order, nil
 }
 
-func (m *MongoRegistryRepository) UpdateOrderStatus(id primitive.ObjectID, status models.OrderStatus) (*models.Order, error) {
-	return m.UpdateOrder(id, bson.D{{"status", status}})
+func (m *MongoRegistryRepository) Enqueue(messages ...*models.OutboxMessage) error {
+	for _, message := range messages {
+		one, err := m.outbox.InsertOne(m.ctx, message)
+		if err != nil {
+			return err
+		}
+
+		message.Id = one.InsertedID.(primitive.ObjectID)
+	}
+
+	return nil
+}
+
+func (m *MongoRegistryRepository) CompleteIdempotencyKey(id primitive.ObjectID, response *models.Order) error {
+	filter := bson.D{{"_id", id}, {"status", models.IdempotencyPending}}
+	update := bson.D{{"$set", bson.D{
+		{"status", models.IdempotencyCompleted},
+		{"response", response},
+	}}}
+
+	result, err := m.keys.UpdateOne(m.ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrIdempotencyKeyReleased
+	}
+
+	return nil
+}
+
+func (m *MongoRegistryRepository) Begin(ctx context.Context) (RegistryRepository, error) {
+	if m.session != nil {
+		return nil, ErrUnitOfWorkStarted
+	}
+
+	session, err := m.orders.Database().Client().StartSession()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = session.StartTransaction(txOptions); err != nil {
+		session.EndSession(ctx)
+		return nil, err
+	}
+
+	uow := *m
+	uow.session = session
+	uow.ctx = mongo.NewSessionContext(ctx, session)
+
+	return &uow, nil
 }
 
 func (m *MongoRegistryRepository) Commit() error {
-	panic("implement me")
+	if m.session == nil {
+		return ErrUnitOfWorkNotStarted
+	}
+	defer m.end()
+
+	return m.session.CommitTransaction(m.ctx)
+}
+
+func (m *MongoRegistryRepository) Rollback() error {
+	if m.session == nil {
+		return nil
+	}
+	defer m.end()
+
+	return m.session.AbortTransaction(m.ctx)
+}
+
+func (m *MongoRegistryRepository) end() {
+	m.session.EndSession(context.Background())
+	m.session = nil
+	m.ctx = context.Background()
+}
+
+// StatusOf returns the new status of the order from the updates.
+func StatusOf(updates []bson.E) (models.OrderStatus, bool) {
+	for _, update := range updates {
+		if update.Key == `status` {
+			return update.Value.(models.OrderStatus), true
+		}
+	}
+
+	return "", false
 }
 
-func CreateStatusUpdateNote(updates []bson.E) bson.M {
+func CreateStatusUpdateNote(updates []bson.E, message string) bson.M {
 	for _, update := range updates {
 		if update.Key != `status` {
 			continue
@@ -81,6 +339,7 @@ func CreateStatusUpdateNote(updates []bson.E) bson.M {
 		return bson.M{`updates`: models.OrderUpdate{
 			Status:    status,
 			Timestamp: time.Now().UTC(),
+			Message:   message,
 		}}
 	}
 
@@ -0,0 +1,217 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"eCommerce/registry/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+const (
+	OutboxCollection = `outbox`
+	// LeaseCollection keeps leases of the background processes which must run in a single instance.
+	LeaseCollection = `leases`
+)
+
+type OutboxRepository interface {
+	// Pending returns messages which are ready to be published in the order they were stored.
+	// Message is not returned while an earlier message of the same key is waiting for the next attempt,
+	// so messages of the key are published in order.
+	Pending(limit int64) ([]models.OutboxMessage, error)
+	MarkSent(id primitive.ObjectID) error
+	MarkFailed(id primitive.ObjectID, reason string, next time.Time) error
+	// MarkDead records the last failure of the message which is not published anymore. Later messages
+	// of its key are published.
+	MarkDead(id primitive.ObjectID, reason string) error
+	// AcquireLease takes or extends the lease of the outbox for the owner until the time.
+	// Returns false when the lease is held by another owner.
+	AcquireLease(owner string, until time.Time) (bool, error)
+}
+
+// outboxLease is id of the lease held by the relay publishing the outbox.
+const outboxLease = `outbox-relay`
+
+type MongoOutboxRepository struct {
+	outbox *mongo.Collection
+	leases *mongo.Collection
+}
+
+func NewMongoOutboxRepository(db *mongo.Database) *MongoOutboxRepository {
+	r := new(MongoOutboxRepository)
+	r.outbox = db.Collection(OutboxCollection)
+	r.leases = db.Collection(LeaseCollection)
+
+	return r
+}
+
+// EnsureIndexes creates indexes of the pending messages and TTL index which removes sent messages after retention period.
+func (m *MongoOutboxRepository) EnsureIndexes(ctx context.Context, retention time.Duration) error {
+	_, err := m.outbox.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{"status", 1}, {"next_attempt_at", 1}, {"_id", 1}},
+		},
+		{
+			Keys: bson.D{{"key", 1}, {"status", 1}, {"next_attempt_at", 1}},
+		},
+		{
+			Keys:    bson.D{{"sent_at", 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(retention.Seconds())),
+		},
+	})
+
+	return err
+}
+
+func (m *MongoOutboxRepository) Pending(limit int64) ([]models.OutboxMessage, error) {
+	now := time.Now().UTC()
+	filter := bson.D{
+		{"status", models.OutboxPending},
+		{"next_attempt_at", bson.D{{"$lte", now}}},
+	}
+	opt := options.Find()
+	opt.SetSort(bson.D{{"_id", 1}})
+	opt.SetLimit(limit)
+
+	records, err := m.outbox.Find(context.Background(), filter, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]models.OutboxMessage, 0, limit)
+	for records.Next(context.Background()) {
+		var record models.OutboxMessage
+		if err = records.Decode(&record); err != nil {
+			return nil, err
+		}
+		list = append(list, record)
+	}
+
+	if len(list) == 0 {
+		return list, nil
+	}
+
+	blocked, err := m.blocked(list, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return unblocked(list, blocked), nil
+}
+
+// blocked returns the earliest message waiting for the next attempt for each key of the messages.
+func (m *MongoOutboxRepository) blocked(messages []models.OutboxMessage, now time.Time) (map[string]primitive.ObjectID, error) {
+	keys := make(bson.A, 0, len(messages))
+	for _, message := range messages {
+		keys = append(keys, message.Key)
+	}
+
+	records, err := m.outbox.Aggregate(context.Background(), mongo.Pipeline{
+		{{"$match", bson.D{
+			{"key", bson.D{{"$in", keys}}},
+			{"status", models.OutboxPending},
+			{"next_attempt_at", bson.D{{"$gt", now}}},
+		}}},
+		{{"$group", bson.D{{"_id", "$key"}, {"first", bson.D{{"$min", "$_id"}}}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	blocked := make(map[string]primitive.ObjectID)
+	for records.Next(context.Background()) {
+		var record struct {
+			Key   string             `bson:"_id"`
+			First primitive.ObjectID `bson:"first"`
+		}
+		if err = records.Decode(&record); err != nil {
+			return nil, err
+		}
+		blocked[record.Key] = record.First
+	}
+
+	return blocked, nil
+}
+
+// unblocked returns messages which were stored before the earliest blocked message of their key.
+func unblocked(messages []models.OutboxMessage, blocked map[string]primitive.ObjectID) []models.OutboxMessage {
+	list := messages[:0]
+	for _, message := range messages {
+		if first, ok := blocked[message.Key]; ok && bytes.Compare(message.Id[:], first[:]) > 0 {
+			continue
+		}
+		list = append(list, message)
+	}
+
+	return list
+}
+
+func (m *MongoOutboxRepository) MarkSent(id primitive.ObjectID) error {
+	filter := bson.D{{"_id", id}}
+	update := bson.D{
+		{"$set", bson.D{
+			{"status", models.OutboxSent},
+			{"sent_at", time.Now().UTC()},
+		}},
+		{"$inc", bson.D{{"attempts", 1}}},
+	}
+
+	_, err := m.outbox.UpdateOne(context.Background(), filter, update)
+	return err
+}
+
+func (m *MongoOutboxRepository) MarkFailed(id primitive.ObjectID, reason string, next time.Time) error {
+	filter := bson.D{{"_id", id}}
+	update := bson.D{
+		{"$set", bson.D{
+			{"last_error", reason},
+			{"next_attempt_at", next},
+		}},
+		{"$inc", bson.D{{"attempts", 1}}},
+	}
+
+	_, err := m.outbox.UpdateOne(context.Background(), filter, update)
+	return err
+}
+
+func (m *MongoOutboxRepository) MarkDead(id primitive.ObjectID, reason string) error {
+	filter := bson.D{{"_id", id}}
+	update := bson.D{
+		{"$set", bson.D{
+			{"status", models.OutboxDead},
+			{"last_error", reason},
+		}},
+		{"$inc", bson.D{{"attempts", 1}}},
+	}
+
+	_, err := m.outbox.UpdateOne(context.Background(), filter, update)
+	return err
+}
+
+func (m *MongoOutboxRepository) AcquireLease(owner string, until time.Time) (bool, error) {
+	filter := bson.D{
+		{"_id", outboxLease},
+		{"$or", bson.A{
+			bson.D{{"owner", owner}},
+			bson.D{{"expires_at", bson.D{{"$lte", time.Now().UTC()}}}},
+		}},
+	}
+	update := bson.D{{"$set", bson.D{
+		{"owner", owner},
+		{"expires_at", until},
+	}}}
+
+	// Lease held by another owner does not match the filter, so the upsert fails on the duplicate id.
+	_, err := m.leases.UpdateOne(context.Background(), filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,36 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+var (
+	ErrUnitOfWorkStarted    = errors.New(`unit of work is already started`)
+	ErrUnitOfWorkNotStarted = errors.New(`unit of work is not started`)
+)
+
+var (
+	wc        = writeconcern.New(writeconcern.WMajority())
+	rc        = readconcern.Snapshot()
+	txOptions = options.Transaction().SetWriteConcern(wc).SetReadConcern(rc)
+)
+
+// WithUnitOfWork runs fn with the repository bound to the new unit of work of the context.
+// Changes made by fn are committed when it succeeds and rolled back otherwise.
+func WithUnitOfWork(ctx context.Context, repository RegistryRepository, fn func(uow RegistryRepository) error) error {
+	uow, err := repository.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer uow.Rollback()
+
+	if err = fn(uow); err != nil {
+		return err
+	}
+
+	return uow.Commit()
+}
@@ -0,0 +1,125 @@
+package data
+
+import (
+	"context"
+	"eCommerce/registry/internal/models"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"testing"
+	"time"
+)
+
+func TestUnitOfWorkCommit(t *testing.T) {
+	outbox := NewMemoryOutboxRepository()
+	repository := NewMemoryRegistryRepository(outbox, NewMemoryIdempotencyRepository())
+	order := insertOrder(t, repository, models.OrderReserved)
+
+	err := WithUnitOfWork(context.Background(), repository, func(uow RegistryRepository) error {
+		if _, err := uow.UpdateOrderStatus(order.Id, models.OrderPaymentPending); err != nil {
+			return err
+		}
+
+		return uow.Enqueue(models.NewOutboxMessage("topic", order.Id.Hex(), nil))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := findOrder(t, repository, order.Id); got.Status != models.OrderPaymentPending {
+		t.Errorf("status %s, want %s", got.Status, models.OrderPaymentPending)
+	}
+	if pending, _ := outbox.Pending(10); len(pending) != 1 {
+		t.Errorf("outbox has %d messages, want 1", len(pending))
+	}
+}
+
+// Handler fails after the order is updated and the message is staged, so neither of them survives.
+func TestUnitOfWorkRollback(t *testing.T) {
+	outbox := NewMemoryOutboxRepository()
+	repository := NewMemoryRegistryRepository(outbox, NewMemoryIdempotencyRepository())
+	order := insertOrder(t, repository, models.OrderReserved)
+	failure := errors.New("handler failed")
+
+	err := WithUnitOfWork(context.Background(), repository, func(uow RegistryRepository) error {
+		if _, err := uow.UpdateOrderStatus(order.Id, models.OrderPaymentPending); err != nil {
+			return err
+		}
+		if err := uow.Enqueue(models.NewOutboxMessage("topic", order.Id.Hex(), nil)); err != nil {
+			return err
+		}
+
+		return failure
+	})
+	if err != failure {
+		t.Fatalf("unit of work: %v, want %v", err, failure)
+	}
+
+	rolledBack := findOrder(t, repository, order.Id)
+	if rolledBack.Status != order.Status || rolledBack.Version != order.Version || len(rolledBack.Updates) != len(order.Updates) {
+		t.Errorf("order %s version %d after rollback, want %s version %d", rolledBack.Status, rolledBack.Version, order.Status, order.Version)
+	}
+	if pending, _ := outbox.Pending(10); len(pending) != 0 {
+		t.Errorf("outbox has %d messages of the rolled back unit of work", len(pending))
+	}
+
+	// Repository is released by the rollback.
+	if _, err = repository.UpdateOrderStatus(order.Id, models.OrderPaymentPending); err != nil {
+		t.Fatalf("update after rollback: %v", err)
+	}
+}
+
+func TestUnitOfWorkState(t *testing.T) {
+	repository := NewMemoryRegistryRepository(NewMemoryOutboxRepository(), NewMemoryIdempotencyRepository())
+
+	if err := repository.Commit(); err != ErrUnitOfWorkNotStarted {
+		t.Fatalf("commit without unit of work: %v, want %v", err, ErrUnitOfWorkNotStarted)
+	}
+
+	uow, err := repository.Begin(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = uow.Begin(context.Background()); err != ErrUnitOfWorkStarted {
+		t.Fatalf("nested unit of work: %v, want %v", err, ErrUnitOfWorkStarted)
+	}
+	if err = uow.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rollback after the commit, as deferred by WithUnitOfWork, changes nothing.
+	if err = uow.Rollback(); err != nil {
+		t.Fatalf("rollback after commit: %v", err)
+	}
+	if err = uow.Commit(); err != ErrUnitOfWorkNotStarted {
+		t.Fatalf("repeated commit: %v, want %v", err, ErrUnitOfWorkNotStarted)
+	}
+}
+
+func insertOrder(t *testing.T, repository RegistryRepository, status models.OrderStatus) *models.Order {
+	t.Helper()
+
+	now := time.Now().UTC()
+	order, err := repository.InsertOrder(&models.Order{
+		UserId:    primitive.NewObjectID(),
+		Status:    status,
+		Timestamp: now,
+		Items:     []models.OrderProduct{{Sku: "apple", Quantity: 1}},
+		Updates:   []models.OrderUpdate{{Status: status, Timestamp: now}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return order
+}
+
+func findOrder(t *testing.T, repository RegistryRepository, id primitive.ObjectID) *models.Order {
+	t.Helper()
+
+	order, err := repository.FindOrderId(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return order
+}
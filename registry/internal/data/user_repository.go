@@ -0,0 +1,89 @@
+package data
+
+import (
+	"context"
+	"eCommerce/registry/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type UserRepository interface {
+	FindUser(id primitive.ObjectID) (*models.User, error)
+	InsertUser(user *models.User) (*models.User, error)
+	InsertRequest(request *models.UserRequest) error
+	// ListRequests returns page of the requests starting from the latest. Requests of all users are listed when userId is nil.
+	ListRequests(userId *primitive.ObjectID, skip, limit int64) ([]models.UserRequest, error)
+}
+
+type MongoUserRepository struct {
+	users    *mongo.Collection
+	requests *mongo.Collection
+}
+
+func NewMongoUserRepository(db *mongo.Database) *MongoUserRepository {
+	r := new(MongoUserRepository)
+	r.users = db.Collection("users")
+	r.requests = db.Collection("requests")
+
+	return r
+}
+
+func (m *MongoUserRepository) FindUser(id primitive.ObjectID) (*models.User, error) {
+	single := m.users.FindOne(context.Background(), bson.M{"_id": id})
+	if err := single.Err(); err != nil {
+		return nil, err
+	}
+
+	user := new(models.User)
+	if err := single.Decode(user); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (m *MongoUserRepository) InsertUser(user *models.User) (*models.User, error) {
+	result, err := m.users.InsertOne(context.Background(), user)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Id = result.InsertedID.(primitive.ObjectID)
+
+	return user, nil
+}
+
+func (m *MongoUserRepository) InsertRequest(request *models.UserRequest) error {
+	_, err := m.requests.InsertOne(context.Background(), request)
+	return err
+}
+
+func (m *MongoUserRepository) ListRequests(userId *primitive.ObjectID, skip, limit int64) ([]models.UserRequest, error) {
+	filter := bson.D{}
+	if userId != nil {
+		filter = bson.D{{"user_id", *userId}}
+	}
+
+	opt := options.Find()
+	opt.SetSort(bson.D{{"_id", -1}})
+	opt.SetSkip(skip)
+	opt.SetLimit(limit)
+
+	records, err := m.requests.Find(context.Background(), filter, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]models.UserRequest, 0, limit)
+	for records.Next(context.Background()) {
+		var record models.UserRequest
+		if err = records.Decode(&record); err != nil {
+			return nil, err
+		}
+		list = append(list, record)
+	}
+
+	return list, nil
+}
@@ -0,0 +1,165 @@
+package events
+
+import (
+	"eCommerce/registry/internal/models"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sync"
+)
+
+var (
+	ErrTooManyConnections     = errors.New(`too many open event streams`)
+	ErrTooManyUserConnections = errors.New(`too many open event streams for the user`)
+	ErrBrokerClosed           = errors.New(`event streams are closed`)
+)
+
+type BrokerConfig struct {
+	// MaxConnections limits number of subscriptions for all users.
+	MaxConnections int
+	// MaxUserConnections limits number of subscriptions for the single user.
+	MaxUserConnections int
+	// BufferSize is number of events kept for the subscriber which is not reading them yet.
+	BufferSize int
+}
+
+// Broker delivers order events to the subscribed clients.
+// Subscriber which is not able to keep up with the events is dropped and its channel is closed.
+type Broker struct {
+	cfg BrokerConfig
+
+	mu          sync.Mutex
+	closed      bool
+	total       int
+	subscribers map[primitive.ObjectID]map[*Subscription]struct{}
+}
+
+func NewBroker(cfg BrokerConfig) *Broker {
+	b := new(Broker)
+	b.cfg = cfg
+	b.subscribers = make(map[primitive.ObjectID]map[*Subscription]struct{})
+
+	return b
+}
+
+// Subscribe creates subscription for the events of the user orders.
+// When orderId is not zero - only events of that order are delivered.
+func (b *Broker) Subscribe(userId, orderId primitive.ObjectID) (*Subscription, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, ErrBrokerClosed
+	}
+
+	if b.cfg.MaxConnections > 0 && b.total >= b.cfg.MaxConnections {
+		return nil, ErrTooManyConnections
+	}
+
+	user := b.subscribers[userId]
+	if b.cfg.MaxUserConnections > 0 && len(user) >= b.cfg.MaxUserConnections {
+		return nil, ErrTooManyUserConnections
+	}
+
+	if user == nil {
+		user = make(map[*Subscription]struct{})
+		b.subscribers[userId] = user
+	}
+
+	s := &Subscription{
+		UserId:  userId,
+		OrderId: orderId,
+		events:  make(chan models.OrderEvent, b.cfg.BufferSize),
+		broker:  b,
+	}
+	user[s] = struct{}{}
+	b.total++
+
+	return s, nil
+}
+
+// OrderUpdated publishes new state of the order to the subscribers.
+func (b *Broker) OrderUpdated(order *models.Order) {
+	event := models.NewOrderEvent(order)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for s := range b.subscribers[event.UserId] {
+		if !s.OrderId.IsZero() && s.OrderId != event.OrderId {
+			continue
+		}
+
+		select {
+		case s.events <- event:
+		default:
+			b.remove(s)
+		}
+	}
+}
+
+// Close drops all subscriptions and rejects new ones. Used to finish streams on server shutdown.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for _, user := range b.subscribers {
+		for s := range user {
+			b.remove(s)
+		}
+	}
+}
+
+// Connections returns number of active subscriptions.
+func (b *Broker) Connections() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.total
+}
+
+func (b *Broker) unsubscribe(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.remove(s)
+}
+
+// remove deletes subscription and closes its channel. Must be called under the lock.
+func (b *Broker) remove(s *Subscription) {
+	user, ok := b.subscribers[s.UserId]
+	if !ok {
+		return
+	}
+
+	if _, ok = user[s]; !ok {
+		return
+	}
+
+	delete(user, s)
+	if len(user) == 0 {
+		delete(b.subscribers, s.UserId)
+	}
+
+	b.total--
+	close(s.events)
+}
+
+// Subscription receives order events until it is closed or dropped by the broker.
+type Subscription struct {
+	UserId  primitive.ObjectID
+	OrderId primitive.ObjectID
+
+	events chan models.OrderEvent
+	broker *Broker
+}
+
+// Events returns channel of the order events. Channel is closed when subscription is dropped.
+func (s *Subscription) Events() <-chan models.OrderEvent {
+	return s.events
+}
+
+// Close releases the subscription.
+func (s *Subscription) Close() {
+	s.broker.unsubscribe(s)
+}
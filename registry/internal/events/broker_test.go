@@ -0,0 +1,142 @@
+package events
+
+import (
+	"eCommerce/registry/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"testing"
+)
+
+func TestBrokerDeliversEventsOfSubscribedOrders(t *testing.T) {
+	b := NewBroker(BrokerConfig{BufferSize: 4})
+	userId := primitive.NewObjectID()
+	first, second := order(userId), order(userId)
+
+	all := subscribe(t, b, userId, primitive.NilObjectID)
+	one := subscribe(t, b, userId, first.Id)
+	other := subscribe(t, b, primitive.NewObjectID(), primitive.NilObjectID)
+
+	b.OrderUpdated(first)
+	b.OrderUpdated(second)
+
+	if got := received(all); len(got) != 2 || got[0] != first.Id || got[1] != second.Id {
+		t.Errorf("user subscription received %v, want %v and %v", got, first.Id, second.Id)
+	}
+	if got := received(one); len(got) != 1 || got[0] != first.Id {
+		t.Errorf("order subscription received %v, want %v", got, first.Id)
+	}
+	if got := received(other); len(got) != 0 {
+		t.Errorf("subscription of another user received %v", got)
+	}
+}
+
+func TestBrokerUnsubscribe(t *testing.T) {
+	b := NewBroker(BrokerConfig{BufferSize: 4})
+	userId := primitive.NewObjectID()
+
+	s := subscribe(t, b, userId, primitive.NilObjectID)
+	s.Close()
+	// Subscription closed twice, e.g. after it was dropped, is released once.
+	s.Close()
+
+	if n := b.Connections(); n != 0 {
+		t.Fatalf("connections %d after unsubscribe, want 0", n)
+	}
+	if _, ok := <-s.Events(); ok {
+		t.Fatal("events channel is open after unsubscribe")
+	}
+
+	// Events of the user are not delivered to the closed subscription.
+	b.OrderUpdated(order(userId))
+}
+
+func TestBrokerLimits(t *testing.T) {
+	b := NewBroker(BrokerConfig{MaxConnections: 3, MaxUserConnections: 2, BufferSize: 1})
+	user, another := primitive.NewObjectID(), primitive.NewObjectID()
+
+	first := subscribe(t, b, user, primitive.NilObjectID)
+	subscribe(t, b, user, primitive.NilObjectID)
+	if _, err := b.Subscribe(user, primitive.NilObjectID); err != ErrTooManyUserConnections {
+		t.Fatalf("subscribe over the user limit: %v, want %v", err, ErrTooManyUserConnections)
+	}
+
+	subscribe(t, b, another, primitive.NilObjectID)
+	if _, err := b.Subscribe(primitive.NewObjectID(), primitive.NilObjectID); err != ErrTooManyConnections {
+		t.Fatalf("subscribe over the total limit: %v, want %v", err, ErrTooManyConnections)
+	}
+
+	// Released subscription frees the place of the user.
+	first.Close()
+	subscribe(t, b, user, primitive.NilObjectID)
+}
+
+func TestBrokerDropsSlowSubscriber(t *testing.T) {
+	b := NewBroker(BrokerConfig{BufferSize: 1})
+	userId := primitive.NewObjectID()
+	s := subscribe(t, b, userId, primitive.NilObjectID)
+
+	b.OrderUpdated(order(userId))
+	b.OrderUpdated(order(userId))
+
+	if n := b.Connections(); n != 0 {
+		t.Fatalf("connections %d after the buffer overflow, want 0", n)
+	}
+	if got := received(s); len(got) != 1 {
+		t.Fatalf("slow subscriber received %d events before it was dropped, want 1", len(got))
+	}
+	if _, ok := <-s.Events(); ok {
+		t.Fatal("events channel of the dropped subscriber is open")
+	}
+
+	// Closing the dropped subscription does not release the place twice.
+	s.Close()
+	subscribe(t, b, userId, primitive.NilObjectID)
+	if n := b.Connections(); n != 1 {
+		t.Fatalf("connections %d, want 1", n)
+	}
+}
+
+func TestBrokerClose(t *testing.T) {
+	b := NewBroker(BrokerConfig{BufferSize: 1})
+	s := subscribe(t, b, primitive.NewObjectID(), primitive.NilObjectID)
+
+	b.Close()
+
+	if _, ok := <-s.Events(); ok {
+		t.Fatal("events channel is open after the broker is closed")
+	}
+	if _, err := b.Subscribe(primitive.NewObjectID(), primitive.NilObjectID); err != ErrBrokerClosed {
+		t.Fatalf("subscribe to the closed broker: %v, want %v", err, ErrBrokerClosed)
+	}
+	s.Close()
+}
+
+func subscribe(t *testing.T, b *Broker, userId, orderId primitive.ObjectID) *Subscription {
+	t.Helper()
+
+	s, err := b.Subscribe(userId, orderId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func order(userId primitive.ObjectID) *models.Order {
+	return &models.Order{Id: primitive.NewObjectID(), UserId: userId, Status: models.OrderPending}
+}
+
+// received returns ids of the orders of the events buffered by the subscription.
+func received(s *Subscription) []primitive.ObjectID {
+	var ids []primitive.ObjectID
+	for {
+		select {
+		case event, ok := <-s.Events():
+			if !ok {
+				return ids
+			}
+			ids = append(ids, event.OrderId)
+		default:
+			return ids
+		}
+	}
+}
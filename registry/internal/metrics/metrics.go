@@ -0,0 +1,82 @@
+// Package metrics declares prometheus metrics of the registry. Metrics are registered in the default registry
+// and served by the diagnostics server.
+package metrics
+
+import (
+	"eCommerce/registry/internal/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"strconv"
+	"time"
+)
+
+// StatusNone is the previous status of the created order.
+const StatusNone = `NONE`
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: `registry`,
+		Subsystem: `http`,
+		Name:      `requests_total`,
+		Help:      `HTTP requests by method, route pattern and response status.`,
+	}, []string{`method`, `route`, `status`})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: `registry`,
+		Subsystem: `http`,
+		Name:      `request_duration_seconds`,
+		Help:      `Duration of HTTP requests by method and route pattern.`,
+		Buckets:   prometheus.DefBuckets,
+	}, []string{`method`, `route`})
+
+	orderTransitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: `registry`,
+		Subsystem: `order`,
+		Name:      `transitions_total`,
+		Help:      `Changes of the order status. Pending statuses kept by the watchdog are counted as transitions to itself.`,
+	}, []string{`from`, `to`})
+
+	sagaDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: `registry`,
+		Subsystem: `order`,
+		Name:      `saga_duration_seconds`,
+		Help:      `Time from the order creation until the saga completed: the order is paid or reached the terminal status.`,
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 14),
+	}, []string{`status`})
+)
+
+// ObserveRequest records the served HTTP request. Route is the pattern of the router, so requests of the same
+// endpoint with different ids are counted together.
+func ObserveRequest(method, route string, status int, duration time.Duration) {
+	httpRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	httpDuration.WithLabelValues(method, route).Observe(duration.Seconds())
+}
+
+// Orders records transitions of the order status. It is the data.OrderListener, so only committed changes
+// are counted.
+type Orders struct{}
+
+func NewOrders() *Orders {
+	return new(Orders)
+}
+
+// OrderUpdated counts the transition to the latest status of the order timeline and observes duration of the saga
+// when the status completes it. Paid order is still allowed to be canceled and failed order is refunded when
+// the late payment arrives, but their sagas are completed.
+func (o *Orders) OrderUpdated(order *models.Order) {
+	n := len(order.Updates)
+	if n == 0 {
+		return
+	}
+
+	from := models.OrderStatus(StatusNone)
+	if n > 1 {
+		from = order.Updates[n-2].Status
+	}
+	to := order.Updates[n-1]
+
+	orderTransitions.WithLabelValues(string(from), string(to.Status)).Inc()
+	if (to.Status == models.OrderPaid || to.Status == models.OrderError || to.Status.IsTerminal()) && from != to.Status {
+		sagaDuration.WithLabelValues(string(to.Status)).Observe(to.Timestamp.Sub(order.Timestamp).Seconds())
+	}
+}
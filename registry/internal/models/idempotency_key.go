@@ -0,0 +1,38 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+type IdempotencyStatus string
+
+const (
+	IdempotencyPending   IdempotencyStatus = `PENDING`
+	IdempotencyCompleted IdempotencyStatus = `COMPLETED`
+)
+
+// IdempotencyKey stores the client provided key of the request together with the request fingerprint and
+// the response, so repeated request is answered with the original response instead of being executed again.
+// Pending key is leased to the request until LockedUntil. Key of the request which did not complete it in time,
+// e.g. because the process crashed, is acquired again by the next request.
+type IdempotencyKey struct {
+	Id          primitive.ObjectID `bson:"_id,omitempty"`
+	Key         string             `bson:"key"`
+	UserId      primitive.ObjectID `bson:"user_id"`
+	Fingerprint string             `bson:"fingerprint"`
+	Status      IdempotencyStatus  `bson:"status"`
+	Response    *Order             `bson:"response,omitempty"`
+	CreatedAt   time.Time          `bson:"created_at"`
+	LockedUntil time.Time          `bson:"locked_until"`
+	ExpiresAt   time.Time          `bson:"expires_at"`
+}
+
+// IsReleased reports whether the key could be acquired again at the time.
+func (k *IdempotencyKey) IsReleased(now time.Time) bool {
+	if !k.ExpiresAt.After(now) {
+		return true
+	}
+
+	return k.Status == IdempotencyPending && !k.LockedUntil.IsZero() && !k.LockedUntil.After(now)
+}
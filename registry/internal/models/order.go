@@ -1,7 +1,9 @@
 package models
 
 import (
+	"eCommerce/contracts"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
 	"time"
 )
 
@@ -13,4 +15,69 @@ type Order struct {
 	Timestamp time.Time          `json:"timestamp" bson:"timestamp" extensions:"x-order=4"`
 	Items     []OrderProduct     `json:"items" bson:"items" extensions:"x-order=5"`
 	Updates   []OrderUpdate      `json:"-" bson:"updates" extensions:"x-order=6"`
+	// CancelRequested is set when the customer cancels the order waiting for the reservation or the payment.
+	// Compensation is started when the response arrives.
+	CancelRequested bool `json:"cancel_requested,omitempty" bson:"cancel_requested,omitempty" extensions:"x-order=7"`
+	// Committed is set when the storage confirmed the commit of the reservation of the paid order.
+	Committed bool  `json:"-" bson:"committed,omitempty"`
+	Version   int64 `json:"-" bson:"version"`
+}
+
+// Event returns the order as the payload of the saga events.
+func (o *Order) Event() *contracts.Order {
+	items := make([]contracts.OrderProduct, len(o.Items))
+	for i, p := range o.Items {
+		items[i] = contracts.OrderProduct{Sku: p.Sku, Quantity: p.Quantity}
+	}
+
+	return &contracts.Order{
+		Id:     o.Id,
+		UserId: o.UserId,
+		Amount: o.Amount,
+		Items:  items,
+	}
+}
+
+// StatusRepeats returns number of the latest updates in a row which are keeping current status of the order.
+func (o *Order) StatusRepeats() int {
+	n := 0
+	for i := len(o.Updates) - 1; i >= 0 && o.Updates[i].Status == o.Status; i-- {
+		n++
+	}
+
+	return n
+}
+
+// OrderDetails is the order with the full timeline of its status updates.
+type OrderDetails struct {
+	Id        primitive.ObjectID `json:"id" extensions:"x-order=0"`
+	UserId    primitive.ObjectID `json:"user_id" extensions:"x-order=1"`
+	Status    OrderStatus        `json:"status" extensions:"x-order=2"`
+	Amount    float64            `json:"amount" extensions:"x-order=3"`
+	Timestamp time.Time          `json:"timestamp" extensions:"x-order=4"`
+	Items     []OrderProduct     `json:"items" extensions:"x-order=5"`
+	Timeline  []OrderUpdate      `json:"timeline" extensions:"x-order=6"`
+	// CancelRequested is set when the order is going to be canceled after the pending response.
+	CancelRequested bool `json:"cancel_requested,omitempty" extensions:"x-order=7"`
+}
+
+// Details returns order details with the updates ordered by time.
+func (o *Order) Details() *OrderDetails {
+	timeline := make([]OrderUpdate, len(o.Updates))
+	copy(timeline, o.Updates)
+	sort.SliceStable(timeline, func(i, j int) bool {
+		return timeline[i].Timestamp.Before(timeline[j].Timestamp)
+	})
+
+	return &OrderDetails{
+		Id:        o.Id,
+		UserId:    o.UserId,
+		Status:    o.Status,
+		Amount:    o.Amount,
+		Timestamp: o.Timestamp,
+		Items:     o.Items,
+		Timeline:  timeline,
+
+		CancelRequested: o.CancelRequested,
+	}
 }
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// OrderEvent notifies subscribers about order status change.
+type OrderEvent struct {
+	OrderId   primitive.ObjectID `json:"order_id" extensions:"x-order=0"`
+	UserId    primitive.ObjectID `json:"user_id" extensions:"x-order=1"`
+	Status    OrderStatus        `json:"status" extensions:"x-order=2"`
+	Amount    float64            `json:"amount" extensions:"x-order=3"`
+	Message   string             `json:"message,omitempty" extensions:"x-order=4"`
+	Timestamp time.Time          `json:"timestamp" extensions:"x-order=5"`
+}
+
+// NewOrderEvent creates event from the current state of the order and its last update.
+func NewOrderEvent(order *Order) OrderEvent {
+	event := OrderEvent{
+		OrderId:   order.Id,
+		UserId:    order.UserId,
+		Status:    order.Status,
+		Amount:    order.Amount,
+		Timestamp: order.Timestamp,
+	}
+
+	if n := len(order.Updates); n > 0 {
+		event.Message = order.Updates[n-1].Message
+		event.Timestamp = order.Updates[n-1].Timestamp
+	}
+
+	return event
+}
+
+// Event creates event from the current state of the order details.
+func (d *OrderDetails) Event() OrderEvent {
+	return NewOrderEvent(&Order{
+		Id:        d.Id,
+		UserId:    d.UserId,
+		Status:    d.Status,
+		Amount:    d.Amount,
+		Timestamp: d.Timestamp,
+		Updates:   d.Timeline,
+	})
+}
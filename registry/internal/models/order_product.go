@@ -1,6 +1,7 @@
 package models
 
+// OrderProduct is the line of the order referencing the product of the storage by SKU.
 type OrderProduct struct {
-	Name     string `json:"name" bson:"name" extensions:"x-order=0"`
+	Sku      string `json:"sku" bson:"sku" extensions:"x-order=0"`
 	Quantity int64  `json:"quantity" bson:"quantity" extensions:"x-order=1"`
 }
@@ -16,4 +16,77 @@ const (
 	OrderReservationCanceled      OrderStatus = `ORDER_RESERVATION_CANCELED`
 	OrderCanceled                 OrderStatus = `ORDER_CANCELED`
 	OrderCancellationError        OrderStatus = `ORDER_CANCELLATION_ERROR`
+	OrderManualReview             OrderStatus = `ORDER_MANUAL_REVIEW`
 )
+
+// OrderTransitions declares allowed changes of the order status. Statuses without transitions are terminal.
+// Pending statuses are allowed to be kept, so the command could be published again by the watchdog.
+// Paid order is kept as well while the commit of its reservation is not confirmed.
+// Orders waiting for the payment fail when their reservation is expired by the storage. Payment which arrives
+// after the order failed is refunded, so the failed order is moved to the payment cancellation.
+var OrderTransitions = map[OrderStatus][]OrderStatus{
+	OrderError: {
+		OrderPaymentCancelPending,
+	},
+	OrderPending: {
+		OrderReservationPending,
+		OrderError,
+	},
+	OrderReservationPending: {
+		OrderReservationPending,
+		OrderReserved,
+		OrderError,
+		OrderManualReview,
+	},
+	OrderReserved: {
+		OrderPaymentPending,
+		OrderReservationCancelPending,
+		OrderError,
+	},
+	OrderPaymentPending: {
+		OrderPaymentPending,
+		OrderPaid,
+		OrderCancelPending,
+		OrderError,
+		OrderManualReview,
+	},
+	OrderPaid: {
+		OrderPaid,
+		OrderPaymentCancelPending,
+		OrderManualReview,
+	},
+	OrderCancelPending: {
+		OrderReservationCancelPending,
+	},
+	OrderPaymentCancelPending: {
+		OrderPaymentCancelPending,
+		OrderPaymentCanceled,
+		OrderCancellationError,
+		OrderManualReview,
+	},
+	OrderPaymentCanceled: {
+		OrderReservationCancelPending,
+	},
+	OrderReservationCancelPending: {
+		OrderReservationCancelPending,
+		OrderCanceled,
+		OrderCancellationError,
+		OrderManualReview,
+	},
+}
+
+// CanTransitionTo reports whether order in the status is allowed to be moved to the next status.
+func (s OrderStatus) CanTransitionTo(next OrderStatus) bool {
+	for _, allowed := range OrderTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsTerminal reports whether the order in the status is not changed anymore.
+func (s OrderStatus) IsTerminal() bool {
+	return len(OrderTransitions[s]) == 0
+}
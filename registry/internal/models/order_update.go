@@ -9,4 +9,5 @@ type OrderUpdate struct {
 	Id        primitive.ObjectID `json:"id" bson:"_id,omitempty" extensions:"x-order=0"`
 	Status    OrderStatus        `json:"status" bson:"status" extensions:"x-order=1"`
 	Timestamp time.Time          `json:"timestamp" bson:"timestamp" extensions:"x-order=2"`
+	Message   string             `json:"message,omitempty" bson:"message,omitempty" extensions:"x-order=3"`
 }
@@ -0,0 +1,145 @@
+package models
+
+import (
+	"context"
+	"eCommerce/contracts"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.opentelemetry.io/otel"
+	"time"
+)
+
+type OutboxStatus string
+
+const (
+	OutboxPending OutboxStatus = `PENDING`
+	OutboxSent    OutboxStatus = `SENT`
+	// OutboxDead is the message which failed the maximum number of attempts. It is not published anymore.
+	OutboxDead OutboxStatus = `DEAD`
+)
+
+// OutboxMessage is the message stored in the same transaction with the order change and published to the bus later.
+type OutboxMessage struct {
+	Id            primitive.ObjectID `bson:"_id,omitempty"`
+	Topic         string             `bson:"topic"`
+	Key           string             `bson:"key"`
+	Value         []byte             `bson:"value"`
+	Headers       []OutboxHeader     `bson:"headers,omitempty"`
+	Status        OutboxStatus       `bson:"status"`
+	Attempts      int                `bson:"attempts"`
+	LastError     string             `bson:"last_error,omitempty"`
+	CreatedAt     time.Time          `bson:"created_at"`
+	NextAttemptAt time.Time          `bson:"next_attempt_at"`
+	SentAt        *time.Time         `bson:"sent_at,omitempty"`
+}
+
+type OutboxHeader struct {
+	Key   string `bson:"key"`
+	Value []byte `bson:"value"`
+}
+
+func NewOutboxMessage(topic, key string, value []byte) *OutboxMessage {
+	now := time.Now().UTC()
+
+	return &OutboxMessage{
+		Topic:         topic,
+		Key:           key,
+		Value:         value,
+		Status:        OutboxPending,
+		CreatedAt:     now,
+		NextAttemptAt: now,
+	}
+}
+
+// NewOrderCommand returns the outbox message with the command of the order saga published to the topic.
+// Command caused by the response continues its saga, otherwise the order id is the correlation id of the new saga.
+// Command is encoded with the codec and its content type is stored in the header along with the trace context.
+func NewOrderCommand(ctx context.Context, codec contracts.Codec, topic string, order *contracts.Order, cause *contracts.Envelope) (*OutboxMessage, error) {
+	t, ok := contracts.TopicEvent(topic)
+	if !ok {
+		return nil, fmt.Errorf("%w: topic %s", contracts.ErrUnknownEventType, topic)
+	}
+
+	var e *contracts.Envelope
+	var err error
+	if cause != nil {
+		e, err = contracts.NewCausedEnvelope(cause, t, order)
+	} else {
+		e, err = contracts.NewEnvelope(t, order.Id.Hex(), order)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := codec.Encode(e)
+	if err != nil {
+		return nil, err
+	}
+
+	message := NewOutboxMessage(topic, order.Id.Hex(), value)
+	message.Headers = []OutboxHeader{{Key: contracts.HeaderContentType, Value: []byte(codec.ContentType())}}
+	message.InjectTrace(ctx)
+
+	return message, nil
+}
+
+// NewWalletCommand returns the outbox message with the command creating the wallet of the user. User id is
+// the key and the correlation id of the command.
+func NewWalletCommand(ctx context.Context, codec contracts.Codec, user *contracts.User) (*OutboxMessage, error) {
+	e, err := contracts.NewEnvelope(contracts.CreateWallet, user.Id.Hex(), user)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := codec.Encode(e)
+	if err != nil {
+		return nil, err
+	}
+
+	message := NewOutboxMessage(contracts.WalletCreateTopic, user.Id.Hex(), value)
+	message.Headers = []OutboxHeader{{Key: contracts.HeaderContentType, Value: []byte(codec.ContentType())}}
+	message.InjectTrace(ctx)
+
+	return message, nil
+}
+
+// InjectTrace stores the trace context in the headers, so the message published later by the relay
+// continues the trace of the change which staged it.
+func (m *OutboxMessage) InjectTrace(ctx context.Context) {
+	otel.GetTextMapPropagator().Inject(ctx, outboxCarrier{m: m})
+}
+
+// outboxCarrier exposes headers of the outbox message to the propagator.
+type outboxCarrier struct {
+	m *OutboxMessage
+}
+
+func (c outboxCarrier) Get(key string) string {
+	for _, h := range c.m.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+
+	return ""
+}
+
+func (c outboxCarrier) Set(key, value string) {
+	for i := range c.m.Headers {
+		if c.m.Headers[i].Key == key {
+			c.m.Headers[i].Value = []byte(value)
+			return
+		}
+	}
+
+	c.m.Headers = append(c.m.Headers, OutboxHeader{Key: key, Value: []byte(value)})
+}
+
+func (c outboxCarrier) Keys() []string {
+	keys := make([]string, len(c.m.Headers))
+	for i, h := range c.m.Headers {
+		keys[i] = h.Key
+	}
+
+	return keys
+}
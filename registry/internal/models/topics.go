@@ -1,14 +0,0 @@
-package models
-
-const (
-	StorageReserveOrderTopic = `storage-reserve-order`
-	StorageCancelOrderTopic  = `storage-cancel-order`
-	WalletCreateTopic        = `wallet-create`
-	WalletPayOrderTopic      = `wallet-pay-order`
-	WalletCancelOrderTopic   = `wallet-cancel-order`
-
-	StorageReserveOrderResponseTopic = `storage-reserve-order-response`
-	StorageCancelOrderResponseTopic  = `storage-cancel-order-response`
-	WalletPayOrderResponseTopic      = `wallet-pay-order-response`
-	WalletCancelOrderResponseTopic   = `wallet-cancel-order-response`
-)
@@ -2,6 +2,16 @@ package main
 
 import "eCommerce/storage/internal/application"
 
+// @title Storage
+// @version 1.0
+// @description This API administrates products of the storage.
+// @description Operator is able to create, update and archive products, restock and adjust their quantities
+// @description and list current reservations of the product. Every change of the stock is recorded as the movement,
+// @description the stock is reconciled with the movements.
+
+// @host localhost:8082
+// @BasePath /
+// @query.collection.format multi
 func main() {
 	app := application.New()
 	app.Build()
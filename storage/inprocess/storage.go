@@ -0,0 +1,124 @@
+// Package inprocess runs the storage service inside the current process on top of the given message bus
+// with products and reservations kept in memory. It is used to test the order saga without external services.
+// Results of the commands are encoded with the given codec. Reservations are held for an hour, expiry of the
+// reservations is triggered by ExpireReservations.
+package inprocess
+
+import (
+	"context"
+	"eCommerce/contracts"
+	"eCommerce/messaging"
+	"eCommerce/storage/internal/api"
+	"eCommerce/storage/internal/consumers"
+	"eCommerce/storage/internal/core"
+	"eCommerce/storage/internal/data"
+	"eCommerce/storage/internal/metrics"
+	"eCommerce/storage/internal/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+// reservationHold is the time the reservations of the in-process storage are held.
+const reservationHold = time.Hour
+
+type Storage struct {
+	repository *data.MemoryStorageRepository
+	consumer   *consumers.StorageConsumer
+	sweeper    *core.ReservationSweeper
+	ledger     *core.Ledger
+	router     http.Handler
+}
+
+func New(log *zap.SugaredLogger, bus messaging.Bus, codec contracts.Codec) (*Storage, error) {
+	s := new(Storage)
+	s.repository = data.NewMemoryStorageRepository()
+	storage := core.NewStorage(log, s.repository, bus, codec, reservationHold)
+	s.sweeper = core.NewReservationSweeper(log, storage, core.ReservationSweeperConfig{BatchSize: 100})
+	s.ledger = core.NewLedger(log, s.repository)
+	s.router = *api.NewRouter(core.NewCatalog(log, s.repository), s.ledger, &api.RouterConfig{Host: "localhost"})
+
+	consumer, err := consumers.NewStorageConsumer(context.Background(), log, bus, storage, messaging.RetryPolicy{
+		MaxAttempts: 3,
+		MinBackoff:  time.Millisecond,
+		MaxBackoff:  10 * time.Millisecond,
+	}, messaging.PoolConfig{
+		Workers:   4,
+		QueueSize: 8,
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.consumer = consumer
+
+	return s, nil
+}
+
+// Handler returns the product administration API of the storage.
+func (s *Storage) Handler() http.Handler {
+	return s.router
+}
+
+// AddProduct puts the product to the storage replacing the product with the same SKU. Quantity of the product
+// is recorded as the opening movement.
+func (s *Storage) AddProduct(sku, name string, cost float64, quantity int64) error {
+	product := models.Product{Sku: sku, Name: name, Cost: cost, Quantity: quantity}
+	if err := s.repository.InsertProducts(product); err != nil {
+		return err
+	}
+
+	_, err := s.ledger.Open(context.Background())
+	return err
+}
+
+// Quantity returns quantity of the product available in stock.
+func (s *Storage) Quantity(sku string) (int64, error) {
+	product, err := s.repository.FindProduct(sku)
+	if err != nil {
+		return 0, err
+	}
+
+	return product.Quantity, nil
+}
+
+// ExpireReservations releases reservations which are not committed by the time as the sweeper of the service does.
+func (s *Storage) ExpireReservations(ctx context.Context, now time.Time) {
+	s.sweeper.Scan(ctx, now)
+}
+
+// Reservation returns status of the latest reservation of the order.
+func (s *Storage) Reservation(orderId string) (string, error) {
+	id, err := primitive.ObjectIDFromHex(orderId)
+	if err != nil {
+		return "", err
+	}
+
+	reservation, err := s.repository.FindReservation(id, models.Held, models.Committed, models.Expired, models.Canceled, models.Success)
+	if err != nil {
+		return "", err
+	}
+
+	return string(reservation.Status), nil
+}
+
+func (s *Storage) Start() {
+	s.consumer.Start()
+}
+
+// StockCollector returns the collector of the stock levels. It is not registered, so several storages
+// could be run in the same process.
+func (s *Storage) StockCollector() prometheus.Collector {
+	return metrics.NewStockCollector(zap.NewNop().Sugar(), s.repository)
+}
+
+// Ping reports readiness of the command consumers.
+func (s *Storage) Ping(ctx context.Context) error {
+	return s.consumer.Ping(ctx)
+}
+
+// Stop drains the consumers, waiting for the commands being processed until the context is done.
+func (s *Storage) Stop(ctx context.Context) error {
+	return s.consumer.Stop(ctx)
+}
@@ -0,0 +1,7 @@
+package api
+
+const (
+	RequestBodyParseError = "Couldn't parse request body"
+	BadPathParameterError = "Bad path parameter error"
+	InternalServerError   = "Internal server error"
+)
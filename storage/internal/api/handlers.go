@@ -0,0 +1,341 @@
+package api
+
+import (
+	"eCommerce/storage/internal/core"
+	"eCommerce/storage/internal/models"
+	"eCommerce/storage/internal/requests"
+	"encoding/json"
+	"github.com/go-chi/chi"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+)
+
+type ProductHandlers struct {
+	core.ProductController
+}
+
+type LedgerHandlers struct {
+	core.LedgerController
+}
+
+// Index godoc
+// @Summary 	Welcome message from microservice.
+// @Description	Returns name, version and link to the swagger.
+// @Tags        general
+// @Accept      json
+// @Produce     json
+// @Success 	200 {object} ServerResponse
+// @Router 		/ [get]
+func Index(swagURI string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		OkResponse(w, ServerResponse{
+			Name:    `storage`,
+			Version: `1.0.0`,
+			Swagger: swagURI,
+		})
+	}
+}
+
+// ListProductsHandler godoc
+// @Summary 	Returns list of the products.
+// @Description	Find and return products ordered by name. Archived products are listed only when requested.
+// @Tags        products
+// @Accept      json
+// @Produce     json
+// @Param   	archived	query	bool	false	"Include archived products"
+// @Success 	200 {object} []models.Product
+// @Failure 	500 {object} api.Response
+// @Router 		/products [get]
+func (c *ProductHandlers) ListProductsHandler(w http.ResponseWriter, r *http.Request) {
+	archived := r.URL.Query().Get("archived") == "true"
+
+	result, err := c.ProductController.ListProducts(archived)
+	if err != nil {
+		InternalErrorResponse(w, InternalServerError)
+		return
+	}
+
+	OkResponse(w, result)
+}
+
+// CreateProductHandler godoc
+// @Summary 	Creates new product.
+// @Description	Adds the product with the initial quantity in stock. SKU of the product must be unique, it is never changed.
+// @Description	Variants of the product share the name and differ by SKU and variant options, e.g. size and colour.
+// @Tags        products
+// @Accept      json
+// @Produce     json
+// @Param   	product	body	requests.ProductRequest	true	"Product"
+// @Success 	201 {object} models.Product
+// @Failure 	400 {object} api.Response
+// @Failure 	409 {object} api.Response
+// @Failure 	422 {object} api.Response
+// @Failure 	500 {object} api.Response
+// @Router 		/products [post]
+func (c *ProductHandlers) CreateProductHandler(w http.ResponseWriter, r *http.Request) {
+	req := new(requests.ProductRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		BadRequestResponse(w, RequestBodyParseError)
+		return
+	}
+
+	result, err := c.ProductController.CreateProduct(r.Context(), req)
+	switch err {
+	case nil:
+		CreatedResponse(w, result)
+	case core.ErrInvalidSku, core.ErrInvalidProduct:
+		UnprocessableEntityResponse(w, err.Error())
+	case core.ErrProductExists:
+		ConflictResponse(w, err.Error())
+	default:
+		InternalErrorResponse(w, InternalServerError)
+	}
+}
+
+// ProductHandler godoc
+// @Summary 	Returns the product.
+// @Description	Find and return the product with its quantity in stock.
+// @Tags        products
+// @Accept      json
+// @Produce     json
+// @Param   	id	path	string	true	"Product ID"
+// @Success 	200 {object} models.Product
+// @Failure 	400 {object} api.Response
+// @Failure 	404 {object} api.Response
+// @Failure 	500 {object} api.Response
+// @Router 		/products/{id} [get]
+func (c *ProductHandlers) ProductHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := productId(w, r)
+	if !ok {
+		return
+	}
+
+	result, err := c.ProductController.GetProduct(id)
+	productResponse(w, result, err)
+}
+
+// UpdateProductHandler godoc
+// @Summary 	Updates the product.
+// @Description	Changes name, variant options and cost of the product. SKU is never changed, quantity is changed
+// @Description	by restocking and adjustments.
+// @Tags        products
+// @Accept      json
+// @Produce     json
+// @Param   	id		path	string					true	"Product ID"
+// @Param   	product	body	requests.ProductRequest	true	"Product"
+// @Success 	200 {object} models.Product
+// @Failure 	400 {object} api.Response
+// @Failure 	404 {object} api.Response
+// @Failure 	422 {object} api.Response
+// @Failure 	500 {object} api.Response
+// @Router 		/products/{id} [put]
+func (c *ProductHandlers) UpdateProductHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := productId(w, r)
+	if !ok {
+		return
+	}
+
+	req := new(requests.ProductRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		BadRequestResponse(w, RequestBodyParseError)
+		return
+	}
+
+	result, err := c.ProductController.UpdateProduct(r.Context(), id, req)
+	productResponse(w, result, err)
+}
+
+// ArchiveProductHandler godoc
+// @Summary 	Archives the product.
+// @Description	Archived product is not reserved by new orders. Reservations of the existing orders are still
+// @Description	committed or released.
+// @Tags        products
+// @Accept      json
+// @Produce     json
+// @Param   	id	path	string	true	"Product ID"
+// @Success 	200 {object} models.Product
+// @Failure 	400 {object} api.Response
+// @Failure 	404 {object} api.Response
+// @Failure 	500 {object} api.Response
+// @Router 		/products/{id} [delete]
+func (c *ProductHandlers) ArchiveProductHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := productId(w, r)
+	if !ok {
+		return
+	}
+
+	result, err := c.ProductController.ArchiveProduct(r.Context(), id)
+	productResponse(w, result, err)
+}
+
+// RestockHandler godoc
+// @Summary 	Restocks the product.
+// @Description	Adds the positive quantity of the product to the stock. Reason of the change is required.
+// @Description	Archived product can not be restocked.
+// @Tags        stock
+// @Accept      json
+// @Produce     json
+// @Param   	id		path	string					true	"Product ID"
+// @Param   	stock	body	requests.StockRequest	true	"Stock change"
+// @Success 	200 {object} models.Product
+// @Failure 	400 {object} api.Response
+// @Failure 	404 {object} api.Response
+// @Failure 	409 {object} api.Response
+// @Failure 	422 {object} api.Response
+// @Failure 	500 {object} api.Response
+// @Router 		/products/{id}/restock [post]
+func (c *ProductHandlers) RestockHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := productId(w, r)
+	if !ok {
+		return
+	}
+
+	req := new(requests.StockRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		BadRequestResponse(w, RequestBodyParseError)
+		return
+	}
+
+	result, err := c.ProductController.Restock(r.Context(), id, req)
+	productResponse(w, result, err)
+}
+
+// AdjustStockHandler godoc
+// @Summary 	Adjusts stock of the product.
+// @Description	Changes stock of the product by the positive or negative quantity, e.g. after the stocktaking.
+// @Description	Reason of the change is required. Stock can not become negative.
+// @Tags        stock
+// @Accept      json
+// @Produce     json
+// @Param   	id		path	string					true	"Product ID"
+// @Param   	stock	body	requests.StockRequest	true	"Stock change"
+// @Success 	200 {object} models.Product
+// @Failure 	400 {object} api.Response
+// @Failure 	404 {object} api.Response
+// @Failure 	409 {object} api.Response
+// @Failure 	422 {object} api.Response
+// @Failure 	500 {object} api.Response
+// @Router 		/products/{id}/adjustments [post]
+func (c *ProductHandlers) AdjustStockHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := productId(w, r)
+	if !ok {
+		return
+	}
+
+	req := new(requests.StockRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		BadRequestResponse(w, RequestBodyParseError)
+		return
+	}
+
+	result, err := c.ProductController.AdjustStock(r.Context(), id, req)
+	productResponse(w, result, err)
+}
+
+// ListReservationsHandler godoc
+// @Summary 	Returns current reservations of the product.
+// @Description	Find and return reservations holding the product until they are committed, canceled or expired.
+// @Tags        stock
+// @Accept      json
+// @Produce     json
+// @Param   	id	path	string	true	"Product ID"
+// @Success 	200 {object} []models.OrderReservation
+// @Failure 	400 {object} api.Response
+// @Failure 	404 {object} api.Response
+// @Failure 	500 {object} api.Response
+// @Router 		/products/{id}/reservations [get]
+func (c *ProductHandlers) ListReservationsHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := productId(w, r)
+	if !ok {
+		return
+	}
+
+	result, err := c.ProductController.ListReservations(id)
+	switch err {
+	case nil:
+		OkResponse(w, result)
+	case core.ErrProductNotFound:
+		NotFoundResponse(w, err.Error())
+	default:
+		InternalErrorResponse(w, InternalServerError)
+	}
+}
+
+// MovementsHandler godoc
+// @Summary 	Returns movements of the product.
+// @Description	Find and return changes of the product stock starting from the earliest using paging. Each movement
+// @Description	refers to the order and the reservation or explains the reason of the manual change.
+// @Tags        stock
+// @Accept      json
+// @Produce     json
+// @Param   	id		path	string	true	"Product ID"
+// @Param   	page	query	string	false	"Page number"
+// @Param   	size	query	string	false	"Page size"
+// @Success 	200 {object} []models.StockMovement
+// @Failure 	400 {object} api.Response
+// @Failure 	404 {object} api.Response
+// @Failure 	500 {object} api.Response
+// @Router 		/products/{id}/movements [get]
+func (c *LedgerHandlers) MovementsHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := productId(w, r)
+	if !ok {
+		return
+	}
+
+	result, err := c.LedgerController.History(id, requests.ParsePageRequest(r))
+	switch err {
+	case nil:
+		OkResponse(w, result)
+	case core.ErrProductNotFound:
+		NotFoundResponse(w, err.Error())
+	default:
+		InternalErrorResponse(w, InternalServerError)
+	}
+}
+
+// ReconciliationHandler godoc
+// @Summary 	Reconciles stock of the products with their movements.
+// @Description	Recomputes quantity of each product from its movements and returns the products which quantity
+// @Description	differs. Empty list means the stock is consistent.
+// @Tags        stock
+// @Accept      json
+// @Produce     json
+// @Success 	200 {object} []models.StockDrift
+// @Failure 	500 {object} api.Response
+// @Router 		/reconciliation [get]
+func (c *LedgerHandlers) ReconciliationHandler(w http.ResponseWriter, r *http.Request) {
+	result, err := c.LedgerController.Reconcile(r.Context())
+	if err != nil {
+		InternalErrorResponse(w, InternalServerError)
+		return
+	}
+
+	OkResponse(w, result)
+}
+
+// productId returns id of the product from the path. Bad request is responded when the id is malformed.
+func productId(w http.ResponseWriter, r *http.Request) (primitive.ObjectID, bool) {
+	id, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		BadRequestResponse(w, BadPathParameterError)
+		return primitive.NilObjectID, false
+	}
+
+	return id, true
+}
+
+// productResponse responds with the product or the error of the product change.
+func productResponse(w http.ResponseWriter, product *models.Product, err error) {
+	switch err {
+	case nil:
+		OkResponse(w, product)
+	case core.ErrProductNotFound:
+		NotFoundResponse(w, err.Error())
+	case core.ErrInvalidProduct, core.ErrInvalidStock:
+		UnprocessableEntityResponse(w, err.Error())
+	case core.ErrProductArchived, core.ErrNegativeStock:
+		ConflictResponse(w, err.Error())
+	default:
+		InternalErrorResponse(w, InternalServerError)
+	}
+}
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"strings"
+)
+
+// Tracing starts the server span of the request, continuing the trace of the traceparent header.
+// Span is named after the route pattern, so requests of the same endpoint are grouped together.
+func Tracing(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		span := trace.SpanFromContext(r.Context())
+		span.SetAttributes(attribute.String("http.request_id", middleware.GetReqID(r.Context())))
+
+		next.ServeHTTP(w, r)
+
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			span.SetName(r.Method + " " + rctx.RoutePattern())
+		}
+	}
+
+	return otelhttp.NewHandler(http.HandlerFunc(fn), "http request")
+}
+
+// DefaultContentType set content type
+func DefaultContentType(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		defer next.ServeHTTP(w, r)
+
+		if !strings.Contains(r.URL.Path, `swagger`) {
+			w.Header().Set(`Content-Type`, `application/json`)
+		}
+	}
+
+	return http.HandlerFunc(fn)
+}
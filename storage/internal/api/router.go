@@ -0,0 +1,49 @@
+package api
+
+import (
+	"eCommerce/storage/internal/core"
+	"fmt"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	swag "github.com/swaggo/http-swagger"
+	"time"
+)
+
+type RouterConfig struct {
+	Host string
+}
+
+func NewRouter(pc core.ProductController, lc core.LedgerController, cfg *RouterConfig) *chi.Router {
+	ph := ProductHandlers{pc}
+	lh := LedgerHandlers{lc}
+
+	swagIndex := fmt.Sprintf("http://%s/swagger/index.html", cfg.Host)
+	swagDoc := fmt.Sprintf("http://%s/swagger/doc.json", cfg.Host)
+
+	var r chi.Router = chi.NewRouter()
+
+	r.Use(middleware.RequestID)
+	r.Use(Tracing)
+	r.Use(middleware.RealIP)
+	r.Use(middleware.Logger)
+	r.Use(middleware.Recoverer)
+	r.Use(middleware.AllowContentType("application/json"))
+	r.Use(middleware.Timeout(10 * time.Second))
+	r.Use(DefaultContentType)
+
+	r.Get("/", Index(swagIndex))
+	r.Get("/products", ph.ListProductsHandler)
+	r.Post("/products", ph.CreateProductHandler)
+	r.Get("/products/{id}", ph.ProductHandler)
+	r.Put("/products/{id}", ph.UpdateProductHandler)
+	r.Delete("/products/{id}", ph.ArchiveProductHandler)
+	r.Post("/products/{id}/restock", ph.RestockHandler)
+	r.Post("/products/{id}/adjustments", ph.AdjustStockHandler)
+	r.Get("/products/{id}/reservations", ph.ListReservationsHandler)
+	r.Get("/products/{id}/movements", lh.MovementsHandler)
+	r.Get("/reconciliation", lh.ReconciliationHandler)
+
+	r.Get("/swagger/*", swag.Handler(swag.URL(swagDoc)))
+
+	return &r
+}
@@ -2,23 +2,43 @@ package application
 
 import (
 	"context"
+	"eCommerce/contracts"
+	"eCommerce/messaging"
+	"eCommerce/storage/docs"
+	"eCommerce/storage/internal/api"
 	"eCommerce/storage/internal/consumers"
 	"eCommerce/storage/internal/core"
+	"eCommerce/storage/internal/data"
+	"eCommerce/storage/internal/metrics"
+	"eCommerce/telemetry"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.uber.org/zap"
+	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
-	"time"
 )
 
 type App struct {
-	cfg *Config
-	ctx context.Context
-	log *zap.SugaredLogger
+	cfg       *Config
+	ctx       context.Context
+	cancelCtx context.CancelFunc
+	log       *zap.SugaredLogger
 
 	resources       *StorageResources
 	storageConsumer *consumers.StorageConsumer
+	// sweeper releases reservations which are not committed in time.
+	sweeper *core.ReservationSweeper
+	// ledger records movements of the stock.
+	ledger *core.Ledger
+	// server serves the product administration API.
+	server *http.Server
+	// topics are declared by the service and ensured on startup.
+	topics *messaging.Topics
+	// diagnostics serves health and readiness probes on the diagnostic port.
+	diagnostics *telemetry.Diagnostics
 }
 
 func New() *App {
@@ -27,15 +47,68 @@ func New() *App {
 	app := new(App)
 	app.log = logger.Sugar()
 	app.cfg = NewConfig()
-	app.ctx = context.Background()
+	app.ctx, app.cancelCtx = context.WithCancel(context.Background())
 
 	return app
 }
 
 func (a *App) Build() {
 	a.resources = NewStorageResources(a.ctx, a.cfg, a.log).Initialize()
-	storage := core.NewStorage(a.log, a.resources.Database, a.resources.Producer)
-	a.storageConsumer = consumers.NewStorageConsumer(context.Background(), a.log, a.cfg.KafkaConnectionUrl, storage)
+	repository := data.NewMongoStorageRepository(a.resources.Database)
+	if err := repository.EnsureIndexes(a.ctx); err != nil {
+		a.log.Fatal(err)
+	}
+	codec, err := contracts.NewCodec(a.cfg.MessageCodec)
+	if err != nil {
+		a.log.Fatal(err)
+	}
+	storage := core.NewStorage(a.log, repository, a.resources.Bus, codec, a.cfg.ReservationHold)
+	a.sweeper = core.NewReservationSweeper(a.log, storage, core.ReservationSweeperConfig{
+		Interval:  a.cfg.ReservationSweepInterval,
+		BatchSize: a.cfg.ReservationSweepBatch,
+	})
+	prometheus.MustRegister(metrics.NewStockCollector(a.log, repository))
+
+	a.topics = messaging.NewTopics(a.resources.Bus, messaging.TopicConfig{
+		Partitions:          a.cfg.TopicPartitions,
+		ReplicationFactor:   a.cfg.TopicReplicationFactor,
+		Retention:           a.cfg.TopicRetention,
+		DeadLetterRetention: a.cfg.TopicDeadLetterRetention,
+	}.Declare(consumers.ProducedTopics, consumers.ConsumedTopics))
+	a.ensureTopics()
+
+	a.storageConsumer, err = consumers.NewStorageConsumer(a.ctx, a.log, a.resources.Bus, storage, messaging.RetryPolicy{
+		MaxAttempts: a.cfg.ConsumerMaxAttempts,
+		MinBackoff:  a.cfg.ConsumerMinBackoff,
+		MaxBackoff:  a.cfg.ConsumerMaxBackoff,
+	}, messaging.PoolConfig{
+		Workers:   a.cfg.ConsumerWorkers,
+		QueueSize: a.cfg.ConsumerQueueSize,
+	})
+	if err != nil {
+		a.log.Fatal(err)
+	}
+
+	a.ledger = core.NewLedger(a.log, repository)
+	router := api.NewRouter(core.NewCatalog(a.log, repository), a.ledger, &api.RouterConfig{Host: a.cfg.ApplicationHost})
+	a.server = &http.Server{Addr: ":" + strconv.Itoa(a.cfg.ApplicationPort), Handler: *router}
+
+	a.diagnostics = telemetry.NewDiagnostics(a.log, a.cfg.DiagnosticPort)
+	a.diagnostics.AddCheck("mongodb", a.resources.PingMongoDB)
+	a.diagnostics.AddCheck("bus", a.resources.PingBus)
+	a.diagnostics.AddCheck("consumers", a.storageConsumer.Ping)
+	a.diagnostics.AddCheck("topics", a.topics.Ping)
+}
+
+// ensureTopics creates missing topics of the service. Service is started anyway, the topics are ensured again
+// by the readiness probe until the broker configuration matches the declaration.
+func (a *App) ensureTopics() {
+	ctx, cancel := context.WithTimeout(a.ctx, telemetry.CheckTimeout)
+	defer cancel()
+
+	if err := a.topics.Ensure(ctx); err != nil {
+		a.log.Errorw("Failed to ensure topics, the service is not ready.", "err", err)
+	}
 }
 
 func (a *App) InitTestProducts() {
@@ -64,7 +137,23 @@ func (a *App) InitTestProducts() {
 	a.log.Info("successfully complete test products initialization...")
 }
 
+// openLedger records opening movements of the products which were in stock before the ledger was introduced.
+func (a *App) openLedger() {
+	opened, err := a.ledger.Open(a.ctx)
+	if err != nil {
+		a.log.Errorw("Failed to open the ledger, stock of the products is reported as drift.", "err", err)
+		return
+	}
+
+	if opened > 0 {
+		a.log.Infow("Opened the ledger of the products.", "products", opened)
+	}
+}
+
 func (a *App) Run() {
+	docs.SwaggerInfo.Host = a.cfg.ApplicationHost
+	docs.SwaggerInfo.BasePath = "/"
+
 	defer func(log *zap.SugaredLogger) {
 		err := log.Sync()
 		if err != nil {
@@ -73,7 +162,16 @@ func (a *App) Run() {
 	}(a.log)
 
 	a.log.Info("Starting the storage service...")
+	a.openLedger()
+	a.diagnostics.Start()
 	a.storageConsumer.Start()
+	a.sweeper.Start(a.ctx)
+
+	go func() {
+		if err := a.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			a.log.Fatal(err)
+		}
+	}()
 
 	interrupt := make(chan os.Signal, 1)
 	interruptSignals := []os.Signal{syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT}
@@ -86,15 +184,27 @@ func (a *App) Run() {
 
 	a.log.Info("Stopping the app...")
 
-	if err := a.storageConsumer.Stop(); err != nil {
+	// Consumers finish the commands being processed within the shutdown timeout, the rest are delivered again.
+	timeout, cancel := context.WithTimeout(context.Background(), a.cfg.ShutdownTimeout)
+	defer cancel()
+
+	if err := a.server.Shutdown(timeout); err != nil {
+		a.log.Error("Got an error while stopping the server.", "err", err)
+	}
+	a.sweeper.Stop()
+	if err := a.storageConsumer.Stop(timeout); err != nil {
 		a.log.Error("Got an error while stopping the storage consumer.", "err", err)
 	}
 
-	timeout, _ := context.WithTimeout(context.Background(), 10*time.Second)
+	a.cancelCtx()
 	err := a.resources.Release(timeout)
 	if err != nil {
 		a.log.Error("Got an error while releasing resources.", "err", err)
 	}
 
+	if err = a.diagnostics.Shutdown(timeout); err != nil {
+		a.log.Error("Got an error while stopping the diagnostics server.", "err", err)
+	}
+
 	a.log.Info("The app is calling the last defers and will be stopped.")
 }
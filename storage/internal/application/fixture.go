@@ -28,8 +28,10 @@ func GenerateProducts(n int) []models.Product {
 }
 
 func NextProduct(n int) models.Product {
+	name := GenerateProductName(n)
 	product := models.Product{
-		Name:     GenerateProductName(n),
+		Sku:      GenerateSku(name),
+		Name:     name,
 		Cost:     float64(n + 1),
 		Quantity: DefaultQuantity,
 	}
@@ -38,5 +40,9 @@ func NextProduct(n int) models.Product {
 }
 
 func GenerateProductName(n int) string {
-	return string(DefaultOffset + n)
+	return string(rune(DefaultOffset + n))
+}
+
+func GenerateSku(name string) string {
+	return "SKU-" + name
 }
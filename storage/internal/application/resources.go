@@ -2,9 +2,13 @@ package application
 
 import (
 	"context"
-	"github.com/segmentio/kafka-go"
+	"eCommerce/messaging"
+	"eCommerce/telemetry"
+	"errors"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo"
 	"go.uber.org/zap"
 )
 
@@ -14,7 +18,9 @@ type StorageResources struct {
 	log        *zap.SugaredLogger
 
 	Database *mongo.Database
-	Producer *kafka.Writer
+	Bus      messaging.Bus
+
+	shutdownTracing telemetry.Shutdown
 }
 
 func NewStorageResources(ctx context.Context, cfg *Config, log *zap.SugaredLogger) *StorageResources {
@@ -27,22 +33,55 @@ func NewStorageResources(ctx context.Context, cfg *Config, log *zap.SugaredLogge
 }
 
 func (sr *StorageResources) Initialize() *StorageResources {
+	sr.initTracing()
 	sr.initDatabase()
-	sr.initProducer()
+	sr.initBus()
 
 	return sr
 }
 
 func (sr *StorageResources) Release(ctx context.Context) error {
+	if err := sr.Bus.Close(); err != nil {
+		return err
+	}
+
 	if err := sr.Database.Client().Disconnect(ctx); err != nil {
 		return err
 	}
 
+	return sr.shutdownTracing(ctx)
+}
+
+// PingMongoDB checks connectivity with the primary of the replica set, which serves the transactions.
+func (sr *StorageResources) PingMongoDB(ctx context.Context) error {
+	return sr.Database.Client().Ping(ctx, readpref.Primary())
+}
+
+// PingBus checks connectivity of the bus publisher with the broker.
+func (sr *StorageResources) PingBus(ctx context.Context) error {
+	if pinger, ok := sr.Bus.(messaging.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+
 	return nil
 }
 
+// initTracing installs the tracer provider which exports spans of the service to the configured exporter.
+func (sr *StorageResources) initTracing() {
+	shutdown, err := telemetry.Setup(telemetry.Config{
+		ServiceName: sr.cfg.ServiceName,
+		Exporter:    sr.cfg.TraceExporter,
+		File:        sr.cfg.TraceFile,
+	})
+	if err != nil {
+		sr.log.Fatal(err)
+	}
+
+	sr.shutdownTracing = shutdown
+}
+
 func (sr *StorageResources) initDatabase() {
-	uri := options.Client().ApplyURI(sr.cfg.MongoConnectionUrl)
+	uri := options.Client().ApplyURI(sr.cfg.MongoConnectionUrl).SetMonitor(otelmongo.NewMonitor())
 	c, err := mongo.Connect(sr.appContext, uri)
 
 	if err != nil {
@@ -52,9 +91,14 @@ func (sr *StorageResources) initDatabase() {
 	sr.Database = c.Database(ServiceName)
 }
 
-func (sr *StorageResources) initProducer() {
-	sr.Producer = &kafka.Writer{
-		Addr:     kafka.TCP(sr.cfg.KafkaConnectionUrl),
-		Balancer: new(kafka.LeastBytes),
+// initBus creates the message bus. In-memory bus is useful for local runs without kafka.
+func (sr *StorageResources) initBus() {
+	switch sr.cfg.MessageBus {
+	case MessageBusKafka:
+		sr.Bus = messaging.NewKafkaBus(sr.cfg.KafkaConnectionUrl)
+	case MessageBusMemory:
+		sr.Bus = messaging.NewMemoryBus(sr.cfg.MemoryBusPartitions)
+	default:
+		sr.log.Fatal(errors.New(`unknown message bus ` + sr.cfg.MessageBus))
 	}
 }
@@ -2,18 +2,17 @@ package consumers
 
 import (
 	"context"
+	"eCommerce/contracts"
+	"eCommerce/messaging"
 	"eCommerce/storage/internal/core"
-	"github.com/segmentio/kafka-go"
+	"errors"
 	"go.uber.org/zap"
-	"io"
-	"log"
 )
 
 const (
-	ReserveOrderTopic = `storage-reserve-order`
 	ReserveOrderGroup = `storage-reserve-order-group`
-	CancelOrderTopic  = `storage-cancel-order`
 	CancelOrderGroup  = `storage-cancel-order-group`
+	CommitOrderGroup  = `storage-commit-order-group`
 )
 
 type StorageConsumer struct {
@@ -21,88 +20,110 @@ type StorageConsumer struct {
 	log     *zap.SugaredLogger
 	storage core.StorageService
 
-	reserveReader *kafka.Reader
-	cancelReader  *kafka.Reader
+	reserveReader messaging.Reader
+	cancelReader  messaging.Reader
+	commitReader  messaging.Reader
+
+	reserveConsumer *messaging.Consumer
+	cancelConsumer  *messaging.Consumer
+	commitConsumer  *messaging.Consumer
 }
 
-func NewStorageConsumer(ctx context.Context, log *zap.SugaredLogger, kafkaAddr string, storage core.StorageService) *StorageConsumer {
+// NewStorageConsumer subscribes to the storage commands. Commands which are failed after all attempts of the policy
+// are moved to the dead letter topics of the bus. Commands of different orders are processed concurrently.
+func NewStorageConsumer(ctx context.Context, log *zap.SugaredLogger, bus messaging.Bus, storage core.StorageService, policy messaging.RetryPolicy, pool messaging.PoolConfig) (*StorageConsumer, error) {
 	consumer := new(StorageConsumer)
 
 	consumer.ctx = ctx
 	consumer.log = log
 	consumer.storage = storage
 
-	consumer.reserveReader = kafka.NewReader(ReaderConfig(kafkaAddr, ReserveOrderTopic, ReserveOrderGroup))
-	consumer.cancelReader = kafka.NewReader(ReaderConfig(kafkaAddr, CancelOrderTopic, CancelOrderGroup))
+	var err error
+	consumer.reserveReader, err = bus.Subscribe(contracts.StorageReserveOrderTopic, ReserveOrderGroup)
+	if err != nil {
+		return nil, err
+	}
 
-	return consumer
-}
+	consumer.cancelReader, err = bus.Subscribe(contracts.StorageCancelOrderTopic, CancelOrderGroup)
+	if err != nil {
+		return nil, err
+	}
 
-// ReserveOrder creates product reservation in storage.
-func (c *StorageConsumer) ReserveOrder(message kafka.Message) error {
-	order, err := ParseOrder(message)
+	consumer.commitReader, err = bus.Subscribe(contracts.StorageCommitOrderTopic, CommitOrderGroup)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = c.storage.ReserveOrder(order)
+	consumer.reserveConsumer = messaging.NewConsumer(log, consumer.reserveReader, bus, consumer.ReserveOrder, policy, pool)
+	consumer.cancelConsumer = messaging.NewConsumer(log, consumer.cancelReader, bus, consumer.CancelOrder, policy, pool)
+	consumer.commitConsumer = messaging.NewConsumer(log, consumer.commitReader, bus, consumer.CommitOrder, policy, pool)
+
+	return consumer, nil
+}
+
+// ReserveOrder creates product reservation in storage. Command with invalid lines is moved to the dead letter topic
+// without retries.
+func (c *StorageConsumer) ReserveOrder(ctx context.Context, message *messaging.Message) error {
+	command, order, err := ParseOrder(message)
 	if err != nil {
-		return err
+		return messaging.Permanent(err)
 	}
 
-	return nil
+	err = c.storage.ReserveOrder(ctx, command, order)
+	if errors.Is(err, core.ErrInvalidOrderLine) {
+		return messaging.Permanent(err)
+	}
+
+	return err
 }
 
 // CancelOrder declines order products reservation.
-func (c *StorageConsumer) CancelOrder(message kafka.Message) error {
-	order, err := ParseOrder(message)
+func (c *StorageConsumer) CancelOrder(ctx context.Context, message *messaging.Message) error {
+	command, order, err := ParseOrder(message)
 	if err != nil {
-		return err
+		return messaging.Permanent(err)
 	}
 
-	err = c.storage.CancelOrder(order)
+	return c.storage.CancelOrder(ctx, command, order)
+}
+
+// CommitOrder makes products reservation of the paid order permanent.
+func (c *StorageConsumer) CommitOrder(ctx context.Context, message *messaging.Message) error {
+	command, order, err := ParseOrder(message)
 	if err != nil {
-		return err
+		return messaging.Permanent(err)
 	}
 
-	return nil
+	return c.storage.CommitOrder(ctx, command, order)
 }
 
 func (c *StorageConsumer) Start() {
-	c.launchConsumer(c.reserveReader, c.ReserveOrder)
-	c.launchConsumer(c.cancelReader, c.CancelOrder)
+	go c.reserveConsumer.Run(c.ctx)
+	go c.cancelConsumer.Run(c.ctx)
+	go c.commitConsumer.Run(c.ctx)
 }
 
-func (c *StorageConsumer) Stop() error {
-	if err := c.reserveReader.Close(); err != nil {
-		log.Fatal("failed to close reader:", err)
-		return err
-	}
-
-	if err := c.cancelReader.Close(); err != nil {
-		log.Fatal("failed to close reader:", err)
-		return err
+// Ping reports readiness of the command consumers.
+func (c *StorageConsumer) Ping(ctx context.Context) error {
+	for _, consumer := range []*messaging.Consumer{c.reserveConsumer, c.cancelConsumer, c.commitConsumer} {
+		if err := consumer.Ping(ctx); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func (c *StorageConsumer) launchConsumer(r *kafka.Reader, handler func(message kafka.Message) error) {
-	go func(c StorageConsumer) {
-		for {
-			m, err := r.ReadMessage(context.Background())
-			if err != nil {
-				if err != io.EOF {
-					c.log.Error(`read message err: `, err)
-				}
-				continue
-			}
-
-			c.log.Info(`read message`, string(m.Key), m.Offset)
-			if err = handler(m); err != nil {
-				c.log.Error(`handle message err:`, err)
-				continue
-			}
+// Stop stops fetching commands, waits for the commands being processed until the context is done and closes
+// the readers. Offsets of the processed commands are committed before the readers are closed.
+func (c *StorageConsumer) Stop(ctx context.Context) error {
+	err := messaging.StopConsumers(ctx, c.reserveConsumer, c.cancelConsumer, c.commitConsumer)
+
+	for _, reader := range []messaging.Reader{c.reserveReader, c.cancelReader, c.commitReader} {
+		if closeErr := reader.Close(); closeErr != nil && err == nil {
+			err = closeErr
 		}
-	}(*c)
+	}
+
+	return err
 }
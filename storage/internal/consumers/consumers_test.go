@@ -0,0 +1,48 @@
+package consumers
+
+import (
+	"context"
+	"eCommerce/contracts"
+	"eCommerce/messaging"
+	"eCommerce/storage/internal/core"
+	"eCommerce/storage/internal/data"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+// Reservation of the order with invalid lines is not retried, the command is moved to the dead letter topic.
+func TestReserveInvalidOrderIsPermanent(t *testing.T) {
+	bus := messaging.NewMemoryBus(1)
+	t.Cleanup(func() { _ = bus.Close() })
+
+	storage := core.NewStorage(zap.NewNop().Sugar(), data.NewMemoryStorageRepository(), bus, contracts.JSONCodec{}, time.Hour)
+	consumer, err := NewStorageConsumer(context.Background(), zap.NewNop().Sugar(), bus, storage, messaging.RetryPolicy{MaxAttempts: 1}, messaging.PoolConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order := &contracts.Order{
+		Id:     primitive.NewObjectID(),
+		UserId: primitive.NewObjectID(),
+		Items:  []contracts.OrderProduct{{Sku: "apple", Quantity: -1}},
+	}
+	e, err := contracts.NewEnvelope(contracts.ReserveOrder, order.Id.Hex(), order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec := contracts.JSONCodec{}
+	value, err := codec.Encode(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &messaging.Message{Topic: contracts.StorageReserveOrderTopic, Key: []byte(order.Id.Hex()), Value: value}
+	m.SetHeader(contracts.HeaderContentType, []byte(codec.ContentType()))
+
+	err = consumer.ReserveOrder(context.Background(), m)
+	if !errors.Is(err, core.ErrInvalidOrderLine) || !messaging.IsPermanent(err) {
+		t.Errorf("reserve the invalid order: %v, want permanent %v", err, core.ErrInvalidOrderLine)
+	}
+}
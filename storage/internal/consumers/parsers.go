@@ -1,18 +1,24 @@
 package consumers
 
 import (
-	"eCommerce/storage/internal/models"
-	"encoding/json"
-	"github.com/segmentio/kafka-go"
+	"eCommerce/contracts"
+	"eCommerce/messaging"
 )
 
-func ParseOrder(message kafka.Message) (*models.Order, error) {
-	order := new(models.Order)
-	err := json.Unmarshal(message.Value, order)
+// ParseOrder decodes the envelope of the command and the order in its payload.
+// Codec of the envelope is selected by the content type header.
+func ParseOrder(message *messaging.Message) (*contracts.Envelope, *contracts.Order, error) {
+	contentType, _ := message.Header(contracts.HeaderContentType)
 
+	e, err := contracts.Decode(message.Topic, string(contentType), message.Value)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return order, nil
+	order := new(contracts.Order)
+	if err = e.Unmarshal(order); err != nil {
+		return nil, nil, err
+	}
+
+	return e, order, nil
 }
@@ -0,0 +1,18 @@
+package consumers
+
+import "eCommerce/contracts"
+
+// Topics declared by the storage. Topics are ensured on startup, consumed topics are declared with dead letter topics.
+var (
+	ConsumedTopics = []string{
+		contracts.StorageReserveOrderTopic,
+		contracts.StorageCancelOrderTopic,
+		contracts.StorageCommitOrderTopic,
+	}
+	ProducedTopics = []string{
+		contracts.StorageReserveOrderResponseTopic,
+		contracts.StorageCancelOrderResponseTopic,
+		contracts.StorageCommitOrderResponseTopic,
+		contracts.StorageReservationExpiredTopic,
+	}
+)
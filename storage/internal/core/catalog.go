@@ -0,0 +1,240 @@
+package core
+
+import (
+	"context"
+	"eCommerce/storage/internal/data"
+	"eCommerce/storage/internal/models"
+	"eCommerce/storage/internal/requests"
+	"eCommerce/telemetry"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+	"strings"
+)
+
+var (
+	ErrProductNotFound = errors.New(`product not found`)
+	ErrProductExists   = errors.New(`product with the same sku already exists`)
+	ErrProductArchived = errors.New(`product is archived`)
+	ErrInvalidSku      = errors.New(`product sku is required and must not contain spaces`)
+	ErrInvalidProduct  = errors.New(`product name and variant options are required, cost and quantity must not be negative`)
+	ErrInvalidStock    = errors.New(`quantity must be positive and reason is required`)
+	ErrNegativeStock   = errors.New(`quantity of the product in stock can not be negative`)
+)
+
+// ProductController administrates products of the storage. Each product is the stock keeping unit, variants
+// of the product are products of the same name with different SKUs and variant options.
+type ProductController interface {
+	// ListProducts returns products ordered by name and SKU, so variants of the product are listed together.
+	// Archived products are returned only when requested.
+	ListProducts(archived bool) ([]models.Product, error)
+	GetProduct(id primitive.ObjectID) (*models.Product, error)
+	CreateProduct(ctx context.Context, request *requests.ProductRequest) (*models.Product, error)
+	// UpdateProduct changes name, variant options and cost of the product.
+	UpdateProduct(ctx context.Context, id primitive.ObjectID, request *requests.ProductRequest) (*models.Product, error)
+	// ArchiveProduct excludes the product from new reservations.
+	ArchiveProduct(ctx context.Context, id primitive.ObjectID) (*models.Product, error)
+	// Restock adds the quantity of the product to the stock.
+	Restock(ctx context.Context, id primitive.ObjectID, request *requests.StockRequest) (*models.Product, error)
+	// AdjustStock changes stock of the product by the positive or negative quantity.
+	AdjustStock(ctx context.Context, id primitive.ObjectID, request *requests.StockRequest) (*models.Product, error)
+	// ListReservations returns reservations holding the product until they are committed or released.
+	ListReservations(id primitive.ObjectID) ([]models.OrderReservation, error)
+}
+
+// Catalog changes products within the unit of work, so the changes are serialized with reservations of the orders.
+type Catalog struct {
+	log        *zap.SugaredLogger
+	repository data.StorageRepository
+}
+
+func NewCatalog(log *zap.SugaredLogger, repository data.StorageRepository) *Catalog {
+	c := new(Catalog)
+	c.log = log
+	c.repository = repository
+
+	return c
+}
+
+func (c *Catalog) ListProducts(archived bool) ([]models.Product, error) {
+	products, err := c.repository.ListProducts()
+	if err != nil {
+		return nil, err
+	}
+
+	if archived {
+		return products, nil
+	}
+
+	active := make([]models.Product, 0, len(products))
+	for _, p := range products {
+		if !p.Archived {
+			active = append(active, p)
+		}
+	}
+
+	return active, nil
+}
+
+func (c *Catalog) GetProduct(id primitive.ObjectID) (*models.Product, error) {
+	return findProduct(c.repository, id)
+}
+
+func (c *Catalog) CreateProduct(ctx context.Context, request *requests.ProductRequest) (*models.Product, error) {
+	sku := strings.TrimSpace(request.Sku)
+	if sku == "" || strings.ContainsAny(sku, " \t\n") {
+		return nil, ErrInvalidSku
+	}
+	if !isValidProduct(request) || request.Quantity < 0 {
+		return nil, ErrInvalidProduct
+	}
+
+	product := &models.Product{
+		Sku:      sku,
+		Name:     strings.TrimSpace(request.Name),
+		Variant:  variant(request.Variant),
+		Cost:     request.Cost,
+		Quantity: request.Quantity,
+	}
+	err := data.WithUnitOfWork(ctx, c.repository, func(uow data.StorageRepository) error {
+		if _, err := uow.InsertProduct(product); err != nil {
+			return err
+		}
+
+		return uow.InsertMovement(NewMovement(product, models.InitialMovement, product.Quantity))
+	})
+	if errors.Is(err, data.ErrDuplicateProduct) {
+		return nil, ErrProductExists
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	telemetry.Logger(ctx, c.log).Infow("product created", "product", product.Id.Hex(), "sku", product.Sku)
+	return product, nil
+}
+
+// UpdateProduct changes name, variant options and cost of the product. Reservations and movements refer
+// to the product by SKU, which is not changed, so the product is renamed regardless of its reservations.
+func (c *Catalog) UpdateProduct(ctx context.Context, id primitive.ObjectID, request *requests.ProductRequest) (*models.Product, error) {
+	if !isValidProduct(request) {
+		return nil, ErrInvalidProduct
+	}
+
+	return c.update(ctx, id, func(uow data.StorageRepository, product *models.Product) error {
+		product.Name, product.Variant, product.Cost = strings.TrimSpace(request.Name), variant(request.Variant), request.Cost
+		return uow.UpdateProduct(product)
+	})
+}
+
+func (c *Catalog) ArchiveProduct(ctx context.Context, id primitive.ObjectID) (*models.Product, error) {
+	return c.update(ctx, id, func(uow data.StorageRepository, product *models.Product) error {
+		product.Archived = true
+		return uow.UpdateProduct(product)
+	})
+}
+
+// Restock is not allowed for archived products, they are not sold anymore.
+func (c *Catalog) Restock(ctx context.Context, id primitive.ObjectID, request *requests.StockRequest) (*models.Product, error) {
+	if request.Quantity <= 0 || strings.TrimSpace(request.Reason) == "" {
+		return nil, ErrInvalidStock
+	}
+
+	return c.update(ctx, id, func(uow data.StorageRepository, product *models.Product) error {
+		if product.Archived {
+			return ErrProductArchived
+		}
+
+		return c.addQuantity(ctx, uow, product, models.RestockMovement, request)
+	})
+}
+
+func (c *Catalog) AdjustStock(ctx context.Context, id primitive.ObjectID, request *requests.StockRequest) (*models.Product, error) {
+	if request.Quantity == 0 || strings.TrimSpace(request.Reason) == "" {
+		return nil, ErrInvalidStock
+	}
+
+	return c.update(ctx, id, func(uow data.StorageRepository, product *models.Product) error {
+		if product.Quantity+request.Quantity < 0 {
+			return ErrNegativeStock
+		}
+
+		return c.addQuantity(ctx, uow, product, models.AdjustmentMovement, request)
+	})
+}
+
+func (c *Catalog) ListReservations(id primitive.ObjectID) ([]models.OrderReservation, error) {
+	product, err := findProduct(c.repository, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.repository.FindProductReservations(product.Sku, models.Held)
+}
+
+// addQuantity changes stock of the product and records the movement with the reason of the request.
+func (c *Catalog) addQuantity(ctx context.Context, uow data.StorageRepository, product *models.Product, t models.MovementType, request *requests.StockRequest) error {
+	changed, err := moveStock(uow, product.Sku, t, request.Quantity, func(m *models.StockMovement) {
+		m.Reason = strings.TrimSpace(request.Reason)
+	})
+	if err != nil {
+		return err
+	}
+	*product = *changed
+
+	telemetry.Logger(ctx, c.log).Infow("product stock changed", "product", product.Id.Hex(),
+		"delta", request.Quantity, "quantity", product.Quantity, "reason", request.Reason)
+	return nil
+}
+
+// update applies fn to the product within the unit of work and returns the changed product.
+func (c *Catalog) update(ctx context.Context, id primitive.ObjectID, fn func(uow data.StorageRepository, product *models.Product) error) (*models.Product, error) {
+	var product *models.Product
+	err := data.WithUnitOfWork(ctx, c.repository, func(uow data.StorageRepository) error {
+		var err error
+		if product, err = findProduct(uow, id); err != nil {
+			return err
+		}
+
+		return fn(uow, product)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return product, nil
+}
+
+func findProduct(repository data.StorageRepository, id primitive.ObjectID) (*models.Product, error) {
+	product, err := repository.FindProductId(id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrProductNotFound
+	}
+
+	return product, err
+}
+
+func isValidProduct(request *requests.ProductRequest) bool {
+	for option, value := range request.Variant {
+		if strings.TrimSpace(option) == "" || strings.TrimSpace(value) == "" {
+			return false
+		}
+	}
+
+	return strings.TrimSpace(request.Name) != "" && request.Cost >= 0
+}
+
+// variant returns trimmed options of the variant, product without options has no variant.
+func variant(options map[string]string) map[string]string {
+	if len(options) == 0 {
+		return nil
+	}
+
+	v := make(map[string]string, len(options))
+	for option, value := range options {
+		v[strings.TrimSpace(option)] = strings.TrimSpace(value)
+	}
+
+	return v
+}
@@ -0,0 +1,153 @@
+package core
+
+import (
+	"context"
+	"eCommerce/storage/internal/data"
+	"eCommerce/storage/internal/models"
+	"eCommerce/storage/internal/requests"
+	"eCommerce/telemetry"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+	"time"
+)
+
+// LedgerController shows movements of the stock and checks that the stock of the products matches them.
+type LedgerController interface {
+	// History returns page of the movements of the product starting from the earliest.
+	History(id primitive.ObjectID, request *requests.PageRequest) ([]models.StockMovement, error)
+	// Reconcile recomputes quantity of each product from its movements and returns the products which differ.
+	Reconcile(ctx context.Context) ([]models.StockDrift, error)
+}
+
+// Ledger records every change of the stock as the immutable movement within the unit of work of the change.
+type Ledger struct {
+	log        *zap.SugaredLogger
+	repository data.StorageRepository
+}
+
+func NewLedger(log *zap.SugaredLogger, repository data.StorageRepository) *Ledger {
+	l := new(Ledger)
+	l.log = log
+	l.repository = repository
+
+	return l
+}
+
+func (l *Ledger) History(id primitive.ObjectID, request *requests.PageRequest) ([]models.StockMovement, error) {
+	if _, err := findProduct(l.repository, id); err != nil {
+		return nil, err
+	}
+
+	return l.repository.ListMovements(id, int64(request.Page*request.Size), int64(request.Size))
+}
+
+// Reconcile reads the products and the movements in the same unit of work, so the stock changed concurrently
+// is not reported as the drift.
+func (l *Ledger) Reconcile(ctx context.Context) ([]models.StockDrift, error) {
+	drifts := make([]models.StockDrift, 0)
+	err := data.WithUnitOfWork(ctx, l.repository, func(uow data.StorageRepository) error {
+		products, err := uow.ListProducts()
+		if err != nil {
+			return err
+		}
+
+		totals, err := uow.MovementTotals()
+		if err != nil {
+			return err
+		}
+
+		for _, p := range products {
+			if total := totals[p.Id]; total != p.Quantity {
+				drifts = append(drifts, models.StockDrift{
+					ProductId: p.Id,
+					Sku:       p.Sku,
+					Quantity:  p.Quantity,
+					Movements: total,
+					Drift:     p.Quantity - total,
+				})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, d := range drifts {
+		telemetry.Logger(ctx, l.log).Warnw("stock drift", "product", d.ProductId.Hex(), "sku", d.Sku,
+			"quantity", d.Quantity, "movements", d.Movements)
+	}
+
+	return drifts, nil
+}
+
+// Open records the opening movement of each product without movements, so the stock added before the ledger
+// was introduced is reconciled. Returns number of the opened products.
+func (l *Ledger) Open(ctx context.Context) (int, error) {
+	opened := 0
+	err := data.WithUnitOfWork(ctx, l.repository, func(uow data.StorageRepository) error {
+		products, err := uow.ListProducts()
+		if err != nil {
+			return err
+		}
+
+		totals, err := uow.MovementTotals()
+		if err != nil {
+			return err
+		}
+
+		for _, p := range products {
+			if _, ok := totals[p.Id]; ok {
+				continue
+			}
+
+			movement := NewMovement(&p, models.OpeningMovement, p.Quantity)
+			if err = uow.InsertMovement(movement); err != nil {
+				return err
+			}
+			opened++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return opened, nil
+}
+
+// NewMovement returns the movement of the product which stock is already changed by the delta.
+func NewMovement(product *models.Product, t models.MovementType, delta int64) *models.StockMovement {
+	return &models.StockMovement{
+		ProductId: product.Id,
+		Sku:       product.Sku,
+		Type:      t,
+		Delta:     delta,
+		Quantity:  product.Quantity,
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// moveStock changes stock of the product by the delta and records the movement in the same unit of work.
+// Order and reservation of the movement are set by the caller through fn.
+func moveStock(uow data.StorageRepository, sku string, t models.MovementType, delta int64, fn func(m *models.StockMovement)) (*models.Product, error) {
+	product, err := uow.AddProductQuantity(sku, delta)
+	if err != nil {
+		return nil, err
+	}
+
+	movement := NewMovement(product, t, delta)
+	if fn != nil {
+		fn(movement)
+	}
+
+	return product, uow.InsertMovement(movement)
+}
+
+// reservationMovement refers the movement to the reservation and its order.
+func reservationMovement(reservation *models.OrderReservation) func(m *models.StockMovement) {
+	return func(m *models.StockMovement) {
+		m.OrderId = reservation.OrderId
+		m.ReservationId = reservation.Id
+	}
+}
@@ -0,0 +1,64 @@
+package core
+
+import (
+	"context"
+	"eCommerce/contracts"
+	"eCommerce/storage/internal/models"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+// Stock changed by the saga is reconciled with its movements, stock changed without the movement is the drift.
+func TestLedgerReconcile(t *testing.T) {
+	repository := newTestRepository(t)
+	storage := NewStorage(zap.NewNop().Sugar(), repository, new(recordingPublisher), contracts.JSONCodec{}, time.Hour)
+	ledger := NewLedger(zap.NewNop().Sugar(), repository)
+
+	reserveOrder(t, storage, []contracts.OrderProduct{{Sku: "apple", Quantity: 2}, {Sku: "pear", Quantity: 1}})
+	if _, err := storage.ExpireReservations(context.Background(), time.Now().UTC().Add(2*time.Hour), 10); err != nil {
+		t.Fatal(err)
+	}
+
+	drifts, err := ledger.Reconcile(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drifts) != 0 {
+		t.Fatalf("drifts %+v, want none", drifts)
+	}
+
+	if _, err = repository.AddProductQuantity("pear", -2); err != nil {
+		t.Fatal(err)
+	}
+
+	drifts, err = ledger.Reconcile(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drifts) != 1 || drifts[0].Sku != "pear" || drifts[0].Quantity != 1 || drifts[0].Movements != 3 || drifts[0].Drift != -2 {
+		t.Errorf("drifts %+v, want -2 pears", drifts)
+	}
+}
+
+// Products without movements are opened once, so the stock added before the ledger is not reported as the drift.
+func TestLedgerOpen(t *testing.T) {
+	repository := newTestRepository(t)
+	ledger := NewLedger(zap.NewNop().Sugar(), repository)
+
+	if opened, err := ledger.Open(context.Background()); err != nil || opened != 0 {
+		t.Fatalf("opened %d products again, err %v", opened, err)
+	}
+
+	apple, err := repository.FindProduct("apple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	movements, err := repository.ListMovements(apple.Id, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(movements) != 1 || movements[0].Type != models.OpeningMovement || movements[0].Delta != 5 {
+		t.Errorf("movements %+v, want opening of 5 apples", movements)
+	}
+}
@@ -0,0 +1,212 @@
+package core
+
+import (
+	"context"
+	"eCommerce/contracts"
+	"eCommerce/storage/internal/data"
+	"eCommerce/storage/internal/models"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+	"os"
+	"testing"
+	"time"
+)
+
+// benchmarkMongoUrl names the variable with the url of the mongodb replica set. Reservations are benchmarked
+// against the database storage_benchmark, which is dropped, when the variable is set.
+const benchmarkMongoUrl = `STORAGE_BENCHMARK_MONGO_URL`
+
+// roundTrip is the latency added to each query of the in-memory repository to model the database round trip.
+const roundTrip = 200 * time.Microsecond
+
+// BenchmarkReserveOrder compares the reservation checking and deducting each order line by its own queries with
+// the batched reservation. Unit of work is rolled back after each reservation, so the stock is never exhausted.
+//
+//	go test ./internal/core -run '^$' -bench ReserveOrder
+func BenchmarkReserveOrder(b *testing.B) {
+	repositories := []struct {
+		name string
+		open func(b *testing.B) data.StorageRepository
+	}{
+		{name: "memory", open: func(b *testing.B) data.StorageRepository {
+			return data.NewMemoryStorageRepository()
+		}},
+		{name: "round-trip", open: func(b *testing.B) data.StorageRepository {
+			return &roundTripRepository{StorageRepository: data.NewMemoryStorageRepository(), delay: roundTrip}
+		}},
+		{name: "mongo", open: openMongo},
+	}
+	approaches := []struct {
+		name    string
+		reserve func(s *Storage, order *contracts.Order) func(uow data.StorageRepository) error
+	}{
+		{name: "per-line", reserve: reservePerLine},
+		{name: "batched", reserve: func(s *Storage, order *contracts.Order) func(uow data.StorageRepository) error {
+			return s.ReserveOrderTx(order)
+		}},
+	}
+
+	for _, r := range repositories {
+		b.Run(r.name, func(b *testing.B) {
+			repository := r.open(b)
+			storage := NewStorage(zap.NewNop().Sugar(), repository, nil, nil, time.Hour)
+
+			for _, lines := range []int{1, 10, 100} {
+				order := benchmarkOrder(b, repository, lines)
+
+				for _, a := range approaches {
+					b.Run(fmt.Sprintf("%s/lines=%d", a.name, lines), func(b *testing.B) {
+						for i := 0; i < b.N; i++ {
+							order.Id = primitive.NewObjectID()
+							if err := rollback(repository, a.reserve(storage, order)); err != nil {
+								b.Fatal(err)
+							}
+						}
+					})
+				}
+			}
+		})
+	}
+}
+
+// reservePerLine reserves the order the way it was reserved before the batching: each line is checked
+// and deducted by its own queries.
+func reservePerLine(s *Storage, order *contracts.Order) func(uow data.StorageRepository) error {
+	return func(uow data.StorageRepository) error {
+		order.Amount = 0
+		for _, p := range order.Items {
+			product, err := uow.FindProduct(p.Sku)
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return ErrOutOfStock
+			}
+			if err != nil {
+				return err
+			}
+			if product.Archived || product.Quantity < p.Quantity {
+				return ErrOutOfStock
+			}
+
+			order.Amount += product.Cost * float64(p.Quantity)
+		}
+
+		reservation := models.OrderToReservation(order, time.Now().UTC().Add(s.hold))
+		if _, err := uow.InsertReservation(reservation); err != nil {
+			return err
+		}
+
+		for _, p := range reservation.Products {
+			if _, err := moveStock(uow, p.Sku, models.ReservationMovement, -p.Quantity, reservationMovement(reservation)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// rollback runs fn within the unit of work and discards its changes.
+func rollback(repository data.StorageRepository, fn func(uow data.StorageRepository) error) error {
+	uow, err := repository.Begin(context.Background())
+	if err != nil {
+		return err
+	}
+	defer uow.Rollback()
+
+	return fn(uow)
+}
+
+// benchmarkOrder adds products of the order lines to the stock and returns the order of one of each product.
+func benchmarkOrder(b *testing.B, repository data.StorageRepository, lines int) *contracts.Order {
+	order := &contracts.Order{UserId: primitive.NewObjectID()}
+	for i := 0; i < lines; i++ {
+		sku := fmt.Sprintf("bench-%d-%d", lines, i)
+		product := &models.Product{Sku: sku, Name: sku, Cost: 1, Quantity: 1000}
+		if _, err := repository.InsertProduct(product); err != nil {
+			b.Fatal(err)
+		}
+
+		order.Items = append(order.Items, contracts.OrderProduct{Sku: sku, Quantity: 1})
+	}
+
+	return order
+}
+
+func openMongo(b *testing.B) data.StorageRepository {
+	url := os.Getenv(benchmarkMongoUrl)
+	if url == "" {
+		b.Skipf("%s is not set", benchmarkMongoUrl)
+	}
+
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(url))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { _ = client.Disconnect(ctx) })
+
+	db := client.Database(`storage_benchmark`)
+	if err = db.Drop(ctx); err != nil {
+		b.Fatal(err)
+	}
+
+	repository := data.NewMongoStorageRepository(db)
+	if err = repository.EnsureIndexes(ctx); err != nil {
+		b.Fatal(err)
+	}
+
+	return repository
+}
+
+// roundTripRepository delays the queries made by the reservation as the database round trip.
+type roundTripRepository struct {
+	data.StorageRepository
+	delay time.Duration
+}
+
+func (r *roundTripRepository) FindProduct(sku string) (*models.Product, error) {
+	time.Sleep(r.delay)
+	return r.StorageRepository.FindProduct(sku)
+}
+
+func (r *roundTripRepository) FindProducts(skus []string) ([]models.Product, error) {
+	time.Sleep(r.delay)
+	return r.StorageRepository.FindProducts(skus)
+}
+
+func (r *roundTripRepository) AddProductQuantity(sku string, delta int64) (*models.Product, error) {
+	time.Sleep(r.delay)
+	return r.StorageRepository.AddProductQuantity(sku, delta)
+}
+
+func (r *roundTripRepository) DeductStock(lines []models.ProductReservation) (int64, error) {
+	time.Sleep(r.delay)
+	return r.StorageRepository.DeductStock(lines)
+}
+
+func (r *roundTripRepository) InsertReservation(reservation *models.OrderReservation) (*models.OrderReservation, error) {
+	time.Sleep(r.delay)
+	return r.StorageRepository.InsertReservation(reservation)
+}
+
+func (r *roundTripRepository) InsertMovement(movement *models.StockMovement) error {
+	time.Sleep(r.delay)
+	return r.StorageRepository.InsertMovement(movement)
+}
+
+func (r *roundTripRepository) InsertMovements(movements []*models.StockMovement) error {
+	time.Sleep(r.delay)
+	return r.StorageRepository.InsertMovements(movements)
+}
+
+func (r *roundTripRepository) Begin(ctx context.Context) (data.StorageRepository, error) {
+	uow, err := r.StorageRepository.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &roundTripRepository{StorageRepository: uow, delay: r.delay}, nil
+}
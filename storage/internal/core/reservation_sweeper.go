@@ -0,0 +1,71 @@
+package core
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"time"
+)
+
+type ReservationSweeperConfig struct {
+	// Interval between scans of the expired reservations.
+	Interval time.Duration
+	// BatchSize is the maximum number of reservations released by a single scan.
+	BatchSize int64
+}
+
+// ReservationSweeper releases held reservations which are not committed in time. Products of the expired
+// reservation are returned to the stock and the event is published, so the registry fails the order.
+type ReservationSweeper struct {
+	log     *zap.SugaredLogger
+	cfg     ReservationSweeperConfig
+	storage *Storage
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewReservationSweeper(log *zap.SugaredLogger, storage *Storage, cfg ReservationSweeperConfig) *ReservationSweeper {
+	s := new(ReservationSweeper)
+	s.log = log
+	s.cfg = cfg
+	s.storage = storage
+
+	return s
+}
+
+func (s *ReservationSweeper) Start(ctx context.Context) {
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(s.cfg.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.Scan(ctx, time.Now().UTC())
+			}
+		}
+	}()
+}
+
+func (s *ReservationSweeper) Stop() {
+	if s.cancel == nil {
+		return
+	}
+
+	s.cancel()
+	<-s.done
+}
+
+// Scan releases reservations which expire before the time.
+func (s *ReservationSweeper) Scan(ctx context.Context, now time.Time) {
+	if _, err := s.storage.ExpireReservations(ctx, now, s.cfg.BatchSize); err != nil {
+		s.log.Errorw("sweeper failed to release expired reservations", "err", err)
+	}
+}
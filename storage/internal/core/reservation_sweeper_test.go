@@ -0,0 +1,77 @@
+package core
+
+import (
+	"context"
+	"eCommerce/contracts"
+	"eCommerce/storage/internal/models"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+// Scan releases held reservations which are expired, committed reservations are kept. Event about the expired
+// reservation is published once.
+func TestReservationSweeperScan(t *testing.T) {
+	repository, publisher := newTestRepository(t), new(recordingPublisher)
+	storage := NewStorage(zap.NewNop().Sugar(), repository, publisher, contracts.JSONCodec{}, time.Hour)
+	sweeper := NewReservationSweeper(zap.NewNop().Sugar(), storage, ReservationSweeperConfig{BatchSize: 10})
+
+	held := reserveOrder(t, storage, []contracts.OrderProduct{{Sku: "apple", Quantity: 2}})
+	committed := reserveOrder(t, storage, []contracts.OrderProduct{{Sku: "pear", Quantity: 1}})
+	command, err := contracts.NewEnvelope(contracts.CommitReservation, committed.Id.Hex(), committed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = storage.CommitOrder(context.Background(), command, committed); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reservations are not expired yet.
+	sweeper.Scan(context.Background(), time.Now().UTC())
+	if events := publisher.published(contracts.StorageReservationExpiredTopic); len(events) != 0 {
+		t.Fatalf("%d reservations expired before the hold is over", len(events))
+	}
+
+	for i := 0; i < 2; i++ {
+		sweeper.Scan(context.Background(), time.Now().UTC().Add(2*time.Hour))
+	}
+
+	events := publisher.published(contracts.StorageReservationExpiredTopic)
+	if len(events) != 1 || string(events[0].Key) != held.Id.Hex() {
+		t.Fatalf("%d expired reservations are published, want the reservation of %s", len(events), held.Id.Hex())
+	}
+	if _, err = repository.FindReservation(held.Id, models.Expired); err != nil {
+		t.Errorf("reservation of the held order: %v", err)
+	}
+	if _, err = repository.FindReservation(committed.Id, models.Committed); err != nil {
+		t.Errorf("reservation of the committed order: %v", err)
+	}
+
+	assertQuantity(t, repository, "apple", 5)
+	assertQuantity(t, repository, "pear", 2)
+}
+
+// Commit of the expired reservation is rejected, so the paid order is refunded.
+func TestCommitExpiredReservation(t *testing.T) {
+	repository, publisher := newTestRepository(t), new(recordingPublisher)
+	storage := NewStorage(zap.NewNop().Sugar(), repository, publisher, contracts.JSONCodec{}, time.Hour)
+
+	order := reserveOrder(t, storage, []contracts.OrderProduct{{Sku: "apple", Quantity: 2}})
+	if released, err := storage.ExpireReservations(context.Background(), time.Now().UTC().Add(2*time.Hour), 10); err != nil || released != 1 {
+		t.Fatalf("released %d reservations, err %v", released, err)
+	}
+
+	command, err := contracts.NewEnvelope(contracts.CommitReservation, order.Id.Hex(), order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = storage.CommitOrder(context.Background(), command, order); err != nil {
+		t.Fatal(err)
+	}
+
+	response := publisher.last(t, contracts.StorageCommitOrderResponseTopic)
+	if message, _ := response.Header(contracts.HeaderMessage); string(message) != ErrReservationExpired.Error() {
+		t.Errorf("commit response %q, want %q", message, ErrReservationExpired)
+	}
+	assertQuantity(t, repository, "apple", 5)
+}
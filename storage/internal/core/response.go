@@ -1,29 +1,72 @@
 package core
 
-import "encoding/json"
+import (
+	"eCommerce/contracts"
+	"eCommerce/storage/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
 
+// Response is the encoded result event of the command. Content type names the codec of the payload.
 type Response struct {
-	IsSuccess bool
-	Message   string
-	Payload   interface{}
+	IsSuccess   bool
+	Message     string
+	ContentType string
+	Payload     []byte
 }
 
-func NewSuccess(message string, payload interface{}) *Response {
-	return &Response{IsSuccess: true, Message: message, Payload: payload}
+func NewSuccess(message, contentType string, payload []byte) *Response {
+	return &Response{IsSuccess: true, Message: message, ContentType: contentType, Payload: payload}
 }
 
-func NewError(err error, payload interface{}) *Response {
-	return &Response{IsSuccess: false, Message: err.Error(), Payload: payload}
+func NewError(err error, contentType string, payload []byte) *Response {
+	return &Response{IsSuccess: false, Message: err.Error(), ContentType: contentType, Payload: payload}
 }
 
-func (r *Response) Marshal() ([]byte, error) {
-	return json.Marshal(r.Payload)
+// NewResult returns the response to the command. Payload of the response is the result event caused by the command
+// encoded with the codec, the error means the command was rejected.
+func NewResult(codec contracts.Codec, command *contracts.Envelope, t contracts.EventType, order *contracts.Order, message string, rejection error) (*Response, error) {
+	result, err := contracts.NewCausedEnvelope(command, t, order)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := codec.Encode(result)
+	if err != nil {
+		return nil, err
+	}
+
+	if rejection != nil {
+		return NewError(rejection, codec.ContentType(), payload), nil
+	}
+
+	return NewSuccess(message, codec.ContentType(), payload), nil
 }
 
 func (r *Response) StatusHeader() []byte {
-	if r.IsSuccess {
-		return []byte{1}
+	return contracts.StatusHeader(r.IsSuccess)
+}
+
+// NewInboxMessage records the response to the processed command of the order.
+func NewInboxMessage(orderId primitive.ObjectID, command models.Command, response *Response) *models.InboxMessage {
+	return &models.InboxMessage{
+		OrderId:     orderId,
+		Command:     command,
+		IsSuccess:   response.IsSuccess,
+		Message:     response.Message,
+		ContentType: response.ContentType,
+		Payload:     response.Payload,
+		ProcessedAt: time.Now().UTC(),
+	}
+}
+
+// InboxResponse restores the response recorded for the processed command.
+// Responses recorded before codecs were introduced are JSON.
+func InboxResponse(message *models.InboxMessage) *Response {
+	contentType := message.ContentType
+	if contentType == "" {
+		contentType = contracts.ContentTypeJSON
 	}
 
-	return []byte{0}
+	return &Response{IsSuccess: message.IsSuccess, Message: message.Message, ContentType: contentType, Payload: message.Payload}
 }
@@ -0,0 +1,107 @@
+package core
+
+import (
+	"eCommerce/contracts"
+	"eCommerce/storage/internal/data"
+	"eCommerce/storage/internal/models"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Shortage is the line of the order which product is out of stock.
+type Shortage struct {
+	Sku       string
+	Requested int64
+	// Available is the quantity of the product in stock, it is zero for unknown and archived products.
+	Available int64
+}
+
+// StockError reports every line of the order which product is out of stock.
+type StockError struct {
+	Shortages []Shortage
+	Err       error
+}
+
+func (e *StockError) Error() string {
+	lines := make([]string, len(e.Shortages))
+	for i, s := range e.Shortages {
+		lines[i] = fmt.Sprintf("%s (requested %d, available %d)", s.Sku, s.Requested, s.Available)
+	}
+
+	return fmt.Sprintf("%s: %s", e.Err, strings.Join(lines, ", "))
+}
+
+func (e *StockError) Unwrap() error {
+	return e.Err
+}
+
+// orderLines returns lines of the order ordered by SKU. Returns ErrInvalidOrderLine when the quantity of the line
+// is not positive or the SKU is repeated in the order.
+func orderLines(order *contracts.Order) ([]models.ProductReservation, error) {
+	seen := make(map[string]struct{}, len(order.Items))
+	lines := make([]models.ProductReservation, 0, len(order.Items))
+	for _, item := range order.Items {
+		if item.Quantity <= 0 {
+			return nil, fmt.Errorf("%w: %s quantity %d", ErrInvalidOrderLine, item.Sku, item.Quantity)
+		}
+		if _, ok := seen[item.Sku]; ok {
+			return nil, fmt.Errorf("%w: %s is repeated", ErrInvalidOrderLine, item.Sku)
+		}
+		seen[item.Sku] = struct{}{}
+
+		lines = append(lines, models.ProductReservation{Sku: item.Sku, Quantity: item.Quantity})
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		return lines[i].Sku < lines[j].Sku
+	})
+
+	return lines, nil
+}
+
+// findStock reads products of the lines with a single query. Returns StockError with every line which product
+// is unknown, archived or has less than the quantity of the line in stock.
+func findStock(uow data.StorageRepository, lines []models.ProductReservation) (map[string]models.Product, error) {
+	skus := make([]string, len(lines))
+	for i, line := range lines {
+		skus[i] = line.Sku
+	}
+
+	found, err := uow.FindProducts(skus)
+	if err != nil {
+		return nil, err
+	}
+
+	products := make(map[string]models.Product, len(found))
+	for _, p := range found {
+		products[p.Sku] = p
+	}
+
+	var shortages []Shortage
+	for _, line := range lines {
+		p, ok := products[line.Sku]
+		if ok && !p.Archived && p.Quantity >= line.Quantity {
+			continue
+		}
+
+		shortage := Shortage{Sku: line.Sku, Requested: line.Quantity}
+		if ok && !p.Archived {
+			shortage.Available = p.Quantity
+		}
+		shortages = append(shortages, shortage)
+	}
+	if len(shortages) > 0 {
+		return nil, &StockError{Shortages: shortages, Err: ErrOutOfStock}
+	}
+
+	return products, nil
+}
+
+// orderAmount returns cost of the order at the current cost of the products.
+func orderAmount(order *contracts.Order, products map[string]models.Product) (amount float64) {
+	for _, item := range order.Items {
+		amount += products[item.Sku].Cost * float64(item.Quantity)
+	}
+
+	return amount
+}
@@ -0,0 +1,245 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"eCommerce/contracts"
+	"eCommerce/storage/internal/data"
+	"eCommerce/storage/internal/models"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Order is rejected listing every line which product is out of stock.
+func TestReserveOrderShortLines(t *testing.T) {
+	repository, publisher := newTestRepository(t), new(recordingPublisher)
+	storage := NewStorage(zap.NewNop().Sugar(), repository, publisher, contracts.JSONCodec{}, time.Hour)
+
+	tests := []struct {
+		name  string
+		items []contracts.OrderProduct
+		lines string
+	}{
+		{
+			name:  "unknown and short",
+			items: []contracts.OrderProduct{{Sku: "apple", Quantity: 2}, {Sku: "pear", Quantity: 4}, {Sku: "kiwi", Quantity: 1}},
+			lines: "kiwi (requested 1, available 0), pear (requested 4, available 3)",
+		},
+		{
+			name:  "archived",
+			items: []contracts.OrderProduct{{Sku: "plum", Quantity: 1}},
+			lines: "plum (requested 1, available 0)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reserveOrder(t, storage, tt.items)
+
+			response := publisher.last(t, contracts.StorageReserveOrderResponseTopic)
+			if status, _ := response.Header(contracts.HeaderStatus); contracts.IsSuccessStatus(status) {
+				t.Fatal("order is reserved, want rejection")
+			}
+			if message, _ := response.Header(contracts.HeaderMessage); !strings.HasSuffix(string(message), tt.lines) {
+				t.Errorf("rejection %q, want the lines %s", message, tt.lines)
+			}
+
+			assertQuantity(t, repository, "apple", 5)
+			assertQuantity(t, repository, "pear", 3)
+		})
+	}
+}
+
+func TestReserveOrderDeductsLines(t *testing.T) {
+	repository, publisher := newTestRepository(t), new(recordingPublisher)
+	storage := NewStorage(zap.NewNop().Sugar(), repository, publisher, contracts.JSONCodec{}, time.Hour)
+
+	order := reserveOrder(t, storage, []contracts.OrderProduct{{Sku: "pear", Quantity: 1}, {Sku: "apple", Quantity: 5}})
+
+	response := publisher.last(t, contracts.StorageReserveOrderResponseTopic)
+	if status, _ := response.Header(contracts.HeaderStatus); !contracts.IsSuccessStatus(status) {
+		message, _ := response.Header(contracts.HeaderMessage)
+		t.Fatalf("order is rejected: %s", message)
+	}
+
+	assertQuantity(t, repository, "apple", 0)
+	assertQuantity(t, repository, "pear", 2)
+
+	reservation, err := repository.FindReservation(order.Id, models.Held)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Amount != 7 || len(reservation.Products) != 2 {
+		t.Errorf("reservation of %v with %d lines, want 7 with 2 lines", order.Amount, len(reservation.Products))
+	}
+}
+
+// Order with the line which quantity is not positive or with the repeated SKU is malformed. It is failed without
+// the response, changes of the stock and the record in the inbox.
+func TestReserveOrderInvalidLines(t *testing.T) {
+	repository, publisher := newTestRepository(t), new(recordingPublisher)
+	storage := NewStorage(zap.NewNop().Sugar(), repository, publisher, contracts.JSONCodec{}, time.Hour)
+
+	tests := []struct {
+		name  string
+		items []contracts.OrderProduct
+	}{
+		{name: "zero", items: []contracts.OrderProduct{{Sku: "apple", Quantity: 1}, {Sku: "pear", Quantity: 0}}},
+		{name: "negative", items: []contracts.OrderProduct{{Sku: "apple", Quantity: 1}, {Sku: "pear", Quantity: -2}}},
+		{name: "repeated sku", items: []contracts.OrderProduct{{Sku: "apple", Quantity: 1}, {Sku: "pear", Quantity: 1}, {Sku: "apple", Quantity: 1}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order := &contracts.Order{Id: primitive.NewObjectID(), UserId: primitive.NewObjectID(), Items: tt.items}
+			command, err := contracts.NewEnvelope(contracts.ReserveOrder, order.Id.Hex(), order)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err = storage.ReserveOrder(context.Background(), command, order); !errors.Is(err, ErrInvalidOrderLine) {
+				t.Fatalf("reserve: %v, want %v", err, ErrInvalidOrderLine)
+			}
+			if _, err = repository.FindInboxMessage(order.Id, models.ReserveOrderCommand); !errors.Is(err, mongo.ErrNoDocuments) {
+				t.Errorf("inbox message of the invalid order: %v", err)
+			}
+		})
+	}
+
+	if published := publisher.published(contracts.StorageReserveOrderResponseTopic); len(published) != 0 {
+		t.Errorf("%d responses are published for the invalid orders", len(published))
+	}
+	assertQuantity(t, repository, "apple", 5)
+	assertQuantity(t, repository, "pear", 3)
+}
+
+// Redelivered command is answered with the recorded response, the stock is deducted once.
+func TestReserveOrderRedelivered(t *testing.T) {
+	repository, publisher := newTestRepository(t), new(recordingPublisher)
+	storage := NewStorage(zap.NewNop().Sugar(), repository, publisher, contracts.JSONCodec{}, time.Hour)
+
+	order := &contracts.Order{
+		Id:     primitive.NewObjectID(),
+		UserId: primitive.NewObjectID(),
+		Items:  []contracts.OrderProduct{{Sku: "apple", Quantity: 2}, {Sku: "pear", Quantity: 1}},
+	}
+	command, err := contracts.NewEnvelope(contracts.ReserveOrder, order.Id.Hex(), order)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err = storage.ReserveOrder(context.Background(), command, order); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	responses := publisher.published(contracts.StorageReserveOrderResponseTopic)
+	if len(responses) != 2 {
+		t.Fatalf("%d responses are published, want 2", len(responses))
+	}
+	if status, _ := responses[0].Header(contracts.HeaderStatus); !contracts.IsSuccessStatus(status) {
+		message, _ := responses[0].Header(contracts.HeaderMessage)
+		t.Fatalf("order is rejected: %s", message)
+	}
+	if !bytes.Equal(responses[0].Value, responses[1].Value) {
+		t.Errorf("replayed response %s, want %s", responses[1].Value, responses[0].Value)
+	}
+	for _, key := range []string{contracts.HeaderContentType, contracts.HeaderStatus, contracts.HeaderMessage} {
+		want, _ := responses[0].Header(key)
+		if got, _ := responses[1].Header(key); !bytes.Equal(got, want) {
+			t.Errorf("header %s of the replayed response %q, want %q", key, got, want)
+		}
+	}
+
+	assertQuantity(t, repository, "apple", 3)
+	assertQuantity(t, repository, "pear", 2)
+
+	apple, err := repository.FindProduct("apple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	movements, err := repository.ListMovements(apple.Id, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(movements) != 2 {
+		t.Errorf("%d movements of apples, want opening and the reservation", len(movements))
+	}
+}
+
+// Line taken from the stock after it is read is not deducted. Reservation is rolled back with the deducted lines,
+// so the retried command rejects the order with the short line.
+func TestReserveOrderStockChanged(t *testing.T) {
+	repository, publisher := newTestRepository(t), new(recordingPublisher)
+	changed := &changedStock{StorageRepository: repository, sku: "pear", quantity: 2}
+	storage := NewStorage(zap.NewNop().Sugar(), changed, publisher, contracts.JSONCodec{}, time.Hour)
+
+	order := &contracts.Order{
+		Id:     primitive.NewObjectID(),
+		UserId: primitive.NewObjectID(),
+		Items:  []contracts.OrderProduct{{Sku: "apple", Quantity: 2}, {Sku: "pear", Quantity: 2}},
+	}
+	command, err := contracts.NewEnvelope(contracts.ReserveOrder, order.Id.Hex(), order)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = storage.ReserveOrder(context.Background(), command, order); !errors.Is(err, ErrStockChanged) {
+		t.Fatalf("reserve: %v, want %v", err, ErrStockChanged)
+	}
+	if published := publisher.published(contracts.StorageReserveOrderResponseTopic); len(published) != 0 {
+		t.Fatalf("%d responses are published for the rolled back reservation", len(published))
+	}
+	if _, err = repository.FindReservation(order.Id, models.Held); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("reservation of the rolled back order: %v", err)
+	}
+	if _, err = repository.FindInboxMessage(order.Id, models.ReserveOrderCommand); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("inbox message of the rolled back order: %v", err)
+	}
+	assertQuantity(t, repository, "apple", 5)
+
+	// Stock is taken by the concurrent reservation committed in the meantime.
+	if _, err = repository.AddProductQuantity("pear", -changed.quantity); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = storage.ReserveOrder(context.Background(), command, order); err != nil {
+		t.Fatal(err)
+	}
+	response := publisher.last(t, contracts.StorageReserveOrderResponseTopic)
+	if message, _ := response.Header(contracts.HeaderMessage); !strings.HasSuffix(string(message), "pear (requested 2, available 1)") {
+		t.Errorf("rejection %q, want the short pear", message)
+	}
+	assertQuantity(t, repository, "apple", 5)
+}
+
+// changedStock takes the quantity of the product from the stock of the unit of work right before the stock
+// is deducted, as if the concurrent reservation took it after the stock was read.
+type changedStock struct {
+	data.StorageRepository
+	sku      string
+	quantity int64
+}
+
+func (r *changedStock) Begin(ctx context.Context) (data.StorageRepository, error) {
+	uow, err := r.StorageRepository.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &changedStock{StorageRepository: uow, sku: r.sku, quantity: r.quantity}, nil
+}
+
+func (r *changedStock) DeductStock(lines []models.ProductReservation) (int64, error) {
+	if _, err := r.StorageRepository.AddProductQuantity(r.sku, -r.quantity); err != nil {
+		return 0, err
+	}
+
+	return r.StorageRepository.DeductStock(lines)
+}
@@ -2,158 +2,245 @@ package core
 
 import (
 	"context"
+	"eCommerce/contracts"
+	"eCommerce/messaging"
+	"eCommerce/storage/internal/data"
 	"eCommerce/storage/internal/models"
+	"eCommerce/telemetry"
 	"errors"
-	"github.com/segmentio/kafka-go"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
-	"go.mongodb.org/mongo-driver/mongo/readconcern"
-	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 	"go.uber.org/zap"
-)
-
-const (
-	StorageReserveOrderResponseTopic = `storage-reserve-order-response`
-	StorageCancelOrderResponseTopic  = `storage-cancel-order-response`
+	"time"
 )
 
 var (
-	wc        = writeconcern.New(writeconcern.WMajority())
-	rc        = readconcern.Snapshot()
-	txOptions = options.Transaction().SetWriteConcern(wc).SetReadConcern(rc)
+	ErrOutOfStock         = errors.New(`one of the products out of stock`)
+	ErrNotReserved        = errors.New(`out of order`)
+	ErrReservationExpired = errors.New(`reservation of the order is expired`)
+	// ErrStockChanged is returned when the stock read by the reservation is taken concurrently before it is
+	// deducted. It is not the rejection, so the reservation is rolled back and the command is retried.
+	ErrStockChanged = errors.New(`stock of the products is changed concurrently`)
+	// ErrInvalidOrderLine is returned for the line with the quantity which is not positive or the repeated SKU.
+	// Registry does not send such lines, so the command is malformed and is not retried.
+	ErrInvalidOrderLine = errors.New(`order line is invalid`)
 )
 
+// activeStatuses are statuses of the reservations which products are taken from the stock.
+var activeStatuses = []models.ReservationStatus{models.Held, models.Committed, models.Success}
+
 type StorageService interface {
-	ReserveOrder(order *models.Order) error
-	CancelOrder(order *models.Order) error
+	ReserveOrder(ctx context.Context, command *contracts.Envelope, order *contracts.Order) error
+	CancelOrder(ctx context.Context, command *contracts.Envelope, order *contracts.Order) error
+	CommitOrder(ctx context.Context, command *contracts.Envelope, order *contracts.Order) error
 }
 
 type Storage struct {
-	log          *zap.SugaredLogger
-	products     *mongo.Collection
-	reservations *mongo.Collection
-	producer     *kafka.Writer
+	log        *zap.SugaredLogger
+	repository data.StorageRepository
+	producer   messaging.Publisher
+	codec      contracts.Codec
+	// hold is the time the reservation is kept until it is committed.
+	hold time.Duration
 }
 
-func NewStorage(log *zap.SugaredLogger, database *mongo.Database, producer *kafka.Writer) *Storage {
+// NewStorage returns the storage which publishes results of the commands encoded with the codec.
+// Reservations are held for the hold duration, reservations which are not committed in time are expired.
+func NewStorage(log *zap.SugaredLogger, repository data.StorageRepository, producer messaging.Publisher, codec contracts.Codec, hold time.Duration) *Storage {
 	storage := new(Storage)
 	storage.log = log
-	storage.products = database.Collection(`products`)
-	storage.reservations = database.Collection(`reservations`)
+	storage.repository = repository
 	storage.producer = producer
+	storage.codec = codec
+	storage.hold = hold
 
 	return storage
 }
 
-func (s Storage) ReserveOrder(order *models.Order) error {
-	response := new(Response)
-	defer func() {
-		message, err := response.Marshal()
-		if err != nil {
-			message = []byte(`marshaling error`)
+// ReserveOrder reserves products of the order and publishes the result. Rejected reservation is a result too,
+// so only failures of the repository or the bus and the concurrently changed stock are returned to be retried.
+// Invalid lines of the order are returned as ErrInvalidOrderLine.
+func (s Storage) ReserveOrder(ctx context.Context, command *contracts.Envelope, order *contracts.Order) error {
+	response, err := s.process(ctx, order, models.ReserveOrderCommand, func(uow data.StorageRepository) (*Response, error) {
+		err := s.ReserveOrderTx(order)(uow)
+		switch {
+		case errors.Is(err, ErrOutOfStock):
+			return NewResult(s.codec, command, contracts.ReserveOrderResult, order, "", err)
+		case err != nil:
+			return nil, err
 		}
 
-		err = s.producer.WriteMessages(context.Background(), kafka.Message{
-			Key:   []byte(order.Id.Hex()),
-			Value: message,
-			Topic: StorageReserveOrderResponseTopic,
-			Headers: []kafka.Header{
-				{Key: `status`, Value: response.StatusHeader()},
-				{Key: `message`, Value: []byte(response.Message)},
-			},
-		})
-		if err != nil {
-			s.log.Error(err)
+		return NewResult(s.codec, command, contracts.ReserveOrderResult, order, "reserved order", nil)
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.respond(ctx, contracts.StorageReserveOrderResponseTopic, order, response)
+}
+
+// CancelOrder returns reserved products of the order to the stock and publishes the result.
+func (s Storage) CancelOrder(ctx context.Context, command *contracts.Envelope, order *contracts.Order) error {
+	response, err := s.process(ctx, order, models.CancelOrderCommand, func(uow data.StorageRepository) (*Response, error) {
+		err := s.CancelOrderTx(order)(uow)
+		switch {
+		case errors.Is(err, ErrNotReserved):
+			return NewResult(s.codec, command, contracts.CancelReservationResult, order, "", err)
+		case err != nil:
+			return nil, err
 		}
-	}()
 
-	dbSession, err := s.products.Database().Client().StartSession()
+		return NewResult(s.codec, command, contracts.CancelReservationResult, order, "canceled order", nil)
+	})
 	if err != nil {
-		response = NewError(err, order)
 		return err
 	}
-	defer dbSession.EndSession(context.Background())
 
-	err = mongo.WithSession(context.Background(), dbSession, s.ReserveOrderTx(dbSession, order))
+	return s.respond(ctx, contracts.StorageCancelOrderResponseTopic, order, response)
+}
+
+// CommitOrder turns the held reservation of the paid order into the permanent deduction and publishes the result.
+// Commit is rejected when the reservation is expired, so the order has to be refunded.
+func (s Storage) CommitOrder(ctx context.Context, command *contracts.Envelope, order *contracts.Order) error {
+	response, err := s.process(ctx, order, models.CommitOrderCommand, func(uow data.StorageRepository) (*Response, error) {
+		err := s.CommitOrderTx(order)(uow)
+		switch {
+		case errors.Is(err, ErrNotReserved), errors.Is(err, ErrReservationExpired):
+			return NewResult(s.codec, command, contracts.CommitReservationResult, order, "", err)
+		case err != nil:
+			return nil, err
+		}
+
+		return NewResult(s.codec, command, contracts.CommitReservationResult, order, "committed order", nil)
+	})
 	if err != nil {
-		response = NewError(err, order)
 		return err
 	}
 
-	response = NewSuccess("reserved order", order)
-
-	return nil
+	return s.respond(ctx, contracts.StorageCommitOrderResponseTopic, order, response)
 }
 
-func (s Storage) CancelOrder(order *models.Order) error {
-	response := new(Response)
-	defer func() {
-		message, err := response.Marshal()
-		if err != nil {
-			message = []byte(`marshaling error`)
+// ExpireReservations releases held reservations which expire before the time and publishes events about them.
+// Event is published after the products are returned, reservations released earlier without the event
+// being published are notified again. Returns number of the released reservations.
+func (s Storage) ExpireReservations(ctx context.Context, before time.Time, limit int64) (int, error) {
+	expired, err := s.repository.FindExpiredReservations(before, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	released := 0
+	for i := range expired {
+		err = data.WithUnitOfWork(ctx, s.repository, s.ExpireReservationTx(&expired[i]))
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			// Reservation was committed or canceled in the meantime.
+			continue
+		case err != nil:
+			return released, err
 		}
-		err = s.producer.WriteMessages(context.Background(), kafka.Message{
-			Key:   []byte(order.Id.Hex()),
-			Value: message,
-			Topic: StorageCancelOrderResponseTopic,
-			Headers: []kafka.Header{
-				{Key: `status`, Value: response.StatusHeader()},
-				{Key: `message`, Value: []byte(response.Message)},
-			},
-		})
-		if err != nil {
-			s.log.Error(err)
+
+		released++
+		telemetry.Logger(ctx, s.log).Infow("reservation expired", "order", expired[i].OrderId.Hex())
+	}
+
+	unnotified, err := s.repository.FindUnnotifiedReservations(limit)
+	if err != nil {
+		return released, err
+	}
+
+	for i := range unnotified {
+		if err = s.notifyExpired(ctx, &unnotified[i]); err != nil {
+			return released, err
 		}
-	}()
+	}
 
-	dbSession, err := s.products.Database().Client().StartSession()
+	return released, nil
+}
+
+func (s Storage) notifyExpired(ctx context.Context, reservation *models.OrderReservation) error {
+	order := reservation.Order()
+	event, err := contracts.NewEnvelope(contracts.ReservationExpired, order.Id.Hex(), order)
 	if err != nil {
-		response = NewError(err, order)
 		return err
 	}
-	defer dbSession.EndSession(context.Background())
 
-	err = mongo.WithSession(context.Background(), dbSession, s.CancelOrderTx(dbSession, order))
+	payload, err := s.codec.Encode(event)
 	if err != nil {
-		response = NewError(err, order)
 		return err
 	}
 
-	response = NewSuccess("canceled order", order)
+	response := NewError(ErrReservationExpired, s.codec.ContentType(), payload)
+	if err = s.respond(ctx, contracts.StorageReservationExpiredTopic, order, response); err != nil {
+		return err
+	}
 
-	return nil
+	return s.repository.MarkReservationNotified(reservation.Id)
 }
 
-func (s Storage) ReserveOrderTx(session mongo.Session, order *models.Order) func(ctx mongo.SessionContext) error {
-	return func(ctx mongo.SessionContext) error {
-		if err := session.StartTransaction(txOptions); err != nil {
-			s.log.Error(err)
+// process executes the command of the order once. Response of the command is recorded in the inbox within
+// the unit of work of the command, so the redelivered command gets the recorded response without changes of the stock.
+func (s Storage) process(ctx context.Context, order *contracts.Order, command models.Command, fn func(uow data.StorageRepository) (*Response, error)) (response *Response, err error) {
+	err = data.WithUnitOfWork(ctx, s.repository, func(uow data.StorageRepository) error {
+		processed, err := uow.FindInboxMessage(order.Id, command)
+		if err == nil {
+			telemetry.Logger(ctx, s.log).Infow("command is already processed", "order", order.Id.Hex(), "command", command)
+			response = InboxResponse(processed)
+			return nil
+		}
+		if !errors.Is(err, mongo.ErrNoDocuments) {
 			return err
 		}
 
-		amount, err := s.OrderAmount(ctx, order)
+		if response, err = fn(uow); err != nil {
+			return err
+		}
+
+		return uow.InsertInboxMessage(NewInboxMessage(order.Id, command, response))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func (s Storage) respond(ctx context.Context, topic string, order *contracts.Order, response *Response) error {
+	return s.producer.Publish(ctx, messaging.Message{
+		Key:   []byte(order.Id.Hex()),
+		Value: response.Payload,
+		Topic: topic,
+		Headers: []messaging.Header{
+			{Key: contracts.HeaderContentType, Value: []byte(response.ContentType)},
+			{Key: contracts.HeaderStatus, Value: response.StatusHeader()},
+			{Key: contracts.HeaderMessage, Value: []byte(response.Message)},
+		},
+	})
+}
+
+// ReserveOrderTx reads all products of the order with a single query and takes them from the stock with
+// a single batch, so the transaction does not grow with the number of the order lines. Returns StockError
+// with every line which product is out of stock.
+func (s Storage) ReserveOrderTx(order *contracts.Order) func(uow data.StorageRepository) error {
+	return func(uow data.StorageRepository) error {
+		lines, err := orderLines(order)
 		if err != nil {
-			_ = session.AbortTransaction(ctx)
-			s.log.Error(err)
 			return err
 		}
 
-		order.Amount = amount
-		if err = s.DeductProducts(ctx, order); err != nil {
-			_ = session.AbortTransaction(ctx)
+		products, err := findStock(uow, lines)
+		if err != nil {
 			s.log.Error(err)
 			return err
 		}
 
-		reservation := models.OrderToReservation(order)
-		_, err = s.reservations.InsertOne(context.Background(), reservation)
-		if err != nil {
+		order.Amount = orderAmount(order, products)
+		reservation := models.OrderToReservation(order, time.Now().UTC().Add(s.hold))
+		if _, err = uow.InsertReservation(reservation); err != nil {
 			s.log.Error(err)
 			return err
 		}
 
-		if err = session.CommitTransaction(ctx); err != nil {
+		if err = s.DeductProducts(uow, reservation, lines, products); err != nil {
 			s.log.Error(err)
 			return err
 		}
@@ -162,115 +249,128 @@ func (s Storage) ReserveOrderTx(session mongo.Session, order *models.Order) func
 	}
 }
 
-func (s Storage) CancelOrderTx(session mongo.Session, order *models.Order) func(ctx mongo.SessionContext) error {
-	return func(ctx mongo.SessionContext) error {
-		if err := session.StartTransaction(txOptions); err != nil {
+// CancelOrderTx returns products of the reservation to the stock. Products of the expired reservation
+// are already returned, so its cancellation succeeds without changes of the stock.
+func (s Storage) CancelOrderTx(order *contracts.Order) func(uow data.StorageRepository) error {
+	return func(uow data.StorageRepository) error {
+		reservation, err := s.IsReserved(uow, order)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			if err = s.IsExpired(uow, order); errors.Is(err, ErrReservationExpired) {
+				return nil
+			}
 			return err
 		}
-
-		reservation, err := s.IsReserved(ctx, order)
 		if err != nil {
-			_ = session.AbortTransaction(ctx)
-			return errors.New("out of order")
-		}
-
-		if err = s.ReturnProducts(ctx, reservation); err != nil {
-			_ = session.AbortTransaction(ctx)
-			return err
-		}
-
-		if err = session.CommitTransaction(ctx); err != nil {
 			return err
 		}
 
-		return nil
+		return s.ReturnProducts(uow, reservation, models.Canceled)
 	}
 }
 
-func (s Storage) OrderAmount(ctx mongo.SessionContext, order *models.Order) (amount float64, err error) {
-	for _, p := range order.Items {
-		filter := bson.D{
-			{"name", p.Name},
-			{"quantity", bson.D{{"$gte", p.Quantity}}},
+// CommitOrderTx commits the held reservation. Reservations made before holds were introduced are
+// deducted permanently already.
+func (s Storage) CommitOrderTx(order *contracts.Order) func(uow data.StorageRepository) error {
+	return func(uow data.StorageRepository) error {
+		reservation, err := s.IsReserved(uow, order)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return s.IsExpired(uow, order)
+		}
+		if err != nil {
+			return err
+		}
+
+		if reservation.Status != models.Held {
+			return nil
 		}
 
-		single := s.products.FindOne(ctx, filter)
-		if single.Err() != nil {
-			return 0, errors.New(`one of the products out of stock`)
+		for _, p := range reservation.Products {
+			product, err := uow.FindProduct(p.Sku)
+			if err != nil {
+				return err
+			}
+
+			movement := NewMovement(product, models.CommitMovement, 0)
+			reservationMovement(reservation)(movement)
+			if err = uow.InsertMovement(movement); err != nil {
+				return err
+			}
 		}
 
-		product := new(models.Product)
-		err = single.Decode(product)
+		return uow.UpdateReservationStatus(reservation.Id, models.Committed)
+	}
+}
+
+// ExpireReservationTx returns products of the reservation to the stock when it is still held.
+// Returns mongo.ErrNoDocuments when the reservation is not held anymore.
+func (s Storage) ExpireReservationTx(reservation *models.OrderReservation) func(uow data.StorageRepository) error {
+	return func(uow data.StorageRepository) error {
+		held, err := uow.FindReservation(reservation.OrderId, models.Held)
 		if err != nil {
-			return 0, err
+			return err
 		}
 
-		amount += product.Cost * float64(p.Quantity)
+		return s.ReturnProducts(uow, held, models.Expired)
 	}
-
-	return amount, nil
 }
 
-func (s Storage) IsInStock(ctx mongo.SessionContext, order *models.Order) bool {
-	for _, p := range order.Items {
-		filter := bson.D{
-			{"name", p.Name},
-			{"quantity", bson.D{{"$gte", p.Quantity}}},
-		}
+// IsReserved returns the reservation of the order which products are taken from the stock.
+func (s Storage) IsReserved(uow data.StorageRepository, order *contracts.Order) (*models.OrderReservation, error) {
+	return uow.FindReservation(order.Id, activeStatuses...)
+}
 
-		single := s.products.FindOne(ctx, filter)
-		if single.Err() != nil {
-			return false
-		}
+// IsExpired returns ErrReservationExpired when the reservation of the order is expired and ErrNotReserved
+// when the order has no reservation.
+func (s Storage) IsExpired(uow data.StorageRepository, order *contracts.Order) error {
+	_, err := uow.FindReservation(order.Id, models.Expired)
+	switch {
+	case err == nil:
+		return ErrReservationExpired
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return ErrNotReserved
 	}
 
-	return true
+	return err
 }
 
-func (s Storage) IsReserved(ctx mongo.SessionContext, order *models.Order) (*models.OrderReservation, error) {
-	filter := bson.D{
-		{"order_id", order.Id},
-		{"status", models.Success},
+// DeductProducts takes the lines of the reservation from the stock of the products read by the unit of work
+// and records the movements. Every line is guarded by its quantity, so the stock never becomes negative.
+// Returns ErrStockChanged when some line is not deducted, the retried reservation reads the stock again
+// and reports exactly which lines are short.
+func (s Storage) DeductProducts(uow data.StorageRepository, reservation *models.OrderReservation, lines []models.ProductReservation, products map[string]models.Product) error {
+	deducted, err := uow.DeductStock(lines)
+	if err != nil {
+		return err
 	}
-	single := s.reservations.FindOne(ctx, filter)
-	if err := single.Err(); err != nil {
-		return nil, err
+	if deducted != int64(len(lines)) {
+		return ErrStockChanged
 	}
 
-	reservation := new(models.OrderReservation)
-	if err := single.Decode(reservation); err != nil {
-		return nil, err
+	movements := make([]*models.StockMovement, len(lines))
+	for i, line := range lines {
+		product := products[line.Sku]
+		product.Quantity -= line.Quantity
+
+		movements[i] = NewMovement(&product, models.ReservationMovement, -line.Quantity)
+		reservationMovement(reservation)(movements[i])
 	}
 
-	return reservation, nil
+	return uow.InsertMovements(movements)
 }
 
-func (s Storage) DeductProducts(ctx mongo.SessionContext, order *models.Order) error {
-	for _, p := range order.Items {
-		filter := bson.D{{"name", p.Name}}
-		update := bson.D{{"$inc", bson.D{{"quantity", -p.Quantity}}}}
-		single := s.products.FindOneAndUpdate(ctx, filter, update)
-		if err := single.Err(); err != nil {
-			return err
-		}
+// ReturnProducts returns products of the reservation to the stock and closes the reservation with the status.
+// Canceled reservation is recorded as the cancellation, otherwise as the expiry.
+func (s Storage) ReturnProducts(uow data.StorageRepository, order *models.OrderReservation, status models.ReservationStatus) error {
+	t := models.ExpiryMovement
+	if status == models.Canceled {
+		t = models.CancellationMovement
 	}
 
-	return nil
-}
-
-func (s Storage) ReturnProducts(ctx mongo.SessionContext, order *models.OrderReservation) error {
 	for _, p := range order.Products {
-		filter := bson.D{{"name", p.ProductName}}
-		update := bson.D{{"$inc", bson.D{{"quantity", p.Quantity}}}}
-		single := s.products.FindOneAndUpdate(ctx, filter, update)
-		if err := single.Err(); err != nil {
+		if _, err := moveStock(uow, p.Sku, t, p.Quantity, reservationMovement(order)); err != nil {
 			return err
 		}
 	}
 
-	filter := bson.D{{"_id", order.Id}}
-	update := bson.D{{"$set", bson.D{{"status", models.Canceled}}}}
-	s.reservations.FindOneAndUpdate(ctx, filter, update)
-
-	return nil
+	return uow.UpdateReservationStatus(order.Id, status)
 }
@@ -0,0 +1,109 @@
+package core
+
+import (
+	"context"
+	"eCommerce/contracts"
+	"eCommerce/messaging"
+	"eCommerce/storage/internal/data"
+	"eCommerce/storage/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+	"sync"
+	"testing"
+)
+
+// newTestRepository returns the repository with 5 apples, 3 pears and the archived plum. Each product is opened
+// in the ledger, so its stock is reconciled with the movements.
+func newTestRepository(t *testing.T) *data.MemoryStorageRepository {
+	t.Helper()
+
+	repository := data.NewMemoryStorageRepository()
+	err := repository.InsertProducts(
+		models.Product{Id: primitive.NewObjectID(), Sku: "apple", Name: "apple", Cost: 1, Quantity: 5},
+		models.Product{Id: primitive.NewObjectID(), Sku: "pear", Name: "pear", Cost: 2, Quantity: 3},
+		models.Product{Id: primitive.NewObjectID(), Sku: "plum", Name: "plum", Cost: 3, Quantity: 10, Archived: true},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = NewLedger(zap.NewNop().Sugar(), repository).Open(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return repository
+}
+
+// reserveOrder sends the command to reserve the new order of the items.
+func reserveOrder(t *testing.T, storage *Storage, items []contracts.OrderProduct) *contracts.Order {
+	t.Helper()
+
+	order := &contracts.Order{Id: primitive.NewObjectID(), UserId: primitive.NewObjectID(), Items: items}
+	command, err := contracts.NewEnvelope(contracts.ReserveOrder, order.Id.Hex(), order)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = storage.ReserveOrder(context.Background(), command, order); err != nil {
+		t.Fatal(err)
+	}
+
+	return order
+}
+
+func assertQuantity(t *testing.T, repository data.StorageRepository, sku string, want int64) {
+	t.Helper()
+
+	product, err := repository.FindProduct(sku)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if product.Quantity != want {
+		t.Errorf("quantity of %s %d, want %d", sku, product.Quantity, want)
+	}
+}
+
+// recordingPublisher keeps the published messages.
+type recordingPublisher struct {
+	mu       sync.Mutex
+	messages []messaging.Message
+}
+
+func (p *recordingPublisher) Publish(_ context.Context, messages ...messaging.Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.messages = append(p.messages, messages...)
+	return nil
+}
+
+func (p *recordingPublisher) Close() error {
+	return nil
+}
+
+// published returns the messages published to the topic.
+func (p *recordingPublisher) published(topic string) []messaging.Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var messages []messaging.Message
+	for _, m := range p.messages {
+		if m.Topic == topic {
+			messages = append(messages, m)
+		}
+	}
+
+	return messages
+}
+
+// last returns the latest message published to the topic.
+func (p *recordingPublisher) last(t *testing.T, topic string) messaging.Message {
+	t.Helper()
+
+	messages := p.published(topic)
+	if len(messages) == 0 {
+		t.Fatalf("nothing is published to %s", topic)
+	}
+
+	return messages[len(messages)-1]
+}
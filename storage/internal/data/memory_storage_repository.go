@@ -0,0 +1,477 @@
+package data
+
+import (
+	"context"
+	"eCommerce/storage/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStorageRepository keeps products, reservations, movements and processed commands in the process memory.
+// Unit of work holds the exclusive lock of the storage until it is committed or rolled back,
+// so units of work are serializable. Repository of the unit of work must not be used concurrently.
+type MemoryStorageRepository struct {
+	store *memoryStore
+	// tx is the working copy of the unit of work.
+	tx *memoryState
+}
+
+type memoryStore struct {
+	mu    sync.Mutex
+	state *memoryState
+}
+
+type memoryState struct {
+	products     map[string]models.Product
+	reservations []models.OrderReservation
+	movements    []models.StockMovement
+	inbox        map[inboxKey]models.InboxMessage
+}
+
+type inboxKey struct {
+	orderId primitive.ObjectID
+	command models.Command
+}
+
+func NewMemoryStorageRepository() *MemoryStorageRepository {
+	r := new(MemoryStorageRepository)
+	r.store = &memoryStore{state: &memoryState{
+		products: make(map[string]models.Product),
+		inbox:    make(map[inboxKey]models.InboxMessage),
+	}}
+
+	return r
+}
+
+func (s *memoryState) copy() *memoryState {
+	c := &memoryState{
+		products:     make(map[string]models.Product, len(s.products)),
+		reservations: make([]models.OrderReservation, len(s.reservations)),
+		movements:    make([]models.StockMovement, len(s.movements)),
+		inbox:        make(map[inboxKey]models.InboxMessage, len(s.inbox)),
+	}
+	for name, p := range s.products {
+		c.products[name] = p
+	}
+	for key, m := range s.inbox {
+		c.inbox[key] = m
+	}
+	copy(c.reservations, s.reservations)
+	copy(c.movements, s.movements)
+
+	return c
+}
+
+func (m *MemoryStorageRepository) do(fn func(s *memoryState) error) error {
+	if m.tx != nil {
+		return fn(m.tx)
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	return fn(m.store.state)
+}
+
+// InsertProducts adds products to the storage replacing the products with the same SKU.
+func (m *MemoryStorageRepository) InsertProducts(products ...models.Product) error {
+	return m.do(func(s *memoryState) error {
+		for _, p := range products {
+			if p.Id.IsZero() {
+				p.Id = primitive.NewObjectID()
+			}
+			s.products[p.Sku] = p
+		}
+		return nil
+	})
+}
+
+// FindProduct returns the product by SKU regardless of its quantity.
+func (m *MemoryStorageRepository) FindProduct(sku string) (*models.Product, error) {
+	product := new(models.Product)
+	err := m.do(func(s *memoryState) error {
+		p, ok := s.products[sku]
+		if !ok {
+			return mongo.ErrNoDocuments
+		}
+		*product = p
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return product, nil
+}
+
+func (m *MemoryStorageRepository) FindProducts(skus []string) ([]models.Product, error) {
+	products := make([]models.Product, 0, len(skus))
+	err := m.do(func(s *memoryState) error {
+		for _, sku := range skus {
+			if p, ok := s.products[sku]; ok {
+				products = append(products, p)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return products, nil
+}
+
+func (m *MemoryStorageRepository) ListProducts() ([]models.Product, error) {
+	products := make([]models.Product, 0)
+	err := m.do(func(s *memoryState) error {
+		for _, p := range s.products {
+			products = append(products, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(products, func(i, j int) bool {
+		if products[i].Name != products[j].Name {
+			return products[i].Name < products[j].Name
+		}
+		return products[i].Sku < products[j].Sku
+	})
+
+	return products, nil
+}
+
+func (m *MemoryStorageRepository) FindProductId(id primitive.ObjectID) (*models.Product, error) {
+	product := new(models.Product)
+	err := m.do(func(s *memoryState) error {
+		for _, p := range s.products {
+			if p.Id == id {
+				*product = p
+				return nil
+			}
+		}
+		return mongo.ErrNoDocuments
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return product, nil
+}
+
+func (m *MemoryStorageRepository) InsertProduct(product *models.Product) (*models.Product, error) {
+	err := m.do(func(s *memoryState) error {
+		if _, ok := s.products[product.Sku]; ok {
+			return ErrDuplicateProduct
+		}
+
+		product.Id = primitive.NewObjectID()
+		s.products[product.Sku] = *product
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return product, nil
+}
+
+func (m *MemoryStorageRepository) UpdateProduct(product *models.Product) error {
+	return m.do(func(s *memoryState) error {
+		for sku, p := range s.products {
+			if p.Id != product.Id {
+				continue
+			}
+
+			p.Name, p.Variant, p.Cost, p.Archived = product.Name, product.Variant, product.Cost, product.Archived
+			s.products[sku] = p
+			return nil
+		}
+		return mongo.ErrNoDocuments
+	})
+}
+
+func (m *MemoryStorageRepository) AddProductQuantity(sku string, delta int64) (*models.Product, error) {
+	product := new(models.Product)
+	err := m.do(func(s *memoryState) error {
+		p, ok := s.products[sku]
+		if !ok {
+			return mongo.ErrNoDocuments
+		}
+		p.Quantity += delta
+		s.products[sku] = p
+		*product = p
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return product, nil
+}
+
+func (m *MemoryStorageRepository) DeductStock(lines []models.ProductReservation) (int64, error) {
+	var deducted int64
+	err := m.do(func(s *memoryState) error {
+		for _, line := range lines {
+			p, ok := s.products[line.Sku]
+			if !ok || p.Archived || p.Quantity < line.Quantity {
+				continue
+			}
+			p.Quantity -= line.Quantity
+			s.products[line.Sku] = p
+			deducted++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return deducted, nil
+}
+
+func (m *MemoryStorageRepository) InsertMovements(movements []*models.StockMovement) error {
+	return m.do(func(s *memoryState) error {
+		for _, movement := range movements {
+			movement.Id = primitive.NewObjectID()
+			s.movements = append(s.movements, *movement)
+		}
+		return nil
+	})
+}
+
+func (m *MemoryStorageRepository) InsertMovement(movement *models.StockMovement) error {
+	return m.do(func(s *memoryState) error {
+		movement.Id = primitive.NewObjectID()
+		s.movements = append(s.movements, *movement)
+		return nil
+	})
+}
+
+func (m *MemoryStorageRepository) ListMovements(productId primitive.ObjectID, skip, limit int64) ([]models.StockMovement, error) {
+	movements := make([]models.StockMovement, 0)
+	err := m.do(func(s *memoryState) error {
+		for _, movement := range s.movements {
+			if movement.ProductId == productId {
+				movements = append(movements, movement)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if skip >= int64(len(movements)) {
+		return movements[:0], nil
+	}
+	movements = movements[skip:]
+	if limit > 0 && int64(len(movements)) > limit {
+		movements = movements[:limit]
+	}
+
+	return movements, nil
+}
+
+func (m *MemoryStorageRepository) MovementTotals() (map[primitive.ObjectID]int64, error) {
+	totals := make(map[primitive.ObjectID]int64)
+	err := m.do(func(s *memoryState) error {
+		for _, movement := range s.movements {
+			totals[movement.ProductId] += movement.Delta
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return totals, nil
+}
+
+func (m *MemoryStorageRepository) InsertReservation(reservation *models.OrderReservation) (*models.OrderReservation, error) {
+	err := m.do(func(s *memoryState) error {
+		reservation.Id = primitive.NewObjectID()
+		s.reservations = append(s.reservations, *reservation)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return reservation, nil
+}
+
+func (m *MemoryStorageRepository) FindReservation(orderId primitive.ObjectID, statuses ...models.ReservationStatus) (*models.OrderReservation, error) {
+	reservation := new(models.OrderReservation)
+	err := m.do(func(s *memoryState) error {
+		for _, r := range s.reservations {
+			if r.OrderId == orderId && hasStatus(r, statuses) {
+				*reservation = r
+				return nil
+			}
+		}
+		return mongo.ErrNoDocuments
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return reservation, nil
+}
+
+func (m *MemoryStorageRepository) UpdateReservationStatus(id primitive.ObjectID, status models.ReservationStatus) error {
+	return m.do(func(s *memoryState) error {
+		for i := range s.reservations {
+			if s.reservations[i].Id == id {
+				s.reservations[i].Status = status
+				return nil
+			}
+		}
+		return mongo.ErrNoDocuments
+	})
+}
+
+func hasStatus(reservation models.OrderReservation, statuses []models.ReservationStatus) bool {
+	for _, status := range statuses {
+		if reservation.Status == status {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *MemoryStorageRepository) FindProductReservations(sku string, statuses ...models.ReservationStatus) ([]models.OrderReservation, error) {
+	return m.findReservations(0, func(r models.OrderReservation) bool {
+		if !hasStatus(r, statuses) {
+			return false
+		}
+		for _, p := range r.Products {
+			if p.Sku == sku {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func (m *MemoryStorageRepository) FindExpiredReservations(before time.Time, limit int64) ([]models.OrderReservation, error) {
+	return m.findReservations(limit, func(r models.OrderReservation) bool {
+		return r.Status == models.Held && r.ExpiresAt.Before(before)
+	})
+}
+
+func (m *MemoryStorageRepository) FindUnnotifiedReservations(limit int64) ([]models.OrderReservation, error) {
+	return m.findReservations(limit, func(r models.OrderReservation) bool {
+		return r.Status == models.Expired && !r.Notified
+	})
+}
+
+// findReservations returns matching reservations ordered by the expiry time. Zero limit returns all of them.
+func (m *MemoryStorageRepository) findReservations(limit int64, match func(r models.OrderReservation) bool) ([]models.OrderReservation, error) {
+	reservations := make([]models.OrderReservation, 0)
+	err := m.do(func(s *memoryState) error {
+		for _, r := range s.reservations {
+			if match(r) {
+				reservations = append(reservations, r)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(reservations, func(i, j int) bool {
+		return reservations[i].ExpiresAt.Before(reservations[j].ExpiresAt)
+	})
+	if limit > 0 && int64(len(reservations)) > limit {
+		reservations = reservations[:limit]
+	}
+
+	return reservations, nil
+}
+
+func (m *MemoryStorageRepository) MarkReservationNotified(id primitive.ObjectID) error {
+	return m.do(func(s *memoryState) error {
+		for i := range s.reservations {
+			if s.reservations[i].Id == id {
+				s.reservations[i].Notified = true
+				return nil
+			}
+		}
+		return mongo.ErrNoDocuments
+	})
+}
+
+func (m *MemoryStorageRepository) FindInboxMessage(orderId primitive.ObjectID, command models.Command) (*models.InboxMessage, error) {
+	message := new(models.InboxMessage)
+	err := m.do(func(s *memoryState) error {
+		processed, ok := s.inbox[inboxKey{orderId: orderId, command: command}]
+		if !ok {
+			return mongo.ErrNoDocuments
+		}
+		*message = processed
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+func (m *MemoryStorageRepository) InsertInboxMessage(message *models.InboxMessage) error {
+	return m.do(func(s *memoryState) error {
+		key := inboxKey{orderId: message.OrderId, command: message.Command}
+		if _, ok := s.inbox[key]; ok {
+			return ErrDuplicateInboxMessage
+		}
+
+		message.Id = primitive.NewObjectID()
+		s.inbox[key] = *message
+		return nil
+	})
+}
+
+func (m *MemoryStorageRepository) Begin(_ context.Context) (StorageRepository, error) {
+	if m.tx != nil {
+		return nil, ErrUnitOfWorkStarted
+	}
+
+	m.store.mu.Lock()
+
+	uow := *m
+	uow.tx = m.store.state.copy()
+
+	return &uow, nil
+}
+
+func (m *MemoryStorageRepository) Commit() error {
+	if m.tx == nil {
+		return ErrUnitOfWorkNotStarted
+	}
+
+	m.store.state = m.tx
+	m.tx = nil
+	m.store.mu.Unlock()
+
+	return nil
+}
+
+func (m *MemoryStorageRepository) Rollback() error {
+	if m.tx == nil {
+		return nil
+	}
+
+	m.tx = nil
+	m.store.mu.Unlock()
+
+	return nil
+}
@@ -0,0 +1,546 @@
+package data
+
+import (
+	"context"
+	"eCommerce/storage/internal/models"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+var (
+	// ErrDuplicateInboxMessage is returned when the processed command of the order is recorded again.
+	ErrDuplicateInboxMessage = errors.New(`command of the order is already processed`)
+	// ErrDuplicateProduct is returned when the product is stored with the SKU of another product.
+	ErrDuplicateProduct = errors.New(`product with the same sku already exists`)
+)
+
+// Error codes of the mongodb commands.
+const (
+	namespaceNotFound = 26
+	indexNotFound     = 27
+)
+
+type StorageRepository interface {
+	// FindProduct returns the product by SKU regardless of its quantity.
+	FindProduct(sku string) (*models.Product, error)
+	FindProductId(id primitive.ObjectID) (*models.Product, error)
+	// FindProducts returns products of the SKUs regardless of their quantity. Unknown SKUs are skipped.
+	FindProducts(skus []string) ([]models.Product, error)
+	// ListProducts returns all products of the storage ordered by name and SKU.
+	ListProducts() ([]models.Product, error)
+	// InsertProduct adds the product to the catalog. Returns ErrDuplicateProduct when the SKU is taken.
+	InsertProduct(product *models.Product) (*models.Product, error)
+	// UpdateProduct replaces name, variant, cost and archived flag of the product. SKU is never changed,
+	// quantity is changed only by the delta.
+	UpdateProduct(product *models.Product) error
+	// AddProductQuantity changes quantity of the product in stock by the delta and returns the changed product.
+	AddProductQuantity(sku string, delta int64) (*models.Product, error)
+	// DeductStock takes quantities of the lines from the stock in one batch. Line is deducted only when there is
+	// at least its quantity of the available product in stock. Returns number of the deducted lines.
+	DeductStock(lines []models.ProductReservation) (int64, error)
+	InsertReservation(reservation *models.OrderReservation) (*models.OrderReservation, error)
+	// FindReservation returns the reservation of the order in one of the statuses.
+	FindReservation(orderId primitive.ObjectID, statuses ...models.ReservationStatus) (*models.OrderReservation, error)
+	UpdateReservationStatus(id primitive.ObjectID, status models.ReservationStatus) error
+	// FindProductReservations returns reservations of the product in one of the statuses.
+	FindProductReservations(sku string, statuses ...models.ReservationStatus) ([]models.OrderReservation, error)
+	// FindExpiredReservations returns held reservations which expiry time is before the time.
+	FindExpiredReservations(before time.Time, limit int64) ([]models.OrderReservation, error)
+	// FindUnnotifiedReservations returns expired reservations which event is not published yet.
+	FindUnnotifiedReservations(limit int64) ([]models.OrderReservation, error)
+	// MarkReservationNotified records that the event about the expired reservation is published.
+	MarkReservationNotified(id primitive.ObjectID) error
+	// InsertMovement records the stock change. Movements are never changed or deleted.
+	InsertMovement(movement *models.StockMovement) error
+	// InsertMovements records the stock changes in one batch.
+	InsertMovements(movements []*models.StockMovement) error
+	// ListMovements returns page of the movements of the product starting from the earliest.
+	ListMovements(productId primitive.ObjectID, skip, limit int64) ([]models.StockMovement, error)
+	// MovementTotals returns sum of the movement deltas of each product.
+	MovementTotals() (map[primitive.ObjectID]int64, error)
+	// FindInboxMessage returns the record of the command of the order which is already processed.
+	FindInboxMessage(orderId primitive.ObjectID, command models.Command) (*models.InboxMessage, error)
+	// InsertInboxMessage records the processed command. Command of the order could be recorded only once.
+	InsertInboxMessage(message *models.InboxMessage) error
+	// Begin starts the unit of work. Changes made through the returned repository are staged in the database
+	// transaction until Commit is called, Rollback discards them. Rollback after Commit has no effect.
+	// Operations of the unit of work are traced as children of the span of the context.
+	Begin(ctx context.Context) (StorageRepository, error)
+	Commit() error
+	Rollback() error
+}
+
+type MongoStorageRepository struct {
+	ctx          context.Context
+	session      mongo.Session
+	products     *mongo.Collection
+	reservations *mongo.Collection
+	movements    *mongo.Collection
+	inbox        *mongo.Collection
+}
+
+func NewMongoStorageRepository(db *mongo.Database) *MongoStorageRepository {
+	r := new(MongoStorageRepository)
+	r.ctx = context.Background()
+	r.products = db.Collection(`products`)
+	r.reservations = db.Collection(`reservations`)
+	r.movements = db.Collection(`movements`)
+	r.inbox = db.Collection(`inbox`)
+
+	return r
+}
+
+func (m *MongoStorageRepository) FindProduct(sku string) (*models.Product, error) {
+	return m.findProduct(bson.D{{"sku", sku}})
+}
+
+func (m *MongoStorageRepository) FindProductId(id primitive.ObjectID) (*models.Product, error) {
+	return m.findProduct(bson.D{{"_id", id}})
+}
+
+func (m *MongoStorageRepository) FindProducts(skus []string) ([]models.Product, error) {
+	filter := bson.D{{"sku", bson.D{{"$in", skus}}}}
+	records, err := m.products.Find(m.ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	products := make([]models.Product, 0, len(skus))
+	if err = records.All(m.ctx, &products); err != nil {
+		return nil, err
+	}
+
+	return products, nil
+}
+
+func (m *MongoStorageRepository) findProduct(filter bson.D) (*models.Product, error) {
+	single := m.products.FindOne(m.ctx, filter)
+	if err := single.Err(); err != nil {
+		return nil, err
+	}
+
+	product := new(models.Product)
+	if err := single.Decode(product); err != nil {
+		return nil, err
+	}
+
+	return product, nil
+}
+
+func (m *MongoStorageRepository) InsertProduct(product *models.Product) (*models.Product, error) {
+	one, err := m.products.InsertOne(m.ctx, product)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrDuplicateProduct
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	product.Id = one.InsertedID.(primitive.ObjectID)
+
+	return product, nil
+}
+
+func (m *MongoStorageRepository) UpdateProduct(product *models.Product) error {
+	filter := bson.D{{"_id", product.Id}}
+	update := bson.D{{"$set", bson.D{
+		{"name", product.Name},
+		{"variant", product.Variant},
+		{"cost", product.Cost},
+		{"archived", product.Archived},
+	}}}
+
+	return m.products.FindOneAndUpdate(m.ctx, filter, update).Err()
+}
+
+func (m *MongoStorageRepository) ListProducts() ([]models.Product, error) {
+	option := options.Find().SetSort(bson.D{{"name", 1}, {"sku", 1}})
+	records, err := m.products.Find(m.ctx, bson.D{}, option)
+	if err != nil {
+		return nil, err
+	}
+
+	products := make([]models.Product, 0)
+	if err = records.All(m.ctx, &products); err != nil {
+		return nil, err
+	}
+
+	return products, nil
+}
+
+func (m *MongoStorageRepository) AddProductQuantity(sku string, delta int64) (*models.Product, error) {
+	filter := bson.D{{"sku", sku}}
+	update := bson.D{{"$inc", bson.D{{"quantity", delta}}}}
+	option := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	single := m.products.FindOneAndUpdate(m.ctx, filter, update, option)
+	if err := single.Err(); err != nil {
+		return nil, err
+	}
+
+	product := new(models.Product)
+	if err := single.Decode(product); err != nil {
+		return nil, err
+	}
+
+	return product, nil
+}
+
+func (m *MongoStorageRepository) DeductStock(lines []models.ProductReservation) (int64, error) {
+	if len(lines) == 0 {
+		return 0, nil
+	}
+
+	writes := make([]mongo.WriteModel, len(lines))
+	for i, line := range lines {
+		filter := bson.D{
+			{"sku", line.Sku},
+			{"quantity", bson.D{{"$gte", line.Quantity}}},
+			{"archived", bson.D{{"$ne", true}}},
+		}
+		update := bson.D{{"$inc", bson.D{{"quantity", -line.Quantity}}}}
+		writes[i] = mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update)
+	}
+
+	result, err := m.products.BulkWrite(m.ctx, writes, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return 0, err
+	}
+
+	return result.MatchedCount, nil
+}
+
+func (m *MongoStorageRepository) InsertReservation(reservation *models.OrderReservation) (*models.OrderReservation, error) {
+	one, err := m.reservations.InsertOne(m.ctx, reservation)
+	if err != nil {
+		return nil, err
+	}
+
+	reservation.Id = one.InsertedID.(primitive.ObjectID)
+
+	return reservation, nil
+}
+
+func (m *MongoStorageRepository) FindReservation(orderId primitive.ObjectID, statuses ...models.ReservationStatus) (*models.OrderReservation, error) {
+	filter := bson.D{
+		{"order_id", orderId},
+		{"status", bson.D{{"$in", statuses}}},
+	}
+
+	single := m.reservations.FindOne(m.ctx, filter)
+	if err := single.Err(); err != nil {
+		return nil, err
+	}
+
+	reservation := new(models.OrderReservation)
+	if err := single.Decode(reservation); err != nil {
+		return nil, err
+	}
+
+	return reservation, nil
+}
+
+func (m *MongoStorageRepository) UpdateReservationStatus(id primitive.ObjectID, status models.ReservationStatus) error {
+	filter := bson.D{{"_id", id}}
+	update := bson.D{{"$set", bson.D{{"status", status}}}}
+
+	return m.reservations.FindOneAndUpdate(m.ctx, filter, update).Err()
+}
+
+func (m *MongoStorageRepository) FindProductReservations(sku string, statuses ...models.ReservationStatus) ([]models.OrderReservation, error) {
+	filter := bson.D{
+		{"products.sku", sku},
+		{"status", bson.D{{"$in", statuses}}},
+	}
+
+	return m.findReservations(filter, 0)
+}
+
+func (m *MongoStorageRepository) FindExpiredReservations(before time.Time, limit int64) ([]models.OrderReservation, error) {
+	filter := bson.D{
+		{"status", models.Held},
+		{"expires_at", bson.D{{"$lt", before}}},
+	}
+
+	return m.findReservations(filter, limit)
+}
+
+func (m *MongoStorageRepository) FindUnnotifiedReservations(limit int64) ([]models.OrderReservation, error) {
+	filter := bson.D{
+		{"status", models.Expired},
+		{"notified", bson.D{{"$ne", true}}},
+	}
+
+	return m.findReservations(filter, limit)
+}
+
+func (m *MongoStorageRepository) findReservations(filter bson.D, limit int64) ([]models.OrderReservation, error) {
+	option := options.Find().SetSort(bson.D{{"expires_at", 1}}).SetLimit(limit)
+	records, err := m.reservations.Find(m.ctx, filter, option)
+	if err != nil {
+		return nil, err
+	}
+
+	reservations := make([]models.OrderReservation, 0)
+	if err = records.All(m.ctx, &reservations); err != nil {
+		return nil, err
+	}
+
+	return reservations, nil
+}
+
+func (m *MongoStorageRepository) MarkReservationNotified(id primitive.ObjectID) error {
+	filter := bson.D{{"_id", id}}
+	update := bson.D{{"$set", bson.D{{"notified", true}}}}
+
+	return m.reservations.FindOneAndUpdate(m.ctx, filter, update).Err()
+}
+
+func (m *MongoStorageRepository) InsertMovement(movement *models.StockMovement) error {
+	one, err := m.movements.InsertOne(m.ctx, movement)
+	if err != nil {
+		return err
+	}
+
+	movement.Id = one.InsertedID.(primitive.ObjectID)
+
+	return nil
+}
+
+func (m *MongoStorageRepository) InsertMovements(movements []*models.StockMovement) error {
+	if len(movements) == 0 {
+		return nil
+	}
+
+	documents := make([]interface{}, len(movements))
+	for i, movement := range movements {
+		documents[i] = movement
+	}
+
+	many, err := m.movements.InsertMany(m.ctx, documents)
+	if err != nil {
+		return err
+	}
+
+	for i, id := range many.InsertedIDs {
+		movements[i].Id = id.(primitive.ObjectID)
+	}
+
+	return nil
+}
+
+func (m *MongoStorageRepository) ListMovements(productId primitive.ObjectID, skip, limit int64) ([]models.StockMovement, error) {
+	filter := bson.D{{"product_id", productId}}
+	option := options.Find().SetSort(bson.D{{"_id", 1}}).SetSkip(skip).SetLimit(limit)
+
+	records, err := m.movements.Find(m.ctx, filter, option)
+	if err != nil {
+		return nil, err
+	}
+
+	movements := make([]models.StockMovement, 0)
+	if err = records.All(m.ctx, &movements); err != nil {
+		return nil, err
+	}
+
+	return movements, nil
+}
+
+func (m *MongoStorageRepository) MovementTotals() (map[primitive.ObjectID]int64, error) {
+	pipeline := mongo.Pipeline{
+		{{"$group", bson.D{{"_id", "$product_id"}, {"total", bson.D{{"$sum", "$delta"}}}}}},
+	}
+
+	records, err := m.movements.Aggregate(m.ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	var totals []struct {
+		ProductId primitive.ObjectID `bson:"_id"`
+		Total     int64              `bson:"total"`
+	}
+	if err = records.All(m.ctx, &totals); err != nil {
+		return nil, err
+	}
+
+	result := make(map[primitive.ObjectID]int64, len(totals))
+	for _, t := range totals {
+		result[t.ProductId] = t.Total
+	}
+
+	return result, nil
+}
+
+// EnsureIndexes creates unique indexes of the processed commands and the product SKUs, indexes of
+// the reservations scanned by the sweeper and listed per product, and the index of the product movements.
+// Collections are created by the indexes as well, because they can not be created implicitly inside the transaction.
+// Documents stored before SKUs were introduced are migrated first, see MigrateSkus.
+func (m *MongoStorageRepository) EnsureIndexes(ctx context.Context) error {
+	if err := m.MigrateSkus(ctx); err != nil {
+		return err
+	}
+
+	_, err := m.inbox.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{"order_id", 1}, {"command", 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = m.products.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{"sku", 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{"name", 1}, {"sku", 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = m.reservations.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{"status", 1}, {"expires_at", 1}}},
+		{Keys: bson.D{{"products.sku", 1}, {"status", 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = m.movements.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{"product_id", 1}, {"_id", 1}},
+	})
+
+	return err
+}
+
+// MigrateSkus identifies products stored before SKUs were introduced by their names, so commands of the orders
+// referencing products by name are still processed. Reservations and movements referencing products by name
+// refer to the same SKUs. Unique index of the product names is dropped, products of the same name are variants.
+func (m *MongoStorageRepository) MigrateSkus(ctx context.Context) error {
+	_, err := m.products.UpdateMany(ctx, bson.D{{"sku", bson.D{{"$exists", false}}}}, mongo.Pipeline{
+		{{"$set", bson.D{{"sku", "$name"}}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = m.reservations.UpdateMany(ctx, bson.D{{"products.product_name", bson.D{{"$exists", true}}}}, mongo.Pipeline{
+		{{"$set", bson.D{{"products", bson.D{{"$map", bson.D{
+			{"input", "$products"},
+			{"as", "p"},
+			{"in", bson.D{
+				{"_id", "$$p._id"},
+				{"sku", bson.D{{"$ifNull", bson.A{"$$p.sku", "$$p.product_name"}}}},
+				{"quantity", "$$p.quantity"},
+			}},
+		}}}}}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = m.movements.UpdateMany(ctx, bson.D{{"product_name", bson.D{{"$exists", true}}}}, mongo.Pipeline{
+		{{"$set", bson.D{{"sku", "$product_name"}}}},
+		{{"$unset", "product_name"}},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = dropIndex(ctx, m.products, "name_1"); err != nil {
+		return err
+	}
+
+	return dropIndex(ctx, m.reservations, "products.product_name_1_status_1")
+}
+
+// dropIndex drops the index of the collection when it exists.
+func dropIndex(ctx context.Context, collection *mongo.Collection, name string) error {
+	_, err := collection.Indexes().DropOne(ctx, name)
+
+	var e mongo.CommandError
+	if errors.As(err, &e) && (e.Code == indexNotFound || e.Code == namespaceNotFound) {
+		return nil
+	}
+
+	return err
+}
+
+func (m *MongoStorageRepository) FindInboxMessage(orderId primitive.ObjectID, command models.Command) (*models.InboxMessage, error) {
+	filter := bson.D{
+		{"order_id", orderId},
+		{"command", command},
+	}
+
+	single := m.inbox.FindOne(m.ctx, filter)
+	if err := single.Err(); err != nil {
+		return nil, err
+	}
+
+	message := new(models.InboxMessage)
+	if err := single.Decode(message); err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+func (m *MongoStorageRepository) InsertInboxMessage(message *models.InboxMessage) error {
+	one, err := m.inbox.InsertOne(m.ctx, message)
+	if err != nil {
+		return err
+	}
+
+	message.Id = one.InsertedID.(primitive.ObjectID)
+
+	return nil
+}
+
+func (m *MongoStorageRepository) Begin(ctx context.Context) (StorageRepository, error) {
+	if m.session != nil {
+		return nil, ErrUnitOfWorkStarted
+	}
+
+	session, err := m.products.Database().Client().StartSession()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = session.StartTransaction(txOptions); err != nil {
+		session.EndSession(ctx)
+		return nil, err
+	}
+
+	uow := *m
+	uow.session = session
+	uow.ctx = mongo.NewSessionContext(ctx, session)
+
+	return &uow, nil
+}
+
+func (m *MongoStorageRepository) Commit() error {
+	if m.session == nil {
+		return ErrUnitOfWorkNotStarted
+	}
+	defer m.end()
+
+	return m.session.CommitTransaction(m.ctx)
+}
+
+func (m *MongoStorageRepository) Rollback() error {
+	if m.session == nil {
+		return nil
+	}
+	defer m.end()
+
+	return m.session.AbortTransaction(m.ctx)
+}
+
+func (m *MongoStorageRepository) end() {
+	m.session.EndSession(context.Background())
+	m.session = nil
+	m.ctx = context.Background()
+}
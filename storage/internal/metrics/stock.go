@@ -0,0 +1,47 @@
+// Package metrics declares prometheus metrics of the storage. Metrics are served by the diagnostics server.
+package metrics
+
+import (
+	"eCommerce/storage/internal/data"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+var stockDesc = prometheus.NewDesc(
+	`storage_product_stock`,
+	`Quantity of the product in stock, which is available for reservation.`,
+	[]string{`sku`}, nil,
+)
+
+// StockCollector reads stock levels of the products from the repository on each scrape,
+// so the levels are correct regardless of which instance of the service changed them.
+type StockCollector struct {
+	log        *zap.SugaredLogger
+	repository data.StorageRepository
+}
+
+func NewStockCollector(log *zap.SugaredLogger, repository data.StorageRepository) *StockCollector {
+	c := new(StockCollector)
+	c.log = log
+	c.repository = repository
+
+	return c
+}
+
+func (c *StockCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- stockDesc
+}
+
+// Collect reports the stock of each product. Levels are skipped when the repository is not available,
+// so other metrics of the service are still scraped.
+func (c *StockCollector) Collect(ch chan<- prometheus.Metric) {
+	products, err := c.repository.ListProducts()
+	if err != nil {
+		c.log.Warnw("failed to collect stock levels", "err", err)
+		return
+	}
+
+	for _, p := range products {
+		ch <- prometheus.MustNewConstMetric(stockDesc, prometheus.GaugeValue, float64(p.Quantity), p.Sku)
+	}
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+const (
+	ReserveOrderCommand Command = `reserve-order`
+	CancelOrderCommand  Command = `cancel-order`
+	CommitOrderCommand  Command = `commit-order`
+)
+
+// Command is the type of the command processed by the storage.
+type Command string
+
+// InboxMessage records the processed command of the order with the response sent to it,
+// so the redelivered command is answered with the same response instead of being processed again.
+type InboxMessage struct {
+	Id          primitive.ObjectID `bson:"_id,omitempty"`
+	OrderId     primitive.ObjectID `bson:"order_id"`
+	Command     Command            `bson:"command"`
+	IsSuccess   bool               `bson:"is_success"`
+	Message     string             `bson:"message"`
+	ContentType string             `bson:"content_type,omitempty"`
+	Payload     []byte             `bson:"payload"`
+	ProcessedAt time.Time          `bson:"processed_at"`
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+const (
+	// OpeningMovement records quantity of the product which was in stock before the ledger was introduced.
+	OpeningMovement MovementType = `opening`
+	// InitialMovement records quantity of the product when it is created.
+	InitialMovement      MovementType = `initial`
+	ReservationMovement  MovementType = `reservation`
+	CancellationMovement MovementType = `cancellation`
+	ExpiryMovement       MovementType = `expiry`
+	// CommitMovement records the reservation becoming permanent, it does not change quantity of the product.
+	CommitMovement     MovementType = `commit`
+	RestockMovement    MovementType = `restock`
+	AdjustmentMovement MovementType = `adjustment`
+)
+
+// MovementType is the reason of the stock change.
+type MovementType string
+
+// StockMovement is the immutable record of the stock change of the product. Sum of the deltas of the product
+// movements is the quantity of the product in stock. Quantity is the stock of the product after the movement.
+type StockMovement struct {
+	Id            primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	ProductId     primitive.ObjectID `json:"product_id" bson:"product_id"`
+	Sku           string             `json:"sku" bson:"sku"`
+	Type          MovementType       `json:"type" bson:"type"`
+	Delta         int64              `json:"delta" bson:"delta"`
+	Quantity      int64              `json:"quantity" bson:"quantity"`
+	OrderId       primitive.ObjectID `json:"order_id" bson:"order_id,omitempty"`
+	ReservationId primitive.ObjectID `json:"reservation_id" bson:"reservation_id,omitempty"`
+	Reason        string             `json:"reason,omitempty" bson:"reason,omitempty"`
+	CreatedAt     time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// StockDrift reports the product which quantity differs from the sum of its movements.
+type StockDrift struct {
+	ProductId primitive.ObjectID `json:"product_id"`
+	Sku       string             `json:"sku"`
+	Quantity  int64              `json:"quantity"`
+	Movements int64              `json:"movements"`
+	Drift     int64              `json:"drift"`
+}
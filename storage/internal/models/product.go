@@ -2,9 +2,17 @@ package models
 
 import "go.mongodb.org/mongo-driver/bson/primitive"
 
+// Product is the stock keeping unit of the catalog. Variants of the same product, e.g. of different size or colour,
+// share the name and differ by the SKU and the variant options, each variant has its own cost and stock.
 type Product struct {
-	Id       primitive.ObjectID `bson:"_id,omitempty"`
-	Name     string             `bson:"name"`
-	Cost     float64            `bson:"cost"`
-	Quantity int64              `bson:"quantity"`
+	Id primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	// Sku is the stable identity of the product referenced by orders, reservations and movements.
+	Sku  string `json:"sku" bson:"sku"`
+	Name string `json:"name" bson:"name"`
+	// Variant holds options of the variant, e.g. size and colour. Product without variants has none.
+	Variant  map[string]string `json:"variant,omitempty" bson:"variant,omitempty"`
+	Cost     float64           `json:"cost" bson:"cost"`
+	Quantity int64             `json:"quantity" bson:"quantity"`
+	// Archived product is not reserved by new orders, reservations of its existing orders are still processed.
+	Archived bool `json:"archived" bson:"archived,omitempty"`
 }
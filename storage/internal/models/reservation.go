@@ -1,12 +1,21 @@
 package models
 
 import (
+	"eCommerce/contracts"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
 )
 
 const (
-	Success  ReservationStatus = `success`
+	// Held reservation keeps products out of stock until it is committed, canceled or expired.
+	Held ReservationStatus = `held`
+	// Committed reservation is the permanent deduction of the paid order.
+	Committed ReservationStatus = `committed`
+	// Expired reservation was not committed in time, its products are returned to the stock.
+	Expired  ReservationStatus = `expired`
 	Canceled ReservationStatus = `canceled`
+	// Success is the status of the reservations made before holds were introduced. They are never expired.
+	Success ReservationStatus = `success`
 )
 
 type ReservationStatus string
@@ -14,28 +23,46 @@ type ReservationStatus string
 type OrderReservation struct {
 	Id       primitive.ObjectID   `json:"id" bson:"_id,omitempty"`
 	OrderId  primitive.ObjectID   `json:"order_id" bson:"order_id"`
+	UserId   primitive.ObjectID   `json:"user_id" bson:"user_id,omitempty"`
 	Products []ProductReservation `json:"products" bson:"products"`
 	Status   ReservationStatus    `json:"status" bson:"status"`
+	// ExpiresAt is the time the held reservation is released unless it is committed.
+	ExpiresAt time.Time `json:"expires_at" bson:"expires_at,omitempty"`
+	// Notified is set when the event about the expired reservation is published.
+	Notified bool `json:"-" bson:"notified,omitempty"`
 }
 
 type ProductReservation struct {
-	Id          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	ProductName string             `json:"product_name" bson:"product_name"`
-	Quantity    int64              `json:"quantity" bson:"quantity"`
+	Id       primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Sku      string             `json:"sku" bson:"sku"`
+	Quantity int64              `json:"quantity" bson:"quantity"`
 }
 
-func OrderToReservation(order *Order) *OrderReservation {
+// OrderToReservation returns the reservation holding products of the order until the expiry time.
+func OrderToReservation(order *contracts.Order, expiresAt time.Time) *OrderReservation {
 	r := new(OrderReservation)
-	r.Status = Success
+	r.Status = Held
 	r.OrderId = order.Id
+	r.UserId = order.UserId
+	r.ExpiresAt = expiresAt
 	r.Products = make([]ProductReservation, len(order.Items))
 
 	for i, x := range order.Items {
 		r.Products[i] = ProductReservation{
-			ProductName: x.Name,
-			Quantity:    x.Quantity,
+			Sku:      x.Sku,
+			Quantity: x.Quantity,
 		}
 	}
 
 	return r
 }
+
+// Order returns the order of the reservation as the payload of the storage events.
+func (r *OrderReservation) Order() *contracts.Order {
+	items := make([]contracts.OrderProduct, len(r.Products))
+	for i, p := range r.Products {
+		items[i] = contracts.OrderProduct{Sku: p.Sku, Quantity: p.Quantity}
+	}
+
+	return &contracts.Order{Id: r.OrderId, UserId: r.UserId, Items: items}
+}
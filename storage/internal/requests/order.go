@@ -8,6 +8,6 @@ type OrderRequest struct {
 }
 
 type OrderProduct struct {
-	Name     string `json:"name"`
+	Sku      string `json:"sku"`
 	Quantity int64  `json:"quantity"`
 }
@@ -0,0 +1,18 @@
+package requests
+
+// ProductRequest describes the product of the catalog. SKU and quantity are used only when the product is created,
+// SKU is never changed and stock of the existing product is changed by restocking and adjustments.
+type ProductRequest struct {
+	Sku  string `json:"sku"`
+	Name string `json:"name"`
+	// Variant holds options of the variant, e.g. size and colour.
+	Variant  map[string]string `json:"variant,omitempty"`
+	Cost     float64           `json:"cost"`
+	Quantity int64             `json:"quantity"`
+}
+
+// StockRequest changes stock of the product by the quantity. Reason explains the change.
+type StockRequest struct {
+	Quantity int64  `json:"quantity"`
+	Reason   string `json:"reason"`
+}
@@ -0,0 +1,145 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// CheckTimeout limits duration of each readiness check.
+const CheckTimeout = 3 * time.Second
+
+// Check reports readiness of the dependency of the service. Nil means the dependency is ready.
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// ReadinessReport is the body of the readiness probe. Checks contain "ok" or the error of each check.
+type ReadinessReport struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// Diagnostics is the HTTP server of the probes, metrics and the profiler:
+//   - /healthz responds while the process is alive;
+//   - /readyz runs the checks and responds 503 when any of them failed;
+//   - /metrics serves metrics of the default prometheus registry;
+//   - /debug/pprof/ serves runtime profiles.
+type Diagnostics struct {
+	log    *zap.SugaredLogger
+	server *http.Server
+
+	mu     sync.RWMutex
+	checks []namedCheck
+}
+
+func NewDiagnostics(log *zap.SugaredLogger, port int) *Diagnostics {
+	d := new(Diagnostics)
+	d.log = log
+	d.server = &http.Server{Addr: ":" + strconv.Itoa(port), Handler: d.Handler()}
+
+	return d
+}
+
+// AddCheck registers the readiness check. Checks may be added after the server is started.
+func (d *Diagnostics) AddCheck(name string, check Check) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.checks = append(d.checks, namedCheck{name: name, check: check})
+}
+
+func (d *Diagnostics) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", d.Healthz)
+	mux.HandleFunc("/readyz", d.Readyz)
+	mux.Handle("/metrics", promhttp.Handler())
+
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	return mux
+}
+
+// Healthz responds 200 while the process is able to serve requests.
+func (d *Diagnostics) Healthz(w http.ResponseWriter, _ *http.Request) {
+	d.respond(w, http.StatusOK, ReadinessReport{Status: `ok`})
+}
+
+// Readyz runs the checks concurrently and responds 200 when all of them passed, 503 otherwise.
+func (d *Diagnostics) Readyz(w http.ResponseWriter, r *http.Request) {
+	report := d.Ready(r.Context())
+	if report.Status != `ok` {
+		d.respond(w, http.StatusServiceUnavailable, report)
+		return
+	}
+
+	d.respond(w, http.StatusOK, report)
+}
+
+// Ready runs the checks. Status of the report is "ok" when all checks passed and "unavailable" otherwise.
+func (d *Diagnostics) Ready(ctx context.Context) ReadinessReport {
+	d.mu.RLock()
+	checks := append([]namedCheck(nil), d.checks...)
+	d.mu.RUnlock()
+
+	results := make([]error, len(checks))
+	wg := sync.WaitGroup{}
+	wg.Add(len(checks))
+	for i, c := range checks {
+		go func(i int, c namedCheck) {
+			defer wg.Done()
+
+			checkCtx, cancel := context.WithTimeout(ctx, CheckTimeout)
+			defer cancel()
+			results[i] = c.check(checkCtx)
+		}(i, c)
+	}
+	wg.Wait()
+
+	report := ReadinessReport{Status: `ok`, Checks: make(map[string]string, len(checks))}
+	for i, c := range checks {
+		if results[i] != nil {
+			report.Status = `unavailable`
+			report.Checks[c.name] = results[i].Error()
+			continue
+		}
+		report.Checks[c.name] = `ok`
+	}
+
+	return report
+}
+
+func (d *Diagnostics) respond(w http.ResponseWriter, status int, report ReadinessReport) {
+	w.Header().Set(`Content-Type`, `application/json`)
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		d.log.Errorw("failed to write diagnostics response", "err", err)
+	}
+}
+
+// Start serves the diagnostics in the background.
+func (d *Diagnostics) Start() {
+	go func() {
+		if err := d.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			d.log.Errorw("diagnostics server failed", "addr", d.server.Addr, "err", err)
+		}
+	}()
+}
+
+// Shutdown stops the server gracefully, waiting for the active requests.
+func (d *Diagnostics) Shutdown(ctx context.Context) error {
+	return d.server.Shutdown(ctx)
+}
@@ -0,0 +1,12 @@
+module eCommerce/telemetry
+
+go 1.16
+
+require (
+	github.com/prometheus/client_golang v1.12.1
+	go.opentelemetry.io/otel v1.4.1
+	go.opentelemetry.io/otel/exporters/stdout/stdouttrace v1.4.1
+	go.opentelemetry.io/otel/sdk v1.4.1
+	go.opentelemetry.io/otel/trace v1.4.1
+	go.uber.org/zap v1.20.0
+)
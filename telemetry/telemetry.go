@@ -0,0 +1,112 @@
+// Package telemetry configures OpenTelemetry tracing and diagnostics of the services. Spans of the HTTP handlers,
+// messages and mongodb operations are recorded by the global tracer provider installed by Setup.
+// Diagnostics server exposes health and readiness probes, prometheus metrics and the profiler on a separate port.
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"io"
+	"os"
+)
+
+// Exporters of the spans.
+const (
+	// ExporterNone records spans without exporting them, so trace ids are still propagated and logged.
+	ExporterNone   = `none`
+	ExporterStdout = `stdout`
+	// ExporterFile appends spans to the file as JSON.
+	ExporterFile = `file`
+)
+
+var ErrUnknownExporter = errors.New(`unknown trace exporter`)
+
+type Config struct {
+	ServiceName string
+	Exporter    string
+	// File is the path of the file exporter.
+	File string
+}
+
+// Shutdown flushes recorded spans and closes the exporter.
+type Shutdown func(ctx context.Context) error
+
+// Setup installs the tracer provider of the service and W3C trace context propagator as the global ones.
+func Setup(cfg Config) (Shutdown, error) {
+	var out io.WriteCloser
+	switch cfg.Exporter {
+	case ExporterNone:
+		return install(sdktrace.NewTracerProvider(sdktrace.WithResource(serviceResource(cfg.ServiceName))), nil), nil
+	case ExporterStdout:
+		out = nopCloser{os.Stdout}
+	case ExporterFile:
+		file, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		out = file
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownExporter, cfg.Exporter)
+	}
+
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(out))
+	if err != nil {
+		_ = out.Close()
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(serviceResource(cfg.ServiceName)),
+	)
+
+	return install(provider, out), nil
+}
+
+func install(provider *sdktrace.TracerProvider, out io.Closer) Shutdown {
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if out != nil {
+			if closeErr := out.Close(); err == nil {
+				err = closeErr
+			}
+		}
+
+		return err
+	}
+}
+
+func serviceResource(name string) *resource.Resource {
+	return resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(name))
+}
+
+// Logger returns the logger with trace and span ids of the context,
+// so log lines of all services processing the same order are correlated by the trace.
+func Logger(ctx context.Context, log *zap.SugaredLogger) *zap.SugaredLogger {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return log
+	}
+
+	return log.With("trace_id", sc.TraceID().String(), "span_id", sc.SpanID().String())
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,90 @@
+// Package inprocess runs the wallet service inside the current process on top of the given message bus
+// with wallets kept in memory. It is used to test the order saga without external services.
+// Results of the commands are encoded with the given codec.
+package inprocess
+
+import (
+	"context"
+	"eCommerce/contracts"
+	"eCommerce/messaging"
+	"eCommerce/wallet/internal/consumers"
+	"eCommerce/wallet/internal/core"
+	"eCommerce/wallet/internal/data"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+	"time"
+)
+
+type Wallet struct {
+	repository    *data.MemoryWalletRepository
+	userConsumer  *consumers.UserConsumer
+	orderConsumer *consumers.OrderConsumer
+}
+
+func New(log *zap.SugaredLogger, bus messaging.Bus, codec contracts.Codec) (*Wallet, error) {
+	w := new(Wallet)
+	w.repository = data.NewMemoryWalletRepository()
+	controller := core.NewWalletController(context.Background(), log, w.repository, bus, codec)
+	// Payment delivered before the command creating the wallet is retried until the wallet is created.
+	policy := messaging.RetryPolicy{
+		MaxAttempts: 10,
+		MinBackoff:  time.Millisecond,
+		MaxBackoff:  100 * time.Millisecond,
+	}
+	pool := messaging.PoolConfig{
+		Workers:   4,
+		QueueSize: 8,
+	}
+
+	var err error
+	w.userConsumer, err = consumers.NewUserConsumer(context.Background(), log, bus, controller, policy, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	w.orderConsumer, err = consumers.NewOrderConsumer(context.Background(), log, bus, controller, policy, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// Balance returns balance of the user wallet. User id is the hex representation of the user identifier.
+func (w *Wallet) Balance(userId string) (float64, error) {
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return 0, err
+	}
+
+	wallet, err := w.repository.FindUserWallet(id)
+	if err != nil {
+		return 0, err
+	}
+
+	return wallet.Balance, nil
+}
+
+func (w *Wallet) Start() {
+	w.userConsumer.Start()
+	w.orderConsumer.Start()
+}
+
+// Ping reports readiness of the wallet and order consumers.
+func (w *Wallet) Ping(ctx context.Context) error {
+	if err := w.userConsumer.Ping(ctx); err != nil {
+		return err
+	}
+
+	return w.orderConsumer.Ping(ctx)
+}
+
+// Stop drains the consumers, waiting for the commands being processed until the context is done.
+func (w *Wallet) Stop(ctx context.Context) error {
+	err := w.userConsumer.Stop(ctx)
+	if orderErr := w.orderConsumer.Stop(ctx); orderErr != nil && err == nil {
+		err = orderErr
+	}
+
+	return err
+}
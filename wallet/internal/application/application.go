@@ -2,13 +2,16 @@ package application
 
 import (
 	"context"
+	"eCommerce/contracts"
+	"eCommerce/messaging"
+	"eCommerce/telemetry"
 	"eCommerce/wallet/internal/consumers"
 	"eCommerce/wallet/internal/core"
+	"eCommerce/wallet/internal/data"
 	"go.uber.org/zap"
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 )
 
 type App struct {
@@ -20,6 +23,10 @@ type App struct {
 	resource      *WalletResources
 	userConsumer  *consumers.UserConsumer
 	orderConsumer *consumers.OrderConsumer
+	// topics are declared by the service and ensured on startup.
+	topics *messaging.Topics
+	// diagnostics serves health and readiness probes on the diagnostic port.
+	diagnostics *telemetry.Diagnostics
 }
 
 func New() *App {
@@ -35,9 +42,60 @@ func New() *App {
 
 func (a *App) Build() {
 	a.resource = NewWalletResources(a.ctx, a.log, a.cfg).Initialize()
-	controller := core.NewWalletController(a.ctx, a.log, a.resource.Database, a.resource.KafkaProducer)
-	a.userConsumer = consumers.NewUserConsumer(a.ctx, a.log, a.cfg.KafkaConnectionUrl, controller)
-	a.orderConsumer = consumers.NewOrderConsumer(a.ctx, a.log, a.cfg.KafkaConnectionUrl, controller)
+	repository := data.NewMongoWalletRepository(a.resource.Database)
+	if err := repository.EnsureIndexes(a.ctx); err != nil {
+		a.log.Fatal(err)
+	}
+	codec, err := contracts.NewCodec(a.cfg.MessageCodec)
+	if err != nil {
+		a.log.Fatal(err)
+	}
+	controller := core.NewWalletController(a.ctx, a.log, repository, a.resource.Bus, codec)
+	policy := messaging.RetryPolicy{
+		MaxAttempts: a.cfg.ConsumerMaxAttempts,
+		MinBackoff:  a.cfg.ConsumerMinBackoff,
+		MaxBackoff:  a.cfg.ConsumerMaxBackoff,
+	}
+	pool := messaging.PoolConfig{
+		Workers:   a.cfg.ConsumerWorkers,
+		QueueSize: a.cfg.ConsumerQueueSize,
+	}
+
+	a.topics = messaging.NewTopics(a.resource.Bus, messaging.TopicConfig{
+		Partitions:          a.cfg.TopicPartitions,
+		ReplicationFactor:   a.cfg.TopicReplicationFactor,
+		Retention:           a.cfg.TopicRetention,
+		DeadLetterRetention: a.cfg.TopicDeadLetterRetention,
+	}.Declare(consumers.ProducedTopics, consumers.ConsumedTopics))
+	a.ensureTopics()
+
+	a.userConsumer, err = consumers.NewUserConsumer(a.ctx, a.log, a.resource.Bus, controller, policy, pool)
+	if err != nil {
+		a.log.Fatal(err)
+	}
+
+	a.orderConsumer, err = consumers.NewOrderConsumer(a.ctx, a.log, a.resource.Bus, controller, policy, pool)
+	if err != nil {
+		a.log.Fatal(err)
+	}
+
+	a.diagnostics = telemetry.NewDiagnostics(a.log, a.cfg.DiagnosticPort)
+	a.diagnostics.AddCheck("mongodb", a.resource.PingMongoDB)
+	a.diagnostics.AddCheck("bus", a.resource.PingBus)
+	a.diagnostics.AddCheck("user-consumer", a.userConsumer.Ping)
+	a.diagnostics.AddCheck("order-consumer", a.orderConsumer.Ping)
+	a.diagnostics.AddCheck("topics", a.topics.Ping)
+}
+
+// ensureTopics creates missing topics of the service. Service is started anyway, the topics are ensured again
+// by the readiness probe until the broker configuration matches the declaration.
+func (a *App) ensureTopics() {
+	ctx, cancel := context.WithTimeout(a.ctx, telemetry.CheckTimeout)
+	defer cancel()
+
+	if err := a.topics.Ensure(ctx); err != nil {
+		a.log.Errorw("Failed to ensure topics, the service is not ready.", "err", err)
+	}
 }
 
 func (a *App) Run() {
@@ -49,6 +107,7 @@ func (a *App) Run() {
 	}(a.log)
 
 	a.log.Info("Starting the wallet service...")
+	a.diagnostics.Start()
 	a.userConsumer.Start()
 	a.orderConsumer.Start()
 
@@ -63,16 +122,24 @@ func (a *App) Run() {
 
 	a.log.Info("Stopping the app...")
 
-	if err := a.userConsumer.Stop(); err != nil {
-		a.log.Error("Got an error while stopping the business logic server.", "err", err)
+	// Consumers finish the commands being processed within the shutdown timeout, the rest are delivered again.
+	timeout, cancel := context.WithTimeout(context.Background(), a.cfg.ShutdownTimeout)
+	defer cancel()
+
+	if err := a.userConsumer.Stop(timeout); err != nil {
+		a.log.Error("Got an error while stopping the user consumer.", "err", err)
 	}
 
-	if err := a.orderConsumer.Stop(); err != nil {
-		a.log.Error("Got an error while stopping the business logic server.", "err", err)
+	if err := a.orderConsumer.Stop(timeout); err != nil {
+		a.log.Error("Got an error while stopping the order consumer.", "err", err)
 	}
 
-	timeout, _ := context.WithTimeout(context.Background(), 10*time.Second)
+	a.cancelCtx()
 	a.resource.Release(timeout)
 
+	if err := a.diagnostics.Shutdown(timeout); err != nil {
+		a.log.Error("Got an error while stopping the diagnostics server.", "err", err)
+	}
+
 	a.log.Info("The app is calling the last defers and will be stopped.")
 }
@@ -2,20 +2,25 @@ package application
 
 import (
 	"context"
-	"github.com/segmentio/kafka-go"
+	"eCommerce/messaging"
+	"eCommerce/telemetry"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo"
 	"go.uber.org/zap"
 	"sync"
 )
 
 type WalletResources struct {
-	Cfg           *Config
-	Ctx           context.Context
-	Log           *zap.SugaredLogger
-	Mongo         *mongo.Client
-	Database      *mongo.Database
-	KafkaProducer *kafka.Writer
+	Cfg      *Config
+	Ctx      context.Context
+	Log      *zap.SugaredLogger
+	Mongo    *mongo.Client
+	Database *mongo.Database
+	Bus      messaging.Bus
+
+	shutdownTracing telemetry.Shutdown
 }
 
 func NewWalletResources(ctx context.Context, log *zap.SugaredLogger, cfg *Config) *WalletResources {
@@ -29,8 +34,9 @@ func NewWalletResources(ctx context.Context, log *zap.SugaredLogger, cfg *Config
 }
 
 func (w *WalletResources) Initialize() *WalletResources {
+	w.InitializeTracing()
 
-	uri := options.Client().ApplyURI(w.Cfg.MongoConnectionUrl)
+	uri := options.Client().ApplyURI(w.Cfg.MongoConnectionUrl).SetMonitor(otelmongo.NewMonitor())
 	c, err := mongo.Connect(w.Ctx, uri)
 	if err != nil {
 		w.Log.Fatal(err)
@@ -42,14 +48,52 @@ func (w *WalletResources) Initialize() *WalletResources {
 	}
 
 	w.Database = c.Database(ServiceName)
-	w.KafkaProducer = &kafka.Writer{
-		Addr:     kafka.TCP(w.Cfg.KafkaConnectionUrl),
-		Balancer: new(kafka.LeastBytes),
-	}
+	w.Bus = w.InitializeBus()
 
 	return w
 }
 
+// InitializeTracing installs the tracer provider which exports spans of the service to the configured exporter.
+func (w *WalletResources) InitializeTracing() {
+	shutdown, err := telemetry.Setup(telemetry.Config{
+		ServiceName: w.Cfg.ServiceName,
+		Exporter:    w.Cfg.TraceExporter,
+		File:        w.Cfg.TraceFile,
+	})
+	if err != nil {
+		w.Log.Fatal(err)
+	}
+
+	w.shutdownTracing = shutdown
+}
+
+// InitializeBus creates the message bus. In-memory bus is useful for local runs without kafka.
+func (w *WalletResources) InitializeBus() messaging.Bus {
+	switch w.Cfg.MessageBus {
+	case MessageBusKafka:
+		return messaging.NewKafkaBus(w.Cfg.KafkaConnectionUrl)
+	case MessageBusMemory:
+		return messaging.NewMemoryBus(w.Cfg.MemoryBusPartitions)
+	}
+
+	w.Log.Fatalf("unknown message bus %q", w.Cfg.MessageBus)
+	return nil
+}
+
+// PingMongoDB checks connectivity with the primary of the replica set, which serves the transactions.
+func (w *WalletResources) PingMongoDB(ctx context.Context) error {
+	return w.Database.Client().Ping(ctx, readpref.Primary())
+}
+
+// PingBus checks connectivity of the bus publisher with the broker.
+func (w *WalletResources) PingBus(ctx context.Context) error {
+	if pinger, ok := w.Bus.(messaging.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+
+	return nil
+}
+
 func (w *WalletResources) Release(ctx context.Context) {
 	wg := sync.WaitGroup{}
 	wg.Add(2)
@@ -63,10 +107,14 @@ func (w *WalletResources) Release(ctx context.Context) {
 
 	go func(ctx context.Context, wg *sync.WaitGroup) {
 		defer wg.Done()
-		if err := w.KafkaProducer.Close(); err != nil {
-			w.Log.Error("Got an error while releasing kafka resources.", "err", err)
+		if err := w.Bus.Close(); err != nil {
+			w.Log.Error("Got an error while releasing message bus resources.", "err", err)
 		}
 	}(ctx, &wg)
 
 	wg.Wait()
+
+	if err := w.shutdownTracing(ctx); err != nil {
+		w.Log.Error("Got an error while flushing traces.", "err", err)
+	}
 }
@@ -2,84 +2,100 @@ package consumers
 
 import (
 	"context"
+	"eCommerce/contracts"
+	"eCommerce/messaging"
 	"eCommerce/wallet/internal/core"
 	"eCommerce/wallet/internal/models"
-	"encoding/json"
-	"github.com/segmentio/kafka-go"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.uber.org/zap"
-	"io"
-	"log"
 )
 
 const (
-	PayTopic = `wallet-pay-order`
-	PayGroup = `wallet-pay-order-group`
+	PayGroup    = `wallet-pay-order-group`
+	CancelGroup = `wallet-cancel-order-group`
 )
 
-// OrderConsumer for the user related events
+// OrderConsumer for the order related events
 type OrderConsumer struct {
-	ctx    context.Context
-	log    *zap.SugaredLogger
-	wallet *core.WalletController
-	writer *kafka.Writer
-	reader *kafka.Reader
+	ctx          context.Context
+	log          *zap.SugaredLogger
+	wallet       *core.WalletController
+	reader       messaging.Reader
+	cancelReader messaging.Reader
+
+	payConsumer    *messaging.Consumer
+	cancelConsumer *messaging.Consumer
 }
 
-func NewOrderConsumer(ctx context.Context, log *zap.SugaredLogger, kafkaAddr string, wallet *core.WalletController) *OrderConsumer {
+// NewOrderConsumer subscribes to the order payment commands. Commands which are failed after all attempts of the
+// policy are moved to the dead letter topics of the bus.
+func NewOrderConsumer(ctx context.Context, log *zap.SugaredLogger, bus messaging.Bus, wallet *core.WalletController, policy messaging.RetryPolicy, pool messaging.PoolConfig) (*OrderConsumer, error) {
 	consumer := new(OrderConsumer)
 	consumer.ctx = ctx
 	consumer.log = log
 	consumer.wallet = wallet
 
-	cfg := ReaderConfig(kafkaAddr, PayTopic, PayGroup)
-	consumer.reader = kafka.NewReader(cfg)
+	var err error
+	consumer.reader, err = bus.Subscribe(contracts.WalletPayOrderTopic, PayGroup)
+	if err != nil {
+		return nil, err
+	}
+
+	consumer.cancelReader, err = bus.Subscribe(contracts.WalletCancelOrderTopic, CancelGroup)
+	if err != nil {
+		return nil, err
+	}
+
+	consumer.payConsumer = messaging.NewConsumer(log, consumer.reader, bus, func(ctx context.Context, m *messaging.Message) error {
+		_, err := consumer.ReserveCredit(ctx, m)
+		return err
+	}, policy, pool)
+	consumer.cancelConsumer = messaging.NewConsumer(log, consumer.cancelReader, bus, consumer.CancelOrderTransaction, policy, pool)
 
-	return consumer
+	return consumer, nil
 }
 
 func (c *OrderConsumer) Start() {
-	go func() {
-		for {
-			m, err := c.reader.ReadMessage(context.Background())
-			if err != nil {
-				if err != io.EOF {
-					c.log.Error(`read message err: `, err)
-				}
-				continue
-			}
-
-			c.log.Info(`read message `, string(m.Key), m.Offset)
-			_, err = c.ReserveCredit(m)
-			if err != nil {
-				continue
-			}
-		}
-	}()
+	go c.payConsumer.Run(c.ctx)
+	go c.cancelConsumer.Run(c.ctx)
 }
 
-func (c *OrderConsumer) Stop() error {
-	if err := c.reader.Close(); err != nil {
-		log.Fatal("failed to close reader:", err)
+// Ping reports readiness of the payment and cancellation consumers.
+func (c *OrderConsumer) Ping(ctx context.Context) error {
+	if err := c.payConsumer.Ping(ctx); err != nil {
 		return err
 	}
 
-	return nil
+	return c.cancelConsumer.Ping(ctx)
+}
+
+// Stop stops fetching commands, waits for the commands being processed until the context is done and closes
+// the readers. Offsets of the processed commands are committed before the readers are closed.
+func (c *OrderConsumer) Stop(ctx context.Context) error {
+	err := messaging.StopConsumers(ctx, c.payConsumer, c.cancelConsumer)
+
+	for _, reader := range []messaging.Reader{c.reader, c.cancelReader} {
+		if closeErr := reader.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
 }
 
 // ReserveCredit event creates credit reservation for the customer.
-func (c *OrderConsumer) ReserveCredit(message kafka.Message) (*models.Transaction, error) {
-	order, err := ParseOrder(message)
+func (c *OrderConsumer) ReserveCredit(ctx context.Context, message *messaging.Message) (*models.Transaction, error) {
+	command, order, err := ParseOrder(message)
 	if err != nil {
-		return nil, err
+		return nil, messaging.Permanent(err)
 	}
 
 	key, err := primitive.ObjectIDFromHex(string(message.Key))
 	if err != nil {
-		return nil, err
+		return nil, messaging.Permanent(err)
 	}
 
-	payment, err := c.wallet.PayOrder(key, order)
+	payment, err := c.wallet.PayOrder(ctx, key, command, order)
 	if err != nil {
 		return nil, err
 	}
@@ -87,14 +103,14 @@ func (c *OrderConsumer) ReserveCredit(message kafka.Message) (*models.Transactio
 	return payment, nil
 }
 
-// CancelOrderTransaction commits reservation.
-func (c *OrderConsumer) CancelOrderTransaction(message kafka.Message) error {
-	order, err := ParseOrder(message)
+// CancelOrderTransaction refunds order payment to the customer wallet.
+func (c *OrderConsumer) CancelOrderTransaction(ctx context.Context, message *messaging.Message) error {
+	command, order, err := ParseOrder(message)
 	if err != nil {
-		return err
+		return messaging.Permanent(err)
 	}
 
-	_, err = c.wallet.CancelOrder(order)
+	_, err = c.wallet.CancelOrder(ctx, command, order)
 	if err != nil {
 		return err
 	}
@@ -102,13 +118,20 @@ func (c *OrderConsumer) CancelOrderTransaction(message kafka.Message) error {
 	return nil
 }
 
-func ParseOrder(message kafka.Message) (*models.Order, error) {
-	order := new(models.Order)
-	err := json.Unmarshal(message.Value, order)
+// ParseOrder decodes the envelope of the command and the order in its payload.
+// Codec of the envelope is selected by the content type header.
+func ParseOrder(message *messaging.Message) (*contracts.Envelope, *contracts.Order, error) {
+	contentType, _ := message.Header(contracts.HeaderContentType)
 
+	e, err := contracts.Decode(message.Topic, string(contentType), message.Value)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	order := new(contracts.Order)
+	if err = e.Unmarshal(order); err != nil {
+		return nil, nil, err
 	}
 
-	return order, nil
+	return e, order, nil
 }
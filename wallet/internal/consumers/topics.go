@@ -0,0 +1,9 @@
+package consumers
+
+import "eCommerce/contracts"
+
+// Topics declared by the wallet. Topics are ensured on startup, consumed topics are declared with dead letter topics.
+var (
+	ConsumedTopics = []string{contracts.WalletCreateTopic, contracts.WalletPayOrderTopic, contracts.WalletCancelOrderTopic}
+	ProducedTopics = []string{contracts.WalletCreateResponseTopic, contracts.WalletPayOrderResponseTopic, contracts.WalletCancelOrderResponseTopic}
+)
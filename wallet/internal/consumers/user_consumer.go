@@ -2,43 +2,59 @@ package consumers
 
 import (
 	"context"
+	"eCommerce/contracts"
+	"eCommerce/messaging"
 	"eCommerce/wallet/internal/core"
 	"eCommerce/wallet/internal/models"
-	"encoding/json"
-	"github.com/segmentio/kafka-go"
 	"go.uber.org/zap"
-	"log"
 )
 
+const WalletGroup = `wallet-create-group`
+
 // UserConsumer for the user related events
 type UserConsumer struct {
 	ctx    context.Context
 	log    *zap.SugaredLogger
 	wallet *core.WalletController
-	reader *kafka.Reader
+	reader messaging.Reader
+
+	consumer *messaging.Consumer
 }
 
-func NewUserConsumer(ctx context.Context, log *zap.SugaredLogger, kafkaAddr string, wallet *core.WalletController) *UserConsumer {
+func NewUserConsumer(ctx context.Context, log *zap.SugaredLogger, bus messaging.Bus, wallet *core.WalletController, policy messaging.RetryPolicy, pool messaging.PoolConfig) (*UserConsumer, error) {
 	consumer := new(UserConsumer)
 	consumer.ctx = ctx
 	consumer.log = log
 	consumer.wallet = wallet
 
-	readerConfig := ReaderConfig(kafkaAddr, models.WalletTopic, models.WalletGroup)
-	consumer.reader = kafka.NewReader(readerConfig)
+	reader, err := bus.Subscribe(contracts.WalletCreateTopic, WalletGroup)
+	if err != nil {
+		return nil, err
+	}
+	consumer.reader = reader
+	consumer.consumer = messaging.NewConsumer(log, reader, bus, func(ctx context.Context, m *messaging.Message) error {
+		_, err := consumer.NewWallet(ctx, m)
+		return err
+	}, policy, pool)
 
-	return consumer
+	return consumer, nil
 }
 
 // NewWallet creates new wallet for the customer and initialize balance with some bonus.
-func (u *UserConsumer) NewWallet(msg kafka.Message) (*models.Wallet, error) {
-	user := new(models.User)
-	err := json.Unmarshal(msg.Value, user)
+func (u *UserConsumer) NewWallet(ctx context.Context, msg *messaging.Message) (*models.Wallet, error) {
+	contentType, _ := msg.Header(contracts.HeaderContentType)
+
+	command, err := contracts.Decode(msg.Topic, string(contentType), msg.Value)
 	if err != nil {
-		return nil, err
+		return nil, messaging.Permanent(err)
+	}
+
+	user := new(contracts.User)
+	if err = command.Unmarshal(user); err != nil {
+		return nil, messaging.Permanent(err)
 	}
 
-	wallet, err := u.wallet.CreateNewWallet(user)
+	wallet, err := u.wallet.CreateNewWallet(ctx, command, user)
 	if err != nil {
 		return nil, err
 	}
@@ -47,26 +63,21 @@ func (u *UserConsumer) NewWallet(msg kafka.Message) (*models.Wallet, error) {
 }
 
 func (u *UserConsumer) Start() {
-	go func() {
-		for {
-			m, err := u.reader.ReadMessage(u.ctx)
-			if err != nil {
-				continue
-			}
-
-			_, err = u.NewWallet(m)
-			if err != nil {
-				continue
-			}
-		}
-	}()
+	go u.consumer.Run(u.ctx)
 }
 
-func (u *UserConsumer) Stop() error {
-	if err := u.reader.Close(); err != nil {
-		log.Fatal("failed to close reader:", err)
-		return err
+// Ping reports readiness of the wallet creation consumer.
+func (u *UserConsumer) Ping(ctx context.Context) error {
+	return u.consumer.Ping(ctx)
+}
+
+// Stop stops fetching commands, waits for the command being processed until the context is done and closes
+// the reader.
+func (u *UserConsumer) Stop(ctx context.Context) error {
+	err := u.consumer.Stop(ctx)
+	if closeErr := u.reader.Close(); closeErr != nil && err == nil {
+		err = closeErr
 	}
 
-	return nil
+	return err
 }
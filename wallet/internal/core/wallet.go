@@ -2,44 +2,97 @@ package core
 
 import (
 	"context"
+	"eCommerce/contracts"
+	"eCommerce/messaging"
+	"eCommerce/telemetry"
+	"eCommerce/wallet/internal/data"
+	"eCommerce/wallet/internal/metrics"
 	"eCommerce/wallet/internal/models"
-	"encoding/json"
 	"errors"
-	"github.com/segmentio/kafka-go"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.uber.org/zap"
 	"time"
 )
 
+// Errors rejecting the order command. They are sent to the registry in the response instead of being retried.
+var (
+	ErrNilOrder        = errors.New(`argument 'order' is nil`)
+	ErrNotEnoughFunds  = errors.New(`there are not enough funds on the wallet`)
+	ErrNoActivePayment = errors.New(`there is no active payment for the order`)
+)
+
+// ErrWalletNotFound is retried: command of the order may be delivered before the command creating the wallet.
+var ErrWalletNotFound = errors.New(`wallet of the customer is not found`)
+
+// rejectionReason returns the label of the rejection for the metrics.
+func rejectionReason(err error) string {
+	switch {
+	case errors.Is(err, ErrNilOrder):
+		return `nil_order`
+	case errors.Is(err, ErrNotEnoughFunds):
+		return `not_enough_funds`
+	case errors.Is(err, ErrNoActivePayment):
+		return `no_active_payment`
+	}
+
+	return ""
+}
+
+func isRejection(err error) bool {
+	return errors.Is(err, ErrNilOrder) ||
+		errors.Is(err, ErrNotEnoughFunds) ||
+		errors.Is(err, ErrNoActivePayment)
+}
+
 type WalletService interface {
-	CreateNewWallet(user *models.User) (*models.Wallet, error)
-	PayOrder(order *models.Order) (*models.Transaction, error)
-	CancelOrder(order *models.Order) (*models.Transaction, error)
+	CreateNewWallet(ctx context.Context, command *contracts.Envelope, user *contracts.User) (*models.Wallet, error)
+	PayOrder(ctx context.Context, key primitive.ObjectID, command *contracts.Envelope, order *contracts.Order) (*models.Transaction, error)
+	CancelOrder(ctx context.Context, command *contracts.Envelope, order *contracts.Order) (*models.Transaction, error)
 }
 
 type WalletController struct {
-	ctx     context.Context
-	log     *zap.SugaredLogger
-	wallets *mongo.Collection
-	//transactions *mongo.Collection
-	producer *kafka.Writer
+	ctx      context.Context
+	log      *zap.SugaredLogger
+	wallets  data.WalletRepository
+	producer messaging.Publisher
+	codec    contracts.Codec
 }
 
-func NewWalletController(ctx context.Context, log *zap.SugaredLogger, db *mongo.Database, producer *kafka.Writer) *WalletController {
+// NewWalletController returns the controller which publishes results of the commands encoded with the codec.
+func NewWalletController(ctx context.Context, log *zap.SugaredLogger, wallets data.WalletRepository, producer messaging.Publisher, codec contracts.Codec) *WalletController {
 	wallet := new(WalletController)
 
 	wallet.ctx = ctx
 	wallet.log = log
-	wallet.wallets = db.Collection(`wallets`)
+	wallet.wallets = wallets
 	wallet.producer = producer
+	wallet.codec = codec
 
 	return wallet
 }
 
-func (w *WalletController) CreateNewWallet(user *models.User) (*models.Wallet, error) {
+// CreateNewWallet creates the wallet of the customer with the bonus and publishes it.
+// Wallet of the redelivered command is not created again, the same response is published instead.
+func (w *WalletController) CreateNewWallet(ctx context.Context, command *contracts.Envelope, user *contracts.User) (*models.Wallet, error) {
+	var wallet *models.Wallet
+	response, err := w.process(ctx, user.Id, models.CreateWalletCommand, func(uow data.WalletRepository) (*Response, error) {
+		var err error
+		if wallet, err = uow.InsertWallet(NewCustomerWallet(user)); err != nil {
+			return nil, err
+		}
+
+		created := contracts.Wallet{Id: wallet.Id, UserId: wallet.UserId, Balance: wallet.Balance}
+		return NewResult(w.codec, command, contracts.CreateWalletResult, created, `wallet created`, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return wallet, w.respond(ctx, contracts.WalletCreateResponseTopic, user.Id, response)
+}
+
+func NewCustomerWallet(user *contracts.User) *models.Wallet {
 	wallet := new(models.Wallet)
 	wallet.UserId = user.Id
 	wallet.UserName = user.Name
@@ -55,85 +108,61 @@ func (w *WalletController) CreateNewWallet(user *models.User) (*models.Wallet, e
 		},
 	}
 
-	single, err := w.wallets.InsertOne(context.Background(), wallet)
-	if err != nil {
-		return nil, err
-	}
-
-	wallet.Id = single.InsertedID.(primitive.ObjectID)
+	return wallet
+}
 
-	value, err := json.Marshal(wallet)
-	if err != nil {
-		w.log.Error(err)
-	}
+// PayOrder charges the customer wallet and publishes the result. Rejected payment is a result too, so it is
+// published with nil transaction and error, only failures of the repository or the bus are returned to be retried.
+// Transaction is nil as well when the command is redelivered and the recorded result is published again.
+func (w *WalletController) PayOrder(ctx context.Context, key primitive.ObjectID, command *contracts.Envelope, order *contracts.Order) (*models.Transaction, error) {
+	var transaction *models.Transaction
+	var processed bool
+	var reason string
+	response, err := w.process(ctx, key, models.PayOrderCommand, func(uow data.WalletRepository) (*Response, error) {
+		var err error
+		transaction, err = payOrder(uow, order)
+		if isRejection(err) {
+			telemetry.Logger(ctx, w.log).Warnw("order payment rejected", "order", key.Hex(), "reason", err)
+			processed, reason = true, rejectionReason(err)
+			return NewResult(w.codec, command, contracts.PayOrderResult, order, "", err)
+		}
+		if err != nil {
+			return nil, err
+		}
 
-	err = w.producer.WriteMessages(context.Background(), kafka.Message{
-		Key:   []byte(user.Id.Hex()),
-		Value: value,
-		Topic: models.WalletResponseTopic,
+		processed, reason = true, ""
+		return NewResult(w.codec, command, contracts.PayOrderResult, order, `payment successful`, nil)
 	})
 	if err != nil {
-		w.log.Error(err)
+		return nil, err
+	}
+	// Redelivered command is answered from the inbox and is not counted again.
+	if processed {
+		metrics.ObservePayment(metrics.OperationPay, reason)
 	}
 
-	return wallet, nil
+	return transaction, w.respond(ctx, contracts.WalletPayOrderResponseTopic, key, response)
 }
 
-func (w *WalletController) PayOrder(key primitive.ObjectID, order *models.Order) (*models.Transaction, error) {
-	response := new(Response)
-	defer func() {
-		message, err := response.Marshal()
-		if err != nil {
-			w.log.Error(err)
-			message = []byte(`marshaling error`)
-		}
-		err = w.producer.WriteMessages(context.Background(), kafka.Message{
-			Key:   []byte(key.Hex()),
-			Value: message,
-			Topic: models.WalletPayOrderResponseTopic,
-			Headers: []kafka.Header{
-				{Key: `status`, Value: response.StatusHeader()},
-				{Key: `message`, Value: []byte(response.Message)},
-			},
-		})
-		if err != nil {
-			w.log.Error(err)
-		}
-	}()
-
+func payOrder(uow data.WalletRepository, order *contracts.Order) (*models.Transaction, error) {
 	if order == nil {
-		err := errors.New(`argument 'order' is nil`)
-		response = NewError(err, order)
-		return nil, err
+		return nil, ErrNilOrder
 	}
 
-	wallet, err := w.findUserWallet(order.UserId)
+	wallet, err := findUserWallet(uow, order.UserId)
 	if err != nil {
-		response = NewError(err, order)
 		return nil, err
 	}
 
 	if wallet.Balance < order.Amount {
-		err = errors.New("there are not enough funds on the wallet")
-		response = NewError(err, order)
-		return nil, err
+		return nil, ErrNotEnoughFunds
 	}
 
 	transaction := NewOrderPayment(order.Id, order.Amount, wallet.Balance)
-	filter := bson.D{{"_id", wallet.Id}}
-	update := bson.D{
-		{"$push", bson.M{`transactions`: transaction}},
-		{"$set", bson.M{`balance`: transaction.Balance}},
-	}
-	option := options.FindOneAndUpdate().SetReturnDocument(options.After)
-	single := w.wallets.FindOneAndUpdate(context.Background(), filter, update, option)
-	if err = single.Err(); err != nil {
-		response = NewError(err, order)
+	if err = uow.PushTransaction(wallet, transaction); err != nil {
 		return nil, err
 	}
 
-	response = NewSuccess(`payment successful`, order)
-
 	return transaction, nil
 }
 
@@ -149,72 +178,102 @@ func NewOrderPayment(orderId primitive.ObjectID, amount, balance float64) *model
 	return transaction
 }
 
-func (w *WalletController) CancelOrder(order *models.Order) (*models.Transaction, error) {
-	response := new(Response)
-	defer func() {
-		message, err := response.Marshal()
-		if err != nil {
-			w.log.Error(err)
-			message = []byte(`marshaling error`)
+// CancelOrder refunds the order payment and publishes the result. Like PayOrder it returns only failures
+// which have to be retried.
+func (w *WalletController) CancelOrder(ctx context.Context, command *contracts.Envelope, order *contracts.Order) (*models.Transaction, error) {
+	var revert *models.Transaction
+	var processed bool
+	var reason string
+	response, err := w.process(ctx, order.Id, models.CancelOrderCommand, func(uow data.WalletRepository) (*Response, error) {
+		var err error
+		revert, err = cancelOrder(uow, order)
+		if isRejection(err) {
+			telemetry.Logger(ctx, w.log).Warnw("order payment cancellation rejected", "order", order.Id.Hex(), "reason", err)
+			processed, reason = true, rejectionReason(err)
+			return NewResult(w.codec, command, contracts.CancelPaymentResult, order, "", err)
 		}
-		err = w.producer.WriteMessages(context.Background(), kafka.Message{
-			Key:   []byte(order.Id.Hex()),
-			Value: message,
-			Topic: models.WalletCancelOrderResponseTopic,
-			Headers: []kafka.Header{
-				{Key: `status`, Value: response.StatusHeader()},
-				{Key: `message`, Value: []byte(response.Message)},
-			},
-		})
 		if err != nil {
-			w.log.Error(err)
+			return nil, err
 		}
-	}()
 
-	wallet, err := w.findUserWallet(order.Id)
+		processed, reason = true, ""
+		return NewResult(w.codec, command, contracts.CancelPaymentResult, order, "canceled order payment", nil)
+	})
 	if err != nil {
-		response = NewError(err, order)
 		return nil, err
 	}
+	if processed {
+		metrics.ObservePayment(metrics.OperationCancel, reason)
+	}
+
+	return revert, w.respond(ctx, contracts.WalletCancelOrderResponseTopic, order.Id, response)
+}
 
-	transaction, err := w.findTransaction(order.Id)
+func cancelOrder(uow data.WalletRepository, order *contracts.Order) (*models.Transaction, error) {
+	wallet, err := findUserWallet(uow, order.UserId)
 	if err != nil {
-		response = NewError(err, order)
 		return nil, err
 	}
 
-	if wallet.Balance-transaction.Amount < 0 {
-		err = errors.New("there are not enough funds on the wallet")
-		response = NewError(err, order)
+	transaction, err := findOrderPayment(wallet, order.Id)
+	if err != nil {
 		return nil, err
 	}
 
 	revert := RevertOrderPayment(transaction, wallet.Balance)
-	filter := bson.D{
-		{"_id", order.UserId},
-		{"transactions.order_id", order.Id},
-		{"transactions.status", models.TransactionActive},
-	}
-	update := bson.D{
-		{"$push", bson.M{`transactions`: revert}},
-		{"$set", bson.M{`transactions.$.status`: models.TransactionCancelled}},
+	if err = uow.RevertTransaction(wallet, revert); err != nil {
+		return nil, err
 	}
-	option := options.FindOneAndUpdate().SetReturnDocument(options.After)
-	single := w.wallets.FindOneAndUpdate(context.Background(), filter, update, option)
-	if err = single.Err(); err != nil {
-		response = NewError(err, order)
+
+	return revert, nil
+}
+
+// process executes the command once. Response of the command is recorded in the inbox within the unit of work
+// of the command, so the redelivered command gets the recorded response without changes of the wallet.
+func (w *WalletController) process(ctx context.Context, id primitive.ObjectID, command models.Command, fn func(uow data.WalletRepository) (*Response, error)) (response *Response, err error) {
+	err = data.WithUnitOfWork(ctx, w.wallets, func(uow data.WalletRepository) error {
+		processed, err := uow.FindInboxMessage(id, command)
+		if err == nil {
+			telemetry.Logger(ctx, w.log).Infow("command is already processed", "id", id.Hex(), "command", command)
+			response = InboxResponse(processed)
+			return nil
+		}
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			return err
+		}
+
+		if response, err = fn(uow); err != nil {
+			return err
+		}
+
+		return uow.InsertInboxMessage(NewInboxMessage(id, command, response))
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	response = NewSuccess("canceled order payment", order)
+	return response, nil
+}
 
-	return revert, nil
+// respond publishes the result of the order command to the response topic.
+func (w *WalletController) respond(ctx context.Context, topic string, key primitive.ObjectID, response *Response) error {
+	return w.producer.Publish(ctx, messaging.Message{
+		Key:   []byte(key.Hex()),
+		Value: response.Payload,
+		Topic: topic,
+		Headers: []messaging.Header{
+			{Key: contracts.HeaderContentType, Value: []byte(response.ContentType)},
+			{Key: contracts.HeaderStatus, Value: response.StatusHeader()},
+			{Key: contracts.HeaderMessage, Value: []byte(response.Message)},
+		},
+	})
 }
 
 func RevertOrderPayment(transaction *models.Transaction, balance float64) *models.Transaction {
 	revert := new(models.Transaction)
+	revert.OrderId = transaction.OrderId
 	revert.Status = models.TransactionActive
-	revert.Note = `Cancel of order payment ` + transaction.Id.Hex()
+	revert.Note = `Cancel of order payment ` + transaction.OrderId.Hex()
 	revert.Amount = -transaction.Amount
 	revert.Balance = balance - transaction.Amount
 	revert.Timestamp = time.Now().UTC()
@@ -223,34 +282,25 @@ func RevertOrderPayment(transaction *models.Transaction, balance float64) *model
 }
 
 // findUserWallet fetch wallet data from database.
-func (w *WalletController) findUserWallet(id primitive.ObjectID) (*models.Wallet, error) {
-	single := w.wallets.FindOne(context.Background(), bson.M{"user_id": id})
-	if err := single.Err(); err != nil {
-		return nil, err
-	}
-
-	wallet := new(models.Wallet)
-	err := single.Decode(wallet)
-	if err != nil {
-		return nil, err
+func findUserWallet(uow data.WalletRepository, id primitive.ObjectID) (*models.Wallet, error) {
+	wallet, err := uow.FindUserWallet(id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrWalletNotFound
 	}
 
-	return wallet, nil
+	return wallet, err
 }
 
-// findTransaction fetch transaction data from database.
-func (w *WalletController) findTransaction(orderId primitive.ObjectID) (*models.Transaction, error) {
-	single := w.wallets.FindOne(context.Background(), bson.M{"order_id": orderId})
-	if err := single.Err(); err != nil {
-		return nil, err
-	}
-
-	transaction := new(models.Transaction)
-	if err := single.Decode(transaction); err != nil {
-		return nil, err
+// findOrderPayment returns active payment transaction of the order.
+func findOrderPayment(wallet *models.Wallet, orderId primitive.ObjectID) (*models.Transaction, error) {
+	for i := range wallet.Transactions {
+		t := &wallet.Transactions[i]
+		if t.OrderId == orderId && t.Status == models.TransactionActive && t.Amount < 0 {
+			return t, nil
+		}
 	}
 
-	return transaction, nil
+	return nil, ErrNoActivePayment
 }
 
 // updateTransactionStatus find a transaction and updates it status.
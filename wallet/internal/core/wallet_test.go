@@ -0,0 +1,209 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"eCommerce/contracts"
+	"eCommerce/messaging"
+	"eCommerce/wallet/internal/data"
+	"eCommerce/wallet/internal/models"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+	"sync"
+	"testing"
+)
+
+// Redelivered payment is answered with the recorded response, the wallet is charged once.
+func TestPayOrderRedelivered(t *testing.T) {
+	repository, publisher := data.NewMemoryWalletRepository(), new(recordingPublisher)
+	wallet := NewWalletController(context.Background(), zap.NewNop().Sugar(), repository, publisher, contracts.JSONCodec{})
+
+	user := createWallet(t, wallet)
+	order, command := payCommand(t, user, 30)
+
+	for i := 0; i < 2; i++ {
+		if _, err := wallet.PayOrder(context.Background(), order.Id, command, order); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	responses := publisher.published(contracts.WalletPayOrderResponseTopic)
+	if len(responses) != 2 {
+		t.Fatalf("%d responses are published, want 2", len(responses))
+	}
+	if status, _ := responses[0].Header(contracts.HeaderStatus); !contracts.IsSuccessStatus(status) {
+		message, _ := responses[0].Header(contracts.HeaderMessage)
+		t.Fatalf("payment is rejected: %s", message)
+	}
+	assertSameResponse(t, responses[0], responses[1])
+
+	assertBalance(t, repository, user, 70, 2)
+}
+
+// Payment delivered before the wallet is created is failed to be retried. Nothing is recorded in the inbox,
+// so the retried command pays the order once the wallet is created.
+func TestPayOrderWalletNotFound(t *testing.T) {
+	repository, publisher := data.NewMemoryWalletRepository(), new(recordingPublisher)
+	wallet := NewWalletController(context.Background(), zap.NewNop().Sugar(), repository, publisher, contracts.JSONCodec{})
+
+	user := &contracts.User{Id: primitive.NewObjectID()}
+	order, command := payCommand(t, user, 30)
+
+	if _, err := wallet.PayOrder(context.Background(), order.Id, command, order); !errors.Is(err, ErrWalletNotFound) {
+		t.Fatalf("pay: %v, want %v", err, ErrWalletNotFound)
+	}
+	if responses := publisher.published(contracts.WalletPayOrderResponseTopic); len(responses) != 0 {
+		t.Fatalf("%d responses are published for the missing wallet", len(responses))
+	}
+	if _, err := repository.FindInboxMessage(order.Id, models.PayOrderCommand); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Fatalf("inbox message of the retried payment: %v", err)
+	}
+
+	create, err := contracts.NewEnvelope(contracts.CreateWallet, user.Id.Hex(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = wallet.CreateNewWallet(context.Background(), create, user); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = wallet.PayOrder(context.Background(), order.Id, command, order); err != nil {
+		t.Fatal(err)
+	}
+	response := publisher.last(t, contracts.WalletPayOrderResponseTopic)
+	if status, _ := response.Header(contracts.HeaderStatus); !contracts.IsSuccessStatus(status) {
+		message, _ := response.Header(contracts.HeaderMessage)
+		t.Fatalf("payment is rejected: %s", message)
+	}
+
+	assertBalance(t, repository, user, 70, 2)
+}
+
+// Redelivered command creating the wallet does not add the bonus again.
+func TestCreateWalletRedelivered(t *testing.T) {
+	repository, publisher := data.NewMemoryWalletRepository(), new(recordingPublisher)
+	wallet := NewWalletController(context.Background(), zap.NewNop().Sugar(), repository, publisher, contracts.JSONCodec{})
+
+	user := &contracts.User{Id: primitive.NewObjectID()}
+	command, err := contracts.NewEnvelope(contracts.CreateWallet, user.Id.Hex(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err = wallet.CreateNewWallet(context.Background(), command, user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	responses := publisher.published(contracts.WalletCreateResponseTopic)
+	if len(responses) != 2 {
+		t.Fatalf("%d responses are published, want 2", len(responses))
+	}
+	assertSameResponse(t, responses[0], responses[1])
+
+	assertBalance(t, repository, user, 100, 1)
+}
+
+// createWallet creates the wallet of the new user with the bonus.
+func createWallet(t *testing.T, wallet *WalletController) *contracts.User {
+	t.Helper()
+
+	user := &contracts.User{Id: primitive.NewObjectID()}
+	command, err := contracts.NewEnvelope(contracts.CreateWallet, user.Id.Hex(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = wallet.CreateNewWallet(context.Background(), command, user); err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+// payCommand returns the new order of the user and the command to pay it.
+func payCommand(t *testing.T, user *contracts.User, amount float64) (*contracts.Order, *contracts.Envelope) {
+	t.Helper()
+
+	order := &contracts.Order{Id: primitive.NewObjectID(), UserId: user.Id, Amount: amount}
+	command, err := contracts.NewEnvelope(contracts.PayOrder, order.Id.Hex(), order)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return order, command
+}
+
+func assertBalance(t *testing.T, repository data.WalletRepository, user *contracts.User, balance float64, transactions int) {
+	t.Helper()
+
+	wallet, err := repository.FindUserWallet(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wallet.Balance != balance || len(wallet.Transactions) != transactions {
+		t.Errorf("balance %v with %d transactions, want %v with %d", wallet.Balance, len(wallet.Transactions), balance, transactions)
+	}
+}
+
+// assertSameResponse checks that the replayed response is the recorded one.
+func assertSameResponse(t *testing.T, recorded, replayed messaging.Message) {
+	t.Helper()
+
+	if !bytes.Equal(recorded.Key, replayed.Key) || !bytes.Equal(recorded.Value, replayed.Value) {
+		t.Errorf("replayed response %s, want %s", replayed.Value, recorded.Value)
+	}
+	for _, key := range []string{contracts.HeaderContentType, contracts.HeaderStatus, contracts.HeaderMessage} {
+		want, _ := recorded.Header(key)
+		if got, _ := replayed.Header(key); !bytes.Equal(got, want) {
+			t.Errorf("header %s of the replayed response %q, want %q", key, got, want)
+		}
+	}
+}
+
+// recordingPublisher keeps the published messages.
+type recordingPublisher struct {
+	mu       sync.Mutex
+	messages []messaging.Message
+}
+
+func (p *recordingPublisher) Publish(_ context.Context, messages ...messaging.Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.messages = append(p.messages, messages...)
+	return nil
+}
+
+func (p *recordingPublisher) Close() error {
+	return nil
+}
+
+// published returns the messages published to the topic.
+func (p *recordingPublisher) published(topic string) []messaging.Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var messages []messaging.Message
+	for _, m := range p.messages {
+		if m.Topic == topic {
+			messages = append(messages, m)
+		}
+	}
+
+	return messages
+}
+
+// last returns the latest message published to the topic.
+func (p *recordingPublisher) last(t *testing.T, topic string) messaging.Message {
+	t.Helper()
+
+	messages := p.published(topic)
+	if len(messages) == 0 {
+		t.Fatalf("nothing is published to %s", topic)
+	}
+
+	return messages[len(messages)-1]
+}
@@ -0,0 +1,207 @@
+package data
+
+import (
+	"context"
+	"eCommerce/wallet/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"sync"
+)
+
+// MemoryWalletRepository keeps wallets and processed commands in the process memory.
+// Unit of work holds the exclusive lock of the repository until it is committed or rolled back,
+// so units of work are serializable. Repository of the unit of work must not be used concurrently.
+type MemoryWalletRepository struct {
+	store *memoryStore
+	// tx is the working copy of the unit of work.
+	tx *memoryState
+}
+
+type memoryStore struct {
+	mu    sync.Mutex
+	state *memoryState
+}
+
+type memoryState struct {
+	wallets map[primitive.ObjectID]*models.Wallet
+	inbox   map[inboxKey]models.InboxMessage
+}
+
+type inboxKey struct {
+	orderId primitive.ObjectID
+	command models.Command
+}
+
+func NewMemoryWalletRepository() *MemoryWalletRepository {
+	r := new(MemoryWalletRepository)
+	r.store = &memoryStore{state: &memoryState{
+		wallets: make(map[primitive.ObjectID]*models.Wallet),
+		inbox:   make(map[inboxKey]models.InboxMessage),
+	}}
+
+	return r
+}
+
+func copyWallet(w *models.Wallet) *models.Wallet {
+	c := *w
+	c.Transactions = make([]models.Transaction, len(w.Transactions))
+	copy(c.Transactions, w.Transactions)
+
+	return &c
+}
+
+func (s *memoryState) copy() *memoryState {
+	c := &memoryState{
+		wallets: make(map[primitive.ObjectID]*models.Wallet, len(s.wallets)),
+		inbox:   make(map[inboxKey]models.InboxMessage, len(s.inbox)),
+	}
+	for id, w := range s.wallets {
+		c.wallets[id] = copyWallet(w)
+	}
+	for key, m := range s.inbox {
+		c.inbox[key] = m
+	}
+
+	return c
+}
+
+func (m *MemoryWalletRepository) do(fn func(s *memoryState) error) error {
+	if m.tx != nil {
+		return fn(m.tx)
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	return fn(m.store.state)
+}
+
+func (m *MemoryWalletRepository) InsertWallet(wallet *models.Wallet) (*models.Wallet, error) {
+	err := m.do(func(s *memoryState) error {
+		wallet.Id = primitive.NewObjectID()
+		s.wallets[wallet.Id] = copyWallet(wallet)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return wallet, nil
+}
+
+func (m *MemoryWalletRepository) FindUserWallet(userId primitive.ObjectID) (*models.Wallet, error) {
+	var wallet *models.Wallet
+	err := m.do(func(s *memoryState) error {
+		for _, w := range s.wallets {
+			if w.UserId == userId {
+				wallet = copyWallet(w)
+				return nil
+			}
+		}
+		return mongo.ErrNoDocuments
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return wallet, nil
+}
+
+func (m *MemoryWalletRepository) PushTransaction(wallet *models.Wallet, transaction *models.Transaction) error {
+	return m.do(func(s *memoryState) error {
+		w, ok := s.wallets[wallet.Id]
+		if !ok {
+			return mongo.ErrNoDocuments
+		}
+
+		w.Transactions = append(w.Transactions, *transaction)
+		w.Balance = transaction.Balance
+		return nil
+	})
+}
+
+func (m *MemoryWalletRepository) RevertTransaction(wallet *models.Wallet, revert *models.Transaction) error {
+	return m.do(func(s *memoryState) error {
+		w, ok := s.wallets[wallet.Id]
+		if !ok || w.Balance != wallet.Balance {
+			return mongo.ErrNoDocuments
+		}
+
+		for i := range w.Transactions {
+			t := &w.Transactions[i]
+			if t.OrderId == revert.OrderId && t.Status == models.TransactionActive && t.Amount < 0 {
+				t.Status = models.TransactionCancelled
+				w.Transactions = append(w.Transactions, *revert)
+				w.Balance = revert.Balance
+				return nil
+			}
+		}
+		return mongo.ErrNoDocuments
+	})
+}
+
+func (m *MemoryWalletRepository) FindInboxMessage(orderId primitive.ObjectID, command models.Command) (*models.InboxMessage, error) {
+	message := new(models.InboxMessage)
+	err := m.do(func(s *memoryState) error {
+		processed, ok := s.inbox[inboxKey{orderId: orderId, command: command}]
+		if !ok {
+			return mongo.ErrNoDocuments
+		}
+		*message = processed
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+func (m *MemoryWalletRepository) InsertInboxMessage(message *models.InboxMessage) error {
+	return m.do(func(s *memoryState) error {
+		key := inboxKey{orderId: message.OrderId, command: message.Command}
+		if _, ok := s.inbox[key]; ok {
+			return ErrDuplicateInboxMessage
+		}
+
+		message.Id = primitive.NewObjectID()
+		s.inbox[key] = *message
+		return nil
+	})
+}
+
+func (m *MemoryWalletRepository) Begin(_ context.Context) (WalletRepository, error) {
+	if m.tx != nil {
+		return nil, ErrUnitOfWorkStarted
+	}
+
+	m.store.mu.Lock()
+
+	uow := *m
+	uow.tx = m.store.state.copy()
+
+	return &uow, nil
+}
+
+func (m *MemoryWalletRepository) Commit() error {
+	if m.tx == nil {
+		return ErrUnitOfWorkNotStarted
+	}
+
+	m.store.state = m.tx
+	m.tx = nil
+	m.store.mu.Unlock()
+
+	return nil
+}
+
+func (m *MemoryWalletRepository) Rollback() error {
+	if m.tx == nil {
+		return nil
+	}
+
+	m.tx = nil
+	m.store.mu.Unlock()
+
+	return nil
+}
@@ -0,0 +1,205 @@
+package data
+
+import (
+	"context"
+	"eCommerce/wallet/internal/models"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrDuplicateInboxMessage is returned when the processed command is recorded again.
+var ErrDuplicateInboxMessage = errors.New(`command is already processed`)
+
+type WalletRepository interface {
+	InsertWallet(wallet *models.Wallet) (*models.Wallet, error)
+	FindUserWallet(userId primitive.ObjectID) (*models.Wallet, error)
+	// PushTransaction adds the transaction to the wallet and sets balance of the wallet to the transaction balance.
+	PushTransaction(wallet *models.Wallet, transaction *models.Transaction) error
+	// RevertTransaction marks active payment of the order as cancelled and adds compensating transaction.
+	// Wallet is updated only when its balance was not changed since it was read.
+	RevertTransaction(wallet *models.Wallet, revert *models.Transaction) error
+	// FindInboxMessage returns the record of the command which is already processed.
+	FindInboxMessage(orderId primitive.ObjectID, command models.Command) (*models.InboxMessage, error)
+	// InsertInboxMessage records the processed command. Command could be recorded only once.
+	InsertInboxMessage(message *models.InboxMessage) error
+	// Begin starts the unit of work. Changes made through the returned repository are staged in the database
+	// transaction until Commit is called, Rollback discards them. Rollback after Commit has no effect.
+	// Operations of the unit of work are traced as children of the span of the context.
+	Begin(ctx context.Context) (WalletRepository, error)
+	Commit() error
+	Rollback() error
+}
+
+type MongoWalletRepository struct {
+	ctx     context.Context
+	session mongo.Session
+	wallets *mongo.Collection
+	inbox   *mongo.Collection
+}
+
+func NewMongoWalletRepository(db *mongo.Database) *MongoWalletRepository {
+	r := new(MongoWalletRepository)
+	r.ctx = context.Background()
+	r.wallets = db.Collection(`wallets`)
+	r.inbox = db.Collection(`inbox`)
+
+	return r
+}
+
+// EnsureIndexes creates unique index of the processed commands. Collection is created by the index as well,
+// because it can not be created implicitly inside the transaction.
+func (m *MongoWalletRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := m.inbox.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{"order_id", 1}, {"command", 1}},
+		Options: options.Index().SetUnique(true),
+	})
+
+	return err
+}
+
+func (m *MongoWalletRepository) InsertWallet(wallet *models.Wallet) (*models.Wallet, error) {
+	single, err := m.wallets.InsertOne(m.ctx, wallet)
+	if err != nil {
+		return nil, err
+	}
+
+	wallet.Id = single.InsertedID.(primitive.ObjectID)
+
+	return wallet, nil
+}
+
+func (m *MongoWalletRepository) FindUserWallet(userId primitive.ObjectID) (*models.Wallet, error) {
+	single := m.wallets.FindOne(m.ctx, bson.M{"user_id": userId})
+	if err := single.Err(); err != nil {
+		return nil, err
+	}
+
+	wallet := new(models.Wallet)
+	if err := single.Decode(wallet); err != nil {
+		return nil, err
+	}
+
+	return wallet, nil
+}
+
+func (m *MongoWalletRepository) PushTransaction(wallet *models.Wallet, transaction *models.Transaction) error {
+	filter := bson.D{{"_id", wallet.Id}}
+	update := bson.D{
+		{"$push", bson.M{`transactions`: transaction}},
+		{"$set", bson.M{`balance`: transaction.Balance}},
+	}
+
+	return m.wallets.FindOneAndUpdate(m.ctx, filter, update).Err()
+}
+
+// RevertTransaction marks order payment as cancelled and pushes compensating transaction to the wallet.
+// Both updates are modifying transactions array, so they can not be done by the single update query and
+// the own unit of work is started when the repository is not bound to one.
+func (m *MongoWalletRepository) RevertTransaction(wallet *models.Wallet, revert *models.Transaction) error {
+	if m.session == nil {
+		return WithUnitOfWork(m.ctx, m, func(uow WalletRepository) error {
+			return uow.RevertTransaction(wallet, revert)
+		})
+	}
+
+	filter := bson.D{
+		{"_id", wallet.Id},
+		{"balance", wallet.Balance},
+		{"transactions", bson.D{{"$elemMatch", bson.D{
+			{"order_id", revert.OrderId},
+			{"status", models.TransactionActive},
+			{"amount", bson.D{{"$lt", 0}}},
+		}}}},
+	}
+	update := bson.D{{"$set", bson.D{
+		{"transactions.$.status", models.TransactionCancelled},
+		{"balance", revert.Balance},
+	}}}
+	if err := m.wallets.FindOneAndUpdate(m.ctx, filter, update).Err(); err != nil {
+		return err
+	}
+
+	filter = bson.D{{"_id", wallet.Id}}
+	update = bson.D{{"$push", bson.M{`transactions`: revert}}}
+
+	return m.wallets.FindOneAndUpdate(m.ctx, filter, update).Err()
+}
+
+func (m *MongoWalletRepository) FindInboxMessage(orderId primitive.ObjectID, command models.Command) (*models.InboxMessage, error) {
+	filter := bson.D{
+		{"order_id", orderId},
+		{"command", command},
+	}
+
+	single := m.inbox.FindOne(m.ctx, filter)
+	if err := single.Err(); err != nil {
+		return nil, err
+	}
+
+	message := new(models.InboxMessage)
+	if err := single.Decode(message); err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+func (m *MongoWalletRepository) InsertInboxMessage(message *models.InboxMessage) error {
+	one, err := m.inbox.InsertOne(m.ctx, message)
+	if err != nil {
+		return err
+	}
+
+	message.Id = one.InsertedID.(primitive.ObjectID)
+
+	return nil
+}
+
+func (m *MongoWalletRepository) Begin(ctx context.Context) (WalletRepository, error) {
+	if m.session != nil {
+		return nil, ErrUnitOfWorkStarted
+	}
+
+	session, err := m.wallets.Database().Client().StartSession()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = session.StartTransaction(txOptions); err != nil {
+		session.EndSession(ctx)
+		return nil, err
+	}
+
+	uow := *m
+	uow.session = session
+	uow.ctx = mongo.NewSessionContext(ctx, session)
+
+	return &uow, nil
+}
+
+func (m *MongoWalletRepository) Commit() error {
+	if m.session == nil {
+		return ErrUnitOfWorkNotStarted
+	}
+	defer m.end()
+
+	return m.session.CommitTransaction(m.ctx)
+}
+
+func (m *MongoWalletRepository) Rollback() error {
+	if m.session == nil {
+		return nil
+	}
+	defer m.end()
+
+	return m.session.AbortTransaction(m.ctx)
+}
+
+func (m *MongoWalletRepository) end() {
+	m.session.EndSession(context.Background())
+	m.session = nil
+	m.ctx = context.Background()
+}
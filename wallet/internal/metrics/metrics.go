@@ -0,0 +1,29 @@
+// Package metrics declares prometheus metrics of the wallet. Metrics are served by the diagnostics server.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Operations with the order payment.
+const (
+	OperationPay    = `pay`
+	OperationCancel = `cancel`
+)
+
+var payments = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: `wallet`,
+	Name:      `payments_total`,
+	Help:      `Processed payment commands by operation, result and reason of the rejection.`,
+}, []string{`operation`, `result`, `reason`})
+
+// ObservePayment counts the processed payment command. Empty reason means the command succeeded.
+func ObservePayment(operation, reason string) {
+	if reason == "" {
+		payments.WithLabelValues(operation, `success`, "").Inc()
+		return
+	}
+
+	payments.WithLabelValues(operation, `rejected`, reason).Inc()
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+const (
+	CreateWalletCommand Command = `create-wallet`
+	PayOrderCommand     Command = `pay-order`
+	CancelOrderCommand  Command = `cancel-order`
+)
+
+// Command is the type of the command processed by the wallet.
+type Command string
+
+// InboxMessage records the processed command with the response sent to it, so the redelivered command
+// is answered with the same response instead of being processed again.
+// Commands of the orders are identified by the order id, wallet creation - by the user id.
+type InboxMessage struct {
+	Id          primitive.ObjectID `bson:"_id,omitempty"`
+	OrderId     primitive.ObjectID `bson:"order_id"`
+	Command     Command            `bson:"command"`
+	IsSuccess   bool               `bson:"is_success"`
+	Message     string             `bson:"message"`
+	ContentType string             `bson:"content_type,omitempty"`
+	Payload     []byte             `bson:"payload"`
+	ProcessedAt time.Time          `bson:"processed_at"`
+}
@@ -1,10 +0,0 @@
-package models
-
-const (
-	WalletTopic         = `wallet-create`
-	WalletResponseTopic = `wallet-create-response`
-	WalletGroup         = `wallet-create-group`
-
-	WalletPayOrderResponseTopic    = `wallet-pay-order-response`
-	WalletCancelOrderResponseTopic = `wallet-cancel-order-response`
-)